@@ -0,0 +1,343 @@
+// Package accesslog provides access log middleware for fasthttp servers.
+//
+// Log lines are formatted in the request goroutine and written
+// asynchronously by a background goroutine, so slow outputs don't
+// delay the responses.
+package accesslog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bhargawpradhan/fasthttp"
+)
+
+// Default values used by New for the zero Config fields.
+const (
+	DefaultQueueSize     = 1024
+	DefaultBufferSize    = 64 * 1024
+	DefaultFlushInterval = time.Second
+	DefaultRedactedValue = "[REDACTED]"
+)
+
+// DefaultRedactHeaders contains the headers redacted by default.
+var DefaultRedactHeaders = []string{
+	fasthttp.HeaderAuthorization,
+	fasthttp.HeaderProxyAuthorization,
+	fasthttp.HeaderCookie,
+	fasthttp.HeaderSetCookie,
+}
+
+// Config configures the access log.
+type Config struct {
+	// Output is the destination for the log lines.
+	//
+	// See RotatingFile for a file output with rotation.
+	//
+	// By default os.Stdout is used.
+	Output io.Writer
+
+	// ErrorLogger is used for reporting Output write errors.
+	//
+	// By default the standard logger from the log package is used.
+	ErrorLogger fasthttp.Logger
+
+	// Format is the log line format. It is ignored if Template is set.
+	//
+	// By default FormatCombined is used.
+	Format Format
+
+	// Template is a custom log line format containing ${field}
+	// placeholders, for example:
+	//
+	//     ${remote_ip} ${method} ${uri} ${status} ${duration}ms ${header:X-Request-Id}
+	//
+	// See the Field* constants for the supported fields. Missing values
+	// are written as '-'.
+	Template string
+
+	// RequestHeaders and ResponseHeaders contain the header names
+	// additionally written by FormatJSON and FormatLogfmt.
+	RequestHeaders  []string
+	ResponseHeaders []string
+
+	// RedactHeaders contains the names of the headers, which values are
+	// replaced with RedactedValue in the log lines.
+	//
+	// DefaultRedactHeaders is used if RedactHeaders is nil. Set it
+	// to an empty slice for disabling redaction.
+	RedactHeaders []string
+
+	// RedactedValue replaces the values of the redacted headers.
+	//
+	// DefaultRedactedValue is used by default.
+	RedactedValue string
+
+	// SampleRate is the fraction of the requests to log in the range (0, 1).
+	// Responses with status codes 500 and higher are always logged.
+	//
+	// All the requests are logged by default.
+	SampleRate float64
+
+	// Skip, when set, is called after the handler returns. The request
+	// isn't logged if it returns true.
+	Skip func(ctx *fasthttp.RequestCtx) bool
+
+	// QueueSize is the maximum number of log lines waiting to be written.
+	// Log lines are dropped when the queue is full. See Logger.Dropped.
+	//
+	// DefaultQueueSize is used by default.
+	QueueSize int
+
+	// BufferSize is the size of the write buffer in bytes.
+	//
+	// DefaultBufferSize is used by default.
+	BufferSize int
+
+	// FlushInterval is the maximum duration log lines stay in the write
+	// buffer.
+	//
+	// DefaultFlushInterval is used by default.
+	FlushInterval time.Duration
+}
+
+// Logger is an access logger. It must be created with New.
+type Logger struct {
+	output      io.Writer
+	errorLogger fasthttp.Logger
+	format      Format
+	segments    []segment
+	sampleRate  float64
+	skip        func(ctx *fasthttp.RequestCtx) bool
+
+	requestHeaders  []string
+	responseHeaders []string
+	redact          map[string]struct{}
+	redactedValue   string
+
+	bufferSize    int
+	flushInterval time.Duration
+
+	queue chan *[]byte
+	stop  chan struct{}
+	done  chan struct{}
+
+	linePool     sync.Pool
+	fieldBufPool sync.Pool
+
+	dropped atomic.Uint64
+
+	// mu prevents enqueueing log lines after Close stops the writer.
+	mu     sync.RWMutex
+	closed bool
+}
+
+// New returns an access logger for the given config.
+//
+// The returned Logger must be closed with Close for flushing
+// the buffered log lines.
+func New(cfg Config) (*Logger, error) {
+	l := &Logger{
+		output:          cfg.Output,
+		errorLogger:     cfg.ErrorLogger,
+		format:          cfg.Format,
+		sampleRate:      cfg.SampleRate,
+		skip:            cfg.Skip,
+		requestHeaders:  cfg.RequestHeaders,
+		responseHeaders: cfg.ResponseHeaders,
+		redactedValue:   cfg.RedactedValue,
+		bufferSize:      cfg.BufferSize,
+		flushInterval:   cfg.FlushInterval,
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+	if l.output == nil {
+		l.output = os.Stdout
+	}
+	if l.errorLogger == nil {
+		l.errorLogger = log.Default()
+	}
+	if cfg.Template != "" {
+		segments, err := parseTemplate(cfg.Template)
+		if err != nil {
+			return nil, err
+		}
+		l.segments = segments
+	} else if l.format < FormatCombined || l.format > FormatLogfmt {
+		return nil, fmt.Errorf("unknown access log format %d", l.format)
+	}
+
+	redact := cfg.RedactHeaders
+	if redact == nil {
+		redact = DefaultRedactHeaders
+	}
+	l.redact = make(map[string]struct{}, len(redact))
+	for _, name := range redact {
+		l.redact[strings.ToLower(name)] = struct{}{}
+	}
+	if l.redactedValue == "" {
+		l.redactedValue = DefaultRedactedValue
+	}
+
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	l.queue = make(chan *[]byte, queueSize)
+	if l.bufferSize <= 0 {
+		l.bufferSize = DefaultBufferSize
+	}
+	if l.flushInterval <= 0 {
+		l.flushInterval = DefaultFlushInterval
+	}
+	l.linePool.New = func() any {
+		b := make([]byte, 0, 256)
+		return &b
+	}
+	l.fieldBufPool.New = func() any {
+		b := make([]byte, 0, 64)
+		return &b
+	}
+
+	go l.writer()
+	return l, nil
+}
+
+// Handler returns a request handler logging the requests served by next.
+func (l *Logger) Handler(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		start := time.Now()
+		next(ctx)
+		duration := time.Since(start)
+
+		if !l.shouldLog(ctx) {
+			return
+		}
+
+		e := entry{ctx: ctx, start: start, duration: duration}
+		line := l.linePool.Get().(*[]byte)
+		*line = l.appendLine((*line)[:0], &e)
+
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+		if l.closed {
+			l.dropped.Add(1)
+			l.linePool.Put(line)
+			return
+		}
+		select {
+		case l.queue <- line:
+		default:
+			l.dropped.Add(1)
+			l.linePool.Put(line)
+		}
+	}
+}
+
+// Dropped returns the number of log lines dropped because the queue
+// was full or the Logger was closed.
+func (l *Logger) Dropped() uint64 {
+	return l.dropped.Load()
+}
+
+// Close flushes the queued log lines and stops the background writer.
+//
+// Close doesn't close the Output.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.stop)
+	}
+	l.mu.Unlock()
+	<-l.done
+	return nil
+}
+
+func (l *Logger) shouldLog(ctx *fasthttp.RequestCtx) bool {
+	if l.skip != nil && l.skip(ctx) {
+		return false
+	}
+	if l.sampleRate > 0 && l.sampleRate < 1 && ctx.Response.StatusCode() < fasthttp.StatusInternalServerError {
+		return rand.Float64() < l.sampleRate
+	}
+	return true
+}
+
+func (l *Logger) redacted(name string) bool {
+	if len(l.redact) == 0 {
+		return false
+	}
+	_, ok := l.redact[strings.ToLower(name)]
+	return ok
+}
+
+func (l *Logger) appendLine(dst []byte, e *entry) []byte {
+	if l.segments != nil {
+		return l.appendTemplate(dst, e)
+	}
+	switch l.format {
+	case FormatCommon:
+		return l.appendCommon(dst, e, false)
+	case FormatJSON:
+		return l.appendJSON(dst, e)
+	case FormatLogfmt:
+		return l.appendLogfmt(dst, e)
+	default:
+		return l.appendCommon(dst, e, true)
+	}
+}
+
+func (l *Logger) writer() {
+	defer close(l.done)
+
+	bw := bufio.NewWriterSize(l.output, l.bufferSize)
+	ticker := time.NewTicker(l.flushInterval)
+	defer ticker.Stop()
+
+	write := func(line *[]byte) {
+		if _, err := bw.Write(*line); err != nil {
+			l.errorLogger.Printf("accesslog: cannot write log line: %v", err)
+			// Drop the buffered data, so the following lines
+			// may be written after the output recovers.
+			bw.Reset(l.output)
+		}
+		l.linePool.Put(line)
+	}
+	flush := func() {
+		if bw.Buffered() == 0 {
+			return
+		}
+		if err := bw.Flush(); err != nil {
+			l.errorLogger.Printf("accesslog: cannot flush log lines: %v", err)
+			bw.Reset(l.output)
+		}
+	}
+
+	for {
+		select {
+		case line := <-l.queue:
+			write(line)
+		case <-ticker.C:
+			flush()
+		case <-l.stop:
+			for {
+				select {
+				case line := <-l.queue:
+					write(line)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bhargawpradhan/fasthttp"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	b  bytes.Buffer
+	mu sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+func serve(t *testing.T, l *Logger, h fasthttp.RequestHandler, prepare func(req *fasthttp.Request)) {
+	t.Helper()
+
+	var req fasthttp.Request
+	req.Header.SetMethod(fasthttp.MethodGet)
+	req.Header.SetRequestURI("/foo?bar=baz")
+	req.Header.SetHost("example.com")
+	req.Header.SetUserAgent("test-agent")
+	if prepare != nil {
+		prepare(&req)
+	}
+
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&req, &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234}, nil)
+	l.Handler(h)(&ctx)
+}
+
+func okHandler(ctx *fasthttp.RequestCtx) {
+	ctx.SetStatusCode(fasthttp.StatusCreated)
+	ctx.Response.Header.Set("X-Upstream", "a")
+	ctx.Response.Header.Set(fasthttp.HeaderSetCookie, "session=secret")
+	ctx.SetBodyString("hello")
+}
+
+func newLogger(t *testing.T, cfg Config) (*Logger, *syncBuffer) {
+	t.Helper()
+
+	var out syncBuffer
+	cfg.Output = &out
+	l, err := New(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return l, &out
+}
+
+func TestCombinedFormat(t *testing.T) {
+	t.Parallel()
+
+	l, out := newLogger(t, Config{})
+	serve(t, l, okHandler, func(req *fasthttp.Request) {
+		req.Header.SetReferer(`http://ref/"quoted"`)
+	})
+	if err := l.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	line := out.String()
+	if !strings.HasPrefix(line, "10.0.0.1 - - [") {
+		t.Fatalf("unexpected line %q", line)
+	}
+	want := `] "GET /foo?bar=baz HTTP/1.1" 201 5 "http://ref/\"quoted\"" "test-agent"` + "\n"
+	if !strings.HasSuffix(line, want) {
+		t.Fatalf("unexpected line %q. Expecting suffix %q", line, want)
+	}
+}
+
+func TestCommonFormat(t *testing.T) {
+	t.Parallel()
+
+	l, out := newLogger(t, Config{Format: FormatCommon})
+	serve(t, l, func(ctx *fasthttp.RequestCtx) {}, nil)
+	l.Close()
+
+	want := `] "GET /foo?bar=baz HTTP/1.1" 200 -` + "\n"
+	if line := out.String(); !strings.HasSuffix(line, want) {
+		t.Fatalf("unexpected line %q. Expecting suffix %q", line, want)
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	t.Parallel()
+
+	l, out := newLogger(t, Config{
+		Format:          FormatJSON,
+		RequestHeaders:  []string{"X-Request-Id", fasthttp.HeaderAuthorization},
+		ResponseHeaders: []string{"X-Upstream", fasthttp.HeaderSetCookie},
+	})
+	serve(t, l, okHandler, func(req *fasthttp.Request) {
+		req.Header.Set("X-Request-Id", "abc\"def")
+		req.Header.Set(fasthttp.HeaderAuthorization, "Bearer secret")
+		req.SetBodyString("payload")
+	})
+	l.Close()
+
+	var m map[string]any
+	if err := json.Unmarshal([]byte(out.String()), &m); err != nil {
+		t.Fatalf("cannot parse %q: %v", out.String(), err)
+	}
+	expected := map[string]any{
+		"remote_ip":              "10.0.0.1",
+		"method":                 "GET",
+		"host":                   "example.com",
+		"uri":                    "/foo?bar=baz",
+		"protocol":               "HTTP/1.1",
+		"status":                 float64(201),
+		"bytes_out":              float64(5),
+		"user_agent":             "test-agent",
+		"header:X-Request-Id":    `abc"def`,
+		"header:Authorization":   DefaultRedactedValue,
+		"resp_header:X-Upstream": "a",
+		"resp_header:Set-Cookie": DefaultRedactedValue,
+		"conn_request_num":       float64(0),
+	}
+	for k, v := range expected {
+		if m[k] != v {
+			t.Errorf("unexpected %q: %v. Expecting %v", k, m[k], v)
+		}
+	}
+	if n, _ := m["bytes_in"].(float64); n < float64(len("payload")) {
+		t.Errorf("unexpected bytes_in %v", m["bytes_in"])
+	}
+	if _, ok := m["duration"].(float64); !ok {
+		t.Errorf("missing duration in %v", m)
+	}
+	if _, ok := m["tls_version"]; ok {
+		t.Errorf("unexpected tls_version for the plain connection")
+	}
+}
+
+func TestLogfmtFormat(t *testing.T) {
+	t.Parallel()
+
+	l, out := newLogger(t, Config{Format: FormatLogfmt})
+	serve(t, l, okHandler, func(req *fasthttp.Request) {
+		req.Header.SetUserAgent("agent with spaces")
+	})
+	l.Close()
+
+	line := out.String()
+	for _, s := range []string{"method=GET ", "status=201 ", `user_agent="agent with spaces"`, `uri="/foo?bar=baz"`} {
+		if !strings.Contains(line, s) {
+			t.Fatalf("missing %q in %q", s, line)
+		}
+	}
+	if !strings.HasSuffix(line, "\n") || strings.Count(line, "\n") != 1 {
+		t.Fatalf("unexpected line %q", line)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	t.Parallel()
+
+	l, out := newLogger(t, Config{
+		Template: "${method} ${path} ${query} ${status} ${header:Cookie} ${header:X-Missing} ${resp_header:X-Upstream} ${tls_version}",
+	})
+	serve(t, l, okHandler, func(req *fasthttp.Request) {
+		req.Header.Set(fasthttp.HeaderCookie, "a=b")
+	})
+	l.Close()
+
+	want := "GET /foo bar=baz 201 [REDACTED] - a -\n"
+	if line := out.String(); line != want {
+		t.Fatalf("unexpected line %q. Expecting %q", line, want)
+	}
+}
+
+func TestTemplateError(t *testing.T) {
+	t.Parallel()
+
+	for _, template := range []string{"${unknown}", "${method", "${header:}"} {
+		if _, err := New(Config{Template: template}); err == nil {
+			t.Fatalf("expecting error for template %q", template)
+		}
+	}
+}
+
+func TestRedactDisabled(t *testing.T) {
+	t.Parallel()
+
+	l, out := newLogger(t, Config{
+		Template:      "${header:Authorization}",
+		RedactHeaders: []string{},
+	})
+	serve(t, l, okHandler, func(req *fasthttp.Request) {
+		req.Header.Set(fasthttp.HeaderAuthorization, "Basic xyz")
+	})
+	l.Close()
+
+	if line := out.String(); line != "Basic xyz\n" {
+		t.Fatalf("unexpected line %q", line)
+	}
+}
+
+func TestSkipAndSampling(t *testing.T) {
+	t.Parallel()
+
+	l, out := newLogger(t, Config{
+		Template:   "${status}",
+		SampleRate: 1e-9,
+		Skip: func(ctx *fasthttp.RequestCtx) bool {
+			return string(ctx.Path()) == "/health"
+		},
+	})
+	for i := 0; i < 100; i++ {
+		serve(t, l, okHandler, nil)
+	}
+	serve(t, l, func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusBadGateway)
+	}, nil)
+	serve(t, l, func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+	}, func(req *fasthttp.Request) {
+		req.Header.SetRequestURI("/health")
+	})
+	l.Close()
+
+	// Only the server error must be logged.
+	if line := out.String(); line != "502\n" {
+		t.Fatalf("unexpected output %q", line)
+	}
+}
+
+type blockingWriter struct {
+	unblock chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.unblock
+	return len(p), nil
+}
+
+func TestDropped(t *testing.T) {
+	t.Parallel()
+
+	w := &blockingWriter{unblock: make(chan struct{})}
+	l, err := New(Config{Output: w, QueueSize: 1, BufferSize: 1, Template: "${status}"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		serve(t, l, okHandler, nil)
+	}
+	if l.Dropped() == 0 {
+		t.Fatal("expecting dropped lines")
+	}
+	close(w.unblock)
+	l.Close()
+
+	serve(t, l, okHandler, nil)
+	if l.Dropped() == 0 {
+		t.Fatal("expecting dropped lines after Close")
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	rf := &RotatingFile{
+		Filename:   filepath.Join(dir, "access.log"),
+		MaxSize:    10,
+		MaxBackups: 2,
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := rf.Write([]byte("0123456789")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	backups, err := rf.backups()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("unexpected number of backups %d. Expecting 2", len(backups))
+	}
+	for _, name := range append(backups, rf.Filename) {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(data) != "0123456789" {
+			t.Fatalf("unexpected contents of %q: %q", name, data)
+		}
+	}
+
+	// Writes after Close reopen the file.
+	if _, err := rf.Write([]byte("x")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := rf.Rotate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rf.Close()
+	if data, _ := os.ReadFile(rf.Filename); len(data) != 0 {
+		t.Fatalf("unexpected contents after Rotate: %q", data)
+	}
+}
+
+func TestCloseConcurrentWithHandler(t *testing.T) {
+	t.Parallel()
+
+	l, out := newLogger(t, Config{Template: "${status}"})
+
+	const workers, requests = 8, 100
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < requests; j++ {
+				serve(t, l, okHandler, nil)
+			}
+		}()
+	}
+	l.Close()
+	wg.Wait()
+
+	// Every line must be either written or counted as dropped.
+	written := strings.Count(out.String(), "\n")
+	if total := uint64(written) + l.Dropped(); total != workers*requests {
+		t.Fatalf("unexpected number of written %d and dropped %d lines. Expecting %d in total",
+			written, l.Dropped(), workers*requests)
+	}
+}
+
+func TestRotatingFileCloseError(t *testing.T) {
+	t.Parallel()
+
+	rf := &RotatingFile{Filename: filepath.Join(t.TempDir(), "access.log")}
+	defer rf.Close()
+	if _, err := rf.Write([]byte("foo")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Make closing the file fail on rotation.
+	rf.f.Close()
+	if err := rf.Rotate(); err == nil {
+		t.Fatal("expecting error")
+	}
+
+	// The file is reopened instead of writing to the closed one.
+	if _, err := rf.Write([]byte("bar")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, _ := os.ReadFile(rf.Filename); string(data) != "foobar" {
+		t.Fatalf("unexpected contents %q. Expecting %q", data, "foobar")
+	}
+}
@@ -0,0 +1,405 @@
+package accesslog
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bhargawpradhan/fasthttp"
+)
+
+// Format is a predefined access log format.
+type Format int
+
+const (
+	// FormatCombined is the Apache Combined Log Format.
+	FormatCombined Format = iota
+
+	// FormatCommon is the Apache Common Log Format.
+	FormatCommon
+
+	// FormatJSON writes a JSON object per request.
+	FormatJSON
+
+	// FormatLogfmt writes logfmt key=value pairs per request.
+	FormatLogfmt
+)
+
+// Template fields.
+//
+// Request headers are referenced as "header:Name" and response headers
+// as "resp_header:Name".
+const (
+	FieldTime           = "time"
+	FieldTimeUnix       = "time_unix"
+	FieldRemoteIP       = "remote_ip"
+	FieldMethod         = "method"
+	FieldURI            = "uri"
+	FieldPath           = "path"
+	FieldQuery          = "query"
+	FieldProtocol       = "protocol"
+	FieldHost           = "host"
+	FieldStatus         = "status"
+	FieldBytesIn        = "bytes_in"
+	FieldBytesOut       = "bytes_out"
+	FieldDuration       = "duration"
+	FieldDurationUs     = "duration_us"
+	FieldID             = "id"
+	FieldConnID         = "conn_id"
+	FieldConnRequestNum = "conn_request_num"
+	FieldTLSVersion     = "tls_version"
+	FieldReferer        = "referer"
+	FieldUserAgent      = "user_agent"
+
+	requestHeaderPrefix  = "header:"
+	responseHeaderPrefix = "resp_header:"
+)
+
+// The fields written by FormatJSON and FormatLogfmt.
+var structuredFields = []string{
+	FieldTime, FieldRemoteIP, FieldMethod, FieldHost, FieldURI, FieldProtocol,
+	FieldStatus, FieldBytesIn, FieldBytesOut, FieldDuration, FieldID, FieldConnID,
+	FieldConnRequestNum, FieldTLSVersion, FieldReferer, FieldUserAgent,
+}
+
+const commonTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// entry contains the request data collected after the handler returns.
+type entry struct {
+	ctx      *fasthttp.RequestCtx
+	start    time.Time
+	duration time.Duration
+}
+
+type segment struct {
+	// literal is written as is if field is empty.
+	literal string
+	field   string
+}
+
+// parseTemplate splits the template into literals and ${field} references.
+func parseTemplate(template string) ([]segment, error) {
+	var segments []segment
+	for len(template) > 0 {
+		n := strings.Index(template, "${")
+		if n < 0 {
+			segments = append(segments, segment{literal: template})
+			break
+		}
+		if n > 0 {
+			segments = append(segments, segment{literal: template[:n]})
+		}
+		template = template[n+2:]
+		end := strings.IndexByte(template, '}')
+		if end < 0 {
+			return nil, fmt.Errorf("missing '}' in the template after %q", template)
+		}
+		field := template[:end]
+		if !isValidField(field) {
+			return nil, fmt.Errorf("unknown template field %q", field)
+		}
+		segments = append(segments, segment{field: field})
+		template = template[end+1:]
+	}
+	return segments, nil
+}
+
+func isValidField(field string) bool {
+	switch field {
+	case FieldTime, FieldTimeUnix, FieldRemoteIP, FieldMethod, FieldURI, FieldPath, FieldQuery,
+		FieldProtocol, FieldHost, FieldStatus, FieldBytesIn, FieldBytesOut, FieldDuration,
+		FieldDurationUs, FieldID, FieldConnID, FieldConnRequestNum, FieldTLSVersion,
+		FieldReferer, FieldUserAgent:
+		return true
+	}
+	return (strings.HasPrefix(field, requestHeaderPrefix) && len(field) > len(requestHeaderPrefix)) ||
+		(strings.HasPrefix(field, responseHeaderPrefix) && len(field) > len(responseHeaderPrefix))
+}
+
+// appendField appends the field value to dst. It returns false
+// for missing values.
+func (l *Logger) appendField(dst []byte, field string, e *entry) ([]byte, bool) {
+	ctx := e.ctx
+	switch field {
+	case FieldTime:
+		return e.start.AppendFormat(dst, time.RFC3339), true
+	case FieldTimeUnix:
+		return strconv.AppendInt(dst, e.start.Unix(), 10), true
+	case FieldRemoteIP:
+		return append(dst, ctx.RemoteIP().String()...), true
+	case FieldMethod:
+		return append(dst, ctx.Method()...), true
+	case FieldURI:
+		return append(dst, ctx.RequestURI()...), true
+	case FieldPath:
+		return append(dst, ctx.Path()...), true
+	case FieldQuery:
+		q := ctx.URI().QueryString()
+		return append(dst, q...), len(q) > 0
+	case FieldProtocol:
+		return append(dst, ctx.Request.Header.Protocol()...), true
+	case FieldHost:
+		host := ctx.Host()
+		return append(dst, host...), len(host) > 0
+	case FieldStatus:
+		return strconv.AppendInt(dst, int64(ctx.Response.StatusCode()), 10), true
+	case FieldBytesIn:
+		return strconv.AppendInt(dst, int64(bytesIn(ctx)), 10), true
+	case FieldBytesOut:
+		return strconv.AppendInt(dst, int64(bytesOut(ctx)), 10), true
+	case FieldDuration:
+		return strconv.AppendFloat(dst, float64(e.duration)/float64(time.Millisecond), 'f', 3, 64), true
+	case FieldDurationUs:
+		return strconv.AppendInt(dst, e.duration.Microseconds(), 10), true
+	case FieldID:
+		return strconv.AppendUint(dst, ctx.ID(), 10), true
+	case FieldConnID:
+		return strconv.AppendUint(dst, ctx.ConnID(), 10), true
+	case FieldConnRequestNum:
+		return strconv.AppendUint(dst, ctx.ConnRequestNum(), 10), true
+	case FieldTLSVersion:
+		state := ctx.TLSConnectionState()
+		if state == nil {
+			return dst, false
+		}
+		return append(dst, tls.VersionName(state.Version)...), true
+	case FieldReferer:
+		v := ctx.Referer()
+		return append(dst, v...), len(v) > 0
+	case FieldUserAgent:
+		v := ctx.UserAgent()
+		return append(dst, v...), len(v) > 0
+	}
+
+	if name, ok := strings.CutPrefix(field, requestHeaderPrefix); ok {
+		return l.appendHeader(dst, name, ctx.Request.Header.Peek(name))
+	}
+	if name, ok := strings.CutPrefix(field, responseHeaderPrefix); ok {
+		return l.appendHeader(dst, name, ctx.Response.Header.Peek(name))
+	}
+	return dst, false
+}
+
+func (l *Logger) appendHeader(dst []byte, name string, value []byte) ([]byte, bool) {
+	if len(value) == 0 {
+		return dst, false
+	}
+	if l.redacted(name) {
+		return append(dst, l.redactedValue...), true
+	}
+	return append(dst, value...), true
+}
+
+// bytesIn returns the approximate number of bytes received for the request.
+func bytesIn(ctx *fasthttp.RequestCtx) int {
+	n := len(ctx.Request.Header.RawHeaders())
+	if ctx.Request.IsBodyStream() {
+		if cl := ctx.Request.Header.ContentLength(); cl > 0 {
+			n += cl
+		}
+		return n
+	}
+	return n + len(ctx.Request.Body())
+}
+
+// bytesOut returns the response body size. It is zero for streamed
+// bodies of unknown size.
+func bytesOut(ctx *fasthttp.RequestCtx) int {
+	if ctx.Response.IsBodyStream() {
+		if cl := ctx.Response.Header.ContentLength(); cl > 0 {
+			return cl
+		}
+		return 0
+	}
+	return len(ctx.Response.Body())
+}
+
+func (l *Logger) appendTemplate(dst []byte, e *entry) []byte {
+	for _, s := range l.segments {
+		if s.field == "" {
+			dst = append(dst, s.literal...)
+			continue
+		}
+		var ok bool
+		if dst, ok = l.appendField(dst, s.field, e); !ok {
+			dst = append(dst, '-')
+		}
+	}
+	return append(dst, '\n')
+}
+
+func (l *Logger) appendCommon(dst []byte, e *entry, combined bool) []byte {
+	ctx := e.ctx
+	dst = append(dst, ctx.RemoteIP().String()...)
+	dst = append(dst, " - - ["...)
+	dst = e.start.AppendFormat(dst, commonTimeLayout)
+	dst = append(dst, `] "`...)
+	dst = appendEscaped(dst, ctx.Method())
+	dst = append(dst, ' ')
+	dst = appendEscaped(dst, ctx.RequestURI())
+	dst = append(dst, ' ')
+	dst = appendEscaped(dst, ctx.Request.Header.Protocol())
+	dst = append(dst, `" `...)
+	dst = strconv.AppendInt(dst, int64(ctx.Response.StatusCode()), 10)
+	dst = append(dst, ' ')
+	if n := bytesOut(ctx); n > 0 {
+		dst = strconv.AppendInt(dst, int64(n), 10)
+	} else {
+		dst = append(dst, '-')
+	}
+	if combined {
+		dst = append(dst, ` "`...)
+		dst = appendEscapedOrDash(dst, ctx.Referer())
+		dst = append(dst, `" "`...)
+		dst = appendEscapedOrDash(dst, ctx.UserAgent())
+		dst = append(dst, '"')
+	}
+	return append(dst, '\n')
+}
+
+func (l *Logger) appendJSON(dst []byte, e *entry) []byte {
+	dst = append(dst, '{')
+	first := true
+	l.visitStructured(e, func(key string, value []byte, numeric bool) {
+		if !first {
+			dst = append(dst, ',')
+		}
+		first = false
+		dst = appendJSONString(dst, key)
+		dst = append(dst, ':')
+		if numeric {
+			dst = append(dst, value...)
+		} else {
+			dst = appendJSONString(dst, string(value))
+		}
+	})
+	return append(dst, '}', '\n')
+}
+
+func (l *Logger) appendLogfmt(dst []byte, e *entry) []byte {
+	first := true
+	l.visitStructured(e, func(key string, value []byte, _ bool) {
+		if !first {
+			dst = append(dst, ' ')
+		}
+		first = false
+		dst = append(dst, key...)
+		dst = append(dst, '=')
+		dst = appendLogfmtValue(dst, value)
+	})
+	return append(dst, '\n')
+}
+
+// visitStructured calls f for all the non-empty structured fields
+// and the configured headers.
+func (l *Logger) visitStructured(e *entry, f func(key string, value []byte, numeric bool)) {
+	buf := l.fieldBufPool.Get().(*[]byte)
+	defer l.fieldBufPool.Put(buf)
+
+	for _, field := range structuredFields {
+		v, ok := l.appendField((*buf)[:0], field, e)
+		*buf = v
+		if !ok {
+			continue
+		}
+		f(field, v, isNumericField(field))
+	}
+	for _, name := range l.requestHeaders {
+		v, ok := l.appendHeader((*buf)[:0], name, e.ctx.Request.Header.Peek(name))
+		*buf = v
+		if ok {
+			f(requestHeaderPrefix+name, v, false)
+		}
+	}
+	for _, name := range l.responseHeaders {
+		v, ok := l.appendHeader((*buf)[:0], name, e.ctx.Response.Header.Peek(name))
+		*buf = v
+		if ok {
+			f(responseHeaderPrefix+name, v, false)
+		}
+	}
+}
+
+func isNumericField(field string) bool {
+	switch field {
+	case FieldTimeUnix, FieldStatus, FieldBytesIn, FieldBytesOut, FieldDuration, FieldDurationUs,
+		FieldID, FieldConnID, FieldConnRequestNum:
+		return true
+	}
+	return false
+}
+
+const hex = "0123456789abcdef"
+
+func appendJSONString(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			switch {
+			case c == '"' || c == '\\':
+				dst = append(dst, '\\', c)
+			case c == '\n':
+				dst = append(dst, '\\', 'n')
+			case c == '\r':
+				dst = append(dst, '\\', 'r')
+			case c == '\t':
+				dst = append(dst, '\\', 't')
+			case c < 0x20 || c == 0x7f:
+				dst = append(dst, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+			default:
+				dst = append(dst, c)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			dst = append(dst, `�`...)
+		} else {
+			dst = append(dst, s[i:i+size]...)
+		}
+		i += size
+	}
+	return append(dst, '"')
+}
+
+func appendLogfmtValue(dst, v []byte) []byte {
+	needsQuoting := len(v) == 0
+	for _, c := range v {
+		if c <= ' ' || c == '"' || c == '=' || c == '\\' || c >= utf8.RuneSelf {
+			needsQuoting = true
+			break
+		}
+	}
+	if !needsQuoting {
+		return append(dst, v...)
+	}
+	return appendJSONString(dst, string(v))
+}
+
+// appendEscaped escapes quotes, backslashes and control characters
+// the same way Apache httpd does.
+func appendEscaped(dst, v []byte) []byte {
+	for _, c := range v {
+		switch {
+		case c == '"' || c == '\\':
+			dst = append(dst, '\\', c)
+		case c < 0x20 || c >= 0x7f:
+			dst = append(dst, '\\', 'x', hex[c>>4], hex[c&0xf])
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
+
+func appendEscapedOrDash(dst, v []byte) []byte {
+	if len(v) == 0 {
+		return append(dst, '-')
+	}
+	return appendEscaped(dst, v)
+}
@@ -0,0 +1,184 @@
+package accesslog
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeLayout is appended to the rotated file names.
+const backupTimeLayout = "20060102T150405.000000000"
+
+// RotatingFile is an io.WriteCloser writing to a file, which is rotated
+// when it grows beyond MaxSize bytes or becomes older than MaxAge.
+//
+// Rotated files are renamed to Filename with the rotation time suffix,
+// for example access.log.20240102T150405.000000000.
+//
+// RotatingFile is safe for concurrent use.
+type RotatingFile struct {
+	// Filename is the path to the log file. It is created if missing.
+	Filename string
+
+	// MaxSize is the file size in bytes triggering rotation.
+	//
+	// The file isn't rotated by size if MaxSize isn't positive.
+	MaxSize int64
+
+	// MaxAge is the maximum duration data is written to the file before
+	// the file is rotated.
+	//
+	// The file isn't rotated by time if MaxAge isn't positive.
+	MaxAge time.Duration
+
+	// MaxBackups is the maximum number of the rotated files to keep.
+	// The oldest rotated files are removed.
+	//
+	// All the rotated files are kept if MaxBackups isn't positive.
+	MaxBackups int
+
+	f        *os.File
+	size     int64
+	openedAt time.Time
+
+	mu sync.Mutex
+}
+
+// Write implements io.Writer.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.f == nil {
+		if err := rf.open(); err != nil {
+			return 0, err
+		}
+	}
+	if rf.needsRotation(len(p)) {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the file immediately.
+func (rf *RotatingFile) Rotate() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.f == nil {
+		if err := rf.open(); err != nil {
+			return err
+		}
+	}
+	return rf.rotate()
+}
+
+// Close closes the file.
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
+
+func (rf *RotatingFile) needsRotation(n int) bool {
+	if rf.size == 0 {
+		return false
+	}
+	if rf.MaxSize > 0 && rf.size+int64(n) > rf.MaxSize {
+		return true
+	}
+	return rf.MaxAge > 0 && time.Since(rf.openedAt) >= rf.MaxAge
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.Filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f = f
+	rf.size = fi.Size()
+	rf.openedAt = time.Now()
+	if rf.size > 0 {
+		// Count the age of the existing file from its last modification,
+		// since the file creation time isn't portable.
+		rf.openedAt = fi.ModTime()
+	}
+	return nil
+}
+
+func (rf *RotatingFile) rotate() error {
+	// The file is reopened on the next Write if it cannot be closed
+	// or renamed.
+	err := rf.f.Close()
+	rf.f = nil
+	if err != nil {
+		return err
+	}
+	backup := rf.Filename + "." + time.Now().Format(backupTimeLayout)
+	if err := os.Rename(rf.Filename, backup); err != nil {
+		return err
+	}
+	if err := rf.open(); err != nil {
+		return err
+	}
+	return rf.removeOldBackups()
+}
+
+func (rf *RotatingFile) removeOldBackups() error {
+	if rf.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := rf.backups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > rf.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// backups returns the rotated files sorted from the oldest to the newest.
+func (rf *RotatingFile) backups() ([]string, error) {
+	dir := filepath.Dir(rf.Filename)
+	prefix := filepath.Base(rf.Filename) + "."
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var backups []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if _, err := time.Parse(backupTimeLayout, name[len(prefix):]); err != nil {
+			continue
+		}
+		backups = append(backups, filepath.Join(dir, name))
+	}
+	// The time suffix sorts lexicographically.
+	sort.Strings(backups)
+	return backups, nil
+}
@@ -0,0 +1,416 @@
+package fasthttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCircuitConsecutiveFailures is the default number of consecutive
+	// failures opening CircuitBreaker.
+	DefaultCircuitConsecutiveFailures = 5
+
+	// DefaultCircuitMinRequests is the default minimum number of requests
+	// in the rolling window before CircuitBreaker is opened by failure
+	// or slow call rate.
+	DefaultCircuitMinRequests = 20
+
+	// DefaultCircuitWindow is the default duration of CircuitBreaker
+	// rolling window.
+	DefaultCircuitWindow = 10 * time.Second
+
+	// DefaultCircuitOpenDuration is the default duration CircuitBreaker
+	// stays open before allowing probe requests.
+	DefaultCircuitOpenDuration = 5 * time.Second
+)
+
+var (
+	// ErrCircuitOpen is returned without sending the request when
+	// the circuit breaker is open.
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+
+	// ErrTooManyProbes is returned without sending the request when
+	// the circuit breaker is half-open and the maximum number of probe
+	// requests are already in flight.
+	//
+	// errors.Is(ErrTooManyProbes, ErrCircuitOpen) is true.
+	ErrTooManyProbes = fmt.Errorf("%w: too many half-open probe requests", ErrCircuitOpen)
+)
+
+// CircuitState is the state of CircuitBreaker.
+type CircuitState int32
+
+const (
+	// CircuitClosed lets all the requests through.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen fails all the requests with ErrCircuitOpen.
+	CircuitOpen
+
+	// CircuitHalfOpen lets a limited number of probe requests through.
+	CircuitHalfOpen
+)
+
+// String returns the state name.
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int32(s))
+	}
+}
+
+const circuitBuckets = 10
+
+// CircuitBreaker stops sending requests to the failing upstream.
+//
+// The breaker is closed initially and lets all the requests through.
+// It opens after ConsecutiveFailures consecutive failures, or when the failure
+// rate or the slow call rate in the rolling window exceeds the threshold.
+// The open breaker fails the requests with ErrCircuitOpen without sending
+// them. After OpenDuration the breaker becomes half-open and lets
+// HalfOpenProbes probe requests through. The breaker closes if all
+// the probes succeed and opens again on the first failed probe.
+//
+// CircuitBreaker may be used by HostClient and LBClient. It must be shared
+// only by the clients sending requests to the same upstream.
+//
+// Zero value is usable. It is forbidden copying CircuitBreaker instances
+// after the first use.
+//
+// It is safe calling CircuitBreaker methods from concurrently
+// running goroutines.
+type CircuitBreaker struct {
+	noCopy noCopy
+
+	now func() time.Time
+
+	// IsFailure determines whether the request failed.
+	//
+	// By default errors and responses with 5xx status codes are failures.
+	// Requests canceled by the caller are never counted.
+	IsFailure func(req *Request, resp *Response, err error) bool
+
+	// OnStateChange is called on every state change. It may be used
+	// for alerting. The callback mustn't block.
+	OnStateChange func(from, to CircuitState)
+
+	openedAt time.Time
+
+	buckets [circuitBuckets]circuitBucket
+
+	// ConsecutiveFailures is the number of consecutive failures
+	// opening the breaker.
+	//
+	// By default DefaultCircuitConsecutiveFailures is used.
+	// Negative value disables opening on consecutive failures.
+	ConsecutiveFailures int
+
+	// FailureRateThreshold is the failure rate in (0, 1] range opening
+	// the breaker when reached in the rolling window.
+	//
+	// By default the failure rate isn't checked.
+	FailureRateThreshold float64
+
+	// SlowCallDuration is the duration after which the request
+	// is considered slow.
+	//
+	// By default slow calls aren't tracked.
+	SlowCallDuration time.Duration
+
+	// SlowCallRateThreshold is the slow call rate in (0, 1] range opening
+	// the breaker when reached in the rolling window. Slow probes reopen
+	// the half-open breaker.
+	//
+	// By default the slow call rate isn't checked.
+	SlowCallRateThreshold float64
+
+	// MinRequests is the minimum number of requests in the rolling window
+	// for checking the failure and slow call rates.
+	//
+	// By default DefaultCircuitMinRequests is used.
+	MinRequests int
+
+	// Window is the duration of the rolling window for the failure
+	// and slow call rates.
+	//
+	// By default DefaultCircuitWindow is used.
+	Window time.Duration
+
+	// OpenDuration is the duration the breaker stays open before
+	// becoming half-open.
+	//
+	// By default DefaultCircuitOpenDuration is used.
+	OpenDuration time.Duration
+
+	// HalfOpenProbes is the number of probe requests let through
+	// by the half-open breaker. The breaker closes after all the probes
+	// succeed.
+	//
+	// By default a single probe is used.
+	HalfOpenProbes int
+
+	consecutive    int
+	probes         int
+	probeSuccesses int
+
+	// generation is incremented on every state change, so results
+	// of the requests started in the previous states are ignored.
+	generation uint64
+
+	mu sync.Mutex
+
+	state CircuitState
+}
+
+type circuitBucket struct {
+	idx      int64
+	requests int
+	failures int
+	slow     int
+}
+
+// State returns the current state of the breaker.
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	from, to := cb.refreshState(cb.timeNow())
+	state := cb.state
+	cb.mu.Unlock()
+	cb.notify(from, to)
+	return state
+}
+
+// Reset closes the breaker and clears the collected statistics.
+func (cb *CircuitBreaker) Reset() {
+	cb.mu.Lock()
+	from := cb.state
+	cb.setState(CircuitClosed)
+	cb.mu.Unlock()
+	cb.notify(from, CircuitClosed)
+}
+
+// available returns false if the request would be rejected by allow.
+func (cb *CircuitBreaker) available() bool {
+	switch cb.State() {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		cb.mu.Lock()
+		ok := cb.probes+cb.probeSuccesses < cb.halfOpenProbes()
+		cb.mu.Unlock()
+		return ok
+	default:
+		return true
+	}
+}
+
+// allow returns an error if the request mustn't be sent.
+// Otherwise the request result must be passed to done together
+// with the returned generation.
+func (cb *CircuitBreaker) allow() (uint64, error) {
+	cb.mu.Lock()
+	from, to := cb.refreshState(cb.timeNow())
+	var err error
+	switch cb.state {
+	case CircuitOpen:
+		err = ErrCircuitOpen
+	case CircuitHalfOpen:
+		if cb.probes+cb.probeSuccesses >= cb.halfOpenProbes() {
+			err = ErrTooManyProbes
+		} else {
+			cb.probes++
+		}
+	}
+	generation := cb.generation
+	cb.mu.Unlock()
+	cb.notify(from, to)
+	return generation, err
+}
+
+// done records the result of the request allowed by allow.
+func (cb *CircuitBreaker) done(generation uint64, req *Request, resp *Response, err error, duration time.Duration) {
+	if err != nil && req.ctx != nil && errors.Is(req.ctx.Err(), context.Canceled) {
+		// The caller has abandoned the request, so the error
+		// tells nothing about the upstream health.
+		cb.cancel(generation)
+		return
+	}
+	failure := cb.isFailure(req, resp, err)
+	slow := cb.SlowCallDuration > 0 && duration >= cb.SlowCallDuration
+
+	now := cb.timeNow()
+	cb.mu.Lock()
+	from := cb.state
+	to := from
+	if generation == cb.generation {
+		to = cb.record(now, failure, slow)
+	}
+	cb.mu.Unlock()
+	cb.notify(from, to)
+}
+
+// cancel releases the probe slot taken by allow without recording
+// the request result.
+func (cb *CircuitBreaker) cancel(generation uint64) {
+	cb.mu.Lock()
+	if generation == cb.generation && cb.state == CircuitHalfOpen {
+		cb.probes--
+	}
+	cb.mu.Unlock()
+}
+
+func (cb *CircuitBreaker) isFailure(req *Request, resp *Response, err error) bool {
+	if cb.IsFailure != nil {
+		return cb.IsFailure(req, resp, err)
+	}
+	return err != nil || (resp != nil && resp.StatusCode() >= StatusInternalServerError)
+}
+
+// record records the request result and returns the new state.
+// cb.mu must be held.
+func (cb *CircuitBreaker) record(now time.Time, failure, slow bool) CircuitState {
+	if cb.state == CircuitHalfOpen {
+		cb.probes--
+		if failure || (slow && cb.SlowCallRateThreshold > 0) {
+			cb.open(now)
+		} else {
+			cb.probeSuccesses++
+			if cb.probeSuccesses >= cb.halfOpenProbes() {
+				cb.setState(CircuitClosed)
+			}
+		}
+		return cb.state
+	}
+
+	b := cb.bucket(now)
+	b.requests++
+	if failure {
+		b.failures++
+		cb.consecutive++
+	} else {
+		cb.consecutive = 0
+	}
+	if slow {
+		b.slow++
+	}
+	if cb.shouldOpen(now) {
+		cb.open(now)
+	}
+	return cb.state
+}
+
+// shouldOpen returns true if the closed breaker must be opened.
+// cb.mu must be held.
+func (cb *CircuitBreaker) shouldOpen(now time.Time) bool {
+	maxConsecutive := cb.ConsecutiveFailures
+	if maxConsecutive == 0 {
+		maxConsecutive = DefaultCircuitConsecutiveFailures
+	}
+	if maxConsecutive > 0 && cb.consecutive >= maxConsecutive {
+		return true
+	}
+	if cb.FailureRateThreshold <= 0 && cb.SlowCallRateThreshold <= 0 {
+		return false
+	}
+
+	idx := cb.bucketIdx(now)
+	requests, failures, slow := 0, 0, 0
+	for i := range cb.buckets {
+		if b := &cb.buckets[i]; idx-b.idx < circuitBuckets {
+			requests += b.requests
+			failures += b.failures
+			slow += b.slow
+		}
+	}
+	minRequests := cb.MinRequests
+	if minRequests <= 0 {
+		minRequests = DefaultCircuitMinRequests
+	}
+	if requests < minRequests {
+		return false
+	}
+	if cb.FailureRateThreshold > 0 && float64(failures) >= cb.FailureRateThreshold*float64(requests) {
+		return true
+	}
+	return cb.SlowCallRateThreshold > 0 && float64(slow) >= cb.SlowCallRateThreshold*float64(requests)
+}
+
+func (cb *CircuitBreaker) bucketIdx(now time.Time) int64 {
+	window := cb.Window
+	if window <= 0 {
+		window = DefaultCircuitWindow
+	}
+	return now.UnixNano() / max(int64(window/circuitBuckets), 1)
+}
+
+// bucket returns the rolling window bucket for now.
+// cb.mu must be held.
+func (cb *CircuitBreaker) bucket(now time.Time) *circuitBucket {
+	idx := cb.bucketIdx(now)
+	b := &cb.buckets[idx%circuitBuckets]
+	if b.idx != idx {
+		*b = circuitBucket{idx: idx}
+	}
+	return b
+}
+
+// refreshState makes the open breaker half-open after OpenDuration.
+// cb.mu must be held.
+func (cb *CircuitBreaker) refreshState(now time.Time) (CircuitState, CircuitState) {
+	from := cb.state
+	if from == CircuitOpen {
+		openDuration := cb.OpenDuration
+		if openDuration <= 0 {
+			openDuration = DefaultCircuitOpenDuration
+		}
+		if now.Sub(cb.openedAt) >= openDuration {
+			cb.setState(CircuitHalfOpen)
+		}
+	}
+	return from, cb.state
+}
+
+// cb.mu must be held.
+func (cb *CircuitBreaker) open(now time.Time) {
+	cb.setState(CircuitOpen)
+	cb.openedAt = now
+}
+
+// setState switches the state and clears the statistics.
+// cb.mu must be held.
+func (cb *CircuitBreaker) setState(state CircuitState) {
+	cb.state = state
+	cb.generation++
+	cb.consecutive = 0
+	cb.probes = 0
+	cb.probeSuccesses = 0
+	cb.buckets = [circuitBuckets]circuitBucket{}
+}
+
+func (cb *CircuitBreaker) notify(from, to CircuitState) {
+	if from != to && cb.OnStateChange != nil {
+		cb.OnStateChange(from, to)
+	}
+}
+
+func (cb *CircuitBreaker) halfOpenProbes() int {
+	if cb.HalfOpenProbes <= 0 {
+		return 1
+	}
+	return cb.HalfOpenProbes
+}
+
+func (cb *CircuitBreaker) timeNow() time.Time {
+	if cb.now != nil {
+		return cb.now()
+	}
+	return time.Now()
+}
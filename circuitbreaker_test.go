@@ -0,0 +1,301 @@
+package fasthttp
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testCircuitBreaker struct {
+	*CircuitBreaker
+	now         time.Time
+	transitions []string
+	mu          sync.Mutex
+}
+
+func newTestCircuitBreaker(cb *CircuitBreaker) *testCircuitBreaker {
+	tcb := &testCircuitBreaker{CircuitBreaker: cb, now: time.Unix(1000, 0)}
+	cb.now = func() time.Time { return tcb.now }
+	cb.OnStateChange = func(from, to CircuitState) {
+		tcb.mu.Lock()
+		tcb.transitions = append(tcb.transitions, from.String()+"->"+to.String())
+		tcb.mu.Unlock()
+	}
+	return tcb
+}
+
+func (cb *testCircuitBreaker) call(t *testing.T, err error, duration time.Duration) error {
+	t.Helper()
+
+	generation, allowErr := cb.allow()
+	if allowErr != nil {
+		return allowErr
+	}
+	req := &Request{}
+	cb.done(generation, req, nil, err, duration)
+	return nil
+}
+
+func (cb *testCircuitBreaker) expectState(t *testing.T, expected CircuitState, transitions string) {
+	t.Helper()
+
+	if state := cb.State(); state != expected {
+		t.Fatalf("unexpected state %s. Expecting %s", state, expected)
+	}
+	cb.mu.Lock()
+	s := strings.Join(cb.transitions, " ")
+	cb.mu.Unlock()
+	if s != transitions {
+		t.Fatalf("unexpected transitions %q. Expecting %q", s, transitions)
+	}
+}
+
+var errUpstream = errors.New("upstream failure")
+
+func TestCircuitBreakerConsecutiveFailures(t *testing.T) {
+	t.Parallel()
+
+	cb := newTestCircuitBreaker(&CircuitBreaker{ConsecutiveFailures: 3, OpenDuration: time.Second})
+	for i := 0; i < 5; i++ {
+		// Successes reset consecutive failures.
+		cb.call(t, errUpstream, 0) //nolint:errcheck
+		cb.call(t, nil, 0)         //nolint:errcheck
+	}
+	cb.expectState(t, CircuitClosed, "")
+
+	for i := 0; i < 3; i++ {
+		if err := cb.call(t, errUpstream, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	cb.expectState(t, CircuitOpen, "closed->open")
+	if err := cb.call(t, nil, 0); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrCircuitOpen)
+	}
+
+	// A failed probe opens the breaker again.
+	cb.now = cb.now.Add(time.Second)
+	cb.expectState(t, CircuitHalfOpen, "closed->open open->half-open")
+	if err := cb.call(t, errUpstream, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cb.expectState(t, CircuitOpen, "closed->open open->half-open half-open->open")
+
+	cb.now = cb.now.Add(time.Second)
+	if err := cb.call(t, nil, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cb.expectState(t, CircuitClosed, "closed->open open->half-open half-open->open open->half-open half-open->closed")
+}
+
+func TestCircuitBreakerHalfOpenProbes(t *testing.T) {
+	t.Parallel()
+
+	cb := newTestCircuitBreaker(&CircuitBreaker{ConsecutiveFailures: 1, HalfOpenProbes: 2})
+	cb.call(t, errUpstream, 0) //nolint:errcheck
+	cb.now = cb.now.Add(DefaultCircuitOpenDuration)
+
+	g1, err := cb.allow()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g2, err := cb.allow()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = cb.allow()
+	if !errors.Is(err, ErrTooManyProbes) || !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrTooManyProbes)
+	}
+	if cb.available() {
+		t.Fatal("the breaker with all the probes in flight mustn't be available")
+	}
+
+	req := &Request{}
+	cb.done(g1, req, nil, nil, 0)
+	cb.expectState(t, CircuitHalfOpen, "closed->open open->half-open")
+	cb.done(g2, req, nil, nil, 0)
+	cb.expectState(t, CircuitClosed, "closed->open open->half-open half-open->closed")
+
+	// Results of the requests started before the state change are ignored.
+	cb.done(g1, req, nil, errUpstream, 0)
+	cb.expectState(t, CircuitClosed, "closed->open open->half-open half-open->closed")
+}
+
+func TestCircuitBreakerFailureRate(t *testing.T) {
+	t.Parallel()
+
+	cb := newTestCircuitBreaker(&CircuitBreaker{
+		ConsecutiveFailures:  -1,
+		FailureRateThreshold: 0.5,
+		MinRequests:          10,
+		Window:               10 * time.Second,
+	})
+	// Failures below MinRequests don't open the breaker.
+	for i := 0; i < 9; i++ {
+		cb.call(t, errUpstream, 0) //nolint:errcheck
+	}
+	cb.expectState(t, CircuitClosed, "")
+
+	// Old requests leave the rolling window.
+	cb.now = cb.now.Add(10 * time.Second)
+	for i := 0; i < 6; i++ {
+		cb.call(t, nil, 0) //nolint:errcheck
+	}
+	for i := 0; i < 5; i++ {
+		cb.call(t, errUpstream, 0) //nolint:errcheck
+	}
+	cb.expectState(t, CircuitClosed, "")
+	cb.call(t, errUpstream, 0) //nolint:errcheck
+	cb.expectState(t, CircuitOpen, "closed->open")
+}
+
+func TestCircuitBreakerSlowCalls(t *testing.T) {
+	t.Parallel()
+
+	cb := newTestCircuitBreaker(&CircuitBreaker{
+		SlowCallDuration:      100 * time.Millisecond,
+		SlowCallRateThreshold: 0.5,
+		MinRequests:           4,
+	})
+	cb.call(t, nil, time.Millisecond)     //nolint:errcheck
+	cb.call(t, nil, time.Millisecond)     //nolint:errcheck
+	cb.call(t, nil, 200*time.Millisecond) //nolint:errcheck
+	cb.expectState(t, CircuitClosed, "")
+	cb.call(t, nil, 200*time.Millisecond) //nolint:errcheck
+	cb.expectState(t, CircuitOpen, "closed->open")
+
+	// Slow probes open the breaker again.
+	cb.now = cb.now.Add(DefaultCircuitOpenDuration)
+	cb.call(t, nil, time.Second) //nolint:errcheck
+	cb.expectState(t, CircuitOpen, "closed->open open->half-open half-open->open")
+}
+
+func TestHostClientCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+	var failing atomic.Bool
+	failing.Store(true)
+	ln := startRetryServer(t, func(ctx *RequestCtx) {
+		requests.Add(1)
+		if failing.Load() {
+			ctx.SetStatusCode(StatusInternalServerError)
+		}
+	})
+	cb := newTestCircuitBreaker(&CircuitBreaker{ConsecutiveFailures: 2})
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		CircuitBreaker: cb.CircuitBreaker,
+	}
+
+	for i := 0; i < 2; i++ {
+		statusCode, _, err := c.Get(nil, "http://foobar/")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if statusCode != StatusInternalServerError {
+			t.Fatalf("unexpected status code %d", statusCode)
+		}
+	}
+	if _, _, err := c.Get(nil, "http://foobar/"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrCircuitOpen)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Fatalf("unexpected number of requests %d. Expecting 2", n)
+	}
+
+	failing.Store(false)
+	cb.now = cb.now.Add(DefaultCircuitOpenDuration)
+	statusCode, _, err := c.Get(nil, "http://foobar/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if statusCode != StatusOK {
+		t.Fatalf("unexpected status code %d", statusCode)
+	}
+	cb.expectState(t, CircuitClosed, "closed->open open->half-open half-open->closed")
+}
+
+func TestLBClientCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	newClient := func(statusCode int) (*HostClient, *atomic.Int32) {
+		var requests atomic.Int32
+		ln := startRetryServer(t, func(ctx *RequestCtx) {
+			requests.Add(1)
+			ctx.SetStatusCode(statusCode)
+		})
+		return &HostClient{
+			Addr: "foobar",
+			Dial: func(addr string) (net.Conn, error) {
+				return ln.Dial()
+			},
+		}, &requests
+	}
+	bad, badRequests := newClient(StatusServiceUnavailable)
+	good, goodRequests := newClient(StatusOK)
+
+	breakers := make(map[BalancingClient]*CircuitBreaker)
+	lb := &LBClient{
+		Clients: []BalancingClient{bad, good},
+		CircuitBreaker: func(c BalancingClient) *CircuitBreaker {
+			cb := &CircuitBreaker{ConsecutiveFailures: 1}
+			breakers[c] = cb
+			return cb
+		},
+	}
+
+	req := AcquireRequest()
+	resp := AcquireResponse()
+	defer ReleaseRequest(req)
+	defer ReleaseResponse(resp)
+	req.SetRequestURI("http://foobar/")
+	for i := 0; i < 10; i++ {
+		if err := lb.Do(req, resp); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := badRequests.Load(); n != 1 {
+		t.Fatalf("unexpected number of requests to the failing client %d. Expecting 1", n)
+	}
+	if n := goodRequests.Load(); n != 9 {
+		t.Fatalf("unexpected number of requests to the healthy client %d. Expecting 9", n)
+	}
+	if state := breakers[bad].State(); state != CircuitOpen {
+		t.Fatalf("unexpected state %s. Expecting %s", state, CircuitOpen)
+	}
+
+	// The requests fail fast when all the breakers are open.
+	breakers[good].mu.Lock()
+	breakers[good].open(time.Now())
+	breakers[good].mu.Unlock()
+	if err := lb.Do(req, resp); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrCircuitOpen)
+	}
+
+	// HostClient breakers are respected too.
+	hcBreaker := &CircuitBreaker{}
+	hcBreaker.mu.Lock()
+	hcBreaker.open(time.Now())
+	hcBreaker.mu.Unlock()
+	bad2, _ := newClient(StatusOK)
+	bad2.CircuitBreaker = hcBreaker
+	good2, good2Requests := newClient(StatusOK)
+	lb2 := &LBClient{Clients: []BalancingClient{bad2, good2}}
+	for i := 0; i < 3; i++ {
+		if err := lb2.Do(req, resp); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := good2Requests.Load(); n != 3 {
+		t.Fatalf("unexpected number of requests to the healthy client %d. Expecting 3", n)
+	}
+}
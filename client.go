@@ -3,11 +3,14 @@ package fasthttp
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -202,6 +205,13 @@ type Client struct {
 	// Default TLS config is used if not set.
 	TLSConfig *tls.Config
 
+	// Logger for logging dial errors, retries and connections closed
+	// on errors. Records with attributes are logged if Logger
+	// is a StructuredLogger.
+	//
+	// Nothing is logged by default.
+	Logger Logger
+
 	// RetryIf controls whether a retry should be attempted after an error.
 	//
 	// By default will use isIdempotent function.
@@ -216,12 +226,45 @@ type Client struct {
 	// This field is only effective within the range of MaxIdemponentCallAttempts.
 	RetryIfErr RetryIfErrFunc
 
+	// RetryPolicy controls the delays between retries and the retries
+	// of the responses with retryable status codes.
+	//
+	// RetryPolicy is passed to the HostClients created by the Client.
+	//
+	// By default requests are retried immediately and only on errors.
+	RetryPolicy *RetryPolicy
+
 	// ConfigureClient configures the fasthttp.HostClient.
 	ConfigureClient func(hc *HostClient) error
 
+	// Interceptors wrap every request made via the client, including
+	// the requests made by DoTimeout, DoDeadline, DoContext, DoRedirects,
+	// Get and Post. The first interceptor is the outermost one.
+	//
+	// Interceptors are passed to the HostClients created by the Client.
+	// They are called for each redirect hop while following redirects.
+	// ConfigureClient may append per-host interceptors.
+	//
+	// WARNING: Interceptors mustn't be changed after the first request.
+	Interceptors []Interceptor
+
+	// CookieJar stores the cookies received in responses and attaches
+	// them to the subsequent requests, including the requests made while
+	// following redirects.
+	//
+	// Cookies set in the request by the caller take precedence over
+	// the cookies with the same names from the jar.
+	//
+	// By default cookies aren't stored. See the cookiejar package for
+	// the in-memory implementation.
+	CookieJar CookieJar
+
 	m  map[string]*HostClient
 	ms map[string]*HostClient
 
+	// counters of the HostClients removed by mCleaner.
+	removedStats ConnPoolStats
+
 	// Client name. Used in User-Agent request header.
 	//
 	// Default client name is used if not set.
@@ -243,6 +286,12 @@ type Client struct {
 	// By default connection duration is unlimited.
 	MaxConnDuration time.Duration
 
+	// MaxConnDurationJitter is the maximum random duration subtracted
+	// from MaxConnDuration of every connection.
+	//
+	// By default all the connections live for MaxConnDuration.
+	MaxConnDurationJitter time.Duration
+
 	// Maximum number of attempts for idempotent calls.
 	//
 	// DefaultMaxIdemponentCallAttempts is used if not set.
@@ -520,9 +569,26 @@ func (c *Client) Do(req *Request, resp *Response) error {
 
 	atomic.AddInt32(&hc.pendingClientRequests, 1)
 	defer atomic.AddInt32(&hc.pendingClientRequests, -1)
+	if c.CookieJar != nil {
+		return doCookieJar(c.CookieJar, hc, req, resp)
+	}
 	return hc.Do(req, resp)
 }
 
+// DoContext performs the given request and waits for response until
+// ctx is done.
+//
+// Connection acquisition, dialing, TLS handshake, request writing
+// and response reading are aborted when ctx is canceled. The returned
+// error wraps ctx.Err() in this case, so errors.Is(err, context.Canceled)
+// and errors.Is(err, context.DeadlineExceeded) may be used for checking it.
+// Errors caused by the ctx deadline also wrap ErrTimeout.
+//
+// See Client.Do for the rest of the details.
+func (c *Client) DoContext(ctx context.Context, req *Request, resp *Response) error {
+	return clientDoContext(ctx, req, resp, c)
+}
+
 func (c *Client) hostClient(host []byte, isTLS bool) (*HostClient, error) {
 	m := c.m
 	if isTLS {
@@ -551,9 +617,11 @@ func (c *Client) hostClient(host []byte, isTLS bool) (*HostClient, error) {
 		DialDualStack:                 c.DialDualStack,
 		IsTLS:                         isTLS,
 		TLSConfig:                     c.TLSConfig,
+		Logger:                        c.Logger,
 		MaxConns:                      c.MaxConnsPerHost,
 		MaxIdleConnDuration:           c.MaxIdleConnDuration,
 		MaxConnDuration:               c.MaxConnDuration,
+		MaxConnDurationJitter:         c.MaxConnDurationJitter,
 		MaxIdemponentCallAttempts:     c.MaxIdemponentCallAttempts,
 		ReadBufferSize:                c.ReadBufferSize,
 		WriteBufferSize:               c.WriteBufferSize,
@@ -565,6 +633,8 @@ func (c *Client) hostClient(host []byte, isTLS bool) (*HostClient, error) {
 		MaxConnWaitTimeout:            c.MaxConnWaitTimeout,
 		RetryIf:                       c.RetryIf,
 		RetryIfErr:                    c.RetryIfErr,
+		RetryPolicy:                   c.RetryPolicy,
+		Interceptors:                  slices.Clone(c.Interceptors),
 		ConnPoolStrategy:              c.ConnPoolStrategy,
 		StreamResponseBody:            c.StreamResponseBody,
 		clientReaderPool:              &c.readerPool,
@@ -614,10 +684,15 @@ func (c *Client) mCleaner(m map[string]*HostClient) {
 		c.mLock.Lock()
 		for k, v := range m {
 			v.connsLock.Lock()
-			if v.connsCount == 0 && atomic.LoadInt32(&v.pendingClientRequests) == 0 {
+			removed := v.connsCount == 0 && atomic.LoadInt32(&v.pendingClientRequests) == 0
+			if removed {
 				delete(m, k)
 			}
 			v.connsLock.Unlock()
+			if removed {
+				s := v.Stats()
+				c.removedStats.addCounters(&s)
+			}
 		}
 		if len(m) == 0 {
 			mustStop = true
@@ -693,6 +768,30 @@ type RetryIfFunc func(request *Request) bool
 // the request function will immediately return with the `err`.
 type RetryIfErrFunc func(request *Request, attempts int, err error) (resetTimeout bool, retry bool)
 
+// DoFunc performs the given request and fills the given response
+// like Client.Do.
+type DoFunc func(req *Request, resp *Response) error
+
+// Interceptor wraps request execution with cross-cutting behavior
+// like authentication, logging, metrics, retries, signing or tracing.
+//
+// The DoFunc returned by Interceptor performs the request via next.
+// It may modify the request before calling next, inspect or modify
+// the response after next returns, call next multiple times for retrying
+// the request or return without calling next for short-circuiting it.
+//
+// The returned DoFunc is called concurrently.
+type Interceptor func(next DoFunc) DoFunc
+
+// chainInterceptors returns do wrapped by the given interceptors.
+// The first interceptor is the outermost one.
+func chainInterceptors(do DoFunc, interceptors []Interceptor) DoFunc {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		do = interceptors[i](do)
+	}
+	return do
+}
+
 // RoundTripper wraps every request/response.
 type RoundTripper interface {
 	RoundTrip(hc *HostClient, req *Request, resp *Response) (retry bool, err error)
@@ -741,6 +840,13 @@ type HostClient struct {
 	// Optional TLS config.
 	TLSConfig *tls.Config
 
+	// Logger for logging dial errors, retries and connections closed
+	// on errors. Records with attributes are logged if Logger
+	// is a StructuredLogger.
+	//
+	// Nothing is logged by default.
+	Logger Logger
+
 	// RetryIf controls whether a retry should be attempted after an error.
 	// By default, it uses the isIdempotent function.
 	//
@@ -754,6 +860,42 @@ type HostClient struct {
 	// This field is only effective within the range of MaxIdemponentCallAttempts.
 	RetryIfErr RetryIfErrFunc
 
+	// RetryPolicy controls the delays between retries and the retries
+	// of the responses with retryable status codes.
+	//
+	// By default requests are retried immediately and only on errors.
+	RetryPolicy *RetryPolicy
+
+	// HedgePolicy sends copies of slow idempotent requests
+	// and returns the first successful response. The copies use idle
+	// connections first, so they may be sent to the same address.
+	// Every copy is retried according to RetryPolicy.
+	//
+	// By default requests aren't hedged.
+	HedgePolicy *HedgePolicy
+
+	// CircuitBreaker fails requests fast with ErrCircuitOpen while
+	// the upstream is failing. Every attempt is accounted by the breaker.
+	//
+	// Client may set per-host circuit breakers via ConfigureClient.
+	//
+	// By default requests are always sent.
+	CircuitBreaker *CircuitBreaker
+
+	// Interceptors wrap every request made via the HostClient, including
+	// the requests made by DoTimeout, DoDeadline, DoContext, DoRedirects,
+	// Get and Post. The first interceptor is the outermost one.
+	//
+	// Interceptors wrap the whole request execution including the retries
+	// controlled by RetryIf and RetryIfErr and the copies sent
+	// by HedgePolicy.
+	//
+	// WARNING: Interceptors mustn't be changed after the first request.
+	Interceptors []Interceptor
+
+	interceptorsOnce sync.Once
+	interceptedDo    DoFunc
+
 	connsWait *wantConnQueue
 
 	tlsConfigMap map[string]*tls.Config
@@ -792,6 +934,22 @@ type HostClient struct {
 	// By default connection duration is unlimited.
 	MaxConnDuration time.Duration
 
+	// MaxConnDurationJitter is the maximum random duration subtracted
+	// from MaxConnDuration of every connection, so the connections dialed
+	// together aren't closed together.
+	//
+	// By default all the connections live for MaxConnDuration.
+	MaxConnDurationJitter time.Duration
+
+	// MinIdleConns is the number of idle connections kept in the pool.
+	// The missing connections are dialed in background after the first
+	// request, while the idle connections aren't closed
+	// after MaxIdleConnDuration if there are no more than MinIdleConns
+	// of them. Call Prewarm for dialing them before the first request.
+	//
+	// By default idle connections aren't dialed in advance.
+	MinIdleConns int
+
 	// Idle keep-alive connections are closed after this duration.
 	//
 	// By default idle connections are closed
@@ -843,8 +1001,20 @@ type HostClient struct {
 	// Connection pool strategy. Can be either LIFO or FIFO (default).
 	ConnPoolStrategy ConnPoolStrategyType
 
+	counters connPoolCounters
+
 	connsCount int
 
+	// fillMu serializes dialing of MinIdleConns, filling is set while
+	// they are dialed in background.
+	fillMu  sync.Mutex
+	filling atomic.Bool
+
+	// Consecutive failed background refills and the Unix time
+	// in nanoseconds when the next refill may start.
+	fillFailures atomic.Int32
+	fillRetryAt  atomic.Int64
+
 	connsLock sync.Mutex
 
 	addrsLock        sync.Mutex
@@ -922,6 +1092,12 @@ type clientConn struct {
 
 	createdTime time.Time
 	lastUseTime time.Time
+
+	// addr is the HostClient.Addr entry the connection has been dialed to.
+	addr string
+
+	// maxDuration is HostClient.MaxConnDuration with jitter.
+	maxDuration time.Duration
 }
 
 // Conn returns the underlying net.Conn associated with the client connection.
@@ -999,6 +1175,54 @@ type clientDoer interface {
 	Do(req *Request, resp *Response) error
 }
 
+func clientDoContext(ctx context.Context, req *Request, resp *Response, c clientDoer) error {
+	if err := ctx.Err(); err != nil {
+		return contextError(err)
+	}
+
+	timeout := req.timeout
+	ctxTimeout := false
+	if deadline, ok := ctx.Deadline(); ok {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return contextError(context.DeadlineExceeded)
+		}
+		if timeout <= 0 || d < timeout {
+			req.timeout = d
+			ctxTimeout = true
+		}
+	}
+	prevCtx := req.ctx
+	req.ctx = ctx
+
+	err := c.Do(req, resp)
+
+	req.ctx = prevCtx
+	req.timeout = timeout
+
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = contextError(ctxErr)
+		} else if ctxTimeout && errors.Is(err, ErrTimeout) {
+			// The request timed out at the ctx deadline slightly
+			// before ctx has been done.
+			err = contextError(context.DeadlineExceeded)
+		}
+	}
+	return err
+}
+
+// contextError returns the error returned by DoContext when ctx is done.
+//
+// Deadline errors wrap ErrTimeout in addition to context.DeadlineExceeded,
+// so the existing timeout checks keep working.
+func contextError(ctxErr error) error {
+	if errors.Is(ctxErr, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrTimeout, ctxErr)
+	}
+	return fmt.Errorf("request canceled: %w", ctxErr)
+}
+
 func clientGetURL(dst []byte, url string, c clientDoer) (statusCode int, body []byte, err error) {
 	req := AcquireRequest()
 
@@ -1143,10 +1367,12 @@ func doRequestFollowRedirects(
 	req *Request, resp *Response, url string, maxRedirectsCount int, c clientDoer,
 ) (statusCode int, body []byte, err error) {
 	redirectsCount := 0
+	req.cookieSite = url
 
 	for {
 		req.SetRequestURI(url)
 		if err := req.parseURI(); err != nil {
+			req.cookieSite = ""
 			return 0, nil, err
 		}
 
@@ -1174,6 +1400,7 @@ func doRequestFollowRedirects(
 			req.Header.SetMethod(MethodGet)
 		}
 	}
+	req.cookieSite = ""
 
 	return statusCode, body, err
 }
@@ -1327,6 +1554,20 @@ func (c *HostClient) DoRedirects(req *Request, resp *Response, maxRedirectsCount
 	return err
 }
 
+// DoContext performs the given request and waits for response until
+// ctx is done.
+//
+// Connection acquisition, dialing, TLS handshake, request writing
+// and response reading are aborted when ctx is canceled. The returned
+// error wraps ctx.Err() in this case, so errors.Is(err, context.Canceled)
+// and errors.Is(err, context.DeadlineExceeded) may be used for checking it.
+// Errors caused by the ctx deadline also wrap ErrTimeout.
+//
+// See HostClient.Do for the rest of the details.
+func (c *HostClient) DoContext(ctx context.Context, req *Request, resp *Response) error {
+	return clientDoContext(ctx, req, resp, c)
+}
+
 // Do performs the given http request and sets the corresponding response.
 //
 // Request must contain at least non-zero RequestURI with full url (including
@@ -1342,12 +1583,38 @@ func (c *HostClient) DoRedirects(req *Request, resp *Response, maxRedirectsCount
 // It is recommended obtaining req and resp via AcquireRequest
 // and AcquireResponse in performance-critical code.
 func (c *HostClient) Do(req *Request, resp *Response) error {
+	if len(c.Interceptors) > 0 {
+		c.interceptorsOnce.Do(func() {
+			c.interceptedDo = chainInterceptors(c.doHedged, c.Interceptors)
+		})
+		return c.interceptedDo(req, resp)
+	}
+	return c.doHedged(req, resp)
+}
+
+// doHedged performs the request according to HedgePolicy.
+func (c *HostClient) doHedged(req *Request, resp *Response) error {
+	if p := c.HedgePolicy; p != nil && !c.StreamResponseBody && p.hedgeable(req, resp) {
+		return p.do(req, resp, c.doAttempts)
+	}
+	return c.doAttempts(req, resp)
+}
+
+// doAttempts performs the request retrying it according
+// to MaxIdemponentCallAttempts, RetryIf, RetryIfErr and RetryPolicy.
+// Every attempt is guarded by CircuitBreaker.
+func (c *HostClient) doAttempts(req *Request, resp *Response) error {
 	var (
 		err          error
 		retry        bool
 		resetTimeout bool
+		delay        time.Duration
 	)
+	policy := c.RetryPolicy
 	maxAttempts := c.MaxIdemponentCallAttempts
+	if policy != nil && policy.MaxAttempts > 0 {
+		maxAttempts = policy.MaxAttempts
+	}
 	if maxAttempts <= 0 {
 		maxAttempts = DefaultMaxIdemponentCallAttempts
 	}
@@ -1366,9 +1633,16 @@ func (c *HostClient) Do(req *Request, resp *Response) error {
 	if retryFunc == nil {
 		retryFunc = isIdempotent
 	}
+	if policy != nil && policy.Budget != nil {
+		policy.Budget.onRequest()
+	}
 
 	atomic.AddInt32(&c.pendingRequests, 1)
 	for {
+		if req.ctx != nil && req.ctx.Err() != nil {
+			err = req.ctx.Err()
+			break
+		}
 		// If the original timeout was set, we need to update
 		// the one set on the request to reflect the remaining time.
 		if timeout > 0 {
@@ -1379,12 +1653,37 @@ func (c *HostClient) Do(req *Request, resp *Response) error {
 			}
 		}
 
+		var (
+			generation uint64
+			start      time.Time
+		)
+		if cb := c.CircuitBreaker; cb != nil {
+			var cbErr error
+			if generation, cbErr = cb.allow(); cbErr != nil {
+				// Keep the result of the previous attempt if any.
+				if attempts == 0 {
+					err = cbErr
+				}
+				break
+			}
+			start = time.Now()
+		}
+
 		retry, err = c.do(req, resp)
-		if err == nil || !retry {
+		if cb := c.CircuitBreaker; cb != nil {
+			cb.done(generation, req, resp, err, time.Since(start))
+		}
+		// Responses with retryable status codes are retried
+		// like errors, but the last one is returned to the caller.
+		statusRetry := err == nil && policy != nil && resp != nil && policy.retryStatus(resp.StatusCode())
+		if !statusRetry && (err == nil || !retry) {
+			break
+		}
+		if req.ctx != nil && req.ctx.Err() != nil {
 			break
 		}
 
-		if hasBodyStream {
+		if hasBodyStream && req.getBody == nil {
 			break
 		}
 		// Path prioritization based on ease of computation
@@ -1393,7 +1692,9 @@ func (c *HostClient) Do(req *Request, resp *Response) error {
 		if attempts >= maxAttempts {
 			break
 		}
-		if c.RetryIfErr != nil {
+		if statusRetry {
+			resetTimeout, retry = false, retryFunc(req)
+		} else if c.RetryIfErr != nil {
 			resetTimeout, retry = c.RetryIfErr(req, attempts, err)
 		} else {
 			retry = retryFunc(req)
@@ -1401,6 +1702,31 @@ func (c *HostClient) Do(req *Request, resp *Response) error {
 		if !retry {
 			break
 		}
+		if policy != nil {
+			var retryResp *Response
+			if statusRetry {
+				retryResp = resp
+			}
+			if delay, retry = policy.delay(attempts, delay, retryResp, time.Now()); !retry {
+				break
+			}
+			if timeout > 0 && !resetTimeout && delay >= time.Until(deadline) {
+				break
+			}
+			if policy.Budget != nil && !policy.Budget.withdraw() {
+				break
+			}
+		}
+		if hasBodyStream && !req.replayBodyStream() {
+			break
+		}
+		if c.Logger != nil {
+			c.logRetry(attempts, delay, resp, err, statusRetry)
+		}
+		if sleepErr := sleepContext(req.ctx, delay); sleepErr != nil {
+			err = sleepErr
+			break
+		}
 		if timeout > 0 && resetTimeout {
 			deadline = time.Now().Add(timeout)
 		}
@@ -1425,6 +1751,25 @@ func (c *HostClient) PendingRequests() int {
 	return int(atomic.LoadInt32(&c.pendingRequests))
 }
 
+// logRetry logs the retry after the given number of attempts.
+func (c *HostClient) logRetry(attempts int, delay time.Duration, resp *Response, err error, statusRetry bool) {
+	attrs := []slog.Attr{
+		slog.String("addr", c.Addr),
+		slog.Int("attempts", attempts),
+		slog.Duration("delay", delay),
+	}
+	if statusRetry {
+		statusCode := resp.StatusCode()
+		logRecord(c.Logger, slog.LevelInfo, "HostClient retrying request",
+			append(attrs, slog.Int("status_code", statusCode)),
+			"retrying request to %q after %d attempts: status code %d", c.Addr, attempts, statusCode)
+		return
+	}
+	logRecord(c.Logger, slog.LevelInfo, "HostClient retrying request",
+		append(attrs, slog.Any("error", err)),
+		"retrying request to %q after %d attempts: %v", c.Addr, attempts, err)
+}
+
 func isIdempotent(req *Request) bool {
 	return req.Header.IsGet() || req.Header.IsHead() || req.Header.IsPut()
 }
@@ -1541,9 +1886,35 @@ func (c *HostClient) SetMaxConns(newMaxConns int) {
 }
 
 func (c *HostClient) AcquireConn(reqTimeout time.Duration, connectionClose bool) (cc *clientConn, err error) {
+	return c.acquireConn(context.Background(), reqTimeout, connectionClose, nil)
+}
+
+// acquireConn acquires a connection like AcquireConn. It stops waiting
+// for a free connection and dialing when ctx is done.
+func (c *HostClient) acquireConn(
+	ctx context.Context, reqTimeout time.Duration, connectionClose bool, trace *ClientTrace,
+) (cc *clientConn, err error) {
 	createConn := false
 	startCleaner := false
 
+	// Failed acquisitions don't count, so they don't deflate
+	// ConnPoolStats.ReuseRatio.
+	defer func() {
+		if err == nil {
+			c.counters.connsAcquired.Add(1)
+		}
+	}()
+
+	var waitStart time.Time
+	if trace != nil {
+		trace.getConn(c.Addr)
+		defer func() {
+			if err == nil {
+				trace.gotConn(cc, waitStart)
+			}
+		}()
+	}
+
 	var n int
 	c.connsLock.Lock()
 	n = len(c.conns)
@@ -1577,9 +1948,11 @@ func (c *HostClient) AcquireConn(reqTimeout time.Duration, connectionClose bool)
 			return nil, ErrConnPoolStrategyNotImpl
 		}
 	}
+	c.maybeFillIdleConns()
 	c.connsLock.Unlock()
 
 	if cc != nil {
+		c.counters.connsReused.Add(1)
 		return cc, nil
 	}
 	if !createConn {
@@ -1602,6 +1975,9 @@ func (c *HostClient) AcquireConn(reqTimeout time.Duration, connectionClose bool)
 		}
 
 		// wait for a free connection
+		if trace != nil {
+			waitStart = time.Now()
+		}
 		tc := AcquireTimer(timeout)
 		defer ReleaseTimer(tc)
 
@@ -1618,7 +1994,13 @@ func (c *HostClient) AcquireConn(reqTimeout time.Duration, connectionClose bool)
 
 		select {
 		case <-w.ready:
+			if w.err == nil && !w.conn.lastUseTime.IsZero() {
+				c.counters.connsReused.Add(1)
+			}
 			return w.conn, w.err
+		case <-ctx.Done():
+			c.connsWait.failedWaiters.Add(1)
+			return nil, ctx.Err()
 		case <-tc.C:
 			c.connsWait.failedWaiters.Add(1)
 			if timeoutOverridden {
@@ -1632,12 +2014,12 @@ func (c *HostClient) AcquireConn(reqTimeout time.Duration, connectionClose bool)
 		go c.connsCleaner()
 	}
 
-	conn, err := c.dialHostHard(reqTimeout)
+	conn, addr, err := c.dialHostHard(ctx, reqTimeout, trace)
 	if err != nil {
 		c.decConnsCount()
 		return nil, err
 	}
-	cc = acquireClientConn(conn)
+	cc = c.newClientConn(conn, addr)
 
 	return cc, nil
 }
@@ -1653,14 +2035,14 @@ func (c *HostClient) queueForIdle(w *wantConn) {
 }
 
 func (c *HostClient) dialConnFor(w *wantConn) {
-	conn, err := c.dialHostHard(0)
+	conn, addr, err := c.dialHostHard(context.Background(), 0, nil)
 	if err != nil {
 		w.tryDeliver(nil, err)
 		c.decConnsCount()
 		return
 	}
 
-	cc := acquireClientConn(conn)
+	cc := c.newClientConn(conn, addr)
 	if !w.tryDeliver(cc, nil) {
 		// not delivered, return idle connection
 		c.ReleaseConn(cc)
@@ -1681,7 +2063,7 @@ func (c *HostClient) CloseIdleConnections() {
 	c.connsLock.Unlock()
 
 	for _, cc := range scratch {
-		c.CloseConn(cc)
+		c.closeConn(cc, connCloseIdle)
 	}
 }
 
@@ -1701,7 +2083,8 @@ func (c *HostClient) connsCleaner() {
 		conns := c.conns
 		n := len(conns)
 		i := 0
-		for i < n && currentTime.Sub(conns[i].lastUseTime) > maxIdleConnDuration {
+		// Keep MinIdleConns connections.
+		for i < n-c.MinIdleConns && currentTime.Sub(conns[i].lastUseTime) > maxIdleConnDuration {
 			i++
 		}
 		sleepFor := maxIdleConnDuration
@@ -1722,7 +2105,7 @@ func (c *HostClient) connsCleaner() {
 
 		// Close idle connections.
 		for i, cc := range scratch {
-			c.CloseConn(cc)
+			c.closeConn(cc, connCloseIdle)
 			scratch[i] = nil
 		}
 
@@ -1742,9 +2125,7 @@ func (c *HostClient) connsCleaner() {
 }
 
 func (c *HostClient) CloseConn(cc *clientConn) {
-	c.decConnsCount()
-	cc.c.Close()
-	releaseClientConn(cc)
+	c.closeConn(cc, connCloseError)
 }
 
 func (c *HostClient) decConnsCount() {
@@ -1938,7 +2319,7 @@ func (c *HostClient) nextAddr() string {
 	return addr
 }
 
-func (c *HostClient) dialHostHard(dialTimeout time.Duration) (conn net.Conn, err error) {
+func (c *HostClient) dialHostHard(ctx context.Context, dialTimeout time.Duration, trace *ClientTrace) (conn net.Conn, addr string, err error) {
 	// use dialTimeout to control the timeout of each dial. It does not work if dialTimeout is 0 or if
 	// c.DialTimeout has not been set and c.Dial has been set.
 	// attempt to dial all the available hosts before giving up.
@@ -1958,18 +2339,28 @@ func (c *HostClient) dialHostHard(dialTimeout time.Duration) (conn net.Conn, err
 	}
 	deadline := time.Now().Add(timeout)
 	for n > 0 {
-		addr := c.nextAddr()
+		addr = c.nextAddr()
 		tlsConfig := c.cachedTLSConfig(addr)
-		conn, err = dialAddr(addr, c.Dial, c.DialTimeout, c.DialDualStack, c.IsTLS, tlsConfig, dialTimeout, c.WriteTimeout)
+		conn, err = dialAddr(ctx, addr, c.Dial, c.DialTimeout, c.DialDualStack, c.IsTLS, tlsConfig, dialTimeout, c.WriteTimeout, trace)
+		c.counters.dials.Add(1)
 		if err == nil {
-			return conn, nil
+			return conn, addr, nil
+		}
+		c.counters.dialErrors.Add(1)
+		if c.Logger != nil {
+			logRecord(c.Logger, slog.LevelWarn, "HostClient dial error",
+				[]slog.Attr{slog.String("addr", addr), slog.Any("error", err)},
+				"cannot dial %q: %v", addr, err)
+		}
+		if ctx.Err() != nil {
+			break
 		}
 		if time.Since(deadline) >= 0 {
 			break
 		}
 		n--
 	}
-	return nil, err
+	return nil, "", err
 }
 
 func (c *HostClient) cachedTLSConfig(addr string) *tls.Config {
@@ -1994,7 +2385,7 @@ func (c *HostClient) cachedTLSConfig(addr string) *tls.Config {
 // ErrTLSHandshakeTimeout indicates there is a timeout from tls handshake.
 var ErrTLSHandshakeTimeout = errors.New("tls handshake timed out")
 
-func tlsClientHandshake(rawConn net.Conn, tlsConfig *tls.Config, deadline time.Time) (_ net.Conn, retErr error) {
+func tlsClientHandshake(ctx context.Context, rawConn net.Conn, tlsConfig *tls.Config, deadline time.Time) (_ net.Conn, retErr error) {
 	defer func() {
 		if retErr != nil {
 			rawConn.Close()
@@ -2005,7 +2396,7 @@ func tlsClientHandshake(rawConn net.Conn, tlsConfig *tls.Config, deadline time.T
 	if err != nil {
 		return nil, err
 	}
-	err = conn.Handshake()
+	err = conn.HandshakeContext(ctx)
 	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 		return nil, ErrTLSHandshakeTimeout
 	}
@@ -2020,11 +2411,14 @@ func tlsClientHandshake(rawConn net.Conn, tlsConfig *tls.Config, deadline time.T
 }
 
 func dialAddr(
-	addr string, dial DialFunc, dialWithTimeout DialFuncWithTimeout, dialDualStack, isTLS bool,
-	tlsConfig *tls.Config, dialTimeout, writeTimeout time.Duration,
+	ctx context.Context, addr string, dial DialFunc, dialWithTimeout DialFuncWithTimeout, dialDualStack, isTLS bool,
+	tlsConfig *tls.Config, dialTimeout, writeTimeout time.Duration, trace *ClientTrace,
 ) (net.Conn, error) {
-	deadline := time.Now().Add(writeTimeout)
-	conn, err := callDialFunc(addr, dial, dialWithTimeout, dialDualStack, isTLS, dialTimeout)
+	var deadline time.Time
+	if writeTimeout > 0 {
+		deadline = time.Now().Add(writeTimeout)
+	}
+	conn, err := callDialFuncContext(ctx, addr, dial, dialWithTimeout, dialDualStack, isTLS, dialTimeout, trace)
 	if err != nil {
 		return nil, err
 	}
@@ -2037,17 +2431,72 @@ func dialAddr(
 	_, isTLSAlready := conn.(interface{ Handshake() error })
 
 	if isTLS && !isTLSAlready {
-		if writeTimeout == 0 {
+		if writeTimeout == 0 && ctx.Done() == nil && !trace.hasTLSHooks() {
 			return tls.Client(conn, tlsConfig), nil
 		}
-		return tlsClientHandshake(conn, tlsConfig, deadline)
+		trace.tlsHandshakeStart()
+		conn, err = tlsClientHandshake(ctx, conn, tlsConfig, deadline)
+		trace.tlsHandshakeDone(conn, err)
+		return conn, err
 	}
 	return conn, nil
 }
 
+// callDialFuncContext calls callDialFunc and stops waiting for it when ctx
+// is done. The connection dialed after ctx is done is closed.
+func callDialFuncContext(
+	ctx context.Context, addr string, dial DialFunc, dialWithTimeout DialFuncWithTimeout, dialDualStack, isTLS bool,
+	timeout time.Duration, trace *ClientTrace,
+) (net.Conn, error) {
+	if ctx.Done() == nil {
+		return callDialFunc(addr, dial, dialWithTimeout, dialDualStack, isTLS, timeout, trace)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	ch := make(chan dialResult, 1)
+	go func() {
+		conn, err := callDialFunc(addr, dial, dialWithTimeout, dialDualStack, isTLS, timeout, trace)
+		ch <- dialResult{conn: conn, err: err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.conn, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-ch; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
 func callDialFunc(
 	addr string, dial DialFunc, dialWithTimeout DialFuncWithTimeout, dialDualStack, isTLS bool, timeout time.Duration,
-) (net.Conn, error) {
+	trace *ClientTrace,
+) (conn net.Conn, err error) {
+	if trace != nil {
+		if dial != nil || dialWithTimeout != nil {
+			trace.connectStart("tcp", addr)
+			defer func() {
+				trace.connectDone("tcp", addr, err)
+			}()
+		} else {
+			// The default dialer calls the DNS and connect hooks.
+			addr = AddMissingPort(addr, isTLS)
+			if timeout <= 0 {
+				timeout = DefaultDialTimeout
+			}
+			return defaultDialer.dial(addr, dialDualStack, timeout, trace)
+		}
+	}
 	if dialWithTimeout != nil {
 		return dialWithTimeout(addr, timeout)
 	}
@@ -2396,6 +2845,7 @@ type pipelineConnClient struct {
 type pipelineWork struct {
 	respCopy Response
 	deadline time.Time
+	ctx      context.Context
 	err      error
 	req      *Request
 	resp     *Response
@@ -2442,12 +2892,54 @@ func (c *PipelineClient) DoDeadline(req *Request, resp *Response, deadline time.
 	return c.getConnClient().DoDeadline(req, resp, deadline)
 }
 
+// DoContext performs the given request and waits for response until
+// ctx is done.
+//
+// The request is abandoned when ctx is canceled. The returned error wraps
+// ctx.Err() in this case, so errors.Is(err, context.Canceled) and
+// errors.Is(err, context.DeadlineExceeded) may be used for checking it.
+// Errors caused by the ctx deadline also wrap ErrTimeout. Since
+// the connection is shared by the pipelined requests, the request
+// already written to the connection is completed in the background.
+//
+// See PipelineClient.Do for the rest of the details.
+func (c *PipelineClient) DoContext(ctx context.Context, req *Request, resp *Response) error {
+	return clientDoContext(ctx, req, resp, pipelineContextDoer{c: c.getConnClient()})
+}
+
+// pipelineContextDoer performs requests with the timeout and context
+// set by clientDoContext.
+type pipelineContextDoer struct {
+	c *pipelineConnClient
+}
+
+func (d pipelineContextDoer) Do(req *Request, resp *Response) error {
+	deadline := zeroTime
+	if req.timeout > 0 {
+		deadline = time.Now().Add(req.timeout)
+	}
+	return d.c.doDeadline(req, resp, deadline)
+}
+
 func (c *pipelineConnClient) DoDeadline(req *Request, resp *Response, deadline time.Time) error {
+	if time.Until(deadline) <= 0 {
+		return ErrTimeout
+	}
+	return c.doDeadline(req, resp, deadline)
+}
+
+// doDeadline performs the request like DoDeadline. The request doesn't
+// time out if deadline is zero. Waiting for the response is stopped
+// when req.ctx is done.
+func (c *pipelineConnClient) doDeadline(req *Request, resp *Response, deadline time.Time) error {
 	c.init()
 
-	timeout := time.Until(deadline)
-	if timeout <= 0 {
-		return ErrTimeout
+	var timeout time.Duration
+	if !deadline.IsZero() {
+		timeout = time.Until(deadline)
+		if timeout <= 0 {
+			return ErrTimeout
+		}
 	}
 
 	if c.DisablePathNormalizing {
@@ -2469,6 +2961,16 @@ func (c *pipelineConnClient) DoDeadline(req *Request, resp *Response, deadline t
 	w.respCopy.Header.disableNormalizing = c.DisableHeaderNamesNormalizing
 	w.req = &w.reqCopy
 	w.resp = &w.respCopy
+	w.ctx = req.ctx
+
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timeoutCh = w.t.C
+	}
+	var done <-chan struct{}
+	if req.ctx != nil {
+		done = req.ctx.Done()
+	}
 
 	// Make a copy of the request in order to avoid data races on timeouts
 	req.copyToSkipBody(&w.reqCopy)
@@ -2482,9 +2984,12 @@ func (c *pipelineConnClient) DoDeadline(req *Request, resp *Response, deadline t
 		// Slow path
 		select {
 		case c.chW <- w:
-		case <-w.t.C:
+		case <-timeoutCh:
 			c.releasePipelineWork(w)
 			return ErrTimeout
+		case <-done:
+			c.releasePipelineWork(w)
+			return req.ctx.Err()
 		}
 	}
 
@@ -2498,8 +3003,11 @@ func (c *pipelineConnClient) DoDeadline(req *Request, resp *Response, deadline t
 		}
 		err = w.err
 		c.releasePipelineWork(w)
-	case <-w.t.C:
+	case <-timeoutCh:
 		err = ErrTimeout
+	case <-done:
+		// w is still referenced by the pipeline, so it cannot be released.
+		err = req.ctx.Err()
 	}
 
 	return err
@@ -2535,6 +3043,7 @@ func (c *pipelineConnClient) releasePipelineWork(w *pipelineWork) {
 	w.respCopy.Reset()
 	w.req = nil
 	w.resp = nil
+	w.ctx = nil
 	w.err = nil
 	c.workPool.Put(w)
 }
@@ -2696,8 +3205,12 @@ func (c *pipelineConnClient) init() {
 			// Keep restarting the worker if it fails (connection errors for example).
 			for {
 				if err := c.worker(); err != nil {
-					c.logger().Printf("error in PipelineClient(%q): %v", c.Addr, err)
-					if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					netErr, ok := err.(net.Error)
+					timeout := ok && netErr.Timeout()
+					logRecord(c.logger(), slog.LevelWarn, "PipelineClient connection error",
+						[]slog.Attr{slog.String("addr", c.Addr), slog.Bool("timeout", timeout), slog.Any("error", err)},
+						"error in PipelineClient(%q): %v", c.Addr, err)
+					if timeout {
 						// Throttle client reconnections on timeout errors
 						time.Sleep(time.Second)
 					}
@@ -2722,7 +3235,7 @@ func (c *pipelineConnClient) init() {
 
 func (c *pipelineConnClient) worker() error {
 	tlsConfig := c.cachedTLSConfig()
-	conn, err := dialAddr(c.Addr, c.Dial, nil, c.DialDualStack, c.IsTLS, tlsConfig, 0, c.WriteTimeout)
+	conn, err := dialAddr(context.Background(), c.Addr, c.Dial, nil, c.DialDualStack, c.IsTLS, tlsConfig, 0, c.WriteTimeout, nil)
 	if err != nil {
 		return err
 	}
@@ -2832,6 +3345,11 @@ func (c *pipelineConnClient) writer(conn net.Conn, stopCh <-chan struct{}) error
 			w.done <- struct{}{}
 			continue
 		}
+		if w.ctx != nil && w.ctx.Err() != nil {
+			w.err = w.ctx.Err()
+			w.done <- struct{}{}
+			continue
+		}
 
 		w.resp.ParseNetConn(conn)
 
@@ -2971,6 +3489,22 @@ var DefaultTransport RoundTripper = &transport{}
 
 type transport struct{}
 
+// closeConnOnDone closes conn when ctx is done. The connection is closed,
+// since not all the net.Conn implementations unblock pending calls
+// on deadline changes.
+func closeConnOnDone(ctx context.Context, conn net.Conn) (stop func() bool) {
+	return context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+}
+
+// stopContextCancel stops the ctx cancellation callback registered
+// with context.AfterFunc. It returns false if the callback has already
+// been started, i.e. the connection has been closed.
+func stopContextCancel(stop func() bool) bool {
+	return stop == nil || stop()
+}
+
 func (t *transport) RoundTrip(hc *HostClient, req *Request, resp *Response) (retry bool, err error) {
 	customSkipBody := resp.SkipBody
 	customStreamBody := resp.StreamBody
@@ -2980,11 +3514,25 @@ func (t *transport) RoundTrip(hc *HostClient, req *Request, resp *Response) (ret
 		deadline = time.Now().Add(req.timeout)
 	}
 
-	cc, err := hc.AcquireConn(req.timeout, req.ConnectionClose())
+	ctx := req.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	trace := req.trace
+	cc, err := hc.acquireConn(ctx, req.timeout, req.ConnectionClose(), trace)
 	if err != nil {
 		return false, err
 	}
 	conn := cc.c
+	if req.hostFromConn && cc.addr != "" {
+		req.Header.SetHost(cc.addr)
+	}
+
+	// Interrupt the pending write or read on ctx cancellation.
+	var stopCancel func() bool
+	if ctx.Done() != nil {
+		stopCancel = closeConnOnDone(ctx, conn)
+	}
 
 	resp.ParseNetConn(conn)
 
@@ -2997,16 +3545,20 @@ func (t *transport) RoundTrip(hc *HostClient, req *Request, resp *Response) (ret
 	}
 
 	if err = conn.SetWriteDeadline(writeDeadline); err != nil {
-		hc.CloseConn(cc)
+		stopContextCancel(stopCancel)
+		hc.closeConnOnError(cc, err)
 		return true, err
 	}
 
 	resetConnection := false
-	if hc.MaxConnDuration > 0 && time.Since(cc.createdTime) > hc.MaxConnDuration && !req.ConnectionClose() {
+	if cc.expired() && !req.ConnectionClose() {
 		req.SetConnectionClose()
 		resetConnection = true
 	}
 
+	if trace != nil && req.MayContinue() {
+		trace.wait100Continue()
+	}
 	bw := hc.AcquireWriter(conn)
 	err = req.Write(bw)
 
@@ -3018,6 +3570,7 @@ func (t *transport) RoundTrip(hc *HostClient, req *Request, resp *Response) (ret
 		err = bw.Flush()
 	}
 	hc.ReleaseWriter(bw)
+	trace.wroteRequest(err)
 
 	// Return ErrTimeout on any timeout.
 	if x, ok := err.(interface{ Timeout() bool }); ok && x.Timeout() {
@@ -3025,7 +3578,8 @@ func (t *transport) RoundTrip(hc *HostClient, req *Request, resp *Response) (ret
 	}
 
 	if err != nil {
-		hc.CloseConn(cc)
+		stopContextCancel(stopCancel)
+		hc.closeConnOnError(cc, err)
 		return true, err
 	}
 
@@ -3038,7 +3592,8 @@ func (t *transport) RoundTrip(hc *HostClient, req *Request, resp *Response) (ret
 	}
 
 	if err = conn.SetReadDeadline(readDeadline); err != nil {
-		hc.CloseConn(cc)
+		stopContextCancel(stopCancel)
+		hc.closeConnOnError(cc, err)
 		return true, err
 	}
 
@@ -3050,26 +3605,45 @@ func (t *transport) RoundTrip(hc *HostClient, req *Request, resp *Response) (ret
 	}
 
 	br := hc.AcquireReader(conn)
-	err = resp.ReadLimitBody(br, hc.MaxResponseBodySize)
+	if trace != nil && trace.GotFirstResponseByte != nil {
+		if _, err = br.Peek(1); err == nil {
+			trace.GotFirstResponseByte()
+		}
+	}
+	err = resp.readLimitBody(br, hc.MaxResponseBodySize, trace)
 	if err != nil {
+		stopContextCancel(stopCancel)
 		hc.ReleaseReader(br)
-		hc.CloseConn(cc)
+		hc.closeConnOnError(cc, err)
 		// Don't retry in case of ErrBodyTooLarge since we will just get the same again.
 		needRetry := err != ErrBodyTooLarge
 		return needRetry, err
 	}
 
 	closeConn := resetConnection || req.ConnectionClose() || resp.ConnectionClose()
+	closeReason := connCloseConnectionClose
+	if resetConnection {
+		closeReason = connCloseLifetime
+	}
 	if customStreamBody && resp.bodyStream != nil {
 		rbs := resp.bodyStream
+		stopStreamCancel := stopCancel
 		resp.bodyStream = newCloseReaderWithError(rbs, func(wErr error) error {
+			// The connection is unusable if ctx cancellation
+			// has already interrupted it.
+			canceled := !stopContextCancel(stopStreamCancel)
 			hc.ReleaseReader(br)
 			if r, ok := rbs.(*requestStream); ok {
 				releaseRequestStream(r)
 			}
-			if closeConn || resp.ConnectionClose() || wErr != nil {
+			switch {
+			case wErr != nil:
+				hc.closeConnOnError(cc, wErr)
+			case canceled:
 				hc.CloseConn(cc)
-			} else {
+			case closeConn || resp.ConnectionClose():
+				hc.closeConn(cc, closeReason)
+			default:
 				hc.ReleaseConn(cc)
 			}
 			return nil
@@ -3078,9 +3652,13 @@ func (t *transport) RoundTrip(hc *HostClient, req *Request, resp *Response) (ret
 	}
 	hc.ReleaseReader(br)
 
-	if closeConn {
+	// The connection is unusable if ctx cancellation has interrupted it.
+	switch {
+	case !stopContextCancel(stopCancel):
 		hc.CloseConn(cc)
-	} else {
+	case closeConn:
+		hc.closeConn(cc, closeReason)
+	default:
 		hc.ReleaseConn(cc)
 	}
 	return false, nil
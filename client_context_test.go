@@ -0,0 +1,310 @@
+package fasthttp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bhargawpradhan/fasthttp/fasthttputil"
+)
+
+// startBlockingServer starts a server, which handler blocks until
+// the returned channel is closed for the requests to /block.
+func startBlockingServer(t *testing.T) (*fasthttputil.InmemoryListener, chan struct{}) {
+	t.Helper()
+
+	ln := fasthttputil.NewInmemoryListener()
+	unblock := make(chan struct{})
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Path()) == "/block" {
+				<-unblock
+			}
+			ctx.WriteString("ok") //nolint:errcheck
+		},
+	}
+	serverCh := make(chan error, 1)
+	go func() {
+		serverCh <- s.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		select {
+		case <-unblock:
+		default:
+			close(unblock)
+		}
+		ln.Close()
+		if err := <-serverCh; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	return ln, unblock
+}
+
+func doContextAsync(ctx context.Context, c interface {
+	DoContext(ctx context.Context, req *Request, resp *Response) error
+}, uri string,
+) chan error {
+	ch := make(chan error, 1)
+	go func() {
+		req := AcquireRequest()
+		resp := AcquireResponse()
+		req.SetRequestURI(uri)
+		ch <- c.DoContext(ctx, req, resp)
+		ReleaseRequest(req)
+		ReleaseResponse(resp)
+	}()
+	return ch
+}
+
+func waitErr(t *testing.T, ch chan error) error {
+	t.Helper()
+
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	return nil
+}
+
+func TestHostClientDoContextCancelRead(t *testing.T) {
+	t.Parallel()
+
+	ln, _ := startBlockingServer(t)
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := doContextAsync(ctx, c, "http://foobar/block")
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	err := waitErr(t, ch)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error: %v. Expecting context.Canceled", err)
+	}
+	if errors.Is(err, ErrTimeout) {
+		t.Fatalf("canceled request must not be reported as timeout: %v", err)
+	}
+	// The interrupted connection must be closed instead of being reused.
+	if n := c.ConnsCount(); n != 0 {
+		t.Fatalf("unexpected number of connections %d. Expecting 0", n)
+	}
+
+	// The client must keep working after the cancellation.
+	if err := waitErr(t, doContextAsync(context.Background(), c, "http://foobar/")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := c.ConnsCount(); n != 1 {
+		t.Fatalf("unexpected number of connections %d. Expecting 1", n)
+	}
+}
+
+func TestHostClientDoContextDeadline(t *testing.T) {
+	t.Parallel()
+
+	ln, _ := startBlockingServer(t)
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := waitErr(t, doContextAsync(ctx, c, "http://foobar/block"))
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrTimeout) {
+		t.Fatalf("unexpected error: %v. Expecting context.DeadlineExceeded and ErrTimeout", err)
+	}
+
+	// Already done contexts fail immediately.
+	err = waitErr(t, doContextAsync(ctx, c, "http://foobar/"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error: %v. Expecting context.DeadlineExceeded", err)
+	}
+}
+
+func TestHostClientDoContextCancelConnWait(t *testing.T) {
+	t.Parallel()
+
+	ln, unblock := startBlockingServer(t)
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		MaxConns:           1,
+		MaxConnWaitTimeout: time.Minute,
+	}
+
+	busyCh := doContextAsync(context.Background(), c, "http://foobar/block")
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := doContextAsync(ctx, c, "http://foobar/")
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := waitErr(t, ch); !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error: %v. Expecting context.Canceled", err)
+	}
+
+	close(unblock)
+	if err := waitErr(t, busyCh); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestHostClientDoContextCancelDial(t *testing.T) {
+	t.Parallel()
+
+	ln, _ := startBlockingServer(t)
+	dialStarted := make(chan struct{})
+	releaseDial := make(chan struct{})
+	var dialedConn atomic.Value
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			close(dialStarted)
+			<-releaseDial
+			conn, err := ln.Dial()
+			dialedConn.Store(conn)
+			return conn, err
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := doContextAsync(ctx, c, "http://foobar/")
+	<-dialStarted
+	cancel()
+	if err := waitErr(t, ch); !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error: %v. Expecting context.Canceled", err)
+	}
+	if n := c.ConnsCount(); n != 0 {
+		t.Fatalf("unexpected number of connections %d. Expecting 0", n)
+	}
+
+	// The connection dialed after the cancellation must be closed.
+	close(releaseDial)
+	for i := 0; dialedConn.Load() == nil; i++ {
+		if i > 100 {
+			t.Fatal("dial hasn't finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	conn := dialedConn.Load().(net.Conn)
+	for i := 0; ; i++ {
+		if _, err := conn.Write([]byte("x")); err != nil {
+			break
+		}
+		if i > 100 {
+			t.Fatal("the connection dialed after the cancellation must be closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClientDoContext(t *testing.T) {
+	t.Parallel()
+
+	ln, _ := startBlockingServer(t)
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+
+	for i := 0; i < 3; i++ {
+		req := AcquireRequest()
+		resp := AcquireResponse()
+		req.SetRequestURI("http://foobar/")
+		if err := c.DoContext(context.Background(), req, resp); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(resp.Body()) != "ok" {
+			t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "ok")
+		}
+		ReleaseRequest(req)
+		ReleaseResponse(resp)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := doContextAsync(ctx, c, "http://foobar/block")
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := waitErr(t, ch); !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error: %v. Expecting context.Canceled", err)
+	}
+}
+
+func TestPipelineClientDoContext(t *testing.T) {
+	t.Parallel()
+
+	ln, _ := startBlockingServer(t)
+	c := &PipelineClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+
+	if err := waitErr(t, doContextAsync(context.Background(), c, "http://foobar/")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := doContextAsync(ctx, c, "http://foobar/block")
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := waitErr(t, ch); !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error: %v. Expecting context.Canceled", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := waitErr(t, doContextAsync(ctx, c, "http://foobar/block"))
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrTimeout) {
+		t.Fatalf("unexpected error: %v. Expecting context.DeadlineExceeded and ErrTimeout", err)
+	}
+}
+
+func TestLBClientDoContext(t *testing.T) {
+	t.Parallel()
+
+	ln, _ := startBlockingServer(t)
+	hc := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	lbc := &LBClient{
+		Clients: []BalancingClient{hc},
+		Timeout: time.Minute,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := doContextAsync(ctx, lbc, "http://foobar/block")
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := waitErr(t, ch); !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error: %v. Expecting context.Canceled", err)
+	}
+	// Canceled requests must not penalize the client.
+	if n := lbc.get(nil).PendingRequests(); n != 0 {
+		t.Fatalf("unexpected pending requests %d. Expecting 0", n)
+	}
+
+	if err := waitErr(t, doContextAsync(context.Background(), lbc, "http://foobar/")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package fasthttp
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bhargawpradhan/fasthttp/fasthttputil"
+)
+
+func startInterceptorServer(t *testing.T) *fasthttputil.InmemoryListener {
+	t.Helper()
+
+	ln := fasthttputil.NewInmemoryListener()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Path()) == "/redirect" {
+				ctx.Redirect("/final", StatusFound)
+				return
+			}
+			ctx.Response.Header.Set("X-Auth", string(ctx.Request.Header.Peek("Authorization")))
+			ctx.WriteString("ok") //nolint:errcheck
+		},
+	}
+	serverCh := make(chan error, 1)
+	go func() {
+		serverCh <- s.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		if err := <-serverCh; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	return ln
+}
+
+type interceptorLog struct {
+	calls []string
+	mu    sync.Mutex
+}
+
+func (l *interceptorLog) interceptor(name string) Interceptor {
+	return func(next DoFunc) DoFunc {
+		return func(req *Request, resp *Response) error {
+			l.mu.Lock()
+			l.calls = append(l.calls, name+">"+string(req.URI().Path()))
+			l.mu.Unlock()
+			err := next(req, resp)
+			l.mu.Lock()
+			l.calls = append(l.calls, name+"<")
+			l.mu.Unlock()
+			return err
+		}
+	}
+}
+
+func (l *interceptorLog) reset() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := strings.Join(l.calls, " ")
+	l.calls = l.calls[:0]
+	return s
+}
+
+func TestClientInterceptors(t *testing.T) {
+	t.Parallel()
+
+	ln := startInterceptorServer(t)
+	var log interceptorLog
+	auth := func(next DoFunc) DoFunc {
+		return func(req *Request, resp *Response) error {
+			req.Header.Set("Authorization", "Bearer token")
+			return next(req, resp)
+		}
+	}
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		Interceptors: []Interceptor{log.interceptor("a"), log.interceptor("b"), auth},
+		ConfigureClient: func(hc *HostClient) error {
+			hc.Interceptors = append(hc.Interceptors, log.interceptor("host"))
+			return nil
+		},
+	}
+
+	req := AcquireRequest()
+	resp := AcquireResponse()
+	defer ReleaseRequest(req)
+	defer ReleaseResponse(resp)
+
+	req.SetRequestURI("http://foobar/foo")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s := log.reset(); s != "a>/foo b>/foo host>/foo host< b< a<" {
+		t.Fatalf("unexpected interceptors calls %q", s)
+	}
+	if s := string(resp.Header.Peek("X-Auth")); s != "Bearer token" {
+		t.Fatalf("unexpected Authorization header %q", s)
+	}
+
+	req.SetRequestURI("http://foobar/bar")
+	if err := c.DoTimeout(req, resp, time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s := log.reset(); s != "a>/bar b>/bar host>/bar host< b< a<" {
+		t.Fatalf("unexpected interceptors calls %q", s)
+	}
+
+	// Every redirect hop is intercepted.
+	req.SetRequestURI("http://foobar/redirect")
+	if err := c.DoRedirects(req, resp, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s := log.reset(); s != "a>/redirect b>/redirect host>/redirect host< b< a< a>/final b>/final host>/final host< b< a<" {
+		t.Fatalf("unexpected interceptors calls %q", s)
+	}
+
+	if _, _, err := c.Get(nil, "http://foobar/get"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s := log.reset(); s != "a>/get b>/get host>/get host< b< a<" {
+		t.Fatalf("unexpected interceptors calls %q", s)
+	}
+
+	if _, _, err := c.Post(nil, "http://foobar/post", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s := log.reset(); s != "a>/post b>/post host>/post host< b< a<" {
+		t.Fatalf("unexpected interceptors calls %q", s)
+	}
+
+	// The Client interceptors aren't modified by ConfigureClient.
+	if len(c.Interceptors) != 3 {
+		t.Fatalf("unexpected number of Client interceptors %d. Expecting 3", len(c.Interceptors))
+	}
+}
+
+func TestHostClientInterceptorsShortCircuitAndRetry(t *testing.T) {
+	t.Parallel()
+
+	ln := startInterceptorServer(t)
+	errDenied := errors.New("denied")
+	attempts := 0
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		Interceptors: []Interceptor{
+			func(next DoFunc) DoFunc {
+				return func(req *Request, resp *Response) error {
+					if string(req.URI().Path()) == "/denied" {
+						return errDenied
+					}
+					return next(req, resp)
+				}
+			},
+			// Retry failed responses once.
+			func(next DoFunc) DoFunc {
+				return func(req *Request, resp *Response) error {
+					err := next(req, resp)
+					attempts++
+					if err == nil && string(req.Header.Peek("X-Retried")) == "" {
+						req.Header.Set("X-Retried", "1")
+						err = next(req, resp)
+						attempts++
+					}
+					return err
+				}
+			},
+		},
+	}
+
+	if _, _, err := c.Get(nil, "http://foobar/denied"); !errors.Is(err, errDenied) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errDenied)
+	}
+	if attempts != 0 {
+		t.Fatalf("unexpected number of attempts %d. Expecting 0", attempts)
+	}
+
+	statusCode, body, err := c.Get(nil, "http://foobar/foo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if statusCode != StatusOK || string(body) != "ok" || attempts != 2 {
+		t.Fatalf("unexpected response %d %q after %d attempts", statusCode, body, attempts)
+	}
+}
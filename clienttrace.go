@@ -0,0 +1,222 @@
+package fasthttp
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// ClientTrace contains hooks called at the stages of outgoing
+// client requests. It is similar to net/http/httptrace.ClientTrace.
+//
+// Set it on a request via Request.SetClientTrace. Any hook may be nil.
+// Requests without ClientTrace aren't slowed down by tracing.
+//
+// Hooks may be called concurrently from different goroutines, e.g.
+// during Happy Eyeballs dialing or for the copies sent by HedgePolicy.
+// Hooks are called for every retry of the request.
+//
+// DNSStart and DNSDone are called only if the host name is resolved
+// by the default dialer, i.e. HostClient.Dial and HostClient.DialTimeout
+// aren't set, and the addresses aren't cached. ConnectStart and ConnectDone
+// are called for every TCP address dialed by the default dialer or once
+// around HostClient.Dial otherwise.
+type ClientTrace struct {
+	// GetConn is called before acquiring a connection to addr.
+	GetConn func(addr string)
+
+	// GotConn is called after a connection is acquired.
+	GotConn func(info GotConnInfo)
+
+	// DNSStart is called before resolving a host name.
+	DNSStart func(info DNSStartInfo)
+
+	// DNSDone is called after resolving a host name.
+	DNSDone func(info DNSDoneInfo)
+
+	// ConnectStart is called before dialing a new connection.
+	ConnectStart func(network, addr string)
+
+	// ConnectDone is called after dialing a new connection.
+	ConnectDone func(network, addr string, err error)
+
+	// DualStackDialDone is called after the default dialer establishes
+	// a connection by racing the resolved addresses of the host.
+	// See TCPDialer.FallbackDelay.
+	DualStackDialDone func(info DualStackDialInfo)
+
+	// TLSHandshakeStart is called before the TLS handshake
+	// of a new connection.
+	TLSHandshakeStart func()
+
+	// TLSHandshakeDone is called after the TLS handshake
+	// of a new connection.
+	TLSHandshakeDone func(state tls.ConnectionState, err error)
+
+	// WroteRequest is called after writing the request including its body.
+	WroteRequest func(info WroteRequestInfo)
+
+	// Wait100Continue is called before writing the request
+	// with 'Expect: 100-continue' header. The client doesn't wait
+	// for the interim response before writing the request body.
+	Wait100Continue func()
+
+	// Got100Continue is called after reading 100 Continue interim response.
+	Got100Continue func()
+
+	// GotFirstResponseByte is called after reading the first byte
+	// of the response.
+	GotFirstResponseByte func()
+}
+
+// GotConnInfo is the argument for ClientTrace.GotConn.
+type GotConnInfo struct {
+	// Conn is the acquired connection.
+	Conn net.Conn
+
+	// Reused is true if the connection has been taken from the pool
+	// of idle connections.
+	Reused bool
+
+	// IdleTime is the time the reused connection has been idle
+	// in the pool.
+	IdleTime time.Duration
+
+	// WaitTime is the time spent waiting for a free connection
+	// when all HostClient.MaxConns connections are busy.
+	WaitTime time.Duration
+}
+
+// DNSStartInfo is the argument for ClientTrace.DNSStart.
+type DNSStartInfo struct {
+	Host string
+}
+
+// DNSDoneInfo is the argument for ClientTrace.DNSDone.
+type DNSDoneInfo struct {
+	// Err is the resolving error.
+	Err error
+
+	// Addrs contains the resolved addresses.
+	Addrs []net.IPAddr
+}
+
+// WroteRequestInfo is the argument for ClientTrace.WroteRequest.
+type WroteRequestInfo struct {
+	// Err is the error occurred while writing the request.
+	Err error
+}
+
+// SetClientTrace sets the hooks called while the request is performed
+// by HostClient, Client or LBClient.
+//
+// The trace is cleared by Reset.
+func (req *Request) SetClientTrace(trace *ClientTrace) {
+	req.trace = trace
+}
+
+// ClientTrace returns the trace set via SetClientTrace.
+func (req *Request) ClientTrace() *ClientTrace {
+	return req.trace
+}
+
+func (t *ClientTrace) getConn(addr string) {
+	if t != nil && t.GetConn != nil {
+		t.GetConn(addr)
+	}
+}
+
+func (t *ClientTrace) gotConn(cc *clientConn, waitStart time.Time) {
+	if t == nil || t.GotConn == nil {
+		return
+	}
+	info := GotConnInfo{
+		Conn: cc.c,
+		// Only the connections released to the pool have lastUseTime.
+		Reused: !cc.lastUseTime.IsZero(),
+	}
+	if info.Reused {
+		info.IdleTime = time.Since(cc.lastUseTime)
+	}
+	if !waitStart.IsZero() {
+		info.WaitTime = time.Since(waitStart)
+	}
+	t.GotConn(info)
+}
+
+func (t *ClientTrace) dualStackDialDone(info DualStackDialInfo) {
+	if t != nil && t.DualStackDialDone != nil {
+		t.DualStackDialDone(info)
+	}
+}
+
+func (t *ClientTrace) dnsStart(host string) {
+	if t != nil && t.DNSStart != nil {
+		t.DNSStart(DNSStartInfo{Host: host})
+	}
+}
+
+func (t *ClientTrace) dnsDone(e *tcpAddrEntry, err error) {
+	if t == nil || t.DNSDone == nil {
+		return
+	}
+	info := DNSDoneInfo{Err: err}
+	if e != nil {
+		info.Addrs = make([]net.IPAddr, len(e.addrs))
+		for i, addr := range e.addrs {
+			info.Addrs[i] = net.IPAddr{IP: addr.IP, Zone: addr.Zone}
+		}
+	}
+	t.DNSDone(info)
+}
+
+func (t *ClientTrace) connectStart(network, addr string) {
+	if t != nil && t.ConnectStart != nil {
+		t.ConnectStart(network, addr)
+	}
+}
+
+func (t *ClientTrace) connectDone(network, addr string, err error) {
+	if t != nil && t.ConnectDone != nil {
+		t.ConnectDone(network, addr, err)
+	}
+}
+
+func (t *ClientTrace) hasTLSHooks() bool {
+	return t != nil && (t.TLSHandshakeStart != nil || t.TLSHandshakeDone != nil)
+}
+
+func (t *ClientTrace) tlsHandshakeStart() {
+	if t != nil && t.TLSHandshakeStart != nil {
+		t.TLSHandshakeStart()
+	}
+}
+
+func (t *ClientTrace) tlsHandshakeDone(conn net.Conn, err error) {
+	if t == nil || t.TLSHandshakeDone == nil {
+		return
+	}
+	var state tls.ConnectionState
+	if c, ok := conn.(*tls.Conn); ok && err == nil {
+		state = c.ConnectionState()
+	}
+	t.TLSHandshakeDone(state, err)
+}
+
+func (t *ClientTrace) wait100Continue() {
+	if t != nil && t.Wait100Continue != nil {
+		t.Wait100Continue()
+	}
+}
+
+func (t *ClientTrace) got100Continue() {
+	if t != nil && t.Got100Continue != nil {
+		t.Got100Continue()
+	}
+}
+
+func (t *ClientTrace) wroteRequest(err error) {
+	if t != nil && t.WroteRequest != nil {
+		t.WroteRequest(WroteRequestInfo{Err: err})
+	}
+}
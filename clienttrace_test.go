@@ -0,0 +1,225 @@
+package fasthttp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bhargawpradhan/fasthttp/fasthttputil"
+)
+
+// traceRecorder records the ClientTrace events.
+type traceRecorder struct {
+	events []string
+	conns  []GotConnInfo
+	mu     sync.Mutex
+}
+
+func (r *traceRecorder) add(format string, args ...any) {
+	r.mu.Lock()
+	r.events = append(r.events, fmt.Sprintf(format, args...))
+	r.mu.Unlock()
+}
+
+func (r *traceRecorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := strings.Join(r.events, ",")
+	r.events = r.events[:0]
+	return s
+}
+
+func (r *traceRecorder) trace() *ClientTrace {
+	return &ClientTrace{
+		GetConn: func(addr string) { r.add("GetConn %s", addr) },
+		GotConn: func(info GotConnInfo) {
+			r.add("GotConn reused=%v", info.Reused)
+			r.mu.Lock()
+			r.conns = append(r.conns, info)
+			r.mu.Unlock()
+		},
+		DNSStart:     func(info DNSStartInfo) { r.add("DNSStart %s", info.Host) },
+		DNSDone:      func(info DNSDoneInfo) { r.add("DNSDone %v %v", info.Addrs, info.Err) },
+		ConnectStart: func(network, addr string) { r.add("ConnectStart %s %s", network, addr) },
+		ConnectDone: func(network, addr string, err error) {
+			r.add("ConnectDone %s %s %v", network, addr, err)
+		},
+		TLSHandshakeStart: func() { r.add("TLSHandshakeStart") },
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			r.add("TLSHandshakeDone %v %v", state.HandshakeComplete, err)
+		},
+		WroteRequest:         func(info WroteRequestInfo) { r.add("WroteRequest %v", info.Err) },
+		Wait100Continue:      func() { r.add("Wait100Continue") },
+		Got100Continue:       func() { r.add("Got100Continue") },
+		GotFirstResponseByte: func() { r.add("GotFirstResponseByte") },
+	}
+}
+
+func TestClientTrace(t *testing.T) {
+	t.Parallel()
+
+	ln := startRetryServer(t, func(ctx *RequestCtx) {
+		ctx.Write(ctx.PostBody()) //nolint:errcheck
+	})
+	c := &HostClient{
+		Addr: "example.com",
+		Dial: func(string) (net.Conn, error) { return ln.Dial() },
+	}
+	var r traceRecorder
+
+	req := AcquireRequest()
+	resp := AcquireResponse()
+	defer ReleaseRequest(req)
+	defer ReleaseResponse(resp)
+	req.SetRequestURI("http://example.com/")
+	req.SetClientTrace(r.trace())
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "GetConn example.com,ConnectStart tcp example.com,ConnectDone tcp example.com <nil>," +
+		"GotConn reused=false,WroteRequest <nil>,GotFirstResponseByte"
+	if s := r.String(); s != expected {
+		t.Fatalf("unexpected events %q. Expecting %q", s, expected)
+	}
+
+	// The connection is reused.
+	time.Sleep(10 * time.Millisecond)
+	req.Header.SetMethod(MethodPost)
+	req.Header.Set(HeaderExpect, "100-continue")
+	req.SetBodyString("foobar")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if body := string(resp.Body()); body != "foobar" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "foobar")
+	}
+	expected = "GetConn example.com,GotConn reused=true,Wait100Continue,WroteRequest <nil>," +
+		"GotFirstResponseByte,Got100Continue"
+	if s := r.String(); s != expected {
+		t.Fatalf("unexpected events %q. Expecting %q", s, expected)
+	}
+	if idle := r.conns[1].IdleTime; idle < 10*time.Millisecond {
+		t.Fatalf("unexpected idle time %s", idle)
+	}
+
+	// The trace is cleared by Reset.
+	req.Reset()
+	if req.ClientTrace() != nil {
+		t.Fatal("trace isn't cleared by Reset")
+	}
+}
+
+func TestClientTraceWaitTime(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	ln := startRetryServer(t, func(ctx *RequestCtx) {
+		if string(ctx.Path()) == "/block" {
+			<-release
+		}
+	})
+	c := &HostClient{
+		Addr:               "example.com",
+		Dial:               func(string) (net.Conn, error) { return ln.Dial() },
+		MaxConns:           1,
+		MaxConnWaitTimeout: time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, _, err := c.Get(nil, "http://example.com/block")
+		errCh <- err
+	}()
+	for c.ConnsCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.AfterFunc(20*time.Millisecond, func() { close(release) })
+
+	var r traceRecorder
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.SetRequestURI("http://example.com/")
+	req.SetClientTrace(r.trace())
+	if err := c.Do(req, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(r.conns) != 1 || !r.conns[0].Reused || r.conns[0].WaitTime < 10*time.Millisecond {
+		t.Fatalf("unexpected connections %+v", r.conns)
+	}
+}
+
+func TestClientTraceTLS(t *testing.T) {
+	t.Parallel()
+
+	certData, keyData, err := GenerateTestCertificate("localhost")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	s := &Server{Handler: func(ctx *RequestCtx) {}}
+	go s.ServeTLSEmbed(ln, certData, keyData) //nolint:errcheck
+	defer s.Shutdown()                        //nolint:errcheck
+
+	c := &HostClient{
+		Addr:      "localhost",
+		IsTLS:     true,
+		TLSConfig: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec
+		Dial:      func(string) (net.Conn, error) { return ln.Dial() },
+	}
+	var r traceRecorder
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.SetRequestURI("https://localhost/")
+	req.SetClientTrace(&ClientTrace{
+		TLSHandshakeStart: r.trace().TLSHandshakeStart,
+		TLSHandshakeDone:  r.trace().TLSHandshakeDone,
+	})
+	if err := c.Do(req, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s, expected := r.String(), "TLSHandshakeStart,TLSHandshakeDone true <nil>"; s != expected {
+		t.Fatalf("unexpected events %q. Expecting %q", s, expected)
+	}
+}
+
+func TestClientTraceDialer(t *testing.T) {
+	t.Parallel()
+
+	dns, err := fasthttputil.NewDNSServer()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer dns.Close()
+	dns.SetIPs("backend.test", net.ParseIP("127.0.0.1"))
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	var r traceRecorder
+	d := &TCPDialer{Resolver: dns.Resolver()}
+	addr := "backend.test:" + port
+	for i := 0; i < 2; i++ {
+		conn, err := d.dial(addr, false, time.Second, r.trace())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		conn.Close()
+	}
+	expected := "DNSStart backend.test,DNSDone [{127.0.0.1 }] <nil>," +
+		"ConnectStart tcp4 127.0.0.1:" + port + ",ConnectDone tcp4 127.0.0.1:" + port + " <nil>," +
+		"ConnectStart tcp4 127.0.0.1:" + port + ",ConnectDone tcp4 127.0.0.1:" + port + " <nil>"
+	if s := r.String(); s != expected {
+		t.Fatalf("unexpected events %q. Expecting %q", s, expected)
+	}
+}
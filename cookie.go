@@ -392,10 +392,20 @@ func (c *Cookie) ParseBytes(src []byte) error {
 			switch c.bufK[0] | 0x20 {
 			case 'm':
 				if caseInsensitiveCompare(strCookieMaxAge, c.bufK) {
-					maxAge, err := ParseUint(c.bufV)
+					// Zero and negative values expire the cookie immediately
+					// according to RFC 6265, section 5.2.2.
+					v := c.bufV
+					negative := len(v) > 1 && v[0] == '-'
+					if negative {
+						v = v[1:]
+					}
+					maxAge, err := ParseUint(v)
 					if err != nil {
 						return err
 					}
+					if maxAge == 0 || negative {
+						maxAge = -1
+					}
 					c.maxAge = maxAge
 				}
 
@@ -591,3 +601,68 @@ func caseInsensitiveCompare(a, b []byte) bool {
 	}
 	return true
 }
+
+// CookieJar manages storage and use of cookies in Client requests.
+//
+// Implementations must be safe for concurrent use.
+type CookieJar interface {
+	// SetCookies stores the cookies received in the response to uri.
+	//
+	// site is the URI of the first request in the redirect chain. It is
+	// the same as uri unless the request is made while following redirects.
+	//
+	// The cookies and URIs are valid only until SetCookies returns.
+	SetCookies(uri, site *URI, cookies []*Cookie)
+
+	// Cookies returns the cookies to send in the request with the given
+	// method to uri. See SetCookies for site description.
+	//
+	// The caller doesn't modify the returned cookies.
+	Cookies(uri, site *URI, method []byte) []*Cookie
+}
+
+// doCookieJar performs the request attaching the cookies from jar
+// and storing the received cookies in jar.
+func doCookieJar(jar CookieJar, hc *HostClient, req *Request, resp *Response) error {
+	uri := req.URI()
+	site := uri
+	if req.cookieSite != "" {
+		site = AcquireURI()
+		defer ReleaseURI(site)
+		site.Update(req.cookieSite)
+	}
+
+	h := &req.Header
+	h.collectCookies()
+	n := len(h.cookies)
+	for _, c := range jar.Cookies(uri, site, h.Method()) {
+		// The cookies set by the caller take precedence.
+		if !hasArg(h.cookies[:n], b2s(c.Key())) {
+			h.cookies = appendArgBytes(h.cookies, c.Key(), c.Value(), argsHasValue)
+		}
+	}
+
+	err := hc.Do(req, resp)
+
+	// Do not leak the jar cookies to the next request made with req,
+	// since it may be sent to another URI.
+	h.cookies = h.cookies[:n]
+
+	if err != nil || resp == nil || len(resp.Header.cookies) == 0 {
+		return err
+	}
+	cookies := make([]*Cookie, 0, len(resp.Header.cookies))
+	for i := range resp.Header.cookies {
+		c := AcquireCookie()
+		if c.ParseBytes(resp.Header.cookies[i].value) != nil {
+			ReleaseCookie(c)
+			continue
+		}
+		cookies = append(cookies, c)
+	}
+	jar.SetCookies(uri, site, cookies)
+	for _, c := range cookies {
+		ReleaseCookie(c)
+	}
+	return nil
+}
@@ -220,6 +220,18 @@ func TestCookieMaxAge(t *testing.T) {
 	if !strings.Contains(result, expectedMaxAge0) {
 		t.Fatalf("Unexpected cookie %q. Should contain %q", result, expectedMaxAge0)
 	}
+
+	for _, s := range []string{"foo=bar; Max-Age=0", "foo=bar; max-age=-1"} {
+		if err := c.Parse(s); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if c.MaxAge() >= 0 {
+			t.Fatalf("unexpected max-age %d for %q. Expecting negative value", c.MaxAge(), s)
+		}
+		if c.String() != "foo=bar; max-age=0" {
+			t.Fatalf("unexpected cookie %q", c.String())
+		}
+	}
 }
 
 func TestCookieHttpOnly(t *testing.T) {
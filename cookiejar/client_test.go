@@ -0,0 +1,110 @@
+package cookiejar
+
+import (
+	"net"
+	"testing"
+
+	"github.com/bhargawpradhan/fasthttp"
+	"github.com/bhargawpradhan/fasthttp/fasthttputil"
+)
+
+func TestClientCookieJar(t *testing.T) {
+	t.Parallel()
+
+	ln := fasthttputil.NewInmemoryListener()
+	s := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			// Echo the received cookies in the response header.
+			ctx.Response.Header.Set("X-Cookie", string(ctx.Request.Header.Peek(fasthttp.HeaderCookie)))
+			switch string(ctx.Path()) {
+			case "/login":
+				var c fasthttp.Cookie
+				c.SetKey("session")
+				c.SetValue("abc")
+				c.SetPath("/")
+				c.SetHTTPOnly(true)
+				ctx.Response.Header.SetCookie(&c)
+				ctx.Redirect("http://b.example.com/redirected", fasthttp.StatusFound)
+			case "/redirected":
+				var c fasthttp.Cookie
+				c.SetKey("redirect")
+				c.SetValue("1")
+				c.SetDomain("example.com")
+				ctx.Response.Header.SetCookie(&c)
+				ctx.Redirect("/final", fasthttp.StatusFound)
+			case "/logout":
+				ctx.Response.Header.DelClientCookie("session")
+			}
+		},
+	}
+	go s.Serve(ln) //nolint:errcheck
+	defer ln.Close()
+
+	jar, err := New(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c := &fasthttp.Client{
+		CookieJar: jar,
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+
+	do := func(uri string, prepare func(req *fasthttp.Request)) string {
+		t.Helper()
+
+		req := fasthttp.AcquireRequest()
+		resp := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseRequest(req)
+		defer fasthttp.ReleaseResponse(resp)
+		req.SetRequestURI(uri)
+		if prepare != nil {
+			prepare(req)
+		}
+		if err := c.DoRedirects(req, resp, 5); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// The jar cookies mustn't be left in the request.
+		if v := req.Header.Cookie("session"); len(v) != 0 && prepare == nil {
+			t.Fatalf("unexpected cookie left in the request: %q", v)
+		}
+		return string(resp.Header.Peek("X-Cookie"))
+	}
+
+	// Cookies are captured on every redirect hop and attached to the next hops.
+	if got := do("http://a.example.com/login", nil); got != "redirect=1" {
+		t.Fatalf("unexpected cookies on the final redirect hop %q", got)
+	}
+	if got := do("http://a.example.com/", nil); got != "session=abc; redirect=1" {
+		t.Fatalf("unexpected cookies %q", got)
+	}
+	if got := do("http://b.example.com/", nil); got != "redirect=1" {
+		t.Fatalf("unexpected cookies %q", got)
+	}
+
+	// The request cookies take precedence over the jar.
+	got := do("http://a.example.com/", func(req *fasthttp.Request) {
+		req.Header.SetCookie("session", "manual")
+	})
+	if got != "session=manual; redirect=1" {
+		t.Fatalf("unexpected cookies %q", got)
+	}
+
+	if got := do("http://a.example.com/logout", nil); got != "session=abc; redirect=1" {
+		t.Fatalf("unexpected cookies %q", got)
+	}
+	if got := do("http://a.example.com/", nil); got != "redirect=1" {
+		t.Fatalf("unexpected cookies after logout %q", got)
+	}
+
+	// Get follows redirects too.
+	jar2, _ := New(nil)
+	c.CookieJar = jar2
+	if _, _, err := c.Get(nil, "http://a.example.com/login"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := len(jar2.Entries()); n != 2 {
+		t.Fatalf("unexpected number of stored cookies %d. Expecting 2", n)
+	}
+}
@@ -0,0 +1,522 @@
+// Package cookiejar implements an in-memory cookie jar for fasthttp.Client
+// following RFC 6265bis.
+//
+// The jar matches cookies by domain and path, rejects cookies for public
+// suffixes, honors Expires and Max-Age, and applies Secure, HttpOnly,
+// SameSite and Partitioned (CHIPS) semantics. Persistent cookies may be
+// saved to and loaded from JSON.
+//
+//	jar, _ := cookiejar.New(nil)
+//	c := &fasthttp.Client{CookieJar: jar}
+package cookiejar
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bhargawpradhan/fasthttp"
+	"golang.org/x/net/publicsuffix"
+)
+
+// PublicSuffixList provides the public suffix of a domain.
+//
+// It is compatible with golang.org/x/net/publicsuffix.List.
+type PublicSuffixList interface {
+	// PublicSuffix returns the public suffix of domain.
+	PublicSuffix(domain string) string
+
+	// String returns a description of the source of this public suffix list.
+	String() string
+}
+
+// Options configures the Jar.
+type Options struct {
+	// PublicSuffixList is used for rejecting cookies for public suffixes
+	// like "com" or "co.uk", which would be sent to all the sites
+	// under them.
+	//
+	// By default the list embedded into golang.org/x/net/publicsuffix
+	// is used.
+	PublicSuffixList PublicSuffixList
+}
+
+// ErrRejected is returned by Jar.Set if the cookie cannot be stored.
+var ErrRejected = errors.New("cookie rejected")
+
+// Jar is an in-memory fasthttp.CookieJar.
+//
+// It is safe for concurrent use.
+type Jar struct {
+	psList PublicSuffixList
+
+	// entries is keyed by the registrable domain of the cookie
+	// and then by the entry id.
+	entries map[string]map[string]*Entry
+
+	now func() time.Time
+
+	nextSeqNum uint64
+
+	mu sync.Mutex
+}
+
+// New returns a jar with the given options.
+//
+// Default options are used if o is nil.
+func New(o *Options) (*Jar, error) {
+	j := &Jar{
+		psList:  publicsuffix.List,
+		entries: make(map[string]map[string]*Entry),
+		now:     time.Now,
+	}
+	if o != nil && o.PublicSuffixList != nil {
+		j.psList = o.PublicSuffixList
+	}
+	return j, nil
+}
+
+var _ fasthttp.CookieJar = (*Jar)(nil)
+
+// Entry is a cookie stored in the Jar.
+type Entry struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+
+	// Domain is the host the cookie is sent to. The cookie is sent
+	// to the subdomains of Domain too unless HostOnly is set.
+	Domain string `json:"domain"`
+	Path   string `json:"path"`
+
+	// SameSite is "Strict", "Lax", "None" or empty. Empty value
+	// is treated as "Lax".
+	SameSite string `json:"same_site,omitempty"`
+
+	// PartitionKey is the site the Partitioned cookie has been set in,
+	// like "https://example.com". The cookie is sent only in requests
+	// made from the same site.
+	PartitionKey string `json:"partition_key,omitempty"`
+
+	Expires    time.Time `json:"expires"`
+	Creation   time.Time `json:"creation"`
+	LastAccess time.Time `json:"last_access"`
+
+	Secure     bool `json:"secure,omitempty"`
+	HTTPOnly   bool `json:"http_only,omitempty"`
+	HostOnly   bool `json:"host_only,omitempty"`
+	Persistent bool `json:"persistent,omitempty"`
+
+	seqNum uint64
+}
+
+func (e *Entry) id() string {
+	return e.Name + ";" + e.Domain + ";" + e.Path + ";" + e.PartitionKey
+}
+
+// SetCookies implements fasthttp.CookieJar.
+//
+// Invalid cookies are silently ignored as required by RFC 6265bis.
+func (j *Jar) SetCookies(uri, site *fasthttp.URI, cookies []*fasthttp.Cookie) {
+	for _, c := range cookies {
+		_ = j.set(uri, site, c, true)
+	}
+}
+
+// Set stores the cookie for uri on behalf of a non-HTTP API like
+// document.cookie in browsers.
+//
+// HttpOnly cookies can be neither set nor overwritten via Set.
+// ErrRejected is returned if the cookie cannot be stored.
+func (j *Jar) Set(uri *fasthttp.URI, c *fasthttp.Cookie) error {
+	return j.set(uri, uri, c, false)
+}
+
+func (j *Jar) set(uri, site *fasthttp.URI, c *fasthttp.Cookie, httpAPI bool) error {
+	now := j.now()
+	e, remove, err := j.newEntry(uri, site, c, now, httpAPI)
+	if err != nil {
+		return err
+	}
+
+	key := j.jarKey(e.Domain)
+	id := e.id()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	submap := j.entries[key]
+	old, exists := submap[id]
+	if !httpAPI && exists && old.HTTPOnly {
+		return fmt.Errorf("%w: cannot overwrite HttpOnly cookie %q", ErrRejected, e.Name)
+	}
+	if !e.Secure && !isSecure(uri) && j.shadowsSecure(submap, e) {
+		return fmt.Errorf("%w: cannot overwrite Secure cookie %q from insecure origin", ErrRejected, e.Name)
+	}
+	if remove {
+		delete(submap, id)
+		if len(submap) == 0 {
+			delete(j.entries, key)
+		}
+		return nil
+	}
+	if exists {
+		e.Creation = old.Creation
+		e.seqNum = old.seqNum
+	} else {
+		e.seqNum = j.nextSeqNum
+		j.nextSeqNum++
+	}
+	if submap == nil {
+		submap = make(map[string]*Entry)
+		j.entries[key] = submap
+	}
+	submap[id] = e
+	return nil
+}
+
+// shadowsSecure returns true if e would overwrite or shadow a Secure cookie
+// according to RFC 6265bis, section 5.7, step 16.
+func (j *Jar) shadowsSecure(submap map[string]*Entry, e *Entry) bool {
+	for _, old := range submap {
+		if old.Secure && old.Name == e.Name && old.PartitionKey == e.PartitionKey &&
+			(domainMatch(old.Domain, e.Domain) || domainMatch(e.Domain, old.Domain)) &&
+			pathMatch(e.Path, old.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// newEntry creates the entry for the cookie received from uri.
+// remove is true if the cookie deletes the existing entry.
+func (j *Jar) newEntry(uri, site *fasthttp.URI, c *fasthttp.Cookie, now time.Time, httpAPI bool) (e *Entry, remove bool, err error) {
+	host, err := canonicalHost(uri.Host())
+	if err != nil {
+		return nil, false, err
+	}
+	secure := isSecure(uri)
+
+	e = &Entry{
+		Name:       string(c.Key()),
+		Value:      string(c.Value()),
+		Secure:     c.Secure(),
+		HTTPOnly:   c.HTTPOnly(),
+		Creation:   now,
+		LastAccess: now,
+	}
+	if e.Name == "" && e.Value == "" {
+		return nil, false, fmt.Errorf("%w: empty cookie", ErrRejected)
+	}
+	if !httpAPI && e.HTTPOnly {
+		return nil, false, fmt.Errorf("%w: HttpOnly cookie %q cannot be set by non-HTTP API", ErrRejected, e.Name)
+	}
+	if e.Secure && !secure {
+		return nil, false, fmt.Errorf("%w: Secure cookie %q from insecure origin", ErrRejected, e.Name)
+	}
+
+	if e.Domain, e.HostOnly, err = j.domainAndType(host, string(c.Domain())); err != nil {
+		return nil, false, err
+	}
+
+	e.Path = string(c.Path())
+	if e.Path == "" || e.Path[0] != '/' {
+		e.Path = defaultPath(string(uri.Path()))
+	}
+
+	// Cookie name prefixes from RFC 6265bis, section 4.1.3.
+	lowerName := strings.ToLower(e.Name)
+	if strings.HasPrefix(lowerName, "__secure-") && !e.Secure {
+		return nil, false, fmt.Errorf("%w: __Secure- cookie %q without Secure attribute", ErrRejected, e.Name)
+	}
+	if strings.HasPrefix(lowerName, "__host-") && (!e.Secure || !e.HostOnly || e.Path != "/") {
+		return nil, false, fmt.Errorf("%w: __Host- cookie %q with Domain, non-root Path or without Secure", ErrRejected, e.Name)
+	}
+
+	switch c.SameSite() {
+	case fasthttp.CookieSameSiteStrictMode:
+		e.SameSite = "Strict"
+	case fasthttp.CookieSameSiteLaxMode:
+		e.SameSite = "Lax"
+	case fasthttp.CookieSameSiteNoneMode:
+		if !e.Secure {
+			return nil, false, fmt.Errorf("%w: SameSite=None cookie %q without Secure attribute", ErrRejected, e.Name)
+		}
+		e.SameSite = "None"
+	}
+
+	if c.Partitioned() {
+		if !e.Secure {
+			return nil, false, fmt.Errorf("%w: Partitioned cookie %q without Secure attribute", ErrRejected, e.Name)
+		}
+		e.PartitionKey = j.siteKey(site)
+	}
+
+	// Max-Age takes precedence over Expires.
+	switch maxAge := c.MaxAge(); {
+	case maxAge < 0:
+		return e, true, nil
+	case maxAge > 0:
+		e.Expires = now.Add(time.Duration(maxAge) * time.Second)
+		e.Persistent = true
+	default:
+		if expires := c.Expire(); !expires.Equal(fasthttp.CookieExpireUnlimited) {
+			if !expires.After(now) {
+				return e, true, nil
+			}
+			e.Expires = expires
+			e.Persistent = true
+		}
+	}
+	return e, false, nil
+}
+
+// domainAndType returns the domain of the cookie with the given Domain
+// attribute received from host and whether the cookie is host-only.
+func (j *Jar) domainAndType(host, domain string) (string, bool, error) {
+	if domain == "" {
+		return host, true, nil
+	}
+
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	if domain == "" || strings.HasSuffix(domain, ".") {
+		return "", false, fmt.Errorf("%w: malformed domain %q", ErrRejected, domain)
+	}
+	if isIP(host) {
+		// Domain attribute may be used with IP addresses only if it
+		// equals to the host.
+		if domain != host {
+			return "", false, fmt.Errorf("%w: domain %q for IP address %q", ErrRejected, domain, host)
+		}
+		return host, true, nil
+	}
+
+	if j.psList != nil {
+		if ps := j.psList.PublicSuffix(domain); ps != "" && !hasDotSuffix(domain, ps) {
+			if host == domain {
+				// The public suffix is the host itself, so the cookie
+				// may be stored as host-only.
+				return host, true, nil
+			}
+			return "", false, fmt.Errorf("%w: domain %q is a public suffix", ErrRejected, domain)
+		}
+	}
+
+	if host != domain && !hasDotSuffix(host, domain) {
+		return "", false, fmt.Errorf("%w: domain %q doesn't match host %q", ErrRejected, domain, host)
+	}
+	return domain, false, nil
+}
+
+// Cookies implements fasthttp.CookieJar.
+//
+// The cookies are sorted by path length in descending order and then
+// by creation time as required by RFC 6265bis, section 5.8.3.
+func (j *Jar) Cookies(uri, site *fasthttp.URI, method []byte) []*fasthttp.Cookie {
+	entries := j.matching(uri, site, method, true)
+	if len(entries) == 0 {
+		return nil
+	}
+	cookies := make([]*fasthttp.Cookie, len(entries))
+	for i, e := range entries {
+		c := &fasthttp.Cookie{}
+		c.SetKey(e.Name)
+		c.SetValue(e.Value)
+		cookies[i] = c
+	}
+	return cookies
+}
+
+// Get returns the cookies for uri visible to non-HTTP APIs like
+// document.cookie in browsers. HttpOnly cookies are excluded.
+//
+// The returned cookies contain only names and values.
+func (j *Jar) Get(uri *fasthttp.URI) []*fasthttp.Cookie {
+	entries := j.matching(uri, uri, []byte(fasthttp.MethodGet), false)
+	cookies := make([]*fasthttp.Cookie, 0, len(entries))
+	for _, e := range entries {
+		c := &fasthttp.Cookie{}
+		c.SetKey(e.Name)
+		c.SetValue(e.Value)
+		cookies = append(cookies, c)
+	}
+	return cookies
+}
+
+func (j *Jar) matching(uri, site *fasthttp.URI, method []byte, httpAPI bool) []Entry {
+	host, err := canonicalHost(uri.Host())
+	if err != nil {
+		return nil
+	}
+	secure := isSecure(uri)
+	path := string(uri.Path())
+	if path == "" {
+		path = "/"
+	}
+	sameSite := j.siteKey(uri) == j.siteKey(site)
+	safeMethod := isSafeMethod(method)
+	partitionKey := j.siteKey(site)
+
+	now := j.now()
+	key := j.jarKey(host)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	submap := j.entries[key]
+	var selected []Entry
+	for id, e := range submap {
+		if e.Persistent && !e.Expires.After(now) {
+			delete(submap, id)
+			continue
+		}
+		if !e.shouldSend(host, path, secure) {
+			continue
+		}
+		if !httpAPI && e.HTTPOnly {
+			continue
+		}
+		if e.PartitionKey != "" && e.PartitionKey != partitionKey {
+			continue
+		}
+		switch e.SameSite {
+		case "None":
+		case "Strict":
+			if !sameSite {
+				continue
+			}
+		default:
+			if !sameSite && !safeMethod {
+				continue
+			}
+		}
+		e.LastAccess = now
+		selected = append(selected, *e)
+	}
+	if len(submap) == 0 {
+		delete(j.entries, key)
+	}
+
+	slices.SortFunc(selected, func(a, b Entry) int {
+		if c := cmp.Compare(len(b.Path), len(a.Path)); c != 0 {
+			return c
+		}
+		if c := a.Creation.Compare(b.Creation); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.seqNum, b.seqNum)
+	})
+	return selected
+}
+
+func (e *Entry) shouldSend(host, path string, secure bool) bool {
+	if e.HostOnly {
+		if host != e.Domain {
+			return false
+		}
+	} else if !domainMatch(host, e.Domain) {
+		return false
+	}
+	return pathMatch(path, e.Path) && (secure || !e.Secure)
+}
+
+// siteKey returns the scheme and the registrable domain of uri.
+func (j *Jar) siteKey(uri *fasthttp.URI) string {
+	host, err := canonicalHost(uri.Host())
+	if err != nil {
+		return ""
+	}
+	return string(uri.Scheme()) + "://" + j.jarKey(host)
+}
+
+// jarKey returns the registrable domain (eTLD+1) of host.
+func (j *Jar) jarKey(host string) string {
+	if isIP(host) || j.psList == nil {
+		return host
+	}
+	ps := j.psList.PublicSuffix(host)
+	if ps == host {
+		return host
+	}
+	i := len(host) - len(ps)
+	if i <= 0 || host[i-1] != '.' {
+		// The public suffix list is broken. Use the host as is.
+		return host
+	}
+	prevDot := strings.LastIndexByte(host[:i-1], '.')
+	return host[prevDot+1:]
+}
+
+func isSecure(uri *fasthttp.URI) bool {
+	return string(uri.Scheme()) == "https"
+}
+
+func isSafeMethod(method []byte) bool {
+	switch string(method) {
+	case fasthttp.MethodGet, fasthttp.MethodHead, fasthttp.MethodOptions, fasthttp.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func isIP(host string) bool {
+	return net.ParseIP(host) != nil
+}
+
+// canonicalHost returns the lowercase host without port and trailing dot.
+func canonicalHost(host []byte) (string, error) {
+	h := strings.ToLower(string(host))
+	if strings.HasPrefix(h, "[") {
+		// IPv6 address with optional port.
+		end := strings.IndexByte(h, ']')
+		if end < 0 {
+			return "", fmt.Errorf("%w: malformed host %q", ErrRejected, h)
+		}
+		return h[1:end], nil
+	}
+	if i := strings.LastIndexByte(h, ':'); i >= 0 {
+		h = h[:i]
+	}
+	h = strings.TrimSuffix(h, ".")
+	if h == "" {
+		return "", fmt.Errorf("%w: empty host", ErrRejected)
+	}
+	return h, nil
+}
+
+// hasDotSuffix returns true if s ends with "." + suffix.
+func hasDotSuffix(s, suffix string) bool {
+	return len(s) > len(suffix) && s[len(s)-len(suffix)-1] == '.' && s[len(s)-len(suffix):] == suffix
+}
+
+// domainMatch implements domain-match from RFC 6265bis, section 5.1.3.
+func domainMatch(host, domain string) bool {
+	return host == domain || (!isIP(host) && hasDotSuffix(host, domain))
+}
+
+// pathMatch implements path-match from RFC 6265bis, section 5.1.4.
+func pathMatch(requestPath, cookiePath string) bool {
+	if requestPath == cookiePath {
+		return true
+	}
+	if !strings.HasPrefix(requestPath, cookiePath) {
+		return false
+	}
+	return cookiePath[len(cookiePath)-1] == '/' || requestPath[len(cookiePath)] == '/'
+}
+
+// defaultPath implements default-path from RFC 6265bis, section 5.1.4.
+func defaultPath(path string) string {
+	if path == "" || path[0] != '/' {
+		return "/"
+	}
+	i := strings.LastIndexByte(path, '/')
+	if i == 0 {
+		return "/"
+	}
+	return path[:i]
+}
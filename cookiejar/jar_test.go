@@ -0,0 +1,255 @@
+package cookiejar
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bhargawpradhan/fasthttp"
+)
+
+type testJar struct {
+	*Jar
+	now time.Time
+}
+
+func newTestJar(t *testing.T) *testJar {
+	t.Helper()
+
+	j, err := New(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tj := &testJar{Jar: j, now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	j.now = func() time.Time { return tj.now }
+	return tj
+}
+
+func parseURI(t *testing.T, s string) *fasthttp.URI {
+	t.Helper()
+
+	u := &fasthttp.URI{}
+	if err := u.Parse(nil, []byte(s)); err != nil {
+		t.Fatalf("cannot parse %q: %v", s, err)
+	}
+	return u
+}
+
+func (j *testJar) setCookies(t *testing.T, uri, site string, setCookies ...string) {
+	t.Helper()
+
+	cookies := make([]*fasthttp.Cookie, len(setCookies))
+	for i, s := range setCookies {
+		cookies[i] = &fasthttp.Cookie{}
+		if err := cookies[i].Parse(s); err != nil {
+			t.Fatalf("cannot parse cookie %q: %v", s, err)
+		}
+	}
+	j.SetCookies(parseURI(t, uri), parseURI(t, site), cookies)
+}
+
+func (j *testJar) cookies(t *testing.T, uri, site, method string) string {
+	t.Helper()
+
+	var parts []string
+	for _, c := range j.Cookies(parseURI(t, uri), parseURI(t, site), []byte(method)) {
+		parts = append(parts, string(c.Key())+"="+string(c.Value()))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (j *testJar) expectCookies(t *testing.T, uri, expected string) {
+	t.Helper()
+
+	if s := j.cookies(t, uri, uri, fasthttp.MethodGet); s != expected {
+		t.Fatalf("unexpected cookies for %q: %q. Expecting %q", uri, s, expected)
+	}
+}
+
+func TestJarDomain(t *testing.T) {
+	t.Parallel()
+
+	j := newTestJar(t)
+	j.setCookies(t, "http://www.example.com/", "http://www.example.com/",
+		"host=1",
+		"domain=2; Domain=example.com",
+		"dot=3; Domain=.EXAMPLE.com",
+		"other=4; Domain=other.com",
+		"suffix=5; Domain=com",
+		"sub=6; Domain=sub.www.example.com",
+	)
+	j.expectCookies(t, "http://www.example.com/", "host=1 domain=2 dot=3")
+	j.expectCookies(t, "http://example.com/", "domain=2 dot=3")
+	j.expectCookies(t, "http://a.b.example.com/", "domain=2 dot=3")
+	j.expectCookies(t, "http://other.com/", "")
+	j.expectCookies(t, "http://notexample.com/", "")
+
+	// Public suffixes may be used only as host-only cookies.
+	j.setCookies(t, "http://co.uk/", "http://co.uk/", "a=1; Domain=co.uk")
+	j.expectCookies(t, "http://co.uk/", "a=1")
+	j.expectCookies(t, "http://foo.co.uk/", "")
+	j.setCookies(t, "http://foo.co.uk/", "http://foo.co.uk/", "b=2; Domain=co.uk")
+	j.expectCookies(t, "http://bar.co.uk/", "")
+
+	// IP addresses match only themselves.
+	j.setCookies(t, "http://127.0.0.1:8080/", "http://127.0.0.1:8080/", "ip=1", "bad=2; Domain=0.0.1")
+	j.expectCookies(t, "http://127.0.0.1/", "ip=1")
+}
+
+func TestJarPath(t *testing.T) {
+	t.Parallel()
+
+	j := newTestJar(t)
+	j.setCookies(t, "http://example.com/foo/bar", "http://example.com/",
+		"default=1",
+		"root=2; Path=/",
+		"deep=3; Path=/foo/bar/baz",
+		"invalid=4; Path=relative",
+	)
+	j.expectCookies(t, "http://example.com/", "root=2")
+	j.expectCookies(t, "http://example.com/foobar", "root=2")
+	// Longer paths go first.
+	j.expectCookies(t, "http://example.com/foo/bar/baz/x", "deep=3 default=1 invalid=4 root=2")
+	j.expectCookies(t, "http://example.com/foo", "default=1 invalid=4 root=2")
+}
+
+func TestJarExpiry(t *testing.T) {
+	t.Parallel()
+
+	j := newTestJar(t)
+	j.setCookies(t, "http://example.com/", "http://example.com/",
+		"session=1",
+		"maxage=2; Max-Age=60",
+		"expires=3; Expires="+j.now.Add(time.Hour).Format(time.RFC1123),
+		"both=4; Max-Age=10; Expires="+j.now.Add(time.Hour).Format(time.RFC1123),
+		"past=5; Expires="+j.now.Add(-time.Hour).Format(time.RFC1123),
+	)
+	j.expectCookies(t, "http://example.com/", "session=1 maxage=2 expires=3 both=4")
+
+	j.now = j.now.Add(30 * time.Second)
+	j.expectCookies(t, "http://example.com/", "session=1 maxage=2 expires=3")
+
+	j.now = j.now.Add(time.Minute)
+	j.expectCookies(t, "http://example.com/", "session=1 expires=3")
+
+	// Max-Age=0 and the expiry in the past delete cookies.
+	j.setCookies(t, "http://example.com/", "http://example.com/",
+		"session=; Max-Age=0",
+		"expires=; Expires="+j.now.Add(-time.Second).Format(time.RFC1123),
+	)
+	j.expectCookies(t, "http://example.com/", "")
+}
+
+func TestJarSecure(t *testing.T) {
+	t.Parallel()
+
+	j := newTestJar(t)
+	j.setCookies(t, "http://example.com/", "http://example.com/", "insecure=1; Secure")
+	j.expectCookies(t, "https://example.com/", "")
+
+	j.setCookies(t, "https://example.com/", "https://example.com/",
+		"secure=1; Secure",
+		"__Secure-a=2; Secure",
+		"__Secure-b=3",
+		"__Host-c=4; Secure; Path=/",
+		"__Host-d=5; Secure; Path=/; Domain=example.com",
+		"__Host-e=6; Secure; Path=/foo",
+	)
+	j.expectCookies(t, "https://example.com/", "secure=1 __Secure-a=2 __Host-c=4")
+	j.expectCookies(t, "http://example.com/", "")
+
+	// Insecure origins cannot shadow Secure cookies.
+	j.setCookies(t, "http://example.com/", "http://example.com/", "secure=evil", "secure=evil2; Path=/foo")
+	j.expectCookies(t, "https://example.com/foo", "secure=1 __Secure-a=2 __Host-c=4")
+}
+
+func TestJarHTTPOnly(t *testing.T) {
+	t.Parallel()
+
+	j := newTestJar(t)
+	j.setCookies(t, "http://example.com/", "http://example.com/", "http=1; HttpOnly", "script=2")
+	j.expectCookies(t, "http://example.com/", "http=1 script=2")
+
+	uri := parseURI(t, "http://example.com/")
+	cookies := j.Get(uri)
+	if len(cookies) != 1 || string(cookies[0].Key()) != "script" {
+		t.Fatalf("unexpected cookies visible to non-HTTP API: %v", cookies)
+	}
+
+	var c fasthttp.Cookie
+	c.SetKey("http")
+	c.SetValue("overwritten")
+	if err := j.Set(uri, &c); err == nil {
+		t.Fatal("expecting error when overwriting HttpOnly cookie")
+	}
+	c.SetKey("new")
+	c.SetHTTPOnly(true)
+	if err := j.Set(uri, &c); err == nil {
+		t.Fatal("expecting error when setting HttpOnly cookie")
+	}
+	c.SetHTTPOnly(false)
+	if err := j.Set(uri, &c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	j.expectCookies(t, "http://example.com/", "http=1 script=2 new=overwritten")
+}
+
+func TestJarSameSite(t *testing.T) {
+	t.Parallel()
+
+	j := newTestJar(t)
+	j.setCookies(t, "https://example.com/", "https://example.com/",
+		"strict=1; SameSite=Strict",
+		"lax=2; SameSite=Lax",
+		"default=3",
+		"none=4; SameSite=None; Secure",
+		"insecurenone=5; SameSite=None",
+	)
+
+	check := func(site, method, expected string) {
+		t.Helper()
+
+		if s := j.cookies(t, "https://example.com/", site, method); s != expected {
+			t.Fatalf("unexpected cookies for %s request from %q: %q. Expecting %q", method, site, s, expected)
+		}
+	}
+	// Subdomains are the same site.
+	check("https://a.example.com/", fasthttp.MethodPost, "strict=1 lax=2 default=3 none=4")
+	check("https://other.com/", fasthttp.MethodGet, "lax=2 default=3 none=4")
+	check("https://other.com/", fasthttp.MethodPost, "none=4")
+	// The site is schemeful.
+	check("http://example.com/", fasthttp.MethodPost, "none=4")
+}
+
+func TestJarPartitioned(t *testing.T) {
+	t.Parallel()
+
+	j := newTestJar(t)
+	j.setCookies(t, "https://embed.com/", "https://top.com/",
+		"chips=1; Secure; Partitioned; SameSite=None",
+		"insecure=2; Partitioned",
+	)
+	j.setCookies(t, "https://embed.com/", "https://embed.com/", "own=3")
+
+	if s := j.cookies(t, "https://embed.com/", "https://top.com/", fasthttp.MethodGet); s != "chips=1 own=3" {
+		t.Fatalf("unexpected cookies in top.com partition: %q", s)
+	}
+	if s := j.cookies(t, "https://embed.com/", "https://other.com/", fasthttp.MethodGet); s != "own=3" {
+		t.Fatalf("unexpected cookies in other.com partition: %q", s)
+	}
+	j.expectCookies(t, "https://embed.com/", "own=3")
+}
+
+func TestJarReplace(t *testing.T) {
+	t.Parallel()
+
+	j := newTestJar(t)
+	j.setCookies(t, "http://example.com/", "http://example.com/", "a=1", "b=2")
+	j.now = j.now.Add(time.Second)
+	j.setCookies(t, "http://example.com/", "http://example.com/", "a=3")
+	// The replaced cookie keeps its creation time and its order.
+	j.expectCookies(t, "http://example.com/", "a=3 b=2")
+	if n := len(j.Entries()); n != 2 {
+		t.Fatalf("unexpected number of entries %d. Expecting 2", n)
+	}
+}
@@ -0,0 +1,129 @@
+package cookiejar
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Entries returns copies of all the unexpired cookies stored in the jar
+// including session cookies.
+func (j *Jar) Entries() []Entry {
+	now := j.now()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var entries []Entry
+	for _, submap := range j.entries {
+		for _, e := range submap {
+			if !e.Persistent || e.Expires.After(now) {
+				entries = append(entries, *e)
+			}
+		}
+	}
+	slices.SortFunc(entries, func(a, b Entry) int {
+		return strings.Compare(a.id(), b.id())
+	})
+	return entries
+}
+
+// Save writes the persistent unexpired cookies to w as JSON.
+//
+// Session cookies, i.e. the cookies without Expires and Max-Age,
+// aren't saved, since they end with the session.
+func (j *Jar) Save(w io.Writer) error {
+	entries := j.Entries()
+	persistent := entries[:0]
+	for _, e := range entries {
+		if e.Persistent {
+			persistent = append(persistent, e)
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(persistent)
+}
+
+// Load reads the cookies written by Save from r and adds them to the jar.
+//
+// Expired cookies are skipped. Loaded cookies replace the stored cookies
+// with the same name, domain, path and partition.
+func (j *Jar) Load(r io.Reader) error {
+	var entries []Entry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return err
+	}
+
+	now := j.now()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for i := range entries {
+		e := &entries[i]
+		if e.Name == "" && e.Value == "" || e.Domain == "" || !strings.HasPrefix(e.Path, "/") {
+			continue
+		}
+		if e.Persistent && !e.Expires.After(now) {
+			continue
+		}
+		e.seqNum = j.nextSeqNum
+		j.nextSeqNum++
+
+		key := j.jarKey(e.Domain)
+		submap := j.entries[key]
+		if submap == nil {
+			submap = make(map[string]*Entry)
+			j.entries[key] = submap
+		}
+		submap[e.id()] = e
+	}
+	return nil
+}
+
+// SaveFile saves the persistent cookies to the given file.
+//
+// The file is replaced atomically and is readable only by the owner,
+// since cookies often contain credentials.
+func (j *Jar) SaveFile(filename string) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpName := f.Name()
+	if err = j.Save(f); err == nil {
+		err = f.Chmod(0o600)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, filename)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+	}
+	return err
+}
+
+// LoadFile loads the cookies saved by SaveFile.
+//
+// Missing file isn't an error, so the jar may be loaded
+// before the first SaveFile call.
+func (j *Jar) LoadFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	return j.Load(f)
+}
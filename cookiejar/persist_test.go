@@ -0,0 +1,77 @@
+package cookiejar
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJarSaveLoad(t *testing.T) {
+	t.Parallel()
+
+	j := newTestJar(t)
+	j.setCookies(t, "https://example.com/foo/", "https://example.com/",
+		"session=1",
+		"persistent=2; Max-Age=3600; Secure; HttpOnly; SameSite=Strict",
+		"short=3; Max-Age=10; Domain=example.com",
+	)
+
+	var buf bytes.Buffer
+	if err := j.Save(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	j2 := newTestJar(t)
+	j2.now = j.now.Add(time.Minute)
+	if err := j2.Load(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Session and expired cookies aren't restored.
+	entries := j2.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	e := entries[0]
+	if e.Name != "persistent" || e.Value != "2" || e.Domain != "example.com" || e.Path != "/foo" ||
+		!e.Secure || !e.HTTPOnly || !e.HostOnly || e.SameSite != "Strict" {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	j2.expectCookies(t, "https://example.com/foo/bar", "persistent=2")
+	j2.expectCookies(t, "https://www.example.com/foo/bar", "")
+}
+
+func TestJarSaveLoadFile(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "cookies.json")
+	j := newTestJar(t)
+	if err := j.LoadFile(filename); err != nil {
+		t.Fatalf("unexpected error for missing file: %v", err)
+	}
+	j.setCookies(t, "http://example.com/", "http://example.com/", "a=1; Max-Age=60")
+	if err := j.SaveFile(filename); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("unexpected file permissions %v. Expecting 0600", perm)
+	}
+
+	j2 := newTestJar(t)
+	if err := j2.LoadFile(filename); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	j2.expectCookies(t, "http://example.com/", "a=1")
+
+	if err := os.WriteFile(filename, []byte("not json"), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := j2.LoadFile(filename); err == nil {
+		t.Fatal("expecting error for malformed file")
+	}
+}
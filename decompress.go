@@ -0,0 +1,244 @@
+package fasthttp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultMaxRequestBodyDecompressionRatio is the maximum ratio between
+// decompressed and compressed request body sizes the server accepts
+// by default.
+//
+// See Server.MaxRequestBodyDecompressionRatio for details.
+const DefaultMaxRequestBodyDecompressionRatio = 100
+
+// The decompression ratio isn't checked for bodies smaller than this size,
+// since tiny highly compressible bodies would be rejected otherwise.
+const minDecompressionRatioCheckSize = 64 * 1024
+
+// The maximum number of codings in the request Content-Encoding header.
+const maxRequestContentEncodings = 4
+
+var (
+	// ErrDecompressedBodyTooLarge is returned when the decompressed request body
+	// exceeds the configured limit.
+	ErrDecompressedBodyTooLarge = errors.New("decompressed body size exceeds the given limit")
+
+	// ErrDecompressionRatioTooLarge is returned when the ratio between
+	// the decompressed and the compressed request body sizes exceeds
+	// the configured limit.
+	ErrDecompressionRatioTooLarge = errors.New("body decompression ratio exceeds the given limit")
+)
+
+// requestBodyDecoder decodes the request body according to
+// the Content-Encoding request header and enforces the decompression limits.
+type requestBodyDecoder struct {
+	r   io.Reader
+	src countingReader
+
+	// decoders contains the pooled decoders in the order they were pushed.
+	decoders  [maxRequestContentEncodings]io.Reader
+	decodersN int
+
+	err error
+
+	decodedBytes int64
+	maxSize      int64
+	maxRatio     int64
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+var requestBodyDecoderPool = sync.Pool{
+	New: func() any {
+		return &requestBodyDecoder{}
+	},
+}
+
+func acquireRequestBodyDecoder(src io.Reader, contentEncoding []byte, maxSize, maxRatio int) (*requestBodyDecoder, error) {
+	codings := bytes.Split(contentEncoding, []byte{','})
+	if len(codings) > maxRequestContentEncodings {
+		return nil, ErrContentEncodingUnsupported
+	}
+
+	d := requestBodyDecoderPool.Get().(*requestBodyDecoder)
+	d.maxSize = int64(maxSize)
+	d.maxRatio = int64(maxRatio)
+	d.src.r = src
+	d.r = &d.src
+
+	// Codings are listed in the order they were applied,
+	// so they must be decoded in the reverse order.
+	for i := len(codings) - 1; i >= 0; i-- {
+		if err := d.push(bytes.TrimSpace(codings[i])); err != nil {
+			releaseRequestBodyDecoder(d)
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// releaseRequestBodyDecoder returns the decoders to their pools and d
+// to requestBodyDecoderPool. The underlying request body stream
+// isn't released.
+func releaseRequestBodyDecoder(d *requestBodyDecoder) {
+	for i := d.decodersN - 1; i >= 0; i-- {
+		switch zr := d.decoders[i].(type) {
+		case *gzip.Reader:
+			releaseGzipReader(zr)
+		case *brotli.Reader:
+			releaseBrotliReader(zr)
+		case *zstd.Decoder:
+			releaseZstdReader(zr)
+		case io.ReadCloser:
+			releaseFlateReader(zr)
+		}
+		d.decoders[i] = nil
+	}
+	d.decodersN = 0
+	d.r = nil
+	d.src = countingReader{}
+	d.err = nil
+	d.decodedBytes = 0
+	requestBodyDecoderPool.Put(d)
+}
+
+func (d *requestBodyDecoder) push(coding []byte) error {
+	var (
+		zr  io.Reader
+		err error
+	)
+	switch string(coding) {
+	case "", "identity":
+		return nil
+	case "gzip", "x-gzip":
+		zr, err = acquireGzipReader(d.r)
+	case "deflate":
+		zr, err = acquireFlateReader(d.r)
+	case "br":
+		zr, err = acquireBrotliReader(d.r)
+	case "zstd":
+		zr, err = acquireZstdReader(d.r)
+	default:
+		return ErrContentEncodingUnsupported
+	}
+	if err != nil {
+		return err
+	}
+	d.decoders[d.decodersN] = zr
+	d.decodersN++
+	d.r = zr
+	return nil
+}
+
+func (d *requestBodyDecoder) Read(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+
+	n, err := d.r.Read(p)
+	d.decodedBytes += int64(n)
+
+	if d.maxSize > 0 && d.decodedBytes > d.maxSize {
+		n -= int(d.decodedBytes - d.maxSize)
+		d.decodedBytes = d.maxSize
+		err = ErrDecompressedBodyTooLarge
+	} else if d.maxRatio > 0 && d.decodedBytes > minDecompressionRatioCheckSize &&
+		d.decodedBytes > d.maxRatio*d.src.n {
+		err = ErrDecompressionRatioTooLarge
+	}
+	if err != nil && err != io.EOF {
+		d.err = err
+	}
+	return n, err
+}
+
+// Close releases the decoders and the underlying request body stream.
+//
+// d mustn't be used after Close.
+func (d *requestBodyDecoder) Close() error {
+	if rs, ok := d.src.r.(*requestStream); ok {
+		releaseRequestStream(rs)
+	}
+	releaseRequestBodyDecoder(d)
+	return nil
+}
+
+// requestStreamOf returns the requestStream underlying the request
+// body stream r or nil if there is none.
+func requestStreamOf(r io.Reader) *requestStream {
+	if d, ok := r.(*requestBodyDecoder); ok {
+		r = d.src.r
+	}
+	rs, _ := r.(*requestStream)
+	return rs
+}
+
+// decompressBody transparently decodes the request body according to
+// the Content-Encoding header and removes the header afterwards.
+//
+// Streamed bodies are decoded on the fly, so the limits are enforced when
+// the handler reads the body. Content-Length keeps the encoded body size
+// for streamed bodies.
+func (req *Request) decompressBody(maxSize, maxRatio int) error {
+	contentEncoding := req.Header.ContentEncoding()
+	if len(contentEncoding) == 0 {
+		return nil
+	}
+
+	if req.bodyStream != nil {
+		if req.Header.ContentLength() == 0 {
+			req.Header.del(strContentEncoding)
+			return nil
+		}
+		d, err := acquireRequestBodyDecoder(req.bodyStream, contentEncoding, maxSize, maxRatio)
+		if err != nil {
+			return err
+		}
+		req.bodyStream = d
+		req.Header.del(strContentEncoding)
+		return nil
+	}
+
+	body := req.bodyBytes()
+	if len(body) == 0 {
+		req.Header.del(strContentEncoding)
+		return nil
+	}
+
+	d, err := acquireRequestBodyDecoder(&byteSliceReader{b: body}, contentEncoding, maxSize, maxRatio)
+	if err != nil {
+		return err
+	}
+	bb := requestBodyPool.Get()
+	_, err = copyZeroAlloc(bb, d)
+	releaseRequestBodyDecoder(d)
+	if err != nil {
+		requestBodyPool.Put(bb)
+		return err
+	}
+
+	if req.body != nil {
+		requestBodyPool.Put(req.body)
+	}
+	req.body = bb
+	req.bodyRaw = nil
+	req.Header.del(strContentEncoding)
+	req.Header.SetContentLength(len(bb.B))
+	return nil
+}
@@ -0,0 +1,476 @@
+package fasthttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDiscoveryInterval is the default interval between upstream
+	// discoveries.
+	DefaultDiscoveryInterval = 30 * time.Second
+
+	// DefaultDiscoveryTimeout is the default timeout of a single
+	// upstream discovery.
+	DefaultDiscoveryTimeout = 5 * time.Second
+
+	// DefaultDrainTimeout is the default maximum duration of waiting
+	// for in-flight requests of the removed upstream.
+	DefaultDrainTimeout = 30 * time.Second
+)
+
+// ErrNoBalancingClients is returned by LBClient when there are no clients
+// for sending the request, e.g. when Discovery hasn't found any upstreams.
+var ErrNoBalancingClients = errors.New("no clients available for balancing")
+
+// Upstream is the upstream found by Discoverer.
+type Upstream struct {
+	// Addr is the upstream address in host:port form.
+	Addr string `json:"addr"`
+
+	// Weight is the upstream weight. LBClient.Weight is used if it is zero.
+	Weight int `json:"weight,omitempty"`
+}
+
+// Discoverer returns the current set of upstreams.
+type Discoverer interface {
+	Discover(ctx context.Context) ([]Upstream, error)
+}
+
+// DiscovererFunc is an adapter allowing ordinary functions to be
+// used as Discoverer.
+type DiscovererFunc func(ctx context.Context) ([]Upstream, error)
+
+// Discover implements Discoverer.
+func (f DiscovererFunc) Discover(ctx context.Context) ([]Upstream, error) {
+	return f(ctx)
+}
+
+// Discovery configures continuous reconciliation of LBClient clients
+// with the upstreams returned by Discoverer.
+//
+// New upstreams get clients created by NewClient. Removed upstreams stop
+// receiving new requests, while their in-flight requests are completed.
+// Idle connections of the removed HostClient clients are closed after
+// the in-flight requests complete or DrainTimeout elapses.
+//
+// Clients set in LBClient.Clients aren't affected by Discovery.
+type Discovery struct {
+	// Discoverer returns the current set of upstreams.
+	Discoverer Discoverer
+
+	// NewClient creates the client for the new upstream.
+	//
+	// By default HostClient with the upstream Addr is created.
+	NewClient func(u Upstream) BalancingClient
+
+	// OnError is called when Discoverer returns an error.
+	// The current set of upstreams is kept in this case.
+	//
+	// By default errors are ignored.
+	OnError func(err error)
+
+	// Interval is the interval between discoveries.
+	//
+	// By default DefaultDiscoveryInterval is used.
+	Interval time.Duration
+
+	// Timeout is the timeout of a single discovery.
+	//
+	// By default DefaultDiscoveryTimeout is used.
+	Timeout time.Duration
+
+	// DrainTimeout is the maximum duration of waiting for in-flight
+	// requests of the removed upstream.
+	//
+	// By default DefaultDrainTimeout is used.
+	DrainTimeout time.Duration
+}
+
+func (d *Discovery) timeout() time.Duration {
+	if d.Timeout <= 0 {
+		return DefaultDiscoveryTimeout
+	}
+	return d.Timeout
+}
+
+// Rediscover reconciles the clients with the upstreams returned
+// by Discovery.Discoverer immediately.
+//
+// Discovery.OnError isn't called for the returned error.
+func (cc *LBClient) Rediscover(ctx context.Context) error {
+	cc.once.Do(cc.init)
+	if cc.Discovery == nil {
+		return errors.New("LBClient.Discovery isn't set")
+	}
+	return cc.discover(ctx, cc.Discovery, false)
+}
+
+func (cc *LBClient) runDiscovery(d *Discovery, stopCh <-chan struct{}) {
+	interval := d.Interval
+	if interval <= 0 {
+		interval = DefaultDiscoveryInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), d.timeout())
+		if err := cc.discover(ctx, d, false); err != nil && d.OnError != nil {
+			d.OnError(err)
+		}
+		cancel()
+	}
+}
+
+// discover reconciles the clients with the discovered upstreams.
+// Clients of the initial discovery don't ramp up load.
+func (cc *LBClient) discover(ctx context.Context, d *Discovery, initial bool) error {
+	upstreams, err := d.Discoverer.Discover(ctx)
+	if err != nil {
+		return err
+	}
+	want := make(map[string]Upstream, len(upstreams))
+	addrs := make([]string, 0, len(upstreams))
+	for _, u := range upstreams {
+		if _, ok := want[u.Addr]; ok || u.Addr == "" {
+			continue
+		}
+		want[u.Addr] = u
+		addrs = append(addrs, u.Addr)
+	}
+
+	now := time.Now()
+	var removed []*lbClient
+
+	cc.mu.Lock()
+	// Build a new slice, since the old one may be used by get
+	// callers holding the returned clients.
+	cs := make([]*lbClient, 0, len(cc.cs)+len(want))
+	for _, c := range cc.cs {
+		if c.upstream == "" {
+			cs = append(cs, c)
+			continue
+		}
+		u, ok := want[c.upstream]
+		if !ok {
+			removed = append(removed, c)
+			continue
+		}
+		if u.Weight > 0 {
+			c.weight = u.Weight
+		}
+		delete(want, c.upstream)
+		cs = append(cs, c)
+	}
+	for _, addr := range addrs {
+		u, ok := want[addr]
+		if !ok {
+			continue
+		}
+		var bc BalancingClient
+		if d.NewClient != nil {
+			bc = d.NewClient(u)
+		} else {
+			bc = &HostClient{Addr: u.Addr}
+		}
+		c := cc.newLBClient(bc)
+		c.upstream = u.Addr
+		if u.Weight > 0 {
+			c.weight = u.Weight
+		}
+		if !initial {
+			c.startSlowStart(now)
+		}
+		cs = append(cs, c)
+	}
+	cc.cs = cs
+	cc.rebuildTable()
+	cc.mu.Unlock()
+
+	drainTimeout := d.DrainTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = DefaultDrainTimeout
+	}
+	for _, c := range removed {
+		go c.drain(drainTimeout)
+	}
+	return nil
+}
+
+const drainPollInterval = 100 * time.Millisecond
+
+// drain waits for in-flight requests of the removed client
+// and closes its idle connections.
+func (c *lbClient) drain(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for c.c.PendingRequests() > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+	if closer, ok := c.c.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// DNSDiscoverer discovers upstreams by resolving A and AAAA records.
+//
+// Every resolved IP address becomes a separate upstream.
+type DNSDiscoverer struct {
+	// Resolver is used for the lookups.
+	//
+	// By default net.DefaultResolver is used.
+	Resolver *net.Resolver
+
+	// Addr is the host:port to resolve.
+	Addr string
+}
+
+// Discover implements Discoverer.
+func (d *DNSDiscoverer) Discover(ctx context.Context) ([]Upstream, error) {
+	host, port, err := net.SplitHostPort(d.Addr)
+	if err != nil {
+		return nil, err
+	}
+	r := d.Resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	ips, err := r.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	upstreams := make([]Upstream, 0, len(ips))
+	for _, ip := range ips {
+		upstreams = append(upstreams, Upstream{Addr: net.JoinHostPort(ip.String(), port)})
+	}
+	return upstreams, nil
+}
+
+// SRVDiscoverer discovers upstreams by resolving SRV records.
+//
+// Only the records with the lowest priority are used according
+// to RFC 2782. Record weights become upstream weights.
+type SRVDiscoverer struct {
+	// Resolver is used for the lookups.
+	//
+	// By default net.DefaultResolver is used.
+	Resolver *net.Resolver
+
+	// Service and Proto are the service and protocol of the records,
+	// e.g. "http" and "tcp". Name is looked up directly if they are empty.
+	Service string
+	Proto   string
+
+	// Name is the domain name of the records.
+	Name string
+}
+
+// Discover implements Discoverer.
+func (d *SRVDiscoverer) Discover(ctx context.Context) ([]Upstream, error) {
+	r := d.Resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	_, srvs, err := r.LookupSRV(ctx, d.Service, d.Proto, d.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(srvs) == 0 {
+		return nil, nil
+	}
+	// LookupSRV sorts the records by priority.
+	priority := srvs[0].Priority
+	upstreams := make([]Upstream, 0, len(srvs))
+	for _, srv := range srvs {
+		if srv.Priority != priority {
+			break
+		}
+		target := strings.TrimSuffix(srv.Target, ".")
+		upstreams = append(upstreams, Upstream{
+			Addr:   net.JoinHostPort(target, strconv.Itoa(int(srv.Port))),
+			Weight: int(srv.Weight),
+		})
+	}
+	return upstreams, nil
+}
+
+// FileDiscoverer discovers upstreams listed in JSON or YAML file.
+//
+// The file must contain a list of upstreams either at the top level or
+// under "upstreams" key. Every upstream is either a host:port string
+// or an object with "addr" and optional "weight" keys:
+//
+//	upstreams:
+//	  - 10.0.0.1:80
+//	  - addr: 10.0.0.2:80
+//	    weight: 2
+//
+// Files with .yaml and .yml extensions are parsed as YAML, other files
+// are parsed as JSON. Only the YAML subset shown above is supported.
+//
+// The file is parsed again only when its modification time or size
+// changes, so it may be polled with short Discovery.Interval.
+type FileDiscoverer struct {
+	modTime   time.Time
+	upstreams []Upstream
+
+	// Path is the file path.
+	Path string
+
+	size int64
+
+	mu sync.Mutex
+}
+
+// Discover implements Discoverer.
+func (d *FileDiscoverer) Discover(_ context.Context) ([]Upstream, error) {
+	fi, err := os.Stat(d.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.upstreams != nil && fi.ModTime().Equal(d.modTime) && fi.Size() == d.size {
+		return slices.Clone(d.upstreams), nil
+	}
+	data, err := os.ReadFile(d.Path)
+	if err != nil {
+		return nil, err
+	}
+	var upstreams []Upstream
+	switch strings.ToLower(filepath.Ext(d.Path)) {
+	case ".yaml", ".yml":
+		upstreams, err = parseUpstreamsYAML(data)
+	default:
+		upstreams, err = parseUpstreamsJSON(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %q: %w", d.Path, err)
+	}
+	if upstreams == nil {
+		upstreams = []Upstream{}
+	}
+	d.upstreams = upstreams
+	d.modTime = fi.ModTime()
+	d.size = fi.Size()
+	return slices.Clone(upstreams), nil
+}
+
+// upstreamJSON accepts both host:port strings and Upstream objects.
+type upstreamJSON Upstream
+
+func (u *upstreamJSON) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &u.Addr)
+	}
+	return json.Unmarshal(data, (*Upstream)(u))
+}
+
+func parseUpstreamsJSON(data []byte) ([]Upstream, error) {
+	var list []upstreamJSON
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		var v struct {
+			Upstreams []upstreamJSON `json:"upstreams"`
+		}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		list = v.Upstreams
+	} else if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	upstreams := make([]Upstream, len(list))
+	for i, u := range list {
+		upstreams[i] = Upstream(u)
+	}
+	return upstreams, nil
+}
+
+func parseUpstreamsYAML(data []byte) ([]Upstream, error) {
+	var (
+		upstreams  []Upstream
+		current    *Upstream
+		itemIndent = -1
+	)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	lineNum := 0
+	for sc.Scan() {
+		lineNum++
+		line := sc.Text()
+		if i := strings.Index(line, "#"); i >= 0 && (i == 0 || line[i-1] == ' ') {
+			line = line[:i]
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if trimmed == "upstreams:" && indent == 0 {
+			continue
+		}
+
+		if rest, ok := strings.CutPrefix(trimmed, "-"); ok && (itemIndent < 0 || indent == itemIndent) {
+			itemIndent = indent
+			upstreams = append(upstreams, Upstream{})
+			current = &upstreams[len(upstreams)-1]
+			rest = strings.TrimSpace(rest)
+			if rest == "" {
+				continue
+			}
+			if !strings.Contains(rest, ": ") && !strings.HasSuffix(rest, ":") {
+				current.Addr = yamlScalar(rest)
+				current = nil
+				continue
+			}
+			trimmed = rest
+		} else if current == nil || indent <= itemIndent {
+			return nil, fmt.Errorf("unexpected line %d: %q", lineNum, trimmed)
+		}
+
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return nil, fmt.Errorf("unexpected line %d: %q", lineNum, trimmed)
+		}
+		value = yamlScalar(strings.TrimSpace(value))
+		switch strings.TrimSpace(key) {
+		case "addr":
+			current.Addr = value
+		case "weight":
+			weight, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid weight at line %d: %w", lineNum, err)
+			}
+			current.Weight = weight
+		default:
+			return nil, fmt.Errorf("unknown key %q at line %d", key, lineNum)
+		}
+	}
+	return upstreams, sc.Err()
+}
+
+// yamlScalar removes quotes from YAML scalar.
+func yamlScalar(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,326 @@
+package fasthttp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bhargawpradhan/fasthttp/fasthttputil"
+)
+
+// discoveryBackends serves requests for any upstream address in memory.
+// Responses contain the upstream address.
+type discoveryBackends struct {
+	t       *testing.T
+	lns     map[string]*fasthttputil.InmemoryListener
+	block   chan struct{}
+	blocked chan struct{}
+	mu      sync.Mutex
+}
+
+func newDiscoveryBackends(t *testing.T) *discoveryBackends {
+	return &discoveryBackends{
+		t:       t,
+		lns:     make(map[string]*fasthttputil.InmemoryListener),
+		block:   make(chan struct{}),
+		blocked: make(chan struct{}, 1),
+	}
+}
+
+func (b *discoveryBackends) listener(addr string) *fasthttputil.InmemoryListener {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ln := b.lns[addr]; ln != nil {
+		return ln
+	}
+	ln := startRetryServer(b.t, func(ctx *RequestCtx) {
+		if string(ctx.Path()) == "/block" {
+			b.blocked <- struct{}{}
+			<-b.block
+		}
+		ctx.WriteString(addr) //nolint:errcheck
+	})
+	b.lns[addr] = ln
+	return ln
+}
+
+func (b *discoveryBackends) newClient(u Upstream) BalancingClient {
+	return &HostClient{
+		Addr: u.Addr,
+		Dial: func(addr string) (net.Conn, error) {
+			return b.listener(addr).Dial()
+		},
+	}
+}
+
+func lbUpstreams(t *testing.T, lb *LBClient, n int) string {
+	t.Helper()
+
+	seen := make(map[string]bool)
+	for i := 0; i < n; i++ {
+		_, body, err := lbGet(lb, "/")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		seen[body] = true
+	}
+	addrs := make([]string, 0, len(seen))
+	for addr := range seen {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return strings.Join(addrs, ",")
+}
+
+func lbGet(lb *LBClient, path string) (int, string, error) {
+	req := AcquireRequest()
+	resp := AcquireResponse()
+	defer ReleaseRequest(req)
+	defer ReleaseResponse(resp)
+	req.SetRequestURI("http://backend.test" + path)
+	err := lb.DoTimeout(req, resp, 5*time.Second)
+	return resp.StatusCode(), string(resp.Body()), err
+}
+
+func TestLBClientDNSDiscovery(t *testing.T) {
+	t.Parallel()
+
+	dns, err := fasthttputil.NewDNSServer()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer dns.Close()
+	dns.SetIPs("backend.test", net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"))
+
+	backends := newDiscoveryBackends(t)
+	lb := &LBClient{
+		Discovery: &Discovery{
+			Discoverer: &DNSDiscoverer{Resolver: dns.Resolver(), Addr: "backend.test:80"},
+			NewClient:  backends.newClient,
+			Interval:   time.Hour,
+		},
+	}
+	defer lb.Close()
+
+	if s := lbUpstreams(t, lb, 10); s != "10.0.0.1:80,10.0.0.2:80" {
+		t.Fatalf("unexpected upstreams %q", s)
+	}
+
+	// In-flight requests to the removed upstream complete.
+	errCh := make(chan error, 1)
+	var body string
+	go func() {
+		var err error
+		for {
+			_, body, err = lbGet(lb, "/block")
+			if err != nil || body == "10.0.0.1:80" {
+				break
+			}
+		}
+		errCh <- err
+	}()
+	go func() {
+		// Unblock the requests sent to the remaining upstream.
+		for range backends.blocked {
+			backends.block <- struct{}{}
+		}
+	}()
+
+	dns.SetIPs("backend.test", net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3"))
+	if err := lb.Rediscover(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("unexpected error for in-flight request: %v", err)
+	}
+	close(backends.blocked)
+	if s := lbUpstreams(t, lb, 10); s != "10.0.0.2:80,10.0.0.3:80" {
+		t.Fatalf("unexpected upstreams %q", s)
+	}
+
+	// The upstreams are kept on errors.
+	dns.Delete("backend.test")
+	var dnsErr *net.DNSError
+	if err := lb.Rediscover(context.Background()); !errors.As(err, &dnsErr) {
+		t.Fatalf("unexpected error: %v. Expecting DNS error", err)
+	}
+	if s := lbUpstreams(t, lb, 10); s != "10.0.0.2:80,10.0.0.3:80" {
+		t.Fatalf("unexpected upstreams %q", s)
+	}
+}
+
+func TestSRVDiscoverer(t *testing.T) {
+	t.Parallel()
+
+	dns, err := fasthttputil.NewDNSServer()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer dns.Close()
+	dns.SetSRV("_http._tcp.backend.test",
+		net.SRV{Target: "a.backend.test", Port: 8080, Priority: 10, Weight: 3},
+		net.SRV{Target: "b.backend.test", Port: 8081, Priority: 10, Weight: 1},
+		net.SRV{Target: "backup.backend.test", Port: 8080, Priority: 20, Weight: 1},
+	)
+
+	d := &SRVDiscoverer{Resolver: dns.Resolver(), Service: "http", Proto: "tcp", Name: "backend.test"}
+	upstreams, err := d.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sort.Slice(upstreams, func(i, j int) bool { return upstreams[i].Addr < upstreams[j].Addr })
+	if len(upstreams) != 2 ||
+		upstreams[0] != (Upstream{Addr: "a.backend.test:8080", Weight: 3}) ||
+		upstreams[1] != (Upstream{Addr: "b.backend.test:8081", Weight: 1}) {
+		t.Fatalf("unexpected upstreams %+v", upstreams)
+	}
+}
+
+func TestFileDiscoverer(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name string
+		data string
+	}{
+		{name: "list.json", data: `["10.0.0.1:80", {"addr": "10.0.0.2:80", "weight": 2}]`},
+		{name: "object.json", data: `{"upstreams": ["10.0.0.1:80", {"addr": "10.0.0.2:80", "weight": 2}]}`},
+		{name: "list.yaml", data: "# upstreams\n- 10.0.0.1:80\n- addr: \"10.0.0.2:80\"\n  weight: 2\n"},
+		{name: "object.yml", data: "upstreams:\n  - '10.0.0.1:80' # first\n  -\n    addr: 10.0.0.2:80\n    weight: 2\n"},
+	} {
+		path := filepath.Join(dir, tc.name)
+		if err := os.WriteFile(path, []byte(tc.data), 0o600); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		d := &FileDiscoverer{Path: path}
+		upstreams, err := d.Discover(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", tc.name, err)
+		}
+		if len(upstreams) != 2 ||
+			upstreams[0] != (Upstream{Addr: "10.0.0.1:80"}) ||
+			upstreams[1] != (Upstream{Addr: "10.0.0.2:80", Weight: 2}) {
+			t.Fatalf("unexpected upstreams for %s: %+v", tc.name, upstreams)
+		}
+	}
+
+	for _, data := range []string{"- addr: 10.0.0.1:80\n  port: 80\n", "  weight: 1\n", "- addr: a\n  weight: x\n"} {
+		path := filepath.Join(dir, "invalid.yaml")
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := (&FileDiscoverer{Path: path}).Discover(context.Background()); err == nil {
+			t.Fatalf("expecting error for %q", data)
+		}
+	}
+}
+
+func TestLBClientFileDiscovery(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "upstreams.json")
+	if err := os.WriteFile(path, []byte(`["10.0.0.1:80"]`), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	backends := newDiscoveryBackends(t)
+	lb := &LBClient{
+		Discovery: &Discovery{
+			Discoverer: &FileDiscoverer{Path: path},
+			NewClient:  backends.newClient,
+			Interval:   10 * time.Millisecond,
+		},
+	}
+	defer lb.Close()
+	if s := lbUpstreams(t, lb, 5); s != "10.0.0.1:80" {
+		t.Fatalf("unexpected upstreams %q", s)
+	}
+
+	// The file changes are picked up in background.
+	if err := os.WriteFile(path, []byte(`["10.0.0.1:80", "10.0.0.2:80"]`), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var s string
+	for i := 0; i < 100; i++ {
+		if s = lbUpstreams(t, lb, 10); s == "10.0.0.1:80,10.0.0.2:80" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s != "10.0.0.1:80,10.0.0.2:80" {
+		t.Fatalf("unexpected upstreams %q", s)
+	}
+}
+
+func TestLBClientDiscovererFunc(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu        sync.Mutex
+		upstreams []Upstream
+		errs      []error
+	)
+	errDiscovery := errors.New("discovery failure")
+	backends := newDiscoveryBackends(t)
+	static := backends.newClient(Upstream{Addr: "static:80"})
+	lb := &LBClient{
+		Clients: []BalancingClient{static},
+		Discovery: &Discovery{
+			Discoverer: DiscovererFunc(func(ctx context.Context) ([]Upstream, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				if upstreams == nil {
+					return nil, errDiscovery
+				}
+				return upstreams, nil
+			}),
+			NewClient: backends.newClient,
+			Interval:  time.Hour,
+			OnError: func(err error) {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			},
+		},
+	}
+	defer lb.Close()
+
+	// The initial discovery failure is reported and static clients are used.
+	if s := lbUpstreams(t, lb, 5); s != "static:80" {
+		t.Fatalf("unexpected upstreams %q", s)
+	}
+	mu.Lock()
+	if len(errs) != 1 || !errors.Is(errs[0], errDiscovery) {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	upstreams = []Upstream{{Addr: "a:80"}, {Addr: "a:80"}}
+	mu.Unlock()
+
+	if err := lb.Rediscover(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s := lbUpstreams(t, lb, 10); s != "a:80,static:80" {
+		t.Fatalf("unexpected upstreams %q", s)
+	}
+
+	// No clients at all.
+	lb2 := &LBClient{
+		Discovery: &Discovery{
+			Discoverer: DiscovererFunc(func(ctx context.Context) ([]Upstream, error) {
+				return nil, nil
+			}),
+		},
+	}
+	defer lb2.Close()
+	if _, _, err := lbGet(lb2, "/"); !errors.Is(err, ErrNoBalancingClients) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrNoBalancingClients)
+	}
+}
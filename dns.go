@@ -0,0 +1,87 @@
+package fasthttp
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// TTLResolver is a Resolver returning the time-to-live of the resolved
+// addresses.
+//
+// TCPDialer caches the addresses returned by TTLResolver for their
+// time-to-live clamped to [TCPDialer.DNSMinTTL, TCPDialer.DNSMaxTTL].
+type TTLResolver interface {
+	Resolver
+
+	// LookupIPAddrTTL returns the addresses for the given host
+	// and their time-to-live. Zero time-to-live means it is unknown.
+	// Negative time-to-live means the addresses mustn't be cached.
+	LookupIPAddrTTL(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error)
+}
+
+// lookupIPAddr resolves the host via StaticHosts and Resolver.
+func (d *TCPDialer) lookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error) {
+	if ipaddrs, ok := d.StaticHosts[host]; ok {
+		return ipaddrs, 0, nil
+	}
+	switch r := d.Resolver.(type) {
+	case nil:
+		ipaddrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		return ipaddrs, 0, err
+	case TTLResolver:
+		return r.LookupIPAddrTTL(ctx, host)
+	default:
+		ipaddrs, err := r.LookupIPAddr(ctx, host)
+		return ipaddrs, 0, err
+	}
+}
+
+// dnsCacheDuration returns the duration for caching addresses
+// with the given time-to-live.
+func (d *TCPDialer) dnsCacheDuration(ttl time.Duration) time.Duration {
+	if ttl == 0 {
+		return d.DNSCacheDuration
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+	if ttl < d.DNSMinTTL {
+		ttl = d.DNSMinTTL
+	}
+	if d.DNSMaxTTL > 0 && ttl > d.DNSMaxTTL {
+		ttl = d.DNSMaxTTL
+	}
+	return ttl
+}
+
+// resolveTCPAddrEntry resolves addr and stores the result in the cache.
+//
+// The preferred address family is inherited from prev if it isn't nil.
+func (d *TCPDialer) resolveTCPAddrEntry(addr string, dualStack bool, deadline time.Time, prev *tcpAddrEntry) (*tcpAddrEntry, error) {
+	addrs, ttl, err := d.resolveTCPAddrs(addr, dualStack, deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	e := &tcpAddrEntry{
+		addrs:       addrs,
+		resolveTime: now,
+		expireTime:  now.Add(d.dnsCacheDuration(ttl)),
+	}
+	if prev != nil {
+		e.preferredFamily.Store(prev.preferredFamily.Load())
+	}
+	d.tcpAddrsMap.Store(addr, e)
+	return e, nil
+}
+
+// prefetchTCPAddrs re-resolves addr before the cached entry e expires.
+func (d *TCPDialer) prefetchTCPAddrs(addr string, dualStack bool, e *tcpAddrEntry) {
+	if _, err := d.resolveTCPAddrEntry(addr, dualStack, time.Now().Add(DefaultDialTimeout), e); err != nil {
+		// Let the next dial retry the resolving.
+		atomic.StoreInt32(&e.pending, 0)
+	}
+}
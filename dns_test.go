@@ -0,0 +1,226 @@
+package fasthttp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeTTLResolver struct {
+	err     error
+	ipaddrs []net.IPAddr
+	ttl     time.Duration
+	lookups int
+	mu      sync.Mutex
+}
+
+func (r *fakeTTLResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ipaddrs, _, err := r.LookupIPAddrTTL(ctx, host)
+	return ipaddrs, err
+}
+
+func (r *fakeTTLResolver) LookupIPAddrTTL(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups++
+	if r.err != nil {
+		return nil, 0, r.err
+	}
+	return r.ipaddrs, r.ttl, nil
+}
+
+func (r *fakeTTLResolver) set(ttl time.Duration, err error) {
+	r.mu.Lock()
+	r.ttl = ttl
+	r.err = err
+	r.mu.Unlock()
+}
+
+func (r *fakeTTLResolver) lookupsCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lookups
+}
+
+func newFakeDNSDialer(r Resolver) *TCPDialer {
+	return &TCPDialer{
+		Resolver: r,
+		testHookDial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return &happyEyeballsConn{addr: addr}, nil
+		},
+	}
+}
+
+func dnsCacheExpiry(t *testing.T, d *TCPDialer, addr string) time.Duration {
+	t.Helper()
+
+	item, ok := d.tcpAddrsMap.Load(addr)
+	if !ok {
+		t.Fatalf("missing cache entry for %q", addr)
+	}
+	return time.Until(item.(*tcpAddrEntry).expireTime)
+}
+
+func TestTCPDialerDNSTTL(t *testing.T) {
+	t.Parallel()
+
+	r := &fakeTTLResolver{ipaddrs: []net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}, ttl: time.Hour}
+	d := newFakeDNSDialer(r)
+	d.DNSMinTTL = 10 * time.Second
+	d.DNSMaxTTL = time.Minute
+
+	if _, err := d.Dial("foo.test:80"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ttl := dnsCacheExpiry(t, d, "foo.test:80"); ttl <= 50*time.Second || ttl > time.Minute {
+		t.Fatalf("unexpected cache duration %s. Expecting it clamped to %s", ttl, d.DNSMaxTTL)
+	}
+
+	r.set(time.Second, nil)
+	if _, err := d.Dial("bar.test:80"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ttl := dnsCacheExpiry(t, d, "bar.test:80"); ttl <= 5*time.Second || ttl > 10*time.Second {
+		t.Fatalf("unexpected cache duration %s. Expecting it clamped to %s", ttl, d.DNSMinTTL)
+	}
+
+	// Unknown TTL falls back to DNSCacheDuration.
+	r.set(0, nil)
+	if _, err := d.Dial("baz.test:80"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ttl := dnsCacheExpiry(t, d, "baz.test:80"); ttl <= 50*time.Second || ttl > DefaultDNSCacheDuration {
+		t.Fatalf("unexpected cache duration %s. Expecting %s", ttl, DefaultDNSCacheDuration)
+	}
+	if n := r.lookupsCount(); n != 3 {
+		t.Fatalf("unexpected number of lookups %d. Expecting 3", n)
+	}
+
+	// Negative TTL disables caching unless DNSMinTTL is set.
+	r.set(-1, nil)
+	if _, err := d.Dial("qux.test:80"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ttl := dnsCacheExpiry(t, d, "qux.test:80"); ttl <= 5*time.Second || ttl > 10*time.Second {
+		t.Fatalf("unexpected cache duration %s. Expecting it clamped to %s", ttl, d.DNSMinTTL)
+	}
+	d.DNSMinTTL = 0
+	if _, err := d.Dial("quux.test:80"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ttl := dnsCacheExpiry(t, d, "quux.test:80"); ttl > 0 {
+		t.Fatalf("unexpected cache duration %s. Expecting the addresses not to be cached", ttl)
+	}
+}
+
+func TestTCPDialerDNSStale(t *testing.T) {
+	t.Parallel()
+
+	errOutage := errors.New("resolver outage")
+	for _, staleDuration := range []time.Duration{0, time.Minute} {
+		r := &fakeTTLResolver{ipaddrs: []net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}, ttl: 10 * time.Millisecond}
+		d := newFakeDNSDialer(r)
+		d.DNSStaleDuration = staleDuration
+
+		if _, err := d.Dial("foo.test:80"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+		r.set(10*time.Millisecond, errOutage)
+
+		conn, err := d.Dial("foo.test:80")
+		if staleDuration == 0 {
+			if !errors.Is(err, errOutage) {
+				t.Fatalf("unexpected error: %v. Expecting %v", err, errOutage)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if a := conn.(*happyEyeballsConn).addr; a != "127.0.0.1:80" {
+			t.Fatalf("unexpected upstream %q", a)
+		}
+
+		// The resolver is retried on every dial until it recovers.
+		r.set(time.Minute, nil)
+		if _, err := d.Dial("foo.test:80"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if n := r.lookupsCount(); n != 3 {
+			t.Fatalf("unexpected number of lookups %d. Expecting 3", n)
+		}
+		if ttl := dnsCacheExpiry(t, d, "foo.test:80"); ttl <= 0 {
+			t.Fatalf("the entry hasn't been refreshed after the outage")
+		}
+	}
+}
+
+func TestTCPDialerDNSPrefetch(t *testing.T) {
+	t.Parallel()
+
+	r := &fakeTTLResolver{ipaddrs: []net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}, ttl: 200 * time.Millisecond}
+	d := newFakeDNSDialer(r)
+	d.DNSPrefetchDuration = 150 * time.Millisecond
+
+	if _, err := d.Dial("foo.test:80"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The entry isn't in the prefetch window yet.
+	if _, err := d.Dial("foo.test:80"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := r.lookupsCount(); n != 1 {
+		t.Fatalf("unexpected number of lookups %d. Expecting 1", n)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	r.set(time.Minute, nil)
+	if _, err := d.Dial("foo.test:80"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; r.lookupsCount() < 2; i++ {
+		if i > 100 {
+			t.Fatal("the entry hasn't been prefetched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; dnsCacheExpiry(t, d, "foo.test:80") < time.Second; i++ {
+		if i > 100 {
+			t.Fatal("the prefetched entry hasn't been stored")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTCPDialerStaticHosts(t *testing.T) {
+	t.Parallel()
+
+	r := &fakeTTLResolver{ipaddrs: []net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}}
+	d := newFakeDNSDialer(r)
+	d.StaticHosts = map[string][]net.IPAddr{
+		"foo.test": {{IP: net.IPv4(127, 0, 0, 2)}},
+	}
+
+	conn, err := d.Dial("foo.test:8080")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a := conn.(*happyEyeballsConn).addr; a != "127.0.0.2:8080" {
+		t.Fatalf("unexpected upstream %q", a)
+	}
+	if n := r.lookupsCount(); n != 0 {
+		t.Fatalf("unexpected number of lookups %d. Expecting 0", n)
+	}
+
+	conn, err = d.Dial("bar.test:80")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a := conn.(*happyEyeballsConn).addr; a != "127.0.0.1:80" {
+		t.Fatalf("unexpected upstream %q", a)
+	}
+}
@@ -0,0 +1,255 @@
+package fasthttp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DoHResolver is a TTLResolver sending DNS-over-HTTPS queries (RFC 8484)
+// via Client.
+//
+// It may be used as TCPDialer.Resolver:
+//
+//	dialer := &fasthttp.TCPDialer{
+//		Resolver: &fasthttp.DoHResolver{
+//			URL: "https://1.1.1.1/dns-query",
+//		},
+//	}
+//
+// Use the IP address of the DoH server in URL, so resolving its host
+// name doesn't require DNS.
+type DoHResolver struct {
+	// Client is used for sending the queries.
+	//
+	// By default a Client with DefaultDoHTimeout read and write
+	// timeouts is used.
+	Client *Client
+
+	clientOnce    sync.Once
+	defaultClient *Client
+
+	// URL is the DoH endpoint. For example, https://dns.google/dns-query.
+	URL string
+
+	// DisableIPv6 disables AAAA queries, so only IPv4 addresses
+	// are resolved.
+	DisableIPv6 bool
+}
+
+// DefaultDoHTimeout is the timeout used by DoHResolver for the queries
+// if the lookup context has no deadline.
+const DefaultDoHTimeout = 5 * time.Second
+
+const dohContentType = "application/dns-message"
+
+// LookupIPAddr implements Resolver.
+func (r *DoHResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ipaddrs, _, err := r.LookupIPAddrTTL(ctx, host)
+	return ipaddrs, err
+}
+
+// LookupIPAddrTTL implements TTLResolver.
+//
+// A and AAAA queries are sent concurrently. The returned time-to-live
+// is the minimum time-to-live of the answer records. It is negative
+// if the minimum is zero, so the addresses aren't cached.
+func (r *DoHResolver) LookupIPAddrTTL(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IPAddr{{IP: ip}}, 0, nil
+	}
+	name, err := dnsmessage.NewName(dnsFQDN(host))
+	if err != nil {
+		return nil, 0, &net.DNSError{Err: err.Error(), Name: host}
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultDoHTimeout)
+		defer cancel()
+	}
+
+	types := []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	if r.DisableIPv6 {
+		types = types[:1]
+	}
+	type result struct {
+		err     error
+		ipaddrs []net.IPAddr
+		ttl     time.Duration
+	}
+	results := make([]result, len(types))
+	var wg sync.WaitGroup
+	for i, t := range types {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := &results[i]
+			res.ipaddrs, res.ttl, res.err = r.query(ctx, host, name, t)
+		}()
+	}
+	wg.Wait()
+
+	var ipaddrs []net.IPAddr
+	var ttl time.Duration
+	var ttlSet bool
+	var firstErr error
+	for _, res := range results {
+		if res.err != nil {
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			continue
+		}
+		// Zero time-to-live is valid, so it cannot mean unset.
+		if len(res.ipaddrs) > 0 && (!ttlSet || res.ttl < ttl) {
+			ttl = res.ttl
+			ttlSet = true
+		}
+		ipaddrs = append(ipaddrs, res.ipaddrs...)
+	}
+	if len(ipaddrs) == 0 {
+		if firstErr != nil {
+			return nil, 0, firstErr
+		}
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host, Server: r.URL, IsNotFound: true}
+	}
+	if ttl == 0 {
+		ttl = -1
+	}
+	return ipaddrs, ttl, nil
+}
+
+func (r *DoHResolver) client() *Client {
+	if r.Client != nil {
+		return r.Client
+	}
+	r.clientOnce.Do(func() {
+		r.defaultClient = &Client{
+			ReadTimeout:  DefaultDoHTimeout,
+			WriteTimeout: DefaultDoHTimeout,
+		}
+	})
+	return r.defaultClient
+}
+
+// query sends a single DoH query for the records of the given type.
+func (r *DoHResolver) query(ctx context.Context, host string, name dnsmessage.Name, t dnsmessage.Type) ([]net.IPAddr, time.Duration, error) {
+	// The query ID is zero for making the responses HTTP cache friendly
+	// as recommended by RFC 8484.
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{RecursionDesired: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, 0, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: name, Type: t, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, 0, err
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req := AcquireRequest()
+	resp := AcquireResponse()
+	defer ReleaseRequest(req)
+	defer ReleaseResponse(resp)
+	req.SetRequestURI(r.URL)
+	req.Header.SetMethod(MethodPost)
+	req.Header.SetContentType(dohContentType)
+	req.Header.Set(HeaderAccept, dohContentType)
+	req.SetBodyRaw(msg)
+	if err := r.client().DoContext(ctx, req, resp); err != nil {
+		return nil, 0, &net.DNSError{Err: err.Error(), Name: host, Server: r.URL, IsTimeout: ctx.Err() != nil, IsTemporary: true}
+	}
+	if code := resp.StatusCode(); code != StatusOK {
+		return nil, 0, &net.DNSError{
+			Err:         fmt.Sprintf("unexpected DoH response status code %d", code),
+			Name:        host,
+			Server:      r.URL,
+			IsTemporary: code >= 500,
+		}
+	}
+	return parseDoHResponse(resp.Body(), host, r.URL, t)
+}
+
+// parseDoHResponse returns the addresses of the given type from the DNS
+// message and their minimum time-to-live.
+func parseDoHResponse(msg []byte, host, server string, t dnsmessage.Type) ([]net.IPAddr, time.Duration, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil {
+		return nil, 0, dohParseError(err, host, server)
+	}
+	switch h.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host, Server: server, IsNotFound: true}
+	default:
+		return nil, 0, &net.DNSError{
+			Err:         "DNS server failure: " + h.RCode.String(),
+			Name:        host,
+			Server:      server,
+			IsTemporary: h.RCode == dnsmessage.RCodeServerFailure,
+		}
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, 0, dohParseError(err, host, server)
+	}
+
+	var ipaddrs []net.IPAddr
+	var ttl time.Duration
+	for {
+		rh, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, 0, dohParseError(err, host, server)
+		}
+		// CNAME records are followed by the server, so only the records
+		// of the requested type matter. Their names may differ from host.
+		if rh.Type != t || rh.Class != dnsmessage.ClassINET {
+			if err := p.SkipAnswer(); err != nil {
+				return nil, 0, dohParseError(err, host, server)
+			}
+			continue
+		}
+		var ip net.IP
+		switch t {
+		case dnsmessage.TypeA:
+			a, err := p.AResource()
+			if err != nil {
+				return nil, 0, dohParseError(err, host, server)
+			}
+			ip = net.IP(a.A[:])
+		case dnsmessage.TypeAAAA:
+			aaaa, err := p.AAAAResource()
+			if err != nil {
+				return nil, 0, dohParseError(err, host, server)
+			}
+			ip = net.IP(aaaa.AAAA[:])
+		}
+		recordTTL := time.Duration(rh.TTL) * time.Second
+		if len(ipaddrs) == 0 || recordTTL < ttl {
+			ttl = recordTTL
+		}
+		ipaddrs = append(ipaddrs, net.IPAddr{IP: ip})
+	}
+	return ipaddrs, ttl, nil
+}
+
+func dnsFQDN(host string) string {
+	if len(host) > 0 && host[len(host)-1] == '.' {
+		return host
+	}
+	return host + "."
+}
+
+func dohParseError(err error, host, server string) error {
+	return &net.DNSError{Err: "cannot parse DoH response: " + err.Error(), Name: host, Server: server}
+}
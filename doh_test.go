@@ -0,0 +1,230 @@
+package fasthttp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bhargawpradhan/fasthttp/fasthttputil"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// startFakeDoHServer starts DoH server answering the queries for:
+//
+//   - foo.test: A 1.2.3.4 with TTL 30s and AAAA ::1 with TTL 60s
+//   - alias.test: CNAME to foo.test with TTL 10s
+//   - zero.test: A 1.2.3.5 with TTL 0 and AAAA ::2 with TTL 60s
+//   - broken.test: status code 500
+//
+// Other names are answered with NXDOMAIN.
+func startFakeDoHServer(t *testing.T) (*DoHResolver, *atomic.Int32) {
+	t.Helper()
+
+	var queries atomic.Int32
+	ln := fasthttputil.NewInmemoryListener()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			queries.Add(1)
+			if string(ctx.Path()) != "/dns-query" || !ctx.IsPost() ||
+				string(ctx.Request.Header.ContentType()) != dohContentType {
+				ctx.Error("bad request", StatusBadRequest)
+				return
+			}
+			var q dnsmessage.Message
+			if err := q.Unpack(ctx.PostBody()); err != nil || len(q.Questions) != 1 {
+				ctx.Error("bad request", StatusBadRequest)
+				return
+			}
+			question := q.Questions[0]
+			resp := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: q.ID, Response: true, RecursionAvailable: true},
+				Questions: q.Questions,
+			}
+			target := question.Name
+			switch question.Name.String() {
+			case "broken.test.":
+				ctx.Error("internal error", StatusInternalServerError)
+				return
+			case "alias.test.":
+				target = dnsmessage.MustNewName("foo.test.")
+				resp.Answers = append(resp.Answers, dnsmessage.Resource{
+					Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeCNAME, Class: dnsmessage.ClassINET, TTL: 10},
+					Body:   &dnsmessage.CNAMEResource{CNAME: target},
+				})
+				fallthrough
+			case "foo.test.":
+				switch question.Type {
+				case dnsmessage.TypeA:
+					resp.Answers = append(resp.Answers, dnsmessage.Resource{
+						Header: dnsmessage.ResourceHeader{Name: target, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 30},
+						Body:   &dnsmessage.AResource{A: [4]byte{1, 2, 3, 4}},
+					})
+				case dnsmessage.TypeAAAA:
+					resp.Answers = append(resp.Answers, dnsmessage.Resource{
+						Header: dnsmessage.ResourceHeader{Name: target, Type: dnsmessage.TypeAAAA, Class: dnsmessage.ClassINET, TTL: 60},
+						Body:   &dnsmessage.AAAAResource{AAAA: [16]byte{15: 1}},
+					})
+				}
+			case "zero.test.":
+				switch question.Type {
+				case dnsmessage.TypeA:
+					resp.Answers = append(resp.Answers, dnsmessage.Resource{
+						Header: dnsmessage.ResourceHeader{Name: target, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+						Body:   &dnsmessage.AResource{A: [4]byte{1, 2, 3, 5}},
+					})
+				case dnsmessage.TypeAAAA:
+					resp.Answers = append(resp.Answers, dnsmessage.Resource{
+						Header: dnsmessage.ResourceHeader{Name: target, Type: dnsmessage.TypeAAAA, Class: dnsmessage.ClassINET, TTL: 60},
+						Body:   &dnsmessage.AAAAResource{AAAA: [16]byte{15: 2}},
+					})
+				}
+			default:
+				resp.RCode = dnsmessage.RCodeNameError
+			}
+			b, err := resp.Pack()
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			ctx.SetContentType(dohContentType)
+			ctx.SetBody(b)
+		},
+	}
+	serverCh := make(chan error, 1)
+	go func() {
+		serverCh <- s.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		if err := <-serverCh; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	r := &DoHResolver{
+		URL: "http://doh.test/dns-query",
+		Client: &Client{
+			Dial: func(addr string) (net.Conn, error) {
+				return ln.Dial()
+			},
+		},
+	}
+	return r, &queries
+}
+
+func TestDoHResolver(t *testing.T) {
+	t.Parallel()
+
+	r, queries := startFakeDoHServer(t)
+	ctx := context.Background()
+
+	ipaddrs, ttl, err := r.LookupIPAddrTTL(ctx, "foo.test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ipaddrs) != 2 || ipaddrs[0].IP.String() != "1.2.3.4" || ipaddrs[1].IP.String() != "::1" {
+		t.Fatalf("unexpected addresses %v", ipaddrs)
+	}
+	if ttl != 30*time.Second {
+		t.Fatalf("unexpected ttl %s. Expecting 30s", ttl)
+	}
+	if n := queries.Load(); n != 2 {
+		t.Fatalf("unexpected number of queries %d. Expecting 2", n)
+	}
+
+	// The CNAME chain is followed by the server.
+	ipaddrs, ttl, err = r.LookupIPAddrTTL(ctx, "alias.test.")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ipaddrs) != 2 || ttl != 30*time.Second {
+		t.Fatalf("unexpected addresses %v with ttl %s", ipaddrs, ttl)
+	}
+
+	// IP addresses aren't resolved.
+	queries.Store(0)
+	ipaddrs, err = r.LookupIPAddr(ctx, "10.0.0.1")
+	if err != nil || len(ipaddrs) != 1 || ipaddrs[0].IP.String() != "10.0.0.1" {
+		t.Fatalf("unexpected addresses %v: %v", ipaddrs, err)
+	}
+	if n := queries.Load(); n != 0 {
+		t.Fatalf("unexpected number of queries %d. Expecting 0", n)
+	}
+
+	r.DisableIPv6 = true
+	ipaddrs, err = r.LookupIPAddr(ctx, "foo.test")
+	if err != nil || len(ipaddrs) != 1 || ipaddrs[0].IP.String() != "1.2.3.4" {
+		t.Fatalf("unexpected addresses %v: %v", ipaddrs, err)
+	}
+}
+
+func TestDoHResolverErrors(t *testing.T) {
+	t.Parallel()
+
+	r, _ := startFakeDoHServer(t)
+	ctx := context.Background()
+
+	_, err := r.LookupIPAddr(ctx, "missing.test")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Fatalf("unexpected error: %v. Expecting not found error", err)
+	}
+
+	_, err = r.LookupIPAddr(ctx, "broken.test")
+	if !errors.As(err, &dnsErr) || !dnsErr.Temporary() || dnsErr.IsNotFound {
+		t.Fatalf("unexpected error: %v. Expecting temporary error", err)
+	}
+}
+
+func TestDoHResolverTCPDialer(t *testing.T) {
+	t.Parallel()
+
+	r, queries := startFakeDoHServer(t)
+	d := newFakeDNSDialer(r)
+	d.DNSMaxTTL = 20 * time.Second
+
+	conn, err := d.Dial("foo.test:443")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a := conn.(*happyEyeballsConn).addr; a != "1.2.3.4:443" {
+		t.Fatalf("unexpected upstream %q", a)
+	}
+	if ttl := dnsCacheExpiry(t, d, "foo.test:443"); ttl <= 10*time.Second || ttl > 20*time.Second {
+		t.Fatalf("unexpected cache duration %s", ttl)
+	}
+	if _, err = d.Dial("foo.test:443"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := queries.Load(); n != 2 {
+		t.Fatalf("unexpected number of queries %d. Expecting 2", n)
+	}
+}
+
+func TestDoHResolverZeroTTL(t *testing.T) {
+	t.Parallel()
+
+	r, queries := startFakeDoHServer(t)
+
+	// Zero TTL of the A answer mustn't be replaced by the AAAA one.
+	ipaddrs, ttl, err := r.LookupIPAddrTTL(context.Background(), "zero.test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ipaddrs) != 2 || ttl >= 0 {
+		t.Fatalf("unexpected addresses %v with ttl %s. Expecting negative ttl", ipaddrs, ttl)
+	}
+
+	// The addresses with zero TTL aren't cached.
+	d := newFakeDNSDialer(r)
+	for range 2 {
+		if _, err = d.Dial("zero.test:443"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := queries.Load(); n != 6 {
+		t.Fatalf("unexpected number of queries %d. Expecting 6", n)
+	}
+}
@@ -1,5 +1,5 @@
 // Package fasthttpadaptor provides helper functions for converting net/http
-// request handlers to fasthttp request handlers.
+// request handlers to fasthttp request handlers and vice versa.
 package fasthttpadaptor
 
 import (
@@ -51,15 +51,52 @@ func NewFastHTTPHandlerFunc(h http.HandlerFunc) fasthttp.RequestHandler {
 // switching. Then manually convert net/http handlers to fasthttp handlers
 // according to https://github.com/bhargawpradhan/fasthttp#switching-from-nethttp-to-fasthttp .
 func NewFastHTTPHandler(h http.Handler) fasthttp.RequestHandler {
+	return NewFastHTTPHandlerWithOptions(h, nil)
+}
+
+// HandlerOptions configures the handler returned
+// by NewFastHTTPHandlerWithOptions.
+type HandlerOptions struct {
+	// StreamRequestBody makes r.Body read directly from the request
+	// body stream, so large uploads aren't buffered in memory.
+	//
+	// It takes effect only if Server.StreamRequestBody is enabled.
+	// r.ContentLength is -1 for chunked request bodies.
+	//
+	// By default r.Body reads the fully buffered request body.
+	StreamRequestBody bool
+
+	// StreamResponseBody makes the response stream to the client
+	// via RequestCtx.SetBodyStreamWriter starting from the first Write
+	// without waiting for the handler to return or to call Flush.
+	//
+	// The response headers are sent on the first Write, and the response
+	// body is always sent with chunked transfer encoding.
+	//
+	// By default the response is buffered until the handler returns
+	// or calls Flush.
+	StreamResponseBody bool
+}
+
+// NewFastHTTPHandlerWithOptions wraps net/http handler to fasthttp request
+// handler like NewFastHTTPHandler does, but with the given options.
+//
+// Default options are used if o is nil.
+func NewFastHTTPHandlerWithOptions(h http.Handler, o *HandlerOptions) fasthttp.RequestHandler {
+	var opts HandlerOptions
+	if o != nil {
+		opts = *o
+	}
 	return func(ctx *fasthttp.RequestCtx) {
 		var r http.Request
-		if err := ConvertRequest(ctx, &r, true); err != nil {
+		if err := convertRequest(ctx, &r, true, opts.StreamRequestBody); err != nil {
 			ctx.Logger().Printf("cannot parse requestURI %q: %v", r.RequestURI, err)
 			ctx.Error("Internal Server Error", fasthttp.StatusInternalServerError)
 			return
 		}
 
 		w := acquireWriter(ctx)
+		w.streamResponse = opts.StreamResponseBody
 		// Serve the net/http handler concurrently so we can react to Flush/Hijack.
 		go func() {
 			defer func() {
@@ -211,6 +248,9 @@ type writer struct {
 
 	hijacked atomic.Bool
 
+	// streamResponse switches to streaming on the first Write.
+	streamResponse bool
+
 	modeCh chan int
 
 	streamReady chan struct{}
@@ -260,13 +300,18 @@ func (w *writer) Write(p []byte) (int, error) {
 	}
 
 	w.mu.Lock()
-	defer w.mu.Unlock()
-
 	if w.responseBody == nil {
 		w.bufPool = bufferPool.Get().(*[]byte)
 		w.responseBody = (*w.bufPool)[:0]
 	}
 	w.responseBody = append(w.responseBody, p...)
+	w.mu.Unlock()
+
+	if w.streamResponse {
+		// The buffered bytes are sent first when streaming starts,
+		// so they are still used for Content-Type detection.
+		w.Flush()
+	}
 	return len(p), nil
 }
 
@@ -703,3 +703,92 @@ func TestNewFastHTTPHandlerPanic(t *testing.T) {
 
 	t.Error("expected panic, but it didn't happen")
 }
+
+func TestNewFastHTTPHandlerWithOptions(t *testing.T) {
+	t.Parallel()
+
+	nethttpH := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Content-Length", fmt.Sprint(r.ContentLength))
+
+		// The first chunk is read before the client sends the rest.
+		first := make([]byte, 3)
+		if _, err := io.ReadFull(r.Body, first); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		// The first write is sent without Flush.
+		if _, err := w.Write(first); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		rest, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if _, err = w.Write(rest); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	s := &fasthttp.Server{
+		Handler: NewFastHTTPHandlerWithOptions(http.HandlerFunc(nethttpH), &HandlerOptions{
+			StreamRequestBody:  true,
+			StreamResponseBody: true,
+		}),
+		StreamRequestBody: true,
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	go s.Serve(ln)                     //nolint:errcheck
+	t.Cleanup(func() { s.Shutdown() }) //nolint:errcheck
+
+	clientCh := make(chan struct{})
+	go func() {
+		defer close(clientCh)
+
+		c, err := ln.Dial()
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		defer c.Close()
+		if _, err = c.Write([]byte("POST / HTTP/1.1\r\nHost: aa\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nfoo\r\n")); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+
+		resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+		if err != nil {
+			t.Errorf("unexpected error reading response: %v", err)
+			return
+		}
+		defer resp.Body.Close()
+		if v := resp.Header.Get("X-Content-Length"); v != "-1" {
+			t.Errorf("unexpected request content length %q. Expecting %q", v, "-1")
+		}
+		if v := resp.Header.Get("Content-Type"); v != "text/plain; charset=utf-8" {
+			t.Errorf("unexpected Content-Type header: %q. Expecting %q", v, "text/plain; charset=utf-8")
+		}
+		first := make([]byte, 3)
+		if _, err = io.ReadFull(resp.Body, first); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+
+		if _, err = c.Write([]byte("3\r\nbar\r\n0\r\n\r\n")); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		rest, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Errorf("unexpected error reading body: %v", err)
+		}
+		if body := string(first) + string(rest); body != "foobar" {
+			t.Errorf("unexpected response body: %q. Expecting %q", body, "foobar")
+		}
+	}()
+
+	select {
+	case <-clientCh:
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+}
@@ -0,0 +1,238 @@
+package fasthttpadaptor
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bhargawpradhan/fasthttp"
+)
+
+// NewNetHTTPHandlerFunc wraps fasthttp request handler to net/http
+// handler func, so it can be passed to net/http server.
+//
+// See NewNetHTTPHandler for details.
+func NewNetHTTPHandlerFunc(h fasthttp.RequestHandler) http.HandlerFunc {
+	return NewNetHTTPHandler(h).ServeHTTP
+}
+
+// NewNetHTTPHandler wraps fasthttp request handler to net/http handler,
+// so it can be mounted in net/http server, tested with net/http/httptest
+// and served over HTTP/2 and HTTP/3 stacks built on net/http.
+//
+// The request body is passed to the handler as a body stream, so it is read
+// on demand via RequestCtx.RequestBodyStream or RequestCtx.PostBody.
+// The response body set via SetBodyStream or SetBodyStreamWriter is streamed
+// to the client with flushing after each chunk.
+//
+// RequestCtx.Hijack is supported if the http.ResponseWriter implements
+// http.Hijacker. The response is written to the hijacked connection
+// before calling the HijackHandler unless HijackSetNoResponse is set,
+// and the connection is closed after the HijackHandler returns.
+// Requests are answered with 500 Internal Server Error if the handler
+// hijacks the connection, which cannot be hijacked.
+//
+// The original http.Request is available in the handler via
+// NetHTTPRequest. The user values set by the handler are removed
+// after it returns, and the values implementing io.Closer are closed.
+//
+// RequestCtx used as context.Context isn't canceled when the net/http
+// request is canceled, e.g. when the client disconnects or resets
+// the HTTP/2 stream. See NetHTTPRequest for observing the cancellation.
+func NewNetHTTPHandler(h fasthttp.RequestHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ctx fasthttp.RequestCtx
+		ctx.Init2(newRequestConn(r), nil, false)
+		convertNetHTTPRequest(r, &ctx.Request)
+		ctx.SetUserValue(netHTTPRequestKey{}, r)
+
+		h(&ctx)
+
+		if hijackHandler := ctx.HijackHandler(); hijackHandler != nil {
+			hijack(w, &ctx, hijackHandler)
+		} else {
+			writeNetHTTPResponse(w, r, &ctx.Response)
+		}
+		ctx.Response.CloseBodyStream() //nolint:errcheck
+		ctx.ResetUserValues()
+	})
+}
+
+type netHTTPRequestKey struct{}
+
+// NetHTTPRequest returns the http.Request passed to the handler
+// created via NewNetHTTPHandler or nil for other requests.
+//
+// The values of the net/http request context may be obtained via
+// NetHTTPRequest(ctx).Context().Value(key).
+//
+// ctx is never canceled in the handlers created via NewNetHTTPHandler,
+// so the handler must pass NetHTTPRequest(ctx).Context() instead of ctx
+// to the calls, which must be canceled together with the net/http request:
+//
+//	r := fasthttpadaptor.NetHTTPRequest(ctx)
+//	rows, err := db.QueryContext(r.Context(), query)
+func NetHTTPRequest(ctx *fasthttp.RequestCtx) *http.Request {
+	r, _ := ctx.UserValue(netHTTPRequestKey{}).(*http.Request)
+	return r
+}
+
+func convertNetHTTPRequest(r *http.Request, req *fasthttp.Request) {
+	req.Header.SetMethod(r.Method)
+	req.SetRequestURI(r.URL.RequestURI())
+	req.Header.SetProtocol(r.Proto)
+	req.Header.SetHost(r.Host)
+	for k, vv := range r.Header {
+		for _, v := range vv {
+			req.Header.Add(k, v)
+		}
+	}
+	if r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0 {
+		req.SetBodyStream(r.Body, int(r.ContentLength))
+	}
+}
+
+// writeNetHTTPResponse writes resp to w.
+func writeNetHTTPResponse(w http.ResponseWriter, r *http.Request, resp *fasthttp.Response) {
+	copyResponseHeader(w.Header(), &resp.Header)
+
+	if !resp.IsBodyStream() {
+		body := resp.Body()
+		if code := resp.StatusCode(); code >= 200 && code != fasthttp.StatusNoContent && code != fasthttp.StatusNotModified {
+			w.Header().Set(fasthttp.HeaderContentLength, strconv.Itoa(len(body)))
+		}
+		w.WriteHeader(resp.StatusCode())
+		if r.Method != fasthttp.MethodHead {
+			w.Write(body) //nolint:errcheck
+		}
+		return
+	}
+
+	if n := resp.Header.ContentLength(); n >= 0 {
+		w.Header().Set(fasthttp.HeaderContentLength, strconv.Itoa(n))
+	}
+	w.WriteHeader(resp.StatusCode())
+	if r.Method == fasthttp.MethodHead {
+		return
+	}
+	flusher, _ := w.(http.Flusher)
+	buf := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(buf)
+	stream := resp.BodyStream()
+	for {
+		n, err := stream.Read(*buf)
+		if n > 0 {
+			if _, e := w.Write((*buf)[:n]); e != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// copyResponseHeader copies the fasthttp response headers except
+// the headers managed by net/http.
+func copyResponseHeader(dst http.Header, h *fasthttp.ResponseHeader) {
+	for k, v := range h.All() {
+		switch sk := b2s(k); sk {
+		case fasthttp.HeaderContentLength, fasthttp.HeaderConnection, fasthttp.HeaderTransferEncoding,
+			fasthttp.HeaderDate:
+		default:
+			dst.Add(sk, string(v))
+		}
+	}
+}
+
+// hijack passes the connection hijacked from w to hijackHandler.
+func hijack(w http.ResponseWriter, ctx *fasthttp.RequestCtx, hijackHandler fasthttp.HijackHandler) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	if !ctx.HijackNoResponse() {
+		if err = ctx.Response.Write(rw.Writer); err == nil {
+			err = rw.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+	hijackHandler(&hijackedConn{Conn: conn, r: rw.Reader})
+}
+
+// hijackedConn reads the data buffered by net/http before hijacking.
+type hijackedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *hijackedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// requestConn is the fake connection of the net/http request.
+// It provides the addresses and the TLS state to RequestCtx.
+type requestConn struct {
+	localAddr  net.Addr
+	remoteAddr net.Addr
+}
+
+func newRequestConn(r *http.Request) net.Conn {
+	c := &requestConn{
+		localAddr:  &net.TCPAddr{IP: net.IPv4zero},
+		remoteAddr: &net.TCPAddr{IP: net.IPv4zero},
+	}
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		c.localAddr = addr
+	}
+	if host, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if ip := net.ParseIP(host); ip != nil {
+			p, _ := strconv.Atoi(port)
+			c.remoteAddr = &net.TCPAddr{IP: ip, Port: p}
+		}
+	}
+	if r.TLS != nil {
+		return &tlsRequestConn{requestConn: c, state: *r.TLS}
+	}
+	return c
+}
+
+func (c *requestConn) LocalAddr() net.Addr  { return c.localAddr }
+func (c *requestConn) RemoteAddr() net.Addr { return c.remoteAddr }
+
+func (c *requestConn) Read([]byte) (int, error)  { return 0, io.EOF }
+func (c *requestConn) Write([]byte) (int, error) { return 0, errRequestConn }
+func (c *requestConn) Close() error              { return nil }
+
+func (c *requestConn) SetDeadline(time.Time) error      { return nil }
+func (c *requestConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *requestConn) SetWriteDeadline(time.Time) error { return nil }
+
+var errRequestConn = errors.New("the connection of net/http request cannot be written, use RequestCtx.Hijack")
+
+// tlsRequestConn is the fake connection of the net/http request over TLS.
+type tlsRequestConn struct {
+	*requestConn
+	state tls.ConnectionState
+}
+
+func (c *tlsRequestConn) Handshake() error                     { return nil }
+func (c *tlsRequestConn) ConnectionState() tls.ConnectionState { return c.state }
@@ -0,0 +1,245 @@
+package fasthttpadaptor
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bhargawpradhan/fasthttp"
+)
+
+type closerValue struct {
+	closed atomic.Bool
+}
+
+func (v *closerValue) Close() error {
+	v.closed.Store(true)
+	return nil
+}
+
+type contextKey struct{}
+
+func TestNewNetHTTPHandler(t *testing.T) {
+	t.Parallel()
+
+	closer := &closerValue{}
+	h := NewNetHTTPHandler(func(ctx *fasthttp.RequestCtx) {
+		if m := string(ctx.Method()); m != fasthttp.MethodPost {
+			t.Errorf("unexpected method %q. Expecting %q", m, fasthttp.MethodPost)
+		}
+		if uri := string(ctx.RequestURI()); uri != "/foo/bar?baz=123" {
+			t.Errorf("unexpected request uri %q. Expecting %q", uri, "/foo/bar?baz=123")
+		}
+		if host := string(ctx.Host()); host != "example.com" {
+			t.Errorf("unexpected host %q. Expecting %q", host, "example.com")
+		}
+		if v := string(ctx.Request.Header.Peek("X-Foo")); v != "bar" {
+			t.Errorf("unexpected header X-Foo %q. Expecting %q", v, "bar")
+		}
+		if v := string(ctx.Request.Header.Cookie("session")); v != "xyz" {
+			t.Errorf("unexpected cookie %q. Expecting %q", v, "xyz")
+		}
+		if body := string(ctx.PostBody()); body != "request body" {
+			t.Errorf("unexpected body %q. Expecting %q", body, "request body")
+		}
+		if addr := ctx.RemoteAddr().String(); addr != "192.0.2.1:1234" {
+			t.Errorf("unexpected remote addr %q. Expecting %q", addr, "192.0.2.1:1234")
+		}
+		if ctx.IsTLS() {
+			t.Error("unexpected TLS request")
+		}
+		if v := NetHTTPRequest(ctx).Context().Value(contextKey{}); v != "context value" {
+			t.Errorf("unexpected context value %v. Expecting %q", v, "context value")
+		}
+		ctx.SetUserValue("closer", closer)
+
+		ctx.SetStatusCode(fasthttp.StatusCreated)
+		ctx.SetContentType("application/json")
+		ctx.Response.Header.Set("X-Response", "1")
+		var c fasthttp.Cookie
+		c.SetKey("a")
+		c.SetValue("b")
+		ctx.Response.Header.SetCookie(&c)
+		ctx.SetBodyString(`{"ok":true}`)
+	})
+
+	r := httptest.NewRequest(fasthttp.MethodPost, "http://example.com/foo/bar?baz=123", strings.NewReader("request body"))
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("X-Foo", "bar")
+	r.AddCookie(&http.Cookie{Name: "session", Value: "xyz"})
+	r = r.WithContext(context.WithValue(r.Context(), contextKey{}, "context value"))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("unexpected status code %d. Expecting %d", w.Code, http.StatusCreated)
+	}
+	expected := map[string]string{
+		"Content-Type":   "application/json",
+		"Content-Length": "11",
+		"X-Response":     "1",
+		"Set-Cookie":     "a=b",
+	}
+	for k, v := range expected {
+		if s := w.Header().Get(k); s != v {
+			t.Fatalf("unexpected header %s: %q. Expecting %q", k, s, v)
+		}
+	}
+	if body := w.Body.String(); body != `{"ok":true}` {
+		t.Fatalf("unexpected body %q. Expecting %q", body, `{"ok":true}`)
+	}
+	if !closer.closed.Load() {
+		t.Fatal("the user value isn't closed")
+	}
+}
+
+func TestNewNetHTTPHandlerStream(t *testing.T) {
+	t.Parallel()
+
+	next := make(chan struct{})
+	s := httptest.NewServer(NewNetHTTPHandlerFunc(func(ctx *fasthttp.RequestCtx) {
+		body, err := io.ReadAll(ctx.RequestBodyStream())
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+			w.Write(body) //nolint:errcheck
+			w.Flush()     //nolint:errcheck
+			<-next
+			w.WriteString("bar") //nolint:errcheck
+		})
+	}))
+	defer s.Close()
+
+	resp, err := http.Post(s.URL, "text/plain", io.NopCloser(strings.NewReader("foo")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked" {
+		t.Fatalf("unexpected transfer encoding %q", resp.TransferEncoding)
+	}
+
+	// The first chunk is flushed before the handler writes the rest.
+	first := make([]byte, 3)
+	if _, err = io.ReadFull(resp.Body, first); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	close(next)
+	rest, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if body := string(first) + string(rest); body != "foobar" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "foobar")
+	}
+}
+
+func TestNewNetHTTPHandlerHijack(t *testing.T) {
+	t.Parallel()
+
+	s := httptest.NewServer(NewNetHTTPHandler(func(ctx *fasthttp.RequestCtx) {
+		noResponse := string(ctx.Path()) == "/noresponse"
+		if !noResponse {
+			ctx.SetStatusCode(fasthttp.StatusSwitchingProtocols)
+			ctx.Response.Header.Set(fasthttp.HeaderConnection, "Upgrade")
+			ctx.Response.Header.Set(fasthttp.HeaderUpgrade, "echo")
+		}
+		ctx.HijackSetNoResponse(noResponse)
+		ctx.Hijack(func(c net.Conn) {
+			if noResponse {
+				c.Write([]byte("raw ")) //nolint:errcheck
+			}
+			io.Copy(c, c) //nolint:errcheck
+		})
+	}))
+	defer s.Close()
+
+	for _, path := range []string{"/", "/noresponse"} {
+		conn, err := net.Dial("tcp", s.Listener.Addr().String())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// The data sent along with the request is buffered by net/http.
+		if _, err = conn.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\nhello")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		br := bufio.NewReader(conn)
+		if path == "/" {
+			var resp fasthttp.ResponseHeader
+			if err = resp.Read(br); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.StatusCode() != fasthttp.StatusSwitchingProtocols {
+				t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), fasthttp.StatusSwitchingProtocols)
+			}
+			if v := string(resp.Peek(fasthttp.HeaderUpgrade)); v != "echo" {
+				t.Fatalf("unexpected Upgrade header %q. Expecting %q", v, "echo")
+			}
+		} else {
+			prefix := make([]byte, 4)
+			if _, err = io.ReadFull(br, prefix); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(prefix) != "raw " {
+				t.Fatalf("unexpected response %q. Expecting %q", prefix, "raw ")
+			}
+		}
+		echo := make([]byte, 5)
+		if _, err = io.ReadFull(br, echo); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(echo) != "hello" {
+			t.Fatalf("unexpected echo %q. Expecting %q", echo, "hello")
+		}
+		conn.Close()
+	}
+}
+
+func TestNewNetHTTPHandlerHTTP2(t *testing.T) {
+	t.Parallel()
+
+	s := httptest.NewUnstartedServer(NewNetHTTPHandler(func(ctx *fasthttp.RequestCtx) {
+		if !ctx.IsTLS() || ctx.TLSConnectionState() == nil {
+			t.Error("expecting TLS request")
+		}
+		if string(ctx.Path()) == "/hijack" {
+			ctx.Hijack(func(net.Conn) {})
+			return
+		}
+		ctx.SetBodyString(string(ctx.Request.Header.Protocol()))
+	}))
+	s.EnableHTTP2 = true
+	s.StartTLS()
+	defer s.Close()
+
+	c := s.Client()
+	resp, err := c.Get(s.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.ProtoMajor != 2 || string(body) != "HTTP/2.0" {
+		t.Fatalf("unexpected response %s %q", resp.Proto, body)
+	}
+
+	// HTTP/2 connections cannot be hijacked.
+	resp, err = c.Get(s.URL + "/hijack")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode, http.StatusInternalServerError)
+	}
+}
@@ -16,7 +16,13 @@ import (
 // The http.Request must not be used after the fasthttp handler has returned!
 // Memory in use by the http.Request will be reused after your handler has returned!
 func ConvertRequest(ctx *fasthttp.RequestCtx, r *http.Request, forServer bool) error {
-	body := ctx.PostBody()
+	return convertRequest(ctx, r, forServer, false)
+}
+
+// convertRequest converts ctx.Request to r. r.Body reads directly
+// from the request body stream if streamBody is set and
+// the body is streamed by the server.
+func convertRequest(ctx *fasthttp.RequestCtx, r *http.Request, forServer, streamBody bool) error {
 	strRequestURI := b2s(ctx.RequestURI())
 
 	rURL, err := url.ParseRequestURI(strRequestURI)
@@ -32,11 +38,21 @@ func ConvertRequest(ctx *fasthttp.RequestCtx, r *http.Request, forServer bool) e
 		r.ProtoMajor = 1
 	}
 	r.ProtoMinor = 1
-	r.ContentLength = int64(len(body))
 	r.RemoteAddr = ctx.RemoteAddr().String()
 	r.Host = b2s(ctx.Host())
 	r.TLS = ctx.TLSConnectionState()
-	r.Body = io.NopCloser(bytes.NewReader(body))
+	if bodyStream := ctx.RequestBodyStream(); streamBody && bodyStream != nil {
+		r.ContentLength = int64(ctx.Request.Header.ContentLength())
+		if r.ContentLength < 0 {
+			// The body is chunked or read until the connection is closed.
+			r.ContentLength = -1
+		}
+		r.Body = io.NopCloser(bodyStream)
+	} else {
+		body := ctx.PostBody()
+		r.ContentLength = int64(len(body))
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
 	r.URL = rURL
 
 	if forServer {
@@ -0,0 +1,272 @@
+package fasthttpadaptor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bhargawpradhan/fasthttp"
+)
+
+// Doer performs fasthttp requests.
+//
+// It is implemented by fasthttp.Client, fasthttp.HostClient
+// and fasthttp.PipelineClient.
+type Doer interface {
+	DoContext(ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response) error
+}
+
+// RoundTripper implements http.RoundTripper on top of fasthttp client,
+// so the code accepting only *http.Client benefits from fasthttp
+// connection pooling and dialers, e.g. the dialers from fasthttpproxy.
+//
+// Example usage:
+//
+//	c := &http.Client{
+//		Transport: &fasthttpadaptor.RoundTripper{
+//			Client: &fasthttp.Client{Dial: fasthttpproxy.FasthttpHTTPDialer("localhost:3128")},
+//		},
+//	}
+//
+// Request and response bodies are streamed. Request and response trailers
+// are supported for chunked bodies. Redirects aren't followed by fasthttp,
+// so they are left to http.Client. The request context cancels the request
+// until the response headers are received and aborts reading
+// the response body after that.
+//
+// Responses aren't decompressed, since Accept-Encoding header isn't added
+// to the requests.
+type RoundTripper struct {
+	// Client performs the requests.
+	//
+	// By default a Client with StreamResponseBody is used.
+	Client Doer
+
+	initOnce sync.Once
+	client   Doer
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *RoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.initOnce.Do(func() {
+		t.client = t.Client
+		if t.client == nil {
+			t.client = &fasthttp.Client{StreamResponseBody: true}
+		}
+	})
+
+	if r.Body != nil {
+		defer r.Body.Close()
+	}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	convertClientRequest(r, req)
+
+	// The connection is closed by canceling ctx
+	// if the response body isn't read till the end.
+	ctx, cancel := context.WithCancel(r.Context())
+	resp := fasthttp.AcquireResponse()
+	resp.StreamBody = true
+	if err := t.client.DoContext(ctx, req, resp); err != nil {
+		cancel()
+		fasthttp.ReleaseResponse(resp)
+		return nil, err
+	}
+	res := convertClientResponse(r, resp)
+	res.Body = newResponseBody(ctx, cancel, resp, res)
+	return res, nil
+}
+
+// convertClientRequest converts the net/http client request r to req.
+func convertClientRequest(r *http.Request, req *fasthttp.Request) {
+	req.Header.SetMethod(r.Method)
+	req.SetRequestURI(r.URL.String())
+	if r.Host != "" && r.Host != r.URL.Host {
+		req.UseHostHeader = true
+		req.Header.SetHost(r.Host)
+	}
+	for k, vv := range r.Header {
+		if k == fasthttp.HeaderHost {
+			continue
+		}
+		for _, v := range vv {
+			req.Header.Add(k, v)
+		}
+	}
+	if r.Close {
+		req.SetConnectionClose()
+	}
+
+	if r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+	n := r.ContentLength
+	if n == 0 || len(r.Trailer) > 0 {
+		// The trailers are sent with chunked body only.
+		n = -1
+	}
+	body := io.Reader(r.Body)
+	if len(r.Trailer) > 0 {
+		for k := range r.Trailer {
+			req.Header.AddTrailer(k) //nolint:errcheck
+		}
+		body = &trailerReader{r: r.Body, trailer: r.Trailer, h: &req.Header}
+	}
+	req.SetBodyStream(body, int(n))
+}
+
+// trailerReader copies the request trailers, which are set
+// by net/http caller while reading the body, to h at EOF.
+type trailerReader struct {
+	r       io.Reader
+	trailer http.Header
+	h       *fasthttp.RequestHeader
+}
+
+func (r *trailerReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		for k, vv := range r.trailer {
+			for _, v := range vv {
+				r.h.Add(k, v)
+			}
+		}
+	}
+	return n, err
+}
+
+// convertClientResponse converts resp to the net/http client response.
+func convertClientResponse(r *http.Request, resp *fasthttp.Response) *http.Response {
+	h := &resp.Header
+	// Do not report the default Content-Type missing in the response.
+	h.SetNoDefaultContentType(true)
+	res := &http.Response{
+		Status:        strconv.Itoa(h.StatusCode()) + " " + string(h.StatusMessage()),
+		StatusCode:    h.StatusCode(),
+		Header:        make(http.Header),
+		ContentLength: int64(h.ContentLength()),
+		Close:         h.ConnectionClose(),
+		Request:       r,
+	}
+	if len(h.StatusMessage()) == 0 {
+		res.Status = strconv.Itoa(res.StatusCode) + " " + http.StatusText(res.StatusCode)
+	}
+	res.Proto = string(h.Protocol())
+	var ok bool
+	if res.ProtoMajor, res.ProtoMinor, ok = http.ParseHTTPVersion(res.Proto); !ok {
+		res.Proto, res.ProtoMajor, res.ProtoMinor = "HTTP/1.1", 1, 1
+	}
+
+	for k, v := range h.All() {
+		switch sk := b2s(k); sk {
+		case fasthttp.HeaderTrailer, fasthttp.HeaderConnection, fasthttp.HeaderTransferEncoding:
+		default:
+			res.Header.Add(sk, string(v))
+		}
+	}
+	for k := range h.Trailers() {
+		if res.Trailer == nil {
+			res.Trailer = make(http.Header)
+		}
+		res.Trailer[string(k)] = nil
+		res.Header.Del(string(k))
+	}
+
+	switch {
+	case res.ContentLength == -1:
+		res.TransferEncoding = []string{"chunked"}
+	case res.ContentLength < 0:
+		res.ContentLength = -1
+	}
+	if r.Method == fasthttp.MethodHead {
+		// Content-Length of HEAD response describes GET response body.
+		res.ContentLength = -1
+		if n, err := strconv.ParseInt(res.Header.Get(fasthttp.HeaderContentLength), 10, 64); err == nil {
+			res.ContentLength = n
+		}
+	}
+
+	return res
+}
+
+// responseBody reads the fasthttp response body and releases
+// the response on Close.
+type responseBody struct {
+	r       io.Reader
+	ctx     context.Context
+	cancel  context.CancelFunc
+	resp    *fasthttp.Response
+	trailer http.Header
+	eof     atomic.Bool
+	mu      sync.Mutex
+}
+
+func newResponseBody(ctx context.Context, cancel context.CancelFunc, resp *fasthttp.Response, res *http.Response) *responseBody {
+	b := &responseBody{
+		ctx:     ctx,
+		cancel:  cancel,
+		resp:    resp,
+		trailer: res.Trailer,
+	}
+	if resp.IsBodyStream() {
+		b.r = resp.BodyStream()
+	} else {
+		b.r = bytes.NewReader(resp.Body())
+		b.eof.Store(true)
+	}
+	return b
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.resp == nil {
+		return 0, errBodyClosed
+	}
+	if err := b.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := b.r.Read(p)
+	switch {
+	case err == io.EOF:
+		b.eof.Store(true)
+		for k := range b.trailer {
+			if v := b.resp.Header.Peek(k); len(v) > 0 {
+				b.trailer[k] = strings.Split(string(v), ", ")
+			}
+		}
+	case err != nil && b.ctx.Err() != nil:
+		// The read has been interrupted by the context cancellation.
+		err = b.ctx.Err()
+	}
+	return n, err
+}
+
+func (b *responseBody) Close() error {
+	if !b.eof.Load() {
+		// Interrupt the pending Read and don't reuse the connection
+		// with unread response body.
+		b.cancel()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.resp == nil {
+		return nil
+	}
+	err := b.resp.CloseBodyStream()
+	fasthttp.ReleaseResponse(b.resp)
+	b.resp = nil
+	b.cancel()
+	return err
+}
+
+var errBodyClosed = errors.New("http: read on closed response body")
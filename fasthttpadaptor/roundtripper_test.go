@@ -0,0 +1,308 @@
+package fasthttpadaptor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bhargawpradhan/fasthttp"
+	"github.com/bhargawpradhan/fasthttp/fasthttpproxy"
+)
+
+func TestRoundTripper(t *testing.T) {
+	t.Parallel()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		w.Header()["X-Multi"] = []string{"a", "b"}
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Set("X-Host", r.Host)
+		w.Header().Set("X-Length", r.Header.Get("Content-Length"))
+		w.Header().Set("X-Foo", r.Header.Get("X-Foo"))
+		switch r.URL.Path {
+		case "/nocontent":
+			w.WriteHeader(http.StatusNoContent)
+		case "/notype":
+			w.Header()["Content-Type"] = nil
+			w.Write(body) //nolint:errcheck
+		default:
+			w.Header().Set("Content-Type", "text/x-test")
+			if r.Method == http.MethodHead {
+				w.Header().Set("Content-Length", "3")
+			}
+			w.Write(body) //nolint:errcheck
+		}
+	}))
+	defer s.Close()
+
+	c := &http.Client{Transport: &RoundTripper{}}
+	for _, tc := range []struct {
+		method, path, host string
+		body               io.Reader
+		statusCode         int
+		expectedLength     string
+		expectedBody       string
+		expectedType       string
+	}{
+		{method: http.MethodGet, path: "/", statusCode: http.StatusOK, expectedType: "text/x-test"},
+		{method: http.MethodPost, path: "/", body: strings.NewReader("foo"), statusCode: http.StatusOK, expectedLength: "3", expectedBody: "foo", expectedType: "text/x-test"},
+		{method: http.MethodPut, path: "/", body: io.NopCloser(strings.NewReader("bar")), statusCode: http.StatusOK, expectedBody: "bar", expectedType: "text/x-test"},
+		{method: http.MethodGet, path: "/", host: "example.com", statusCode: http.StatusOK, expectedType: "text/x-test"},
+		{method: http.MethodHead, path: "/", statusCode: http.StatusOK, expectedType: "text/x-test"},
+		{method: http.MethodGet, path: "/nocontent", statusCode: http.StatusNoContent},
+		{method: http.MethodPost, path: "/notype", body: strings.NewReader("baz"), statusCode: http.StatusOK, expectedLength: "3", expectedBody: "baz"},
+	} {
+		req, err := http.NewRequest(tc.method, s.URL+tc.path, tc.body)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		req.Header.Set("X-Foo", "bar")
+		expectedHost := req.URL.Host
+		if tc.host != "" {
+			req.Host = tc.host
+			expectedHost = tc.host
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if resp.StatusCode != tc.statusCode {
+			t.Fatalf("%s %s: unexpected status code %d. Expecting %d", tc.method, tc.path, resp.StatusCode, tc.statusCode)
+		}
+		if resp.ProtoMajor != 1 || resp.ProtoMinor != 1 {
+			t.Fatalf("unexpected protocol %q", resp.Proto)
+		}
+		expected := map[string]string{
+			"X-Method":     tc.method,
+			"X-Host":       expectedHost,
+			"X-Foo":        "bar",
+			"Content-Type": tc.expectedType,
+		}
+		if tc.method != http.MethodHead {
+			expected["X-Length"] = tc.expectedLength
+		}
+		for k, v := range expected {
+			if s := resp.Header.Get(k); s != v {
+				t.Fatalf("%s %s: unexpected header %s: %q. Expecting %q", tc.method, tc.path, k, s, v)
+			}
+		}
+		if v := resp.Header.Values("X-Multi"); len(v) != 2 || v[0] != "a" || v[1] != "b" {
+			t.Fatalf("unexpected X-Multi header %q", v)
+		}
+		if string(body) != tc.expectedBody {
+			t.Fatalf("%s %s: unexpected body %q. Expecting %q", tc.method, tc.path, body, tc.expectedBody)
+		}
+		if tc.method == http.MethodHead && resp.ContentLength != 3 {
+			t.Fatalf("unexpected content length of HEAD response %d", resp.ContentLength)
+		}
+	}
+}
+
+func TestRoundTripperStream(t *testing.T) {
+	t.Parallel()
+
+	next := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Response-Trailer")
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if v := r.Trailer.Get("X-Request-Trailer"); v != "foo" {
+			t.Errorf("unexpected request trailer %q. Expecting %q", v, "foo")
+		}
+		w.Write(body) //nolint:errcheck
+		w.(http.Flusher).Flush()
+		<-next
+		w.Write([]byte("bar")) //nolint:errcheck
+		w.Header().Set("X-Response-Trailer", "baz")
+	}))
+	defer s.Close()
+
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest(http.MethodPost, s.URL, pr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req.Trailer = http.Header{"X-Request-Trailer": nil}
+	go func() {
+		pw.Write([]byte("foo")) //nolint:errcheck
+		req.Trailer.Set("X-Request-Trailer", "foo")
+		pw.Close()
+	}()
+
+	resp, err := (&http.Client{Transport: &RoundTripper{}}).Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.ContentLength != -1 || len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked" {
+		t.Fatalf("unexpected content length %d and transfer encoding %q", resp.ContentLength, resp.TransferEncoding)
+	}
+	if _, ok := resp.Trailer["X-Response-Trailer"]; !ok {
+		t.Fatalf("missing declared trailer in %v", resp.Trailer)
+	}
+
+	// The first chunk is received before the handler writes the rest.
+	first := make([]byte, 3)
+	if _, err = io.ReadFull(resp.Body, first); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	close(next)
+	rest, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if body := string(first) + string(rest); body != "foobar" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "foobar")
+	}
+	if v := resp.Trailer.Get("X-Response-Trailer"); v != "baz" {
+		t.Fatalf("unexpected response trailer %q. Expecting %q", v, "baz")
+	}
+}
+
+func TestRoundTripperRedirect(t *testing.T) {
+	t.Parallel()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/target", http.StatusFound)
+			return
+		}
+		w.Write([]byte(r.URL.Path)) //nolint:errcheck
+	}))
+	defer s.Close()
+
+	resp, err := (&http.Client{Transport: &RoundTripper{}}).Get(s.URL + "/redirect")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(body) != "/target" || resp.Request.URL.Path != "/target" {
+		t.Fatalf("unexpected response %q for %q. Expecting %q", body, resp.Request.URL, "/target")
+	}
+}
+
+func TestRoundTripperContext(t *testing.T) {
+	t.Parallel()
+
+	done := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/body" {
+			w.Write([]byte("foo")) //nolint:errcheck
+			w.(http.Flusher).Flush()
+		}
+		<-done
+	}))
+	defer s.Close()
+	defer close(done)
+
+	c := &http.Client{Transport: &RoundTripper{}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, http.NoBody)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = c.Do(req); !errors.Is(err, fasthttp.ErrTimeout) && !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error: %v. Expecting timeout", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, s.URL+"/body", http.NoBody)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	first := make([]byte, 3)
+	if _, err = io.ReadFull(resp.Body, first); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cancel()
+	if _, err = resp.Body.Read(first); !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, context.Canceled)
+	}
+}
+
+func TestRoundTripperProxy(t *testing.T) {
+	t.Parallel()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello")) //nolint:errcheck
+	}))
+	defer s.Close()
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p := &fasthttpproxy.Proxy{}
+	ps := &fasthttp.Server{Handler: p.Handler}
+	go ps.Serve(ln)     //nolint:errcheck
+	defer ps.Shutdown() //nolint:errcheck
+
+	c := &http.Client{Transport: &RoundTripper{
+		Client: &fasthttp.Client{Dial: fasthttpproxy.FasthttpHTTPDialer(ln.Addr().String())},
+	}}
+	resp, err := c.Get(s.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(body) != "hello" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "hello")
+	}
+}
+
+func TestRoundTripperEarlyClose(t *testing.T) {
+	t.Parallel()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 1<<16))) //nolint:errcheck
+	}))
+	defer s.Close()
+
+	c := &http.Client{Transport: &RoundTripper{}}
+	for i := 0; i < 3; i++ {
+		resp, err := c.Get(s.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// The connection with the unread body must not be reused.
+		buf := make([]byte, 10)
+		if _, err = io.ReadFull(resp.Body, buf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		if _, err = resp.Body.Read(buf); err == nil {
+			t.Fatal("expecting error when reading closed body")
+		}
+	}
+}
@@ -0,0 +1,492 @@
+package fasthttpproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bhargawpradhan/fasthttp"
+	"github.com/bhargawpradhan/fasthttp/internal/proxyutil"
+)
+
+// DefaultProxyTimeout is the default timeout for dialing the destinations
+// and for the forwarded requests.
+const DefaultProxyTimeout = 30 * time.Second
+
+// DefaultProxyRealm is the default realm sent in Proxy-Authenticate header.
+const DefaultProxyRealm = "fasthttp"
+
+// Proxy is a forward HTTP proxy handler.
+//
+// Requests in absolute form, e.g. 'GET http://example.com/ HTTP/1.1',
+// are forwarded via Client, while CONNECT requests are tunneled
+// to the destination over the hijacked connection. The proxy may be used
+// by clients dialing via FasthttpHTTPDialer.
+//
+// Example usage:
+//
+//	p := &fasthttpproxy.Proxy{
+//		Deny:         []string{"10.0.0.0/8", "127.0.0.0/8", "localhost"},
+//		Authenticate: func(user, pass string) bool { return user == "foo" && pass == "bar" },
+//	}
+//	fasthttp.ListenAndServe(":3128", p.Handler)
+//
+// It is safe to call Proxy methods from concurrently running goroutines.
+// Proxy fields mustn't be changed after the first request.
+type Proxy struct {
+	// Client performs the requests in absolute form.
+	//
+	// The destinations are resolved and checked against Allow and Deny
+	// before dialing, and the checked addresses are dialed only. Proxy
+	// wraps Dial and DialTimeout of Client for that on the first request,
+	// so they are called with the resolved IP addresses. Client mustn't
+	// be shared with other users.
+	//
+	// By default a Client dialing via Dial is used.
+	Client *fasthttp.Client
+
+	// Dial dials the CONNECT destinations and the destinations
+	// of the requests forwarded via the default Client.
+	//
+	// Dial is called with the resolved IP address of the destination,
+	// so the address checked against Allow and Deny is the address dialed.
+	//
+	// By default fasthttp.DialTimeout with Timeout is used.
+	Dial fasthttp.DialFunc
+
+	// Resolver resolves the destination domain names, so the resolved
+	// addresses are checked against Allow and Deny before dialing.
+	//
+	// By default net.DefaultResolver is used.
+	Resolver fasthttp.Resolver
+
+	// Authenticate checks the credentials from Proxy-Authorization header.
+	// Requests with invalid credentials are rejected
+	// with 407 Proxy Authentication Required.
+	//
+	// By default the credentials aren't required.
+	Authenticate func(username, password string) bool
+
+	// Logger logs the closed tunnels with the transferred byte counts.
+	// The tunnels are logged as records with attributes
+	// if Logger is fasthttp.StructuredLogger.
+	//
+	// By default the tunnels aren't logged.
+	Logger fasthttp.Logger
+
+	// Realm is sent in Proxy-Authenticate header.
+	//
+	// By default DefaultProxyRealm is used.
+	Realm string
+
+	// Allow lists the allowed destinations. The requests to the remaining
+	// destinations are rejected with 403 Forbidden.
+	//
+	// Each value is an IP address (1.2.3.4), an IP address prefix in CIDR
+	// notation (1.2.3.4/8), a domain name or an asterisk (*) matching
+	// all the destinations. An IP address and a domain name may include
+	// a port (1.2.3.4:443). A domain name matches that name and all
+	// subdomains. A domain name with a leading "." matches subdomains only.
+	// IP addresses and prefixes match the destinations given as IP addresses
+	// and the resolved addresses of domain names. A domain name is allowed
+	// if it matches a domain rule or all its addresses match IP rules.
+	// Invalid values are ignored.
+	//
+	// By default all the destinations not listed in Deny are allowed.
+	Allow []string
+
+	// Deny lists the denied destinations in the format of Allow.
+	// Deny takes precedence over Allow. A domain name is denied
+	// if any of its addresses matches an IP rule.
+	Deny []string
+
+	// Timeout for dialing the destinations and for the forwarded requests.
+	//
+	// By default DefaultProxyTimeout is used.
+	Timeout time.Duration
+
+	client      *fasthttp.Client
+	allow, deny []destRule
+	initOnce    sync.Once
+}
+
+func (p *Proxy) init() {
+	p.allow = parseDestRules(p.Allow)
+	p.deny = parseDestRules(p.Deny)
+	p.client = p.Client
+	if p.client == nil {
+		p.client = &fasthttp.Client{Dial: p.dial}
+	} else {
+		p.wrapClientDial(p.client)
+	}
+}
+
+// wrapClientDial makes c dial the addresses checked by dialChecked
+// via its own Dial or DialTimeout.
+func (p *Proxy) wrapClientDial(c *fasthttp.Client) {
+	dial, dialTimeout := c.Dial, c.DialTimeout
+	c.Dial = nil
+	c.DialTimeout = func(addr string, timeout time.Duration) (net.Conn, error) {
+		if timeout <= 0 {
+			timeout = p.timeout()
+		}
+		return p.dialChecked(addr, func(ipAddr string) (net.Conn, error) {
+			switch {
+			case dialTimeout != nil:
+				return dialTimeout(ipAddr, timeout)
+			case dial != nil:
+				return dial(ipAddr)
+			default:
+				return fasthttp.DialTimeout(ipAddr, timeout)
+			}
+		})
+	}
+}
+
+func (p *Proxy) timeout() time.Duration {
+	if p.Timeout <= 0 {
+		return DefaultProxyTimeout
+	}
+	return p.Timeout
+}
+
+var errDestinationDenied = errors.New("proxy destination is denied")
+
+// dial dials addr via Dial after checking it with dialChecked.
+func (p *Proxy) dial(addr string) (net.Conn, error) {
+	return p.dialChecked(addr, func(ipAddr string) (net.Conn, error) {
+		if p.Dial != nil {
+			return p.Dial(ipAddr)
+		}
+		return fasthttp.DialTimeout(ipAddr, p.timeout())
+	})
+}
+
+// dialChecked resolves addr, checks the resolved addresses against Allow
+// and Deny and dials them in turn. The checked addresses are dialed instead
+// of addr, so the destination can't be changed by resolving the name again.
+func (p *Proxy) dialChecked(addr string, dial fasthttp.DialFunc) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := p.lookup(host)
+	if err != nil {
+		return nil, err
+	}
+	if !p.allowed(host, port, ips) {
+		return nil, errDestinationDenied
+	}
+
+	var conn net.Conn
+	for _, ip := range ips {
+		conn, err = dial(net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+func (p *Proxy) lookup(host string) ([]net.IP, error) {
+	host = strings.Trim(host, "[]")
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	resolver := p.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout())
+	defer cancel()
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("no addresses found for " + host)
+	}
+	ips := make([]net.IP, len(addrs))
+	for i := range addrs {
+		ips[i] = addrs[i].IP
+	}
+	return ips, nil
+}
+
+// Handler serves the proxy requests.
+func (p *Proxy) Handler(ctx *fasthttp.RequestCtx) {
+	p.initOnce.Do(p.init)
+
+	if !p.authorized(&ctx.Request.Header) {
+		realm := p.Realm
+		if realm == "" {
+			realm = DefaultProxyRealm
+		}
+		ctx.Error(fasthttp.StatusMessage(fasthttp.StatusProxyAuthRequired), fasthttp.StatusProxyAuthRequired)
+		ctx.Response.Header.Set(fasthttp.HeaderProxyAuthenticate, `Basic realm="`+realm+`"`)
+		return
+	}
+	ctx.Request.Header.Del(fasthttp.HeaderProxyAuthorization)
+
+	if ctx.IsConnect() {
+		p.connect(ctx)
+		return
+	}
+	p.forward(ctx)
+}
+
+func (p *Proxy) authorized(h *fasthttp.RequestHeader) bool {
+	if p.Authenticate == nil {
+		return true
+	}
+	auth := h.Peek(fasthttp.HeaderProxyAuthorization)
+	if len(auth) < len("Basic ") || !strings.EqualFold(string(auth[:len("Basic ")]), "Basic ") {
+		return false
+	}
+	credentials, err := base64.StdEncoding.DecodeString(string(auth[len("Basic "):]))
+	if err != nil {
+		return false
+	}
+	username, password, ok := strings.Cut(string(credentials), ":")
+	return ok && p.Authenticate(username, password)
+}
+
+// allowed returns whether the destination host and port with the resolved
+// addresses ips is allowed. The addresses are checked only if ips is set.
+func (p *Proxy) allowed(host, port string, ips []net.IP) bool {
+	host = strings.ToLower(strings.TrimSuffix(strings.Trim(host, "[]"), "."))
+	for i := range p.deny {
+		if p.deny[i].match(host, port) {
+			return false
+		}
+		for _, ip := range ips {
+			if p.deny[i].matchIP(ip, port) {
+				return false
+			}
+		}
+	}
+	if len(p.allow) == 0 {
+		return true
+	}
+	for i := range p.allow {
+		if p.allow[i].match(host, port) {
+			return true
+		}
+	}
+	if len(ips) == 0 {
+		// The name is allowed only if it resolves to the allowed addresses.
+		return net.ParseIP(host) == nil && p.hasAllowedIPs()
+	}
+	for _, ip := range ips {
+		if !p.allowedIP(ip, port) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Proxy) allowedIP(ip net.IP, port string) bool {
+	for i := range p.allow {
+		if p.allow[i].matchIP(ip, port) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Proxy) hasAllowedIPs() bool {
+	for i := range p.allow {
+		if p.allow[i].ipNet != nil || p.allow[i].ip != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// forward performs the request in absolute form.
+func (p *Proxy) forward(ctx *fasthttp.RequestCtx) {
+	req := &ctx.Request
+	uri := req.URI()
+	requestURI := req.Header.RequestURI()
+	if !bytes.HasPrefix(requestURI, []byte("http://")) && !bytes.HasPrefix(requestURI, []byte("https://")) {
+		ctx.Error("absolute request URI is required", fasthttp.StatusBadRequest)
+		return
+	}
+	port := "80"
+	if bytes.Equal(uri.Scheme(), []byte("https")) {
+		port = "443"
+	}
+	host := string(uri.Host())
+	if h, pt, err := net.SplitHostPort(host); err == nil {
+		host, port = h, pt
+	}
+	if !p.allowed(host, port, nil) {
+		ctx.Error(fasthttp.StatusMessage(fasthttp.StatusForbidden), fasthttp.StatusForbidden)
+		return
+	}
+	connectionClose := req.Header.ConnectionClose()
+	proxyutil.RemoveHopHeaders(&req.Header)
+	err := p.client.DoTimeout(req, &ctx.Response, p.timeout())
+	if err != nil {
+		ctx.Response.Reset()
+		writeDialError(ctx, err)
+	} else {
+		proxyutil.RemoveHopHeaders(&ctx.Response.Header)
+	}
+	if connectionClose {
+		ctx.SetConnectionClose()
+	}
+}
+
+// connect tunnels the connection to the CONNECT destination.
+func (p *Proxy) connect(ctx *fasthttp.RequestCtx) {
+	addr := string(ctx.Request.Header.RequestURI())
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host == "" || port == "" {
+		ctx.Error("CONNECT destination must be host:port", fasthttp.StatusBadRequest)
+		return
+	}
+	if !p.allowed(host, port, nil) {
+		ctx.Error(fasthttp.StatusMessage(fasthttp.StatusForbidden), fasthttp.StatusForbidden)
+		return
+	}
+
+	conn, err := p.dial(addr)
+	if err != nil {
+		writeDialError(ctx, err)
+		return
+	}
+
+	// The connection is hijacked even if the client asked to close it.
+	ctx.Request.Header.ResetConnectionClose()
+	ctx.HijackSetNoResponse(true)
+	client := ctx.RemoteAddr().String()
+	ctx.Hijack(func(c net.Conn) {
+		if _, err := c.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+			conn.Close()
+			return
+		}
+		start := time.Now()
+		sent, received := proxyutil.Tunnel(c, conn, nil)
+		p.logTunnel(client, addr, sent, received, time.Since(start))
+	})
+}
+
+func (p *Proxy) logTunnel(client, addr string, sent, received int64, d time.Duration) {
+	if p.Logger == nil {
+		return
+	}
+	if sl, ok := p.Logger.(fasthttp.StructuredLogger); ok {
+		sl.LogAttrs(context.Background(), slog.LevelInfo, "proxy tunnel closed",
+			slog.String("client", client),
+			slog.String("destination", addr),
+			slog.Int64("bytes_sent", sent),
+			slog.Int64("bytes_received", received),
+			slog.Duration("duration", d))
+		return
+	}
+	p.Logger.Printf("tunnel %s -> %s closed after %s: %d bytes sent, %d bytes received",
+		client, addr, d, sent, received)
+}
+
+func writeDialError(ctx *fasthttp.RequestCtx, err error) {
+	if errors.Is(err, errDestinationDenied) {
+		ctx.Error(fasthttp.StatusMessage(fasthttp.StatusForbidden), fasthttp.StatusForbidden)
+		return
+	}
+	proxyutil.WriteError(ctx, err)
+}
+
+// destRule is a parsed Proxy.Allow or Proxy.Deny value.
+type destRule struct {
+	ipNet *net.IPNet
+	ip    net.IP
+
+	// domain is matched with subdomains, while suffix is matched
+	// with subdomains only.
+	domain string
+	suffix string
+	port   string
+
+	any bool
+}
+
+func parseDestRules(values []string) []destRule {
+	rules := make([]destRule, 0, len(values))
+	for _, v := range values {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v == "" {
+			continue
+		}
+		if v == "*" {
+			rules = append(rules, destRule{any: true})
+			continue
+		}
+		if _, ipNet, err := net.ParseCIDR(v); err == nil {
+			rules = append(rules, destRule{ipNet: ipNet})
+			continue
+		}
+		var r destRule
+		if host, port, err := net.SplitHostPort(v); err == nil {
+			v, r.port = host, port
+		}
+		v = strings.Trim(v, "[]")
+		switch {
+		case v == "":
+			continue
+		case net.ParseIP(v) != nil:
+			r.ip = net.ParseIP(v)
+		case v[0] == '.':
+			r.suffix = strings.TrimSuffix(v, ".")
+		default:
+			r.domain = strings.TrimSuffix(v, ".")
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// match returns whether the rule matches the lowercase host and port.
+func (r *destRule) match(host, port string) bool {
+	if r.any {
+		return true
+	}
+	if r.port != "" && r.port != port {
+		return false
+	}
+	switch {
+	case r.ipNet != nil || r.ip != nil:
+		ip := net.ParseIP(host)
+		return ip != nil && r.matchIP(ip, port)
+	case r.suffix != "":
+		return strings.HasSuffix(host, r.suffix)
+	default:
+		return host == r.domain || strings.HasSuffix(host, "."+r.domain)
+	}
+}
+
+// matchIP returns whether the IP rule matches the ip and port.
+// Domain rules don't match IP addresses.
+func (r *destRule) matchIP(ip net.IP, port string) bool {
+	if r.any {
+		return true
+	}
+	if r.port != "" && r.port != port {
+		return false
+	}
+	switch {
+	case r.ipNet != nil:
+		return r.ipNet.Contains(ip)
+	case r.ip != nil:
+		return r.ip.Equal(ip)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,261 @@
+package fasthttpproxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bhargawpradhan/fasthttp"
+	"github.com/bhargawpradhan/fasthttp/fasthttputil"
+)
+
+type testLogger struct {
+	lines []string
+	mu    sync.Mutex
+}
+
+func (l *testLogger) Printf(format string, args ...any) {
+	l.mu.Lock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+	l.mu.Unlock()
+}
+
+func (l *testLogger) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.lines, "\n")
+}
+
+// testResolver resolves the names to the IP addresses.
+type testResolver map[string]string
+
+func (r testResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	ip, ok := r[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return []net.IPAddr{{IP: net.ParseIP(ip)}}, nil
+}
+
+// startForwardProxy starts p on a TCP listener in front of the in-memory
+// destination backend.test:80 served by h. backend.test resolves to
+// 192.0.2.10, while internal.test resolves to 10.1.2.3.
+// It returns the proxy address.
+func startForwardProxy(t *testing.T, p *Proxy, h fasthttp.RequestHandler) string {
+	t.Helper()
+
+	backendLn := fasthttputil.NewInmemoryListener()
+	backend := &fasthttp.Server{Handler: h}
+	go backend.Serve(backendLn)              //nolint:errcheck
+	t.Cleanup(func() { backend.Shutdown() }) //nolint:errcheck
+
+	p.Resolver = testResolver{"backend.test": "192.0.2.10", "internal.test": "10.1.2.3"}
+	p.Dial = func(addr string) (net.Conn, error) {
+		if addr != "192.0.2.10:80" {
+			return nil, errors.New("unknown destination " + addr)
+		}
+		return backendLn.Dial()
+	}
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := &fasthttp.Server{Handler: p.Handler}
+	go s.Serve(ln)                     //nolint:errcheck
+	t.Cleanup(func() { s.Shutdown() }) //nolint:errcheck
+	return ln.Addr().String()
+}
+
+func TestProxyConnect(t *testing.T) {
+	t.Parallel()
+
+	var logger testLogger
+	p := &Proxy{
+		Authenticate: func(username, password string) bool { return username == "foo" && password == "bar" },
+		Logger:       &logger,
+	}
+	proxyAddr := startForwardProxy(t, p, func(ctx *fasthttp.RequestCtx) {
+		if v := ctx.Request.Header.Peek(fasthttp.HeaderProxyAuthorization); len(v) > 0 {
+			t.Errorf("unexpected Proxy-Authorization header %q", v)
+		}
+		ctx.SetBodyString("hello from " + string(ctx.Host()))
+	})
+
+	c := &fasthttp.Client{Dial: FasthttpHTTPDialer("foo:bar@" + proxyAddr)}
+	statusCode, body, err := c.Get(nil, "http://backend.test/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if statusCode != fasthttp.StatusOK || string(body) != "hello from backend.test" {
+		t.Fatalf("unexpected response %d %q", statusCode, body)
+	}
+
+	// The tunnel is logged after the connection is closed.
+	c.CloseIdleConnections()
+	for i := 0; i < 100 && logger.String() == ""; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	log := logger.String()
+	if !strings.Contains(log, "-> backend.test:80 closed") || strings.Contains(log, " 0 bytes") {
+		t.Fatalf("unexpected tunnel log %q", log)
+	}
+
+	for _, auth := range []string{"", "foo:baz@"} {
+		c = &fasthttp.Client{Dial: FasthttpHTTPDialer(auth + proxyAddr)}
+		if _, _, err = c.Get(nil, "http://backend.test/"); err == nil || !strings.Contains(err.Error(), "status code: 407") {
+			t.Fatalf("unexpected error: %v. Expecting status code 407", err)
+		}
+	}
+
+	c = &fasthttp.Client{Dial: FasthttpHTTPDialer("foo:bar@" + proxyAddr)}
+	if _, _, err = c.Get(nil, "http://unknown.test/"); err == nil || !strings.Contains(err.Error(), "status code: 502") {
+		t.Fatalf("unexpected error: %v. Expecting status code 502", err)
+	}
+}
+
+func TestProxyConnectDenied(t *testing.T) {
+	t.Parallel()
+
+	for _, p := range []*Proxy{
+		{Deny: []string{"backend.test"}},
+		{Allow: []string{"other.test"}},
+		{Allow: []string{"*"}, Deny: []string{".test:80"}},
+	} {
+		proxyAddr := startForwardProxy(t, p, func(ctx *fasthttp.RequestCtx) {})
+		c := &fasthttp.Client{Dial: FasthttpHTTPDialer(proxyAddr)}
+		if _, _, err := c.Get(nil, "http://backend.test/"); err == nil || !strings.Contains(err.Error(), "status code: 403") {
+			t.Fatalf("unexpected error: %v. Expecting status code 403", err)
+		}
+	}
+}
+
+func TestProxyAbsoluteForm(t *testing.T) {
+	t.Parallel()
+
+	p := &Proxy{Deny: []string{"denied.test"}}
+	proxyAddr := startForwardProxy(t, p, func(ctx *fasthttp.RequestCtx) {
+		if v := ctx.Request.Header.Peek("X-Hop"); len(v) > 0 {
+			t.Errorf("unexpected hop-by-hop header %q", v)
+		}
+		ctx.Response.Header.Set("Keep-Alive", "timeout=5")
+		ctx.SetBodyString(string(ctx.RequestURI()))
+	})
+
+	conn, err := net.Dial("tcp4", proxyAddr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+
+	for _, tc := range []struct {
+		request    string
+		statusCode int
+		body       string
+	}{
+		{"GET http://backend.test/foo?bar HTTP/1.1\r\nHost: backend.test\r\nConnection: X-Hop\r\nX-Hop: 1\r\n\r\n", fasthttp.StatusOK, "/foo?bar"},
+		{"GET /foo HTTP/1.1\r\nHost: backend.test\r\n\r\n", fasthttp.StatusBadRequest, "absolute request URI is required"},
+		{"GET http://denied.test/ HTTP/1.1\r\nHost: denied.test\r\n\r\n", fasthttp.StatusForbidden, "Forbidden"},
+		{"GET http://unknown.test/ HTTP/1.1\r\nHost: unknown.test\r\n\r\n", fasthttp.StatusBadGateway, "Bad Gateway"},
+	} {
+		if _, err = conn.Write([]byte(tc.request)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var resp fasthttp.Response
+		if err = resp.Read(br); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.StatusCode() != tc.statusCode || string(resp.Body()) != tc.body {
+			t.Fatalf("unexpected response %d %q. Expecting %d %q", resp.StatusCode(), resp.Body(), tc.statusCode, tc.body)
+		}
+		if v := resp.Header.Peek("Keep-Alive"); len(v) > 0 {
+			t.Fatalf("unexpected hop-by-hop header %q", v)
+		}
+	}
+}
+
+func TestProxyResolvedDenied(t *testing.T) {
+	t.Parallel()
+
+	for _, p := range []*Proxy{
+		{Deny: []string{"10.0.0.0/8"}},
+		{Allow: []string{"192.0.2.0/24"}},
+		{Allow: []string{"192.0.2.0/24"}, Client: &fasthttp.Client{}},
+	} {
+		if p.Client != nil {
+			// The custom client dials the checked addresses via Proxy.Dial.
+			p.Client.Dial = func(addr string) (net.Conn, error) { return p.Dial(addr) }
+		}
+		proxyAddr := startForwardProxy(t, p, func(ctx *fasthttp.RequestCtx) {})
+
+		// CONNECT
+		c := &fasthttp.Client{Dial: FasthttpHTTPDialer(proxyAddr)}
+		if _, _, err := c.Get(nil, "http://internal.test/"); err == nil || !strings.Contains(err.Error(), "status code: 403") {
+			t.Fatalf("unexpected error: %v. Expecting status code 403", err)
+		}
+		if statusCode, _, err := c.Get(nil, "http://backend.test/"); err != nil || statusCode != fasthttp.StatusOK {
+			t.Fatalf("unexpected response %d, %v. Expecting %d", statusCode, err, fasthttp.StatusOK)
+		}
+
+		// Absolute form. Proxy.Dial accepts the resolved backend.test
+		// address only.
+		for host, statusCode := range map[string]int{
+			"internal.test": fasthttp.StatusForbidden,
+			"backend.test":  fasthttp.StatusOK,
+		} {
+			conn, err := net.Dial("tcp4", proxyAddr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, err = conn.Write([]byte("GET http://" + host + "/ HTTP/1.1\r\nHost: " + host + "\r\n\r\n")); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var resp fasthttp.Response
+			if err = resp.Read(bufio.NewReader(conn)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			conn.Close()
+			if resp.StatusCode() != statusCode {
+				t.Fatalf("unexpected status code %d for %q. Expecting %d", resp.StatusCode(), host, statusCode)
+			}
+		}
+	}
+}
+
+func TestDestRules(t *testing.T) {
+	t.Parallel()
+
+	rules := parseDestRules([]string{"example.com", ".sub.org", "10.0.0.0/8", "192.0.2.1:443", "[2001:db8::1]", "bad/cidr", ""})
+	for _, tc := range []struct {
+		host, port string
+		expected   bool
+	}{
+		{"example.com", "443", true},
+		{"www.example.com", "80", true},
+		{"notexample.com", "80", false},
+		{"sub.org", "80", false},
+		{"a.sub.org", "80", true},
+		{"10.1.2.3", "22", true},
+		{"11.1.2.3", "22", false},
+		{"192.0.2.1", "443", true},
+		{"192.0.2.1", "80", false},
+		{"2001:db8::1", "443", true},
+	} {
+		matched := false
+		for i := range rules {
+			if rules[i].match(tc.host, tc.port) {
+				matched = true
+			}
+		}
+		if matched != tc.expected {
+			t.Fatalf("unexpected match of %s:%s: %v. Expecting %v", tc.host, tc.port, matched, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package fasthttputil
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSServer is a fake DNS server on the loopback interface.
+//
+// It answers A, AAAA and SRV queries for the names registered via SetIPs
+// and SetSRV, so code depending on DNS may be tested without network
+// access. Unknown names are answered with NXDOMAIN.
+type DNSServer struct {
+	conn    net.PacketConn
+	records map[string]*dnsRecords
+	done    chan struct{}
+	queries atomic.Int64
+	mu      sync.RWMutex
+}
+
+type dnsRecords struct {
+	ips  []net.IP
+	srvs []net.SRV
+}
+
+// DNSServerTTL is the TTL of the records served by DNSServer in seconds.
+const DNSServerTTL = 60
+
+// NewDNSServer starts a new fake DNS server on a random UDP port
+// of the loopback interface.
+func NewDNSServer() (*DNSServer, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &DNSServer{
+		conn:    conn,
+		records: make(map[string]*dnsRecords),
+		done:    make(chan struct{}),
+	}
+	go s.serve()
+	return s, nil
+}
+
+// Addr returns the server address.
+func (s *DNSServer) Addr() string {
+	return s.conn.LocalAddr().String()
+}
+
+// Resolver returns the resolver sending all the queries to the server.
+func (s *DNSServer) Resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", s.Addr())
+		},
+	}
+}
+
+// SetIPs sets A and AAAA records of the name.
+func (s *DNSServer) SetIPs(name string, ips ...net.IP) {
+	s.mu.Lock()
+	s.record(name).ips = append([]net.IP(nil), ips...)
+	s.mu.Unlock()
+}
+
+// SetSRV sets SRV records of the name, e.g. "_http._tcp.example.com".
+func (s *DNSServer) SetSRV(name string, srvs ...net.SRV) {
+	s.mu.Lock()
+	s.record(name).srvs = append([]net.SRV(nil), srvs...)
+	s.mu.Unlock()
+}
+
+// Delete deletes all the records of the name.
+func (s *DNSServer) Delete(name string) {
+	s.mu.Lock()
+	delete(s.records, dnsKey(name))
+	s.mu.Unlock()
+}
+
+// Queries returns the number of queries received by the server.
+func (s *DNSServer) Queries() int {
+	return int(s.queries.Load())
+}
+
+// Close stops the server.
+func (s *DNSServer) Close() error {
+	err := s.conn.Close()
+	<-s.done
+	return err
+}
+
+// record returns the records of the name. s.mu must be held.
+func (s *DNSServer) record(name string) *dnsRecords {
+	key := dnsKey(name)
+	r := s.records[key]
+	if r == nil {
+		r = &dnsRecords{}
+		s.records[key] = r
+	}
+	return r
+}
+
+func dnsKey(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+func (s *DNSServer) serve() {
+	defer close(s.done)
+
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		s.queries.Add(1)
+		resp, err := s.answer(buf[:n])
+		if err != nil {
+			continue
+		}
+		s.conn.WriteTo(resp, addr) //nolint:errcheck
+	}
+}
+
+func (s *DNSServer) answer(query []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r := s.records[dnsKey(q.Name.String())]
+	rcode := dnsmessage.RCodeSuccess
+	if r == nil {
+		rcode = dnsmessage.RCodeNameError
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:                 h.ID,
+		Response:           true,
+		Authoritative:      true,
+		RecursionDesired:   h.RecursionDesired,
+		RecursionAvailable: true,
+		RCode:              rcode,
+	})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	if r != nil {
+		rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: DNSServerTTL}
+		switch q.Type {
+		case dnsmessage.TypeA:
+			for _, ip := range r.ips {
+				if ip4 := ip.To4(); ip4 != nil {
+					if err := b.AResource(rh, dnsmessage.AResource{A: [4]byte(ip4)}); err != nil {
+						return nil, err
+					}
+				}
+			}
+		case dnsmessage.TypeAAAA:
+			for _, ip := range r.ips {
+				if ip.To4() == nil && len(ip) == net.IPv6len {
+					if err := b.AAAAResource(rh, dnsmessage.AAAAResource{AAAA: [16]byte(ip)}); err != nil {
+						return nil, err
+					}
+				}
+			}
+		case dnsmessage.TypeSRV:
+			for _, srv := range r.srvs {
+				target, err := dnsmessage.NewName(strings.TrimSuffix(srv.Target, ".") + ".")
+				if err != nil {
+					return nil, err
+				}
+				res := dnsmessage.SRVResource{
+					Priority: srv.Priority,
+					Weight:   srv.Weight,
+					Port:     srv.Port,
+					Target:   target,
+				}
+				if err := b.SRVResource(rh, res); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return b.Finish()
+}
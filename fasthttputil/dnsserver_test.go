@@ -0,0 +1,49 @@
+package fasthttputil
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestDNSServer(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewDNSServer()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	s.SetIPs("foo.test", net.ParseIP("10.0.0.1"), net.ParseIP("::1"))
+	s.SetSRV("_http._tcp.foo.test", net.SRV{Target: "a.foo.test", Port: 8080, Priority: 1, Weight: 5})
+
+	r := s.Resolver()
+	ctx := context.Background()
+	addrs, err := r.LookupHost(ctx, "FOO.test.")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(addrs) != 2 {
+		t.Fatalf("unexpected addresses %v", addrs)
+	}
+
+	_, srvs, err := r.LookupSRV(ctx, "http", "tcp", "foo.test.")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(srvs) != 1 || srvs[0].Target != "a.foo.test." || srvs[0].Port != 8080 || srvs[0].Weight != 5 {
+		t.Fatalf("unexpected SRV records %+v", srvs)
+	}
+
+	s.Delete("foo.test")
+	_, err = r.LookupHost(ctx, "foo.test.")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Fatalf("unexpected error: %v. Expecting not found error", err)
+	}
+	if s.Queries() == 0 {
+		t.Fatal("no queries received")
+	}
+}
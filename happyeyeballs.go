@@ -0,0 +1,159 @@
+package fasthttp
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// DefaultFallbackDelay is the default Connection Attempt Delay used
+// by DialDualStack*. It matches the value recommended by RFC 8305.
+const DefaultFallbackDelay = 250 * time.Millisecond
+
+// DualStackDialInfo describes the connection established by DialDualStack*.
+//
+// It is passed to TCPDialer.OnDualStackDial and ClientTrace.DualStackDialDone.
+type DualStackDialInfo struct {
+	// Upstream is the address the connection has been established to.
+	Upstream string
+
+	// Attempts is the number of connection attempts started,
+	// including the successful one.
+	Attempts int
+
+	// Fallback is true if the connection has been established
+	// to an address other than the first address tried.
+	Fallback bool
+}
+
+// dialHappyEyeballs races connection attempts to the resolved addresses
+// as described in RFC 8305.
+//
+// The next attempt is started after the fallback delay or as soon as
+// the previous attempt fails. The first established connection wins,
+// the remaining attempts are canceled.
+func (d *TCPDialer) dialHappyEyeballs(
+	addr string, e *tcpAddrEntry, idx uint32, deadline time.Time, trace *ClientTrace,
+) (net.Conn, error) {
+	addrs := interleaveAddrs(e.addrs, idx, e.preferredFamily.Load() == 4)
+	delay := d.FallbackDelay
+	if delay == 0 {
+		delay = DefaultFallbackDelay
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	results := make(chan dialResult, len(addrs))
+	started := 0
+	startAttempt := func() {
+		i := started
+		started++
+		go func() {
+			conn, err := d.tryDialContext(ctx, "tcp", addrs[i].String(), deadline, d.concurrencyCh, trace)
+			results <- dialResult{conn: conn, err: err, i: i}
+		}()
+	}
+
+	startAttempt()
+	pending := 1
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var firstErr error
+	for pending > 0 {
+		var timerCh <-chan time.Time
+		if started < len(addrs) {
+			timerCh = timer.C
+		}
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				cancel()
+				if pending > 0 {
+					go drainDialResults(results, pending)
+				}
+				family := int32(6)
+				if addrs[r.i].IP.To4() != nil {
+					family = 4
+				}
+				e.preferredFamily.Store(family)
+				if d.OnDualStackDial != nil || trace != nil {
+					info := DualStackDialInfo{
+						Upstream: addrs[r.i].String(),
+						Attempts: started,
+						Fallback: r.i > 0,
+					}
+					if d.OnDualStackDial != nil {
+						d.OnDualStackDial(addr, info)
+					}
+					trace.dualStackDialDone(info)
+				}
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if started < len(addrs) {
+				// Do not wait for the delay after a failed attempt.
+				startAttempt()
+				pending++
+				timer.Reset(delay)
+			}
+		case <-timerCh:
+			startAttempt()
+			pending++
+			timer.Reset(delay)
+		}
+	}
+	return nil, firstErr
+}
+
+type dialResult struct {
+	conn net.Conn
+	err  error
+	i    int
+}
+
+// drainDialResults closes the connections established by the attempts
+// which lost the race.
+func drainDialResults(results <-chan dialResult, n int) {
+	for range n {
+		if r := <-results; r.conn != nil {
+			r.conn.Close()
+		}
+	}
+}
+
+// interleaveAddrs returns addrs rotated by idx with IPv6 and IPv4 addresses
+// interleaved. The first address belongs to IPv6 family unless preferIPv4
+// is set or there are no IPv6 addresses.
+func interleaveAddrs(addrs []net.TCPAddr, idx uint32, preferIPv4 bool) []net.TCPAddr {
+	n := uint32(len(addrs)) // #nosec G115
+	var v6, v4 []net.TCPAddr
+	for i := range n {
+		a := addrs[(idx+i)%n]
+		if a.IP.To4() != nil {
+			v4 = append(v4, a)
+		} else {
+			v6 = append(v6, a)
+		}
+	}
+	first, second := v6, v4
+	if preferIPv4 {
+		first, second = v4, v6
+	}
+	result := make([]net.TCPAddr, 0, n)
+	for len(first) > 0 || len(second) > 0 {
+		if len(first) > 0 {
+			result = append(result, first[0])
+			first = first[1:]
+		}
+		if len(second) > 0 {
+			result = append(result, second[0])
+			second = second[1:]
+		}
+	}
+	return result
+}
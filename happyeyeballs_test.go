@@ -0,0 +1,218 @@
+package fasthttp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type staticResolver []net.IPAddr
+
+func (r staticResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return r, nil
+}
+
+type happyEyeballsConn struct {
+	net.Conn
+	addr   string
+	closed atomic.Bool
+}
+
+func (c *happyEyeballsConn) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+func TestInterleaveAddrs(t *testing.T) {
+	t.Parallel()
+
+	addrs := []net.TCPAddr{
+		{IP: net.ParseIP("10.0.0.1")},
+		{IP: net.ParseIP("10.0.0.2")},
+		{IP: net.ParseIP("::1")},
+		{IP: net.ParseIP("::2")},
+		{IP: net.ParseIP("10.0.0.3")},
+	}
+	check := func(idx uint32, preferIPv4 bool, expected ...string) {
+		t.Helper()
+
+		result := interleaveAddrs(addrs, idx, preferIPv4)
+		if len(result) != len(expected) {
+			t.Fatalf("unexpected number of addrs %d. Expecting %d", len(result), len(expected))
+		}
+		for i := range result {
+			if s := result[i].IP.String(); s != expected[i] {
+				t.Fatalf("unexpected addr #%d %q. Expecting %q", i, s, expected[i])
+			}
+		}
+	}
+	check(0, false, "::1", "10.0.0.1", "::2", "10.0.0.2", "10.0.0.3")
+	check(0, true, "10.0.0.1", "::1", "10.0.0.2", "::2", "10.0.0.3")
+	check(1, false, "::1", "10.0.0.2", "::2", "10.0.0.3", "10.0.0.1")
+	if result := interleaveAddrs(addrs[:2], 0, false); result[0].IP.String() != "10.0.0.1" {
+		t.Fatalf("unexpected first addr %q", result[0].IP)
+	}
+}
+
+func TestDialDualStackHappyEyeballs(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var dialed []string
+	var v6Canceled atomic.Int32
+	var info DualStackDialInfo
+	d := &TCPDialer{
+		Resolver:      staticResolver{{IP: net.ParseIP("127.0.0.1")}, {IP: net.ParseIP("::1")}},
+		FallbackDelay: 50 * time.Millisecond,
+		OnDualStackDial: func(addr string, i DualStackDialInfo) {
+			info = i
+		},
+		testHookDial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			mu.Lock()
+			dialed = append(dialed, addr)
+			mu.Unlock()
+			if addr == "[::1]:80" {
+				// Blackholed IPv6.
+				<-ctx.Done()
+				v6Canceled.Add(1)
+				return nil, ctx.Err()
+			}
+			return &happyEyeballsConn{addr: addr}, nil
+		},
+	}
+
+	start := time.Now()
+	conn, err := d.DialDualStackTimeout("example.com:80", time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > 500*time.Millisecond {
+		t.Fatalf("unexpected dial duration %s", elapsed)
+	}
+	if a := conn.(*happyEyeballsConn).addr; a != "127.0.0.1:80" {
+		t.Fatalf("unexpected upstream %q", a)
+	}
+	if info.Upstream != "127.0.0.1:80" || info.Attempts != 2 || !info.Fallback {
+		t.Fatalf("unexpected dial info %+v", info)
+	}
+	for i := 0; v6Canceled.Load() == 0; i++ {
+		if i > 100 {
+			t.Fatal("the losing attempt hasn't been canceled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The next dial must start with the remembered IPv4 family.
+	mu.Lock()
+	dialed = dialed[:0]
+	mu.Unlock()
+	start = time.Now()
+	var traceInfo DualStackDialInfo
+	trace := &ClientTrace{
+		DualStackDialDone: func(i DualStackDialInfo) {
+			traceInfo = i
+		},
+	}
+	if _, err = d.dial("example.com:80", true, time.Second, trace); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 50*time.Millisecond {
+		t.Fatalf("unexpected dial duration %s with the remembered family", elapsed)
+	}
+	if info.Fallback || info.Attempts != 1 {
+		t.Fatalf("unexpected dial info %+v", info)
+	}
+	if traceInfo != info {
+		t.Fatalf("unexpected traced dial info %+v. Expecting %+v", traceInfo, info)
+	}
+	mu.Lock()
+	if len(dialed) != 1 || dialed[0] != "127.0.0.1:80" {
+		t.Fatalf("unexpected dialed addrs %q", dialed)
+	}
+	mu.Unlock()
+}
+
+func TestDialDualStackHappyEyeballsFastFallback(t *testing.T) {
+	t.Parallel()
+
+	errRefused := errors.New("connection refused")
+	d := &TCPDialer{
+		Resolver:      staticResolver{{IP: net.ParseIP("::1")}, {IP: net.ParseIP("127.0.0.1")}},
+		FallbackDelay: time.Minute,
+		testHookDial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if addr == "[::1]:80" {
+				return nil, errRefused
+			}
+			return &happyEyeballsConn{addr: addr}, nil
+		},
+	}
+
+	// The failed attempt must start the next one without waiting for FallbackDelay.
+	conn, err := d.DialDualStackTimeout("example.com:80", time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a := conn.(*happyEyeballsConn).addr; a != "127.0.0.1:80" {
+		t.Fatalf("unexpected upstream %q", a)
+	}
+}
+
+func TestDialDualStackHappyEyeballsAllFailed(t *testing.T) {
+	t.Parallel()
+
+	errRefused := errors.New("connection refused")
+	d := &TCPDialer{
+		Resolver: staticResolver{{IP: net.ParseIP("::1")}, {IP: net.ParseIP("127.0.0.1")}},
+		testHookDial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return nil, errRefused
+		},
+	}
+
+	_, err := d.DialDualStackTimeout("example.com:80", time.Second)
+	if !errors.Is(err, errRefused) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errRefused)
+	}
+	var dialErr *ErrDialWithUpstream
+	if !errors.As(err, &dialErr) || dialErr.Upstream != "[::1]:80" {
+		t.Fatalf("unexpected error: %v. Expecting the error for the first address", err)
+	}
+}
+
+func TestDialDualStackReturnsTCPConn(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	d := &TCPDialer{
+		Resolver: staticResolver{{IP: net.ParseIP("::1")}, {IP: net.ParseIP("127.0.0.1")}},
+	}
+	conn, err := d.DialDualStack(net.JoinHostPort("example.com", port))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+
+	// Callers rely on *net.TCPConn for CloseWrite, SyscallConn
+	// and the sendfile fast path of io.ReaderFrom.
+	if _, ok := conn.(*net.TCPConn); !ok {
+		t.Fatalf("unexpected connection type %T. Expecting *net.TCPConn", conn)
+	}
+}
@@ -0,0 +1,313 @@
+package fasthttp
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultHedgeDelay is the default delay before sending a hedged
+	// request copy.
+	DefaultHedgeDelay = 50 * time.Millisecond
+
+	// DefaultMaxConcurrentHedges is the default maximum number of hedged
+	// request copies in flight.
+	DefaultMaxConcurrentHedges = 10
+)
+
+const (
+	// hedgeLatencySamples is the number of recent latencies
+	// the percentile delay is calculated from.
+	hedgeLatencySamples = 1024
+
+	// hedgeMinLatencySamples is the number of latencies required
+	// for using the percentile delay.
+	hedgeMinLatencySamples = 20
+
+	// hedgeLatencyRefresh is the number of new latencies after which
+	// the percentile delay is recalculated.
+	hedgeLatencyRefresh = 64
+)
+
+// HedgePolicy sends additional copies of slow requests to reduce
+// tail latency in HostClient and LBClient.
+//
+// A copy of the request is sent if no response has been received
+// during the hedge delay. The first successful response, i.e. a response
+// without error and with status code below 500, is returned to the caller,
+// while the connections of the remaining copies are closed.
+// HostClient sends the copies over its connection pool like any other
+// request: a copy takes an idle connection if there is one, which may be
+// connected to the same address as the original request, and only a newly
+// dialed connection goes to the next address from HostClient.Addr.
+// LBClient sends the copies to the client selected by LBClient.Strategy.
+//
+// Only requests without body streams are hedged. Responses aren't hedged
+// if Response.StreamBody or HostClient.StreamResponseBody is set.
+//
+// HedgePolicy may be shared by multiple clients.
+type HedgePolicy struct {
+	// IsHedgeable returns whether the request may be hedged.
+	//
+	// By default only GET and HEAD requests are hedged. Make sure
+	// the requests may be safely sent multiple times when overriding it.
+	IsHedgeable func(req *Request) bool
+
+	// Delay is the delay before sending each request copy.
+	//
+	// The delay is used until enough latencies are observed if Percentile
+	// is set.
+	//
+	// By default DefaultHedgeDelay is used.
+	Delay time.Duration
+
+	// Percentile sets the hedge delay to the given percentile
+	// of the recently observed latencies, e.g. 95 for hedging requests
+	// slower than 95% of requests.
+	//
+	// By default the fixed Delay is used.
+	Percentile float64
+
+	// MaxHedges is the maximum number of request copies sent in addition
+	// to the original request.
+	//
+	// By default a single copy is sent.
+	MaxHedges int
+
+	// MaxConcurrentHedges is the number of tokens limiting the request
+	// copies in flight across all the clients sharing the policy. Requests
+	// aren't hedged while the tokens are exhausted, so hedging cannot
+	// overload the servers.
+	//
+	// By default DefaultMaxConcurrentHedges is used.
+	MaxConcurrentHedges int
+
+	requests        atomic.Uint64
+	hedges          atomic.Uint64
+	wins            atomic.Uint64
+	budgetExhausted atomic.Uint64
+
+	inflight atomic.Int32
+
+	latencyMu      sync.Mutex
+	latencies      []time.Duration
+	latencyPos     int
+	latencyPending int
+	latencyDelay   time.Duration
+}
+
+// HedgeStats contains HedgePolicy statistics.
+type HedgeStats struct {
+	// Requests is the number of hedgeable requests.
+	Requests uint64
+
+	// Hedges is the number of request copies sent.
+	Hedges uint64
+
+	// Wins is the number of requests answered first by a copy.
+	Wins uint64
+
+	// BudgetExhausted is the number of copies not sent because
+	// of MaxConcurrentHedges.
+	BudgetExhausted uint64
+}
+
+// WinRate returns the ratio of request copies answered first.
+func (s HedgeStats) WinRate() float64 {
+	if s.Hedges == 0 {
+		return 0
+	}
+	return float64(s.Wins) / float64(s.Hedges)
+}
+
+// Stats returns the policy statistics.
+func (p *HedgePolicy) Stats() HedgeStats {
+	return HedgeStats{
+		Requests:        p.requests.Load(),
+		Hedges:          p.hedges.Load(),
+		Wins:            p.wins.Load(),
+		BudgetExhausted: p.budgetExhausted.Load(),
+	}
+}
+
+func (p *HedgePolicy) hedgeable(req *Request, resp *Response) bool {
+	if req.IsBodyStream() || (resp != nil && resp.StreamBody) {
+		return false
+	}
+	if p.IsHedgeable != nil {
+		return p.IsHedgeable(req)
+	}
+	return req.Header.IsGet() || req.Header.IsHead()
+}
+
+func (p *HedgePolicy) maxHedges() int {
+	if p.MaxHedges <= 0 {
+		return 1
+	}
+	return p.MaxHedges
+}
+
+func (p *HedgePolicy) acquireToken() bool {
+	limit := p.MaxConcurrentHedges
+	if limit <= 0 {
+		limit = DefaultMaxConcurrentHedges
+	}
+	if p.inflight.Add(1) > int32(limit) { // #nosec G115
+		p.inflight.Add(-1)
+		p.budgetExhausted.Add(1)
+		return false
+	}
+	return true
+}
+
+// delay returns the delay before sending the next request copy.
+func (p *HedgePolicy) delay() time.Duration {
+	if p.Percentile > 0 {
+		p.latencyMu.Lock()
+		d := p.latencyDelay
+		p.latencyMu.Unlock()
+		if d > 0 {
+			return d
+		}
+	}
+	if p.Delay <= 0 {
+		return DefaultHedgeDelay
+	}
+	return p.Delay
+}
+
+func (p *HedgePolicy) observe(d time.Duration) {
+	if p.Percentile <= 0 {
+		return
+	}
+
+	p.latencyMu.Lock()
+	defer p.latencyMu.Unlock()
+
+	if len(p.latencies) < hedgeLatencySamples {
+		p.latencies = append(p.latencies, d)
+	} else {
+		p.latencies[p.latencyPos] = d
+		p.latencyPos = (p.latencyPos + 1) % hedgeLatencySamples
+	}
+	p.latencyPending++
+	if len(p.latencies) < hedgeMinLatencySamples ||
+		(p.latencyDelay > 0 && p.latencyPending < hedgeLatencyRefresh) {
+		return
+	}
+	p.latencyPending = 0
+
+	sorted := slices.Clone(p.latencies)
+	slices.Sort(sorted)
+	n := int(float64(len(sorted)) * min(p.Percentile, 100) / 100)
+	p.latencyDelay = sorted[min(n, len(sorted)-1)]
+}
+
+type hedgeAttempt struct {
+	req    *Request
+	resp   *Response
+	err    error
+	cancel context.CancelFunc
+	hedge  bool
+}
+
+func (a *hedgeAttempt) ok() bool {
+	return a.err == nil && a.resp.StatusCode() < StatusInternalServerError
+}
+
+func (a *hedgeAttempt) release() {
+	ReleaseRequest(a.req)
+	ReleaseResponse(a.resp)
+}
+
+// do performs the request via do, sending request copies
+// after the hedge delay.
+func (p *HedgePolicy) do(req *Request, resp *Response, do DoFunc) error {
+	p.requests.Add(1)
+
+	parent := req.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	results := make(chan *hedgeAttempt, p.maxHedges()+1)
+	attempts := make([]*hedgeAttempt, 0, p.maxHedges()+1)
+	launch := func(hedge bool) {
+		a := &hedgeAttempt{
+			req:   AcquireRequest(),
+			resp:  AcquireResponse(),
+			hedge: hedge,
+		}
+		req.CopyTo(a.req)
+		a.req.timeout = req.timeout
+		a.req.trace = req.trace
+		a.req.ctx, a.cancel = context.WithCancel(parent)
+		if resp != nil {
+			a.resp.SkipBody = resp.SkipBody
+		}
+		attempts = append(attempts, a)
+		go func() {
+			start := time.Now()
+			a.err = do(a.req, a.resp)
+			if a.hedge {
+				p.inflight.Add(-1)
+			}
+			if a.ok() {
+				p.observe(time.Since(start))
+			}
+			results <- a
+		}()
+	}
+
+	launch(false)
+	pending := 1
+	timer := time.NewTimer(p.delay())
+	defer timer.Stop()
+
+	var result *hedgeAttempt
+	for result == nil {
+		select {
+		case a := <-results:
+			pending--
+			if a.ok() || pending == 0 {
+				result = a
+			} else {
+				// Wait for the remaining copies.
+				a.release()
+			}
+		case <-timer.C:
+			if len(attempts) > p.maxHedges() || !p.acquireToken() {
+				continue
+			}
+			p.hedges.Add(1)
+			launch(true)
+			pending++
+			timer.Reset(p.delay())
+		}
+	}
+
+	// Abort the remaining copies.
+	for _, a := range attempts {
+		a.cancel()
+	}
+	if pending > 0 {
+		go func() {
+			for i := 0; i < pending; i++ {
+				a := <-results
+				a.release()
+			}
+		}()
+	}
+
+	if result.hedge && result.ok() {
+		p.wins.Add(1)
+	}
+	if resp != nil {
+		result.resp.CopyTo(resp)
+	}
+	err := result.err
+	result.release()
+	return err
+}
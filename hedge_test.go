@@ -0,0 +1,203 @@
+package fasthttp
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowFirstServer delays the response to the first request.
+func slowFirstServer(delay time.Duration) (RequestHandler, *atomic.Int32) {
+	var n atomic.Int32
+	return func(ctx *RequestCtx) {
+		if i := n.Add(1); i == 1 {
+			time.Sleep(delay)
+			ctx.WriteString("slow") //nolint:errcheck
+			return
+		}
+		ctx.WriteString("fast") //nolint:errcheck
+	}, &n
+}
+
+func TestHostClientHedgePolicy(t *testing.T) {
+	t.Parallel()
+
+	h, n := slowFirstServer(time.Second)
+	ln := startRetryServer(t, h)
+	p := &HedgePolicy{Delay: 10 * time.Millisecond}
+	c := &HostClient{
+		Addr:        "example.com",
+		Dial:        func(string) (net.Conn, error) { return ln.Dial() },
+		HedgePolicy: p,
+	}
+
+	req := AcquireRequest()
+	resp := AcquireResponse()
+	defer ReleaseRequest(req)
+	defer ReleaseResponse(resp)
+	req.SetRequestURI("http://example.com/")
+
+	start := time.Now()
+	if err := c.DoTimeout(req, resp, 5*time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("too long hedged request: %s", d)
+	}
+	if body := string(resp.Body()); body != "fast" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "fast")
+	}
+	if n := n.Load(); n != 2 {
+		t.Fatalf("unexpected number of requests %d. Expecting 2", n)
+	}
+	if s := p.Stats(); s.Requests != 1 || s.Hedges != 1 || s.Wins != 1 || s.WinRate() != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	// The connection of the slow request is closed.
+	for i := 0; i < 100 && c.ConnsCount() > 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := c.ConnsCount(); n != 1 {
+		t.Fatalf("unexpected number of connections %d. Expecting 1", n)
+	}
+
+	// Fast requests aren't hedged.
+	req.SetTimeout(0)
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s := p.Stats(); s.Requests != 2 || s.Hedges != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestHostClientHedgePolicyNonIdempotent(t *testing.T) {
+	t.Parallel()
+
+	h, n := slowFirstServer(50 * time.Millisecond)
+	ln := startRetryServer(t, h)
+	p := &HedgePolicy{Delay: time.Millisecond}
+	c := &HostClient{
+		Addr:        "example.com",
+		Dial:        func(string) (net.Conn, error) { return ln.Dial() },
+		HedgePolicy: p,
+	}
+
+	req := AcquireRequest()
+	resp := AcquireResponse()
+	defer ReleaseRequest(req)
+	defer ReleaseResponse(resp)
+	req.SetRequestURI("http://example.com/")
+	req.Header.SetMethod(MethodPost)
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if body := string(resp.Body()); body != "slow" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "slow")
+	}
+	if n := n.Load(); n != 1 {
+		t.Fatalf("unexpected number of requests %d. Expecting 1", n)
+	}
+	if s := p.Stats(); s != (HedgeStats{}) {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestHedgePolicyBudget(t *testing.T) {
+	t.Parallel()
+
+	h, n := slowFirstServer(50 * time.Millisecond)
+	ln := startRetryServer(t, h)
+	p := &HedgePolicy{Delay: time.Millisecond, MaxConcurrentHedges: 1}
+	c := &HostClient{
+		Addr:        "example.com",
+		Dial:        func(string) (net.Conn, error) { return ln.Dial() },
+		HedgePolicy: p,
+	}
+
+	// Another request holds the only token.
+	if !p.acquireToken() {
+		t.Fatal("cannot acquire token")
+	}
+	req := AcquireRequest()
+	resp := AcquireResponse()
+	defer ReleaseRequest(req)
+	defer ReleaseResponse(resp)
+	req.SetRequestURI("http://example.com/")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := n.Load(); n != 1 {
+		t.Fatalf("unexpected number of requests %d. Expecting 1", n)
+	}
+	if s := p.Stats(); s.Hedges != 0 || s.BudgetExhausted == 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestHedgePolicyPercentileDelay(t *testing.T) {
+	t.Parallel()
+
+	p := &HedgePolicy{Delay: time.Second, Percentile: 95}
+	for i := 1; i < hedgeMinLatencySamples; i++ {
+		p.observe(time.Duration(i) * time.Millisecond)
+	}
+	if d := p.delay(); d != time.Second {
+		t.Fatalf("unexpected delay %s. Expecting %s", d, time.Second)
+	}
+	for i := hedgeMinLatencySamples; i <= 1000; i++ {
+		p.observe(time.Duration(i%100+1) * time.Millisecond)
+	}
+	if d := p.delay(); d < 90*time.Millisecond || d > 100*time.Millisecond {
+		t.Fatalf("unexpected delay %s. Expecting 95ms", d)
+	}
+}
+
+func TestLBClientHedgePolicy(t *testing.T) {
+	t.Parallel()
+
+	cs, bcs := newTestBalancingClients(2)
+	cs[0].delay = 300 * time.Millisecond
+	p := &HedgePolicy{Delay: 10 * time.Millisecond}
+	lb := &LBClient{
+		Clients:     bcs,
+		Strategy:    LBWeightedRoundRobin,
+		HedgePolicy: p,
+	}
+
+	start := time.Now()
+	doLBRequests(t, lb, 1, staticURI)
+	if d := time.Since(start); d > 200*time.Millisecond {
+		t.Fatalf("too long hedged request: %s", d)
+	}
+	if n0, n1 := cs[0].requests.Load(), cs[1].requests.Load(); n0 != 1 || n1 != 1 {
+		t.Fatalf("unexpected number of requests %d, %d. Expecting 1, 1", n0, n1)
+	}
+	if s := p.Stats(); s.Hedges != 1 || s.Wins != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	// Failed copies don't win.
+	cs, bcs = newTestBalancingClients(2)
+	cs[0].delay = 50 * time.Millisecond
+	cs[0].setErr(errors.New("failure"))
+	cs[1].setErr(errors.New("failure"))
+	p = &HedgePolicy{Delay: 10 * time.Millisecond}
+	lb = &LBClient{
+		Clients:     bcs,
+		Strategy:    LBWeightedRoundRobin,
+		HedgePolicy: p,
+	}
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.SetRequestURI(staticURI(0))
+	if err := lb.Do(req, nil); err == nil {
+		t.Fatal("expecting error")
+	}
+	if s := p.Stats(); s.Hedges != 1 || s.Wins != 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -42,6 +43,9 @@ type Request struct {
 	w          requestBodyWriter
 	body       *bytebufferpool.ByteBuffer
 
+	// Returns a fresh body stream for retries. Set by SetBodyStreamFunc.
+	getBody func() (io.Reader, error)
+
 	multipartForm         *multipart.Form
 	multipartFormBoundary string
 
@@ -61,8 +65,23 @@ type Request struct {
 	// if <= 0, means not set
 	timeout time.Duration
 
+	// Request context. Set by DoContext
+	// if nil, means not set
+	ctx context.Context
+
+	// URL of the first request in the redirect chain. Set while
+	// following redirects for CookieJar.
+	cookieSite string
+
+	// Hooks called by the client. Set by SetClientTrace.
+	trace *ClientTrace
+
 	secureErrorLogMessage bool
 
+	// Set by LBClient health checks for sending the HostClient.Addr entry
+	// of the acquired connection in Host header.
+	hostFromConn bool
+
 	// Group bool members in order to reduce Request object size.
 	parsedURI      bool
 	parsedPostArgs bool
@@ -862,6 +881,7 @@ func (req *Request) ResetBody() {
 	req.bodyRaw = nil
 	req.RemoveMultipartFormFiles()
 	req.closeBodyStream() //nolint:errcheck
+	req.getBody = nil
 	if req.body != nil {
 		if req.keepBodyBuffer {
 			req.body.Reset()
@@ -936,10 +956,10 @@ func swapRequestBody(a, b *Request) {
 	a.bodyStream, b.bodyStream = b.bodyStream, a.bodyStream
 
 	// This code assumes that if a requestStream was swapped the headers are also swapped or copied.
-	if rs, ok := a.bodyStream.(*requestStream); ok {
+	if rs := requestStreamOf(a.bodyStream); rs != nil {
 		rs.header = &a.Header
 	}
-	if rs, ok := b.bodyStream.(*requestStream); ok {
+	if rs := requestStreamOf(b.bodyStream); rs != nil {
 		rs.header = &b.Header
 	}
 }
@@ -1145,6 +1165,10 @@ func (req *Request) Reset() {
 	req.Header.Reset()
 	req.resetSkipHeader()
 	req.timeout = 0
+	req.ctx = nil
+	req.cookieSite = ""
+	req.trace = nil
+	req.hostFromConn = false
 	req.UseHostHeader = false
 	req.DisableRedirectPathNormalizing = false
 }
@@ -1455,12 +1479,17 @@ func (resp *Response) Read(r *bufio.Reader) error {
 //
 // io.EOF is returned if r is closed before reading the first header byte.
 func (resp *Response) ReadLimitBody(r *bufio.Reader, maxBodySize int) error {
+	return resp.readLimitBody(r, maxBodySize, nil)
+}
+
+func (resp *Response) readLimitBody(r *bufio.Reader, maxBodySize int, trace *ClientTrace) error {
 	resp.resetSkipHeader()
 	err := resp.Header.Read(r)
 	if err != nil {
 		return err
 	}
 	if resp.Header.statusCode == StatusContinue {
+		trace.got100Continue()
 		// Read the next response according to http://www.w3.org/Protocols/rfc2616/rfc2616-sec8.html .
 		if err = resp.Header.Read(r); err != nil {
 			return err
@@ -3225,3 +3225,38 @@ func TestRequestGetTimeOut(t *testing.T) {
 		})
 	}
 }
+
+func TestSwapRequestBodyDecodedStream(t *testing.T) {
+	t.Parallel()
+
+	var a, b Request
+	encoded := AppendGzipBytes(nil, []byte("foobar"))
+	a.Header.SetContentLength(len(encoded))
+
+	bb := bytebufferpool.Get()
+	defer bytebufferpool.Put(bb)
+	bb.B = append(bb.B, encoded...)
+	rs := acquireRequestStream(bb, bufio.NewReader(bytes.NewReader(nil)), &a.Header)
+	d, err := acquireRequestBodyDecoder(rs, []byte("gzip"), 0, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a.bodyStream = d
+
+	a.Header.CopyTo(&b.Header)
+	swapRequestBody(&a, &b)
+	if rs.header != &b.Header {
+		t.Fatalf("the request stream must use the header of the request it has been swapped to")
+	}
+
+	body, err := io.ReadAll(b.bodyStream)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(body) != "foobar" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "foobar")
+	}
+	if err = b.closeBodyStream(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
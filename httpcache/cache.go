@@ -0,0 +1,709 @@
+// Package httpcache provides a shared HTTP cache for fasthttp request
+// handlers following RFC 9111.
+//
+// The cache stores responses of the wrapped handler and serves them
+// to subsequent requests while they are fresh. It honors Cache-Control,
+// Expires, Vary and Age, serves stale responses according to
+// stale-while-revalidate and stale-if-error, revalidates stale responses
+// with conditional requests and collapses concurrent requests for the same
+// missing response into a single call to the wrapped handler.
+package httpcache
+
+import (
+	"bytes"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bhargawpradhan/fasthttp"
+)
+
+const (
+	// DefaultTagHeader is the default response header containing
+	// the cache tags.
+	DefaultTagHeader = "Cache-Tag"
+
+	// DefaultName is the default cache name reported in Cache-Status header.
+	DefaultName = "fasthttp"
+
+	// DefaultMaxEntrySize is the default maximum response body size,
+	// which may be stored.
+	DefaultMaxEntrySize = 1024 * 1024
+
+	// DefaultHeuristicMaxAge is the default limit for the heuristic
+	// freshness lifetime.
+	DefaultHeuristicMaxAge = 24 * time.Hour
+)
+
+// HeaderCacheStatus is the response header reporting how the cache
+// handled the request as described in RFC 9211.
+const HeaderCacheStatus = "Cache-Status"
+
+// Config configures the Cache.
+type Config struct {
+	// Store for the cached responses.
+	//
+	// By default MemoryStore limited to DefaultMaxBytes is used.
+	Store Store
+
+	// KeyFunc returns the cache key for the given request.
+	//
+	// The key must not depend on the request method, since responses
+	// to unsafe methods invalidate the cached responses with the same key.
+	//
+	// By default the request host and the request URI are used.
+	KeyFunc func(ctx *fasthttp.RequestCtx) string
+
+	// TagHeader is the response header containing space or comma separated
+	// tags for purging the responses with Cache.PurgeTag.
+	//
+	// By default DefaultTagHeader is used.
+	TagHeader string
+
+	// Name is reported in Cache-Status response header.
+	//
+	// By default DefaultName is used.
+	Name string
+
+	// The maximum response body size, which may be stored.
+	//
+	// By default DefaultMaxEntrySize is used.
+	MaxEntrySize int
+
+	// The maximum freshness lifetime assigned heuristically to responses
+	// without explicit expiration time.
+	//
+	// By default DefaultHeuristicMaxAge is used.
+	HeuristicMaxAge time.Duration
+
+	// CacheSetCookie allows storing responses with Set-Cookie header.
+	//
+	// Such responses aren't stored by default, since the cookies would
+	// be shared between all the clients.
+	CacheSetCookie bool
+}
+
+// Cache is a shared HTTP cache for fasthttp request handlers.
+//
+// It is safe to call Cache methods from concurrently running goroutines.
+type Cache struct {
+	store   Store
+	keyFunc func(ctx *fasthttp.RequestCtx) string
+
+	inflight map[string]*call
+
+	// now is overridden in tests.
+	now func() time.Time
+
+	tagHeader string
+	name      string
+
+	maxEntrySize    int
+	heuristicMaxAge time.Duration
+
+	inflightMu sync.Mutex
+
+	cacheSetCookie bool
+}
+
+// call is an in-flight request to the wrapped handler.
+type call struct {
+	done chan struct{}
+}
+
+// New returns a Cache with the given config.
+func New(cfg Config) *Cache {
+	c := &Cache{
+		store:           cfg.Store,
+		keyFunc:         cfg.KeyFunc,
+		tagHeader:       cfg.TagHeader,
+		name:            cfg.Name,
+		maxEntrySize:    cfg.MaxEntrySize,
+		heuristicMaxAge: cfg.HeuristicMaxAge,
+		cacheSetCookie:  cfg.CacheSetCookie,
+		inflight:        make(map[string]*call),
+		now:             time.Now,
+	}
+	if c.store == nil {
+		c.store = NewMemoryStore(DefaultMaxBytes)
+	}
+	if c.keyFunc == nil {
+		c.keyFunc = defaultKey
+	}
+	if c.tagHeader == "" {
+		c.tagHeader = DefaultTagHeader
+	}
+	if c.name == "" {
+		c.name = DefaultName
+	}
+	if c.maxEntrySize <= 0 {
+		c.maxEntrySize = DefaultMaxEntrySize
+	}
+	if c.heuristicMaxAge <= 0 {
+		c.heuristicMaxAge = DefaultHeuristicMaxAge
+	}
+	return c
+}
+
+func defaultKey(ctx *fasthttp.RequestCtx) string {
+	return string(ctx.Host()) + string(ctx.URI().RequestURI())
+}
+
+// Handler returns a RequestHandler serving the cached responses of next.
+func (c *Cache) Handler(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		c.handle(next, ctx)
+	}
+}
+
+// Purge removes the response stored under the given key including
+// all its Vary variants and returns the number of removed entries.
+func (c *Cache) Purge(key string) int {
+	n := 0
+	if c.store.Delete(key) {
+		n++
+	}
+	return n + c.store.DeleteTag(varyTag(key))
+}
+
+// PurgeTag removes all the responses with the given tag and returns
+// the number of removed entries.
+func (c *Cache) PurgeTag(tag string) int {
+	return c.store.DeleteTag(tag)
+}
+
+// PurgeHandler purges the cached responses by the 'key' or 'tag' query
+// args and responds with the number of removed entries.
+//
+// The handler must be protected from public access.
+func (c *Cache) PurgeHandler(ctx *fasthttp.RequestCtx) {
+	args := ctx.QueryArgs()
+	n := 0
+	switch {
+	case args.Has("key"):
+		n = c.Purge(string(args.Peek("key")))
+	case args.Has("tag"):
+		n = c.PurgeTag(string(args.Peek("tag")))
+	default:
+		ctx.Error("missing 'key' or 'tag' query arg", fasthttp.StatusBadRequest)
+		return
+	}
+	ctx.SetContentType("text/plain; charset=utf-8")
+	ctx.SetBodyString(strconv.Itoa(n))
+}
+
+func (c *Cache) handle(next fasthttp.RequestHandler, ctx *fasthttp.RequestCtx) {
+	if !ctx.IsGet() && !ctx.IsHead() {
+		next(ctx)
+		// Responses to unsafe methods invalidate the target URI.
+		// See RFC 9111 section 4.4.
+		if isUnsafeMethod(ctx.Method()) {
+			if statusCode := ctx.Response.StatusCode(); statusCode >= 200 && statusCode < 400 {
+				c.Purge(c.keyFunc(ctx))
+			}
+		}
+		return
+	}
+
+	reqCC := requestCacheControl(&ctx.Request.Header)
+	key := c.keyFunc(ctx)
+	now := c.now()
+
+	e := c.lookup(key, &ctx.Request.Header)
+	if e != nil {
+		if c.usable(e, &reqCC, now) {
+			c.writeEntry(ctx, e, now, "hit")
+			return
+		}
+		if e.canServeStaleWhileRevalidate(&reqCC, now) {
+			c.writeEntry(ctx, e, now, "hit; detail=stale-while-revalidate")
+			if ctx.IsGet() && !reqCC.noStore {
+				c.revalidate(next, ctx, key, e, reqCC)
+			}
+			return
+		}
+	}
+
+	if reqCC.onlyIfCached {
+		ctx.Error("Gateway Timeout", fasthttp.StatusGatewayTimeout)
+		c.setStatus(&ctx.Response, "fwd=miss")
+		return
+	}
+
+	if ctx.IsHead() || reqCC.noStore {
+		next(ctx)
+		if ctx.IsHead() {
+			c.setStatus(&ctx.Response, "fwd=method")
+		} else {
+			c.setStatus(&ctx.Response, "fwd=request")
+		}
+		return
+	}
+
+	cl, leader := c.acquireCall(key)
+	if !leader {
+		// Wait for the concurrent request to the wrapped handler
+		// and try using its response.
+		<-cl.done
+		now = c.now()
+		if e = c.lookup(key, &ctx.Request.Header); e != nil && c.usable(e, &reqCC, now) {
+			c.writeEntry(ctx, e, now, "hit; collapsed")
+			return
+		}
+		c.forward(next, ctx, key, e, &reqCC)
+		return
+	}
+	defer c.releaseCall(key, cl)
+
+	c.forward(next, ctx, key, e, &reqCC)
+}
+
+// forward calls the wrapped handler, stores the response and leaves
+// the response for the client in ctx.
+//
+// The stale entry is revalidated with a conditional request if possible.
+func (c *Cache) forward(next fasthttp.RequestHandler, ctx *fasthttp.RequestCtx, key string, stale *Entry, reqCC *cacheControl) {
+	h := &ctx.Request.Header
+
+	conditional := false
+	if stale != nil && len(h.Peek(fasthttp.HeaderIfNoneMatch)) == 0 && len(h.Peek(fasthttp.HeaderIfModifiedSince)) == 0 {
+		if len(stale.ETag) > 0 {
+			h.SetBytesV(fasthttp.HeaderIfNoneMatch, stale.ETag)
+			conditional = true
+		}
+		if !stale.LastModified.IsZero() {
+			h.SetBytesV(fasthttp.HeaderIfModifiedSince, fasthttp.AppendHTTPDate(nil, stale.LastModified))
+			conditional = true
+		}
+	}
+
+	reqTime := c.now()
+	next(ctx)
+	respTime := c.now()
+
+	if conditional {
+		h.Del(fasthttp.HeaderIfNoneMatch)
+		h.Del(fasthttp.HeaderIfModifiedSince)
+	}
+
+	resp := &ctx.Response
+	statusCode := resp.StatusCode()
+
+	if conditional && statusCode == fasthttp.StatusNotModified {
+		if e := c.refresh(key, stale, &ctx.Request, resp, reqTime, respTime); e != nil {
+			c.set(key, e, h)
+			c.writeEntry(ctx, e, respTime, "fwd=stale; fwd-status=304")
+			return
+		}
+		// The refreshed response cannot be stored anymore.
+		// Serve it once since the origin confirmed it is valid.
+		c.Purge(key)
+		c.writeEntry(ctx, stale, respTime, "fwd=stale; fwd-status=304")
+		return
+	}
+
+	if stale != nil && statusCode >= 500 && stale.canServeStaleIfError(reqCC, respTime) {
+		c.writeEntry(ctx, stale, respTime, "fwd=stale; fwd-status="+strconv.Itoa(statusCode)+"; detail=stale-if-error")
+		return
+	}
+
+	status := "fwd=miss"
+	if stale != nil {
+		status = "fwd=stale"
+	}
+	if e := c.newEntry(key, &ctx.Request, resp, reqTime, respTime); e != nil {
+		c.set(key, e, h)
+		status += "; stored"
+	}
+	c.setStatus(resp, status)
+}
+
+// revalidate refreshes the stale entry in the background.
+func (c *Cache) revalidate(next fasthttp.RequestHandler, ctx *fasthttp.RequestCtx, key string, stale *Entry, reqCC cacheControl) {
+	cl, leader := c.acquireCall(key)
+	if !leader {
+		// The entry is already being refreshed.
+		return
+	}
+
+	rctx := &fasthttp.RequestCtx{}
+	rctx.Init(&ctx.Request, ctx.RemoteAddr(), ctx.Logger())
+
+	go func() {
+		defer c.releaseCall(key, cl)
+		c.forward(next, rctx, key, stale, &reqCC)
+	}()
+}
+
+func (c *Cache) acquireCall(key string) (*call, bool) {
+	c.inflightMu.Lock()
+	defer c.inflightMu.Unlock()
+
+	if cl, ok := c.inflight[key]; ok {
+		return cl, false
+	}
+	cl := &call{done: make(chan struct{})}
+	c.inflight[key] = cl
+	return cl, true
+}
+
+func (c *Cache) releaseCall(key string, cl *call) {
+	c.inflightMu.Lock()
+	delete(c.inflight, key)
+	c.inflightMu.Unlock()
+	close(cl.done)
+}
+
+// lookup returns the entry matching the request or nil.
+func (c *Cache) lookup(key string, h *fasthttp.RequestHeader) *Entry {
+	e := c.store.Get(key)
+	if e == nil || e.Response != nil {
+		return e
+	}
+	return c.store.Get(variantKey(key, e.Vary, h))
+}
+
+// set stores the entry for the request.
+func (c *Cache) set(key string, e *Entry, h *fasthttp.RequestHeader) {
+	prev := c.store.Get(key)
+	if prev != nil && prev.Response == nil && !slices.Equal(prev.Vary, e.Vary) {
+		// The response doesn't vary the same way anymore,
+		// so the previous variants are useless.
+		c.store.DeleteTag(varyTag(key))
+	}
+
+	if len(e.Vary) == 0 {
+		c.store.Set(key, e)
+		return
+	}
+	if prev == nil || prev.Response != nil || !slices.Equal(prev.Vary, e.Vary) {
+		c.store.Set(key, &Entry{Vary: e.Vary})
+	}
+	c.store.Set(variantKey(key, e.Vary, h), e)
+}
+
+// usable returns true if the entry may be served without contacting
+// the wrapped handler.
+func (c *Cache) usable(e *Entry, reqCC *cacheControl, now time.Time) bool {
+	if e.NoCache || reqCC.noCache {
+		return false
+	}
+	age := e.Age(now)
+	if reqCC.maxAge >= 0 && age > reqCC.maxAge {
+		return false
+	}
+	lifetime := e.Lifetime
+	if reqCC.minFresh >= 0 {
+		lifetime -= reqCC.minFresh
+	}
+	if age < lifetime {
+		return true
+	}
+	if e.MustRevalidate || reqCC.maxStale < 0 {
+		return false
+	}
+	return age-e.Lifetime <= reqCC.maxStale
+}
+
+func (e *Entry) canServeStaleWhileRevalidate(reqCC *cacheControl, now time.Time) bool {
+	if e.NoCache || e.MustRevalidate || reqCC.noCache || e.StaleWhileRevalidate < 0 {
+		return false
+	}
+	return e.Age(now)-e.Lifetime <= e.StaleWhileRevalidate
+}
+
+func (e *Entry) canServeStaleIfError(reqCC *cacheControl, now time.Time) bool {
+	if e.MustRevalidate {
+		return false
+	}
+	limit := e.StaleIfError
+	if reqCC.staleIfError > limit {
+		limit = reqCC.staleIfError
+	}
+	if limit < 0 {
+		return false
+	}
+	return e.Age(now)-e.Lifetime <= limit
+}
+
+// newEntry returns the entry for the given response or nil
+// if the response cannot be stored.
+func (c *Cache) newEntry(key string, req *fasthttp.Request, resp *fasthttp.Response, reqTime, respTime time.Time) *Entry {
+	if resp.IsBodyStream() {
+		return nil
+	}
+	statusCode := resp.StatusCode()
+	if statusCode < 200 || statusCode == fasthttp.StatusPartialContent || statusCode == fasthttp.StatusNotModified {
+		return nil
+	}
+
+	cc := parseCacheControl(resp.Header.Peek(fasthttp.HeaderCacheControl))
+	if cc.noStore || cc.private {
+		return nil
+	}
+	if len(req.Header.Peek(fasthttp.HeaderAuthorization)) > 0 && !cc.public && !cc.mustRevalidate && cc.sMaxAge < 0 {
+		return nil
+	}
+	if !c.cacheSetCookie && hasCookies(&resp.Header) {
+		return nil
+	}
+
+	vary, ok := parseVary(resp.Header.PeekAll(fasthttp.HeaderVary))
+	if !ok {
+		return nil
+	}
+
+	body := resp.Body()
+	if len(body) > c.maxEntrySize {
+		return nil
+	}
+
+	date := respTime
+	if v := resp.Header.Peek(fasthttp.HeaderDate); len(v) > 0 {
+		if t, err := fasthttp.ParseHTTPDate(v); err == nil {
+			date = t
+		}
+	}
+
+	var lastModified time.Time
+	if v := resp.Header.Peek(fasthttp.HeaderLastModified); len(v) > 0 {
+		if t, err := fasthttp.ParseHTTPDate(v); err == nil {
+			lastModified = t
+		}
+	}
+	etag := append([]byte(nil), resp.Header.Peek(fasthttp.HeaderETag)...)
+
+	lifetime, explicit := c.freshnessLifetime(&cc, &resp.Header, date, lastModified, statusCode)
+	if !explicit && !cc.public && !isHeuristicallyCacheable(statusCode) {
+		return nil
+	}
+	if lifetime <= 0 && len(etag) == 0 && lastModified.IsZero() && cc.staleWhileRevalidate <= 0 {
+		// The response is useless without validators.
+		return nil
+	}
+
+	// Compute the corrected initial age as described
+	// in RFC 9111 section 4.2.3.
+	ageValue := time.Duration(0)
+	if v := resp.Header.Peek(fasthttp.HeaderAge); len(v) > 0 {
+		ageValue = parseDeltaSeconds(v)
+	}
+	apparentAge := respTime.Sub(date)
+	if apparentAge < 0 {
+		apparentAge = 0
+	}
+	correctedAgeValue := ageValue + respTime.Sub(reqTime)
+	initialAge := apparentAge
+	if correctedAgeValue > initialAge {
+		initialAge = correctedAgeValue
+	}
+
+	stored := &fasthttp.Response{}
+	resp.CopyTo(stored)
+	stored.Header.ResetConnectionClose()
+	stored.Header.Del(fasthttp.HeaderAge)
+
+	tags := parseTags(resp.Header.Peek(c.tagHeader))
+	if len(vary) > 0 {
+		tags = append(tags, varyTag(key))
+	}
+
+	return &Entry{
+		Response:             stored,
+		Vary:                 vary,
+		Tags:                 tags,
+		ETag:                 etag,
+		LastModified:         lastModified,
+		RequestTime:          reqTime,
+		ResponseTime:         respTime,
+		InitialAge:           initialAge,
+		Lifetime:             lifetime,
+		StaleWhileRevalidate: cc.staleWhileRevalidate,
+		StaleIfError:         cc.staleIfError,
+		MustRevalidate:       cc.mustRevalidate || cc.proxyRevalidate || cc.sMaxAge >= 0,
+		NoCache:              cc.noCache,
+		Size:                 len(body) + len(stored.Header.Header()),
+	}
+}
+
+// refresh returns the stale entry updated with the headers of
+// the 304 Not Modified response as described in RFC 9111 section 4.3.4.
+func (c *Cache) refresh(key string, stale *Entry, req *fasthttp.Request, notModified *fasthttp.Response, reqTime, respTime time.Time) *Entry {
+	var resp fasthttp.Response
+	stale.Response.CopyTo(&resp)
+	if len(notModified.Header.Peek(fasthttp.HeaderDate)) == 0 {
+		resp.Header.Del(fasthttp.HeaderDate)
+	}
+	for k, v := range notModified.Header.All() {
+		switch string(k) {
+		case fasthttp.HeaderContentLength, fasthttp.HeaderContentType, fasthttp.HeaderContentEncoding,
+			fasthttp.HeaderTransferEncoding, fasthttp.HeaderConnection:
+			continue
+		}
+		resp.Header.SetBytesKV(k, v)
+	}
+	return c.newEntry(key, req, &resp, reqTime, respTime)
+}
+
+func (c *Cache) freshnessLifetime(cc *cacheControl, h *fasthttp.ResponseHeader, date, lastModified time.Time, statusCode int) (time.Duration, bool) {
+	if cc.sMaxAge >= 0 {
+		return cc.sMaxAge, true
+	}
+	if cc.maxAge >= 0 {
+		return cc.maxAge, true
+	}
+	if v := h.Peek(fasthttp.HeaderExpires); len(v) > 0 {
+		expires, err := fasthttp.ParseHTTPDate(v)
+		if err != nil {
+			// Invalid Expires means the response is already expired.
+			return 0, true
+		}
+		if lifetime := expires.Sub(date); lifetime > 0 {
+			return lifetime, true
+		}
+		return 0, true
+	}
+
+	// Heuristic freshness. See RFC 9111 section 4.2.2.
+	if (cc.public || isHeuristicallyCacheable(statusCode)) && !lastModified.IsZero() && lastModified.Before(date) {
+		lifetime := date.Sub(lastModified) / 10
+		if lifetime > c.heuristicMaxAge {
+			lifetime = c.heuristicMaxAge
+		}
+		return lifetime, false
+	}
+	return 0, false
+}
+
+// writeEntry writes the entry to the response.
+func (c *Cache) writeEntry(ctx *fasthttp.RequestCtx, e *Entry, now time.Time, status string) {
+	resp := &ctx.Response
+	e.Response.CopyTo(resp)
+
+	age := e.Age(now)
+	resp.Header.Set(fasthttp.HeaderAge, strconv.FormatInt(int64(age/time.Second), 10))
+
+	if notModified(&ctx.Request.Header, e) {
+		resp.SetStatusCode(fasthttp.StatusNotModified)
+		resp.ResetBody()
+	}
+	if ctx.IsHead() {
+		resp.SkipBody = true
+	}
+
+	if strings.HasPrefix(status, "hit") {
+		status += "; ttl=" + strconv.FormatInt(int64((e.Lifetime-age)/time.Second), 10)
+	}
+	c.setStatus(resp, status)
+}
+
+func (c *Cache) setStatus(resp *fasthttp.Response, status string) {
+	resp.Header.Set(HeaderCacheStatus, c.name+"; "+status)
+}
+
+// notModified returns true if the client already has the entry.
+func notModified(h *fasthttp.RequestHeader, e *Entry) bool {
+	if inm := h.Peek(fasthttp.HeaderIfNoneMatch); len(inm) > 0 {
+		return len(e.ETag) > 0 && etagMatch(inm, e.ETag)
+	}
+	if ims := h.Peek(fasthttp.HeaderIfModifiedSince); len(ims) > 0 && !e.LastModified.IsZero() {
+		t, err := fasthttp.ParseHTTPDate(ims)
+		return err == nil && !e.LastModified.After(t)
+	}
+	return false
+}
+
+// etagMatch performs the weak comparison of If-None-Match header
+// with the ETag as described in RFC 9110 section 13.1.2.
+func etagMatch(ifNoneMatch, etag []byte) bool {
+	etag = bytes.TrimPrefix(etag, []byte("W/"))
+	for _, v := range bytes.Split(ifNoneMatch, []byte{','}) {
+		v = bytes.TrimSpace(v)
+		if len(v) == 1 && v[0] == '*' {
+			return true
+		}
+		if bytes.Equal(bytes.TrimPrefix(v, []byte("W/")), etag) {
+			return true
+		}
+	}
+	return false
+}
+
+func requestCacheControl(h *fasthttp.RequestHeader) cacheControl {
+	v := h.Peek(fasthttp.HeaderCacheControl)
+	cc := parseCacheControl(v)
+	if len(v) == 0 && bytes.Contains(h.Peek(fasthttp.HeaderPragma), []byte("no-cache")) {
+		// See RFC 9111 section 5.4.
+		cc.noCache = true
+	}
+	return cc
+}
+
+func hasCookies(h *fasthttp.ResponseHeader) bool {
+	for range h.Cookies() {
+		return true
+	}
+	return false
+}
+
+// parseVary returns the sorted lowercase request header names from Vary
+// response headers. It returns false for 'Vary: *'.
+func parseVary(values [][]byte) ([]string, bool) {
+	var vary []string
+	for _, v := range values {
+		for _, name := range bytes.Split(v, []byte{','}) {
+			name = bytes.TrimSpace(name)
+			if len(name) == 0 {
+				continue
+			}
+			if len(name) == 1 && name[0] == '*' {
+				return nil, false
+			}
+			vary = append(vary, strings.ToLower(string(name)))
+		}
+	}
+	slices.Sort(vary)
+	return slices.Compact(vary), true
+}
+
+func parseTags(v []byte) []string {
+	var tags []string
+	for _, tag := range bytes.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' }) {
+		tags = append(tags, string(tag))
+	}
+	return tags
+}
+
+// variantKey returns the key of the response variant matching
+// the request header values for the given Vary header names.
+func variantKey(key string, vary []string, h *fasthttp.RequestHeader) string {
+	b := make([]byte, 0, len(key)+64)
+	b = append(b, key...)
+	for _, name := range vary {
+		b = append(b, 0)
+		b = append(b, name...)
+		b = append(b, '=')
+		for i, v := range h.PeekAll(name) {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = append(b, bytes.TrimSpace(v)...)
+		}
+	}
+	return string(b)
+}
+
+// varyTag is the internal tag of all the variants of the key.
+func varyTag(key string) string {
+	return "\x00vary\x00" + key
+}
+
+func isUnsafeMethod(method []byte) bool {
+	switch string(method) {
+	case fasthttp.MethodGet, fasthttp.MethodHead, fasthttp.MethodOptions, fasthttp.MethodTrace:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,444 @@
+package httpcache
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bhargawpradhan/fasthttp"
+)
+
+type testClock struct {
+	now time.Time
+	mu  sync.Mutex
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func newTestCache(cfg Config) (*Cache, *testClock) {
+	clock := &testClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c := New(cfg)
+	c.now = clock.Now
+	return c, clock
+}
+
+func do(h fasthttp.RequestHandler, method, uri string, headers ...string) *fasthttp.RequestCtx {
+	var req fasthttp.Request
+	req.Header.SetMethod(method)
+	req.SetRequestURI(uri)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Init(&req, nil, nil)
+	h(ctx)
+	return ctx
+}
+
+func cacheStatus(ctx *fasthttp.RequestCtx) string {
+	return string(ctx.Response.Header.Peek(HeaderCacheStatus))
+}
+
+func expectStatus(t *testing.T, ctx *fasthttp.RequestCtx, prefix string) {
+	t.Helper()
+	if s := cacheStatus(ctx); !strings.HasPrefix(s, "fasthttp; "+prefix) {
+		t.Fatalf("unexpected Cache-Status %q. Expecting prefix %q", s, "fasthttp; "+prefix)
+	}
+}
+
+func expectBody(t *testing.T, ctx *fasthttp.RequestCtx, body string) {
+	t.Helper()
+	if b := string(ctx.Response.Body()); b != body {
+		t.Fatalf("unexpected body %q. Expecting %q", b, body)
+	}
+}
+
+func TestCacheMaxAge(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	c, clock := newTestCache(Config{})
+	h := c.Handler(func(ctx *fasthttp.RequestCtx) {
+		n := calls.Add(1)
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "max-age=60")
+		ctx.SetBodyString("body" + strconv.Itoa(int(n)))
+	})
+
+	ctx := do(h, fasthttp.MethodGet, "http://example.com/foo")
+	expectStatus(t, ctx, "fwd=miss; stored")
+	expectBody(t, ctx, "body1")
+
+	clock.Add(30 * time.Second)
+	ctx = do(h, fasthttp.MethodGet, "http://example.com/foo")
+	expectStatus(t, ctx, "hit")
+	expectBody(t, ctx, "body1")
+	if age := string(ctx.Response.Header.Peek(fasthttp.HeaderAge)); age != "30" {
+		t.Fatalf("unexpected Age %q. Expecting %q", age, "30")
+	}
+
+	// The request directives restrict the acceptable age.
+	ctx = do(h, fasthttp.MethodGet, "http://example.com/foo", fasthttp.HeaderCacheControl, "max-age=10")
+	expectStatus(t, ctx, "fwd=stale")
+	expectBody(t, ctx, "body2")
+
+	clock.Add(61 * time.Second)
+	ctx = do(h, fasthttp.MethodGet, "http://example.com/foo")
+	expectStatus(t, ctx, "fwd=stale; stored")
+	expectBody(t, ctx, "body3")
+
+	ctx = do(h, fasthttp.MethodGet, "http://example.com/bar")
+	expectStatus(t, ctx, "fwd=miss")
+	expectBody(t, ctx, "body4")
+
+	if n := calls.Load(); n != 4 {
+		t.Fatalf("unexpected number of handler calls %d. Expecting 4", n)
+	}
+}
+
+func TestCacheNotStorable(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		header []string
+		status int
+	}{
+		{name: "no-store", header: []string{fasthttp.HeaderCacheControl, "no-store, max-age=60"}},
+		{name: "private", header: []string{fasthttp.HeaderCacheControl, "private, max-age=60"}},
+		{name: "vary-star", header: []string{fasthttp.HeaderCacheControl, "max-age=60", fasthttp.HeaderVary, "*"}},
+		{name: "set-cookie", header: []string{fasthttp.HeaderCacheControl, "max-age=60", fasthttp.HeaderSetCookie, "a=b"}},
+		{name: "no-freshness", header: []string{}},
+		{name: "partial", header: []string{fasthttp.HeaderCacheControl, "max-age=60"}, status: fasthttp.StatusPartialContent},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c, _ := newTestCache(Config{})
+			h := c.Handler(func(ctx *fasthttp.RequestCtx) {
+				for i := 0; i < len(tc.header); i += 2 {
+					ctx.Response.Header.Set(tc.header[i], tc.header[i+1])
+				}
+				if tc.status != 0 {
+					ctx.SetStatusCode(tc.status)
+				}
+			})
+			do(h, fasthttp.MethodGet, "http://example.com/")
+			ctx := do(h, fasthttp.MethodGet, "http://example.com/")
+			expectStatus(t, ctx, "fwd=miss")
+			if strings.Contains(cacheStatus(ctx), "stored") {
+				t.Fatalf("unexpected Cache-Status %q", cacheStatus(ctx))
+			}
+		})
+	}
+}
+
+func TestCacheAuthorization(t *testing.T) {
+	t.Parallel()
+
+	c, _ := newTestCache(Config{})
+	cacheControl := "max-age=60"
+	h := c.Handler(func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, cacheControl)
+	})
+
+	ctx := do(h, fasthttp.MethodGet, "http://example.com/", fasthttp.HeaderAuthorization, "Bearer foo")
+	expectStatus(t, ctx, "fwd=miss")
+	if strings.Contains(cacheStatus(ctx), "stored") {
+		t.Fatalf("unexpected Cache-Status %q", cacheStatus(ctx))
+	}
+
+	cacheControl = "public, max-age=60"
+	do(h, fasthttp.MethodGet, "http://example.com/", fasthttp.HeaderAuthorization, "Bearer foo")
+	ctx = do(h, fasthttp.MethodGet, "http://example.com/", fasthttp.HeaderAuthorization, "Bearer foo")
+	expectStatus(t, ctx, "hit")
+}
+
+func TestCacheVary(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	c, _ := newTestCache(Config{})
+	h := c.Handler(func(ctx *fasthttp.RequestCtx) {
+		calls.Add(1)
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "max-age=60")
+		ctx.Response.Header.Set(fasthttp.HeaderVary, "Accept-Language")
+		ctx.SetBody(ctx.Request.Header.Peek(fasthttp.HeaderAcceptLanguage))
+	})
+
+	for _, lang := range []string{"en", "de", "en", "de"} {
+		ctx := do(h, fasthttp.MethodGet, "http://example.com/", fasthttp.HeaderAcceptLanguage, lang)
+		expectBody(t, ctx, lang)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("unexpected number of handler calls %d. Expecting 2", n)
+	}
+
+	if n := c.Purge("example.com/"); n != 3 {
+		t.Fatalf("unexpected number of purged entries %d. Expecting 3", n)
+	}
+	ctx := do(h, fasthttp.MethodGet, "http://example.com/", fasthttp.HeaderAcceptLanguage, "en")
+	expectStatus(t, ctx, "fwd=miss")
+}
+
+func TestCacheRevalidate(t *testing.T) {
+	t.Parallel()
+
+	var calls, notModified atomic.Int32
+	c, clock := newTestCache(Config{})
+	h := c.Handler(func(ctx *fasthttp.RequestCtx) {
+		calls.Add(1)
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "max-age=10")
+		ctx.Response.Header.Set(fasthttp.HeaderETag, `"v1"`)
+		if string(ctx.Request.Header.Peek(fasthttp.HeaderIfNoneMatch)) == `"v1"` {
+			notModified.Add(1)
+			ctx.SetStatusCode(fasthttp.StatusNotModified)
+			return
+		}
+		ctx.SetBodyString("body")
+	})
+
+	do(h, fasthttp.MethodGet, "http://example.com/")
+	clock.Add(20 * time.Second)
+
+	ctx := do(h, fasthttp.MethodGet, "http://example.com/")
+	expectStatus(t, ctx, "fwd=stale; fwd-status=304")
+	expectBody(t, ctx, "body")
+	if ctx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("unexpected status code %d", ctx.Response.StatusCode())
+	}
+	if v := ctx.Request.Header.Peek(fasthttp.HeaderIfNoneMatch); len(v) != 0 {
+		t.Fatalf("unexpected If-None-Match left in the request: %q", v)
+	}
+
+	// The refreshed entry is fresh again.
+	ctx = do(h, fasthttp.MethodGet, "http://example.com/")
+	expectStatus(t, ctx, "hit")
+	expectBody(t, ctx, "body")
+
+	// Conditional requests from clients are answered by the cache.
+	ctx = do(h, fasthttp.MethodGet, "http://example.com/", fasthttp.HeaderIfNoneMatch, `W/"v1"`)
+	if ctx.Response.StatusCode() != fasthttp.StatusNotModified {
+		t.Fatalf("unexpected status code %d. Expecting %d", ctx.Response.StatusCode(), fasthttp.StatusNotModified)
+	}
+
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("unexpected number of handler calls %d. Expecting 2", n)
+	}
+	if n := notModified.Load(); n != 1 {
+		t.Fatalf("unexpected number of 304 responses %d. Expecting 1", n)
+	}
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	revalidated := make(chan struct{}, 1)
+	c, clock := newTestCache(Config{})
+	h := c.Handler(func(ctx *fasthttp.RequestCtx) {
+		n := calls.Add(1)
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "max-age=10, stale-while-revalidate=30")
+		ctx.SetBodyString("body" + strconv.Itoa(int(n)))
+		if n > 1 {
+			revalidated <- struct{}{}
+		}
+	})
+
+	do(h, fasthttp.MethodGet, "http://example.com/")
+	clock.Add(20 * time.Second)
+
+	ctx := do(h, fasthttp.MethodGet, "http://example.com/")
+	expectStatus(t, ctx, "hit; detail=stale-while-revalidate")
+	expectBody(t, ctx, "body1")
+
+	select {
+	case <-revalidated:
+	case <-time.After(time.Second):
+		t.Fatal("timeout when waiting for the background revalidation")
+	}
+	// Wait for the background revalidation to store the response.
+	for i := 0; i < 100; i++ {
+		if ctx = do(h, fasthttp.MethodGet, "http://example.com/"); string(ctx.Response.Body()) == "body2" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	expectStatus(t, ctx, "hit")
+	expectBody(t, ctx, "body2")
+
+	clock.Add(time.Minute)
+	ctx = do(h, fasthttp.MethodGet, "http://example.com/")
+	expectStatus(t, ctx, "fwd=stale")
+}
+
+func TestCacheStaleIfError(t *testing.T) {
+	t.Parallel()
+
+	var fail atomic.Bool
+	c, clock := newTestCache(Config{})
+	h := c.Handler(func(ctx *fasthttp.RequestCtx) {
+		if fail.Load() {
+			ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+			return
+		}
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "max-age=10, stale-if-error=60")
+		ctx.SetBodyString("body")
+	})
+
+	do(h, fasthttp.MethodGet, "http://example.com/")
+	fail.Store(true)
+	clock.Add(20 * time.Second)
+
+	ctx := do(h, fasthttp.MethodGet, "http://example.com/")
+	expectStatus(t, ctx, "fwd=stale; fwd-status=503; detail=stale-if-error")
+	expectBody(t, ctx, "body")
+
+	clock.Add(time.Minute)
+	ctx = do(h, fasthttp.MethodGet, "http://example.com/")
+	if ctx.Response.StatusCode() != fasthttp.StatusServiceUnavailable {
+		t.Fatalf("unexpected status code %d. Expecting %d", ctx.Response.StatusCode(), fasthttp.StatusServiceUnavailable)
+	}
+}
+
+func TestCacheCollapsing(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	c, _ := newTestCache(Config{})
+	h := c.Handler(func(ctx *fasthttp.RequestCtx) {
+		calls.Add(1)
+		<-release
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "max-age=60")
+		ctx.SetBodyString("body")
+	})
+
+	const concurrency = 10
+	var wg sync.WaitGroup
+	results := make(chan string, concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := do(h, fasthttp.MethodGet, "http://example.com/")
+			results <- string(ctx.Response.Body())
+		}()
+	}
+
+	// Wait until the leader reaches the handler.
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	for body := range results {
+		if body != "body" {
+			t.Fatalf("unexpected body %q", body)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("unexpected number of handler calls %d. Expecting 1", n)
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	c, _ := newTestCache(Config{})
+	h := c.Handler(func(ctx *fasthttp.RequestCtx) {
+		calls.Add(1)
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "max-age=60")
+		ctx.Response.Header.Set(DefaultTagHeader, "users user-1")
+	})
+
+	do(h, fasthttp.MethodGet, "http://example.com/users/1")
+	do(h, fasthttp.MethodPost, "http://example.com/users/1")
+	ctx := do(h, fasthttp.MethodGet, "http://example.com/users/1")
+	expectStatus(t, ctx, "fwd=miss; stored")
+
+	do(h, fasthttp.MethodGet, "http://example.com/users/2")
+	if n := c.PurgeTag("users"); n != 2 {
+		t.Fatalf("unexpected number of purged entries %d. Expecting 2", n)
+	}
+
+	do(h, fasthttp.MethodGet, "http://example.com/users/1")
+	purge := do(c.PurgeHandler, fasthttp.MethodPost, "http://admin/purge?key=example.com/users/1")
+	expectBody(t, purge, "1")
+	ctx = do(h, fasthttp.MethodGet, "http://example.com/users/1")
+	expectStatus(t, ctx, "fwd=miss")
+
+	purge = do(c.PurgeHandler, fasthttp.MethodPost, "http://admin/purge")
+	if purge.Response.StatusCode() != fasthttp.StatusBadRequest {
+		t.Fatalf("unexpected status code %d. Expecting %d", purge.Response.StatusCode(), fasthttp.StatusBadRequest)
+	}
+}
+
+func TestCacheOnlyIfCached(t *testing.T) {
+	t.Parallel()
+
+	c, _ := newTestCache(Config{})
+	h := c.Handler(func(ctx *fasthttp.RequestCtx) {
+		t.Error("unexpected handler call")
+	})
+
+	ctx := do(h, fasthttp.MethodGet, "http://example.com/", fasthttp.HeaderCacheControl, "only-if-cached")
+	if ctx.Response.StatusCode() != fasthttp.StatusGatewayTimeout {
+		t.Fatalf("unexpected status code %d. Expecting %d", ctx.Response.StatusCode(), fasthttp.StatusGatewayTimeout)
+	}
+}
+
+func TestCacheExpiresAndHeuristic(t *testing.T) {
+	t.Parallel()
+
+	c, clock := newTestCache(Config{})
+	now := clock.Now()
+	h := c.Handler(func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.Header.Set(fasthttp.HeaderDate, string(fasthttp.AppendHTTPDate(nil, now)))
+		if string(ctx.Path()) == "/expires" {
+			ctx.Response.Header.Set(fasthttp.HeaderExpires, string(fasthttp.AppendHTTPDate(nil, now.Add(time.Minute))))
+		} else {
+			ctx.Response.Header.Set(fasthttp.HeaderLastModified, string(fasthttp.AppendHTTPDate(nil, now.Add(-100*time.Minute))))
+		}
+	})
+
+	do(h, fasthttp.MethodGet, "http://example.com/expires")
+	do(h, fasthttp.MethodGet, "http://example.com/heuristic")
+	clock.Add(50 * time.Second)
+
+	expectStatus(t, do(h, fasthttp.MethodGet, "http://example.com/expires"), "hit; ttl=10")
+	expectStatus(t, do(h, fasthttp.MethodGet, "http://example.com/heuristic"), "hit; ttl=550")
+}
+
+func TestParseCacheControl(t *testing.T) {
+	t.Parallel()
+
+	cc := parseCacheControl([]byte(`public, max-age="60", s-maxage=120, no-cache="Set-Cookie", max-stale, stale-if-error=5, foo=bar`))
+	if !cc.public || !cc.noCache || cc.maxAge != time.Minute || cc.sMaxAge != 2*time.Minute ||
+		cc.maxStale != maxDuration || cc.staleIfError != 5*time.Second || cc.staleWhileRevalidate != -1 {
+		t.Fatalf("unexpected cache control %+v", cc)
+	}
+
+	cc = parseCacheControl([]byte(`max-age=foo`))
+	if cc.maxAge != 0 {
+		t.Fatalf("unexpected max-age %v. Expecting 0", cc.maxAge)
+	}
+}
@@ -0,0 +1,132 @@
+package httpcache
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+)
+
+// cacheControl contains the parsed Cache-Control directives.
+//
+// Durations are negative for absent directives.
+type cacheControl struct {
+	maxAge               time.Duration
+	sMaxAge              time.Duration
+	maxStale             time.Duration
+	minFresh             time.Duration
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+
+	noStore         bool
+	noCache         bool
+	private         bool
+	public          bool
+	mustRevalidate  bool
+	proxyRevalidate bool
+	onlyIfCached    bool
+}
+
+// parseCacheControl parses Cache-Control header value as described
+// in RFC 9111 section 5.2.
+//
+// Unknown directives are ignored. Directives with invalid arguments are
+// treated in the most conservative way, i.e. invalid max-age means
+// the response is stale.
+func parseCacheControl(v []byte) cacheControl {
+	cc := cacheControl{
+		maxAge:               -1,
+		sMaxAge:              -1,
+		maxStale:             -1,
+		minFresh:             -1,
+		staleWhileRevalidate: -1,
+		staleIfError:         -1,
+	}
+
+	for len(v) > 0 {
+		var d []byte
+		if n := bytes.IndexByte(v, ','); n >= 0 {
+			d, v = v[:n], v[n+1:]
+		} else {
+			d, v = v, nil
+		}
+		d = bytes.TrimSpace(d)
+		if len(d) == 0 {
+			continue
+		}
+
+		name, arg := d, []byte(nil)
+		hasArg := false
+		if n := bytes.IndexByte(d, '='); n >= 0 {
+			name, arg = bytes.TrimSpace(d[:n]), bytes.TrimSpace(d[n+1:])
+			arg = bytes.Trim(arg, `"`)
+			hasArg = true
+		}
+
+		switch string(bytes.ToLower(name)) {
+		case "max-age":
+			cc.maxAge = parseDeltaSeconds(arg)
+		case "s-maxage":
+			cc.sMaxAge = parseDeltaSeconds(arg)
+		case "max-stale":
+			if hasArg {
+				cc.maxStale = parseDeltaSeconds(arg)
+			} else {
+				// max-stale without argument accepts a response
+				// of any staleness.
+				cc.maxStale = maxDuration
+			}
+		case "min-fresh":
+			cc.minFresh = parseDeltaSeconds(arg)
+		case "stale-while-revalidate":
+			cc.staleWhileRevalidate = parseDeltaSeconds(arg)
+		case "stale-if-error":
+			cc.staleIfError = parseDeltaSeconds(arg)
+		case "no-store":
+			cc.noStore = true
+		case "no-cache":
+			// The qualified form (no-cache="field") is treated
+			// as the unqualified one.
+			cc.noCache = true
+		case "private":
+			cc.private = true
+		case "public":
+			cc.public = true
+		case "must-revalidate":
+			cc.mustRevalidate = true
+		case "proxy-revalidate":
+			cc.proxyRevalidate = true
+		case "only-if-cached":
+			cc.onlyIfCached = true
+		}
+	}
+	return cc
+}
+
+const maxDuration = time.Duration(1<<63 - 1)
+
+// maxDeltaSeconds is the greatest delta-seconds value as recommended
+// by RFC 9111 section 1.2.2.
+const maxDeltaSeconds = 1<<31 - 1
+
+// parseDeltaSeconds returns zero for invalid values, so the response is
+// treated as stale.
+func parseDeltaSeconds(v []byte) time.Duration {
+	n, err := strconv.ParseUint(string(v), 10, 64)
+	if err != nil {
+		return 0
+	}
+	if n > maxDeltaSeconds {
+		n = maxDeltaSeconds
+	}
+	return time.Duration(n) * time.Second
+}
+
+// isHeuristicallyCacheable returns true for status codes, which
+// are cacheable by default as defined in RFC 9110 section 15.1.
+func isHeuristicallyCacheable(statusCode int) bool {
+	switch statusCode {
+	case 200, 203, 204, 300, 301, 308, 404, 405, 410, 414, 501:
+		return true
+	}
+	return false
+}
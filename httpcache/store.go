@@ -0,0 +1,234 @@
+package httpcache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/bhargawpradhan/fasthttp"
+)
+
+// Entry is a cached response together with the metadata required
+// for computing its freshness.
+//
+// Entries are shared between concurrently running handlers, so they
+// must not be modified after being passed to Store.Set.
+type Entry struct {
+	// Response is the stored response. It is nil for entries, which only
+	// record the Vary header names of the stored response variants.
+	Response *fasthttp.Response
+
+	// Vary contains the normalized names of the request headers
+	// the response varies on.
+	Vary []string
+
+	// Tags are used for purging the entry with Cache.PurgeTag.
+	Tags []string
+
+	// ETag and LastModified are the validators of the response.
+	ETag         []byte
+	LastModified time.Time
+
+	// RequestTime and ResponseTime are the times the request has been
+	// sent to the origin handler and the response has been received.
+	RequestTime  time.Time
+	ResponseTime time.Time
+
+	// InitialAge is the corrected initial age of the response
+	// as defined in RFC 9111 section 4.2.3.
+	InitialAge time.Duration
+
+	// Lifetime is the freshness lifetime of the response.
+	Lifetime time.Duration
+
+	// StaleWhileRevalidate and StaleIfError are the durations the stale
+	// response may be served for. Negative values mean the response
+	// may not be served stale.
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+
+	// MustRevalidate is true if the response must not be served stale.
+	MustRevalidate bool
+
+	// NoCache is true if the response must be revalidated before
+	// being served.
+	NoCache bool
+
+	// Size is the approximate memory size of the entry in bytes.
+	Size int
+}
+
+// Age returns the current age of the entry.
+func (e *Entry) Age(now time.Time) time.Duration {
+	age := e.InitialAge + now.Sub(e.ResponseTime)
+	if age < 0 {
+		return 0
+	}
+	return age
+}
+
+// Store stores cache entries.
+//
+// Store implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the entry for the given key or nil if the key is missing.
+	Get(key string) *Entry
+
+	// Set stores the entry under the given key replacing the previous one.
+	Set(key string, e *Entry)
+
+	// Delete removes the entry for the given key and returns true
+	// if the entry existed.
+	Delete(key string) bool
+
+	// DeleteTag removes all the entries containing the given tag
+	// and returns the number of removed entries.
+	DeleteTag(tag string) int
+}
+
+// DefaultMaxBytes is the default memory limit for MemoryStore.
+const DefaultMaxBytes = 64 * 1024 * 1024
+
+// MemoryStore is an in-memory Store evicting the least recently used
+// entries when the total size of the entries exceeds the limit.
+type MemoryStore struct {
+	maxBytes int
+	bytes    int
+
+	items map[string]*list.Element
+	tags  map[string]map[string]struct{}
+	ll    list.List
+
+	mu sync.Mutex
+}
+
+type memoryItem struct {
+	e   *Entry
+	key string
+}
+
+// NewMemoryStore returns MemoryStore limited to the given number of bytes.
+//
+// DefaultMaxBytes is used if maxBytes isn't positive.
+func NewMemoryStore(maxBytes int) *MemoryStore {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	return &MemoryStore{
+		maxBytes: maxBytes,
+		items:    make(map[string]*list.Element),
+		tags:     make(map[string]map[string]struct{}),
+	}
+}
+
+// Get implements Store.
+func (s *MemoryStore) Get(key string) *Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[key]
+	if !ok {
+		return nil
+	}
+	s.ll.MoveToFront(el)
+	return el.Value.(*memoryItem).e
+}
+
+// Set implements Store.
+//
+// Entries larger than the store limit are ignored.
+func (s *MemoryStore) Set(key string, e *Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.items[key]; ok {
+		s.removeElement(el)
+	}
+	size := entrySize(key, e)
+	if size > s.maxBytes {
+		return
+	}
+
+	s.items[key] = s.ll.PushFront(&memoryItem{key: key, e: e})
+	s.bytes += size
+	for _, tag := range e.Tags {
+		keys := s.tags[tag]
+		if keys == nil {
+			keys = make(map[string]struct{})
+			s.tags[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+
+	for s.bytes > s.maxBytes {
+		s.removeElement(s.ll.Back())
+	}
+}
+
+// Delete implements Store.
+func (s *MemoryStore) Delete(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[key]
+	if ok {
+		s.removeElement(el)
+	}
+	return ok
+}
+
+// DeleteTag implements Store.
+func (s *MemoryStore) DeleteTag(tag string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for key := range s.tags[tag] {
+		if el, ok := s.items[key]; ok {
+			s.removeElement(el)
+			n++
+		}
+	}
+	delete(s.tags, tag)
+	return n
+}
+
+// Len returns the number of stored entries.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ll.Len()
+}
+
+// Bytes returns the total size of the stored entries.
+func (s *MemoryStore) Bytes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bytes
+}
+
+func (s *MemoryStore) removeElement(el *list.Element) {
+	item := s.ll.Remove(el).(*memoryItem)
+	delete(s.items, item.key)
+	s.bytes -= entrySize(item.key, item.e)
+	for _, tag := range item.e.Tags {
+		if keys := s.tags[tag]; keys != nil {
+			delete(keys, item.key)
+			if len(keys) == 0 {
+				delete(s.tags, tag)
+			}
+		}
+	}
+}
+
+// entryOverhead approximates the memory used by the Entry
+// and the store bookkeeping.
+const entryOverhead = 256
+
+func entrySize(key string, e *Entry) int {
+	size := entryOverhead + len(key) + e.Size
+	for _, tag := range e.Tags {
+		size += len(tag)
+	}
+	return size
+}
@@ -0,0 +1,64 @@
+package httpcache
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestMemoryStoreEviction(t *testing.T) {
+	t.Parallel()
+
+	s := NewMemoryStore(10 * (entryOverhead + 100))
+	for i := 0; i < 10; i++ {
+		s.Set(strconv.Itoa(i), &Entry{Size: 100 - len(strconv.Itoa(i))})
+	}
+	if s.Len() != 10 {
+		t.Fatalf("unexpected number of entries %d. Expecting 10", s.Len())
+	}
+
+	// Touch the oldest entry, so the next one is evicted instead.
+	if s.Get("0") == nil {
+		t.Fatal("missing entry 0")
+	}
+	s.Set("new", &Entry{Size: 50})
+	if s.Get("1") != nil {
+		t.Fatal("entry 1 must be evicted")
+	}
+	if s.Get("0") == nil {
+		t.Fatal("entry 0 must not be evicted")
+	}
+	if s.Bytes() > 10*(entryOverhead+100) {
+		t.Fatalf("store size %d exceeds the limit", s.Bytes())
+	}
+
+	// Entries exceeding the limit aren't stored.
+	s.Set("huge", &Entry{Size: 100 * entryOverhead})
+	if s.Get("huge") != nil {
+		t.Fatal("huge entry must not be stored")
+	}
+}
+
+func TestMemoryStoreTags(t *testing.T) {
+	t.Parallel()
+
+	s := NewMemoryStore(0)
+	s.Set("a", &Entry{Tags: []string{"x", "y"}})
+	s.Set("b", &Entry{Tags: []string{"x"}})
+	s.Set("c", &Entry{})
+
+	if n := s.DeleteTag("x"); n != 2 {
+		t.Fatalf("unexpected number of deleted entries %d. Expecting 2", n)
+	}
+	if n := s.DeleteTag("y"); n != 0 {
+		t.Fatalf("unexpected number of deleted entries %d. Expecting 0", n)
+	}
+	if s.Len() != 1 || s.Get("c") == nil {
+		t.Fatal("entry c must be kept")
+	}
+	if !s.Delete("c") || s.Delete("c") {
+		t.Fatal("unexpected Delete result")
+	}
+	if s.Bytes() != 0 {
+		t.Fatalf("unexpected store size %d. Expecting 0", s.Bytes())
+	}
+}
@@ -0,0 +1,31 @@
+// Package logutil contains the logging helpers shared by the fasthttp
+// and the prefork packages.
+package logutil
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Logger is the Printf logger. It matches fasthttp.Logger.
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+// structuredLogger matches fasthttp.StructuredLogger.
+type structuredLogger interface {
+	LogAttrs(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr)
+}
+
+// Record logs the record with the given attributes if l is
+// fasthttp.StructuredLogger. Otherwise the format and args are passed
+// to l.Printf. Nothing is logged to Printf loggers if format is empty.
+func Record(l Logger, level slog.Level, msg string, attrs []slog.Attr, format string, args ...any) {
+	if sl, ok := l.(structuredLogger); ok {
+		sl.LogAttrs(context.Background(), level, msg, attrs...)
+		return
+	}
+	if format != "" {
+		l.Printf(format, args...)
+	}
+}
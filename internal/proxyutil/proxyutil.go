@@ -0,0 +1,101 @@
+// Package proxyutil contains the helpers shared by the reverseproxy
+// and the fasthttpproxy packages.
+package proxyutil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bhargawpradhan/fasthttp"
+)
+
+// HopHeaders are the hop-by-hop headers, which aren't forwarded.
+var HopHeaders = []string{
+	fasthttp.HeaderConnection,
+	fasthttp.HeaderProxyConnection,
+	fasthttp.HeaderKeepAlive,
+	fasthttp.HeaderProxyAuthenticate,
+	fasthttp.HeaderProxyAuthorization,
+	fasthttp.HeaderTE,
+	fasthttp.HeaderTransferEncoding,
+	fasthttp.HeaderUpgrade,
+}
+
+// Header is implemented by fasthttp.RequestHeader and fasthttp.ResponseHeader.
+type Header interface {
+	Peek(key string) []byte
+	Del(key string)
+}
+
+// RemoveHopHeaders removes the hop-by-hop headers including the headers
+// listed in Connection header.
+func RemoveHopHeaders(h Header) {
+	for _, name := range strings.Split(string(h.Peek(fasthttp.HeaderConnection)), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			h.Del(name)
+		}
+	}
+	for _, name := range HopHeaders {
+		h.Del(name)
+	}
+}
+
+// Tunnel copies data between the client connection c and the upstream
+// connection until either side is closed. Both connections are closed
+// before Tunnel returns.
+//
+// The data sent to c is read from r, which may contain the upstream data
+// buffered along with the response headers. r defaults to upstream if nil.
+//
+// It returns the number of bytes sent to and received from the upstream.
+func Tunnel(c, upstream net.Conn, r io.Reader) (sent, received int64) {
+	if r == nil {
+		r = upstream
+	}
+
+	var once sync.Once
+	closeConns := func() {
+		once.Do(func() {
+			c.Close()
+			upstream.Close()
+		})
+	}
+
+	var n atomic.Int64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		k, _ := io.Copy(upstream, c)
+		n.Store(k)
+		closeConns()
+	}()
+	received, _ = io.Copy(c, r)
+	closeConns()
+	<-done
+	return n.Load(), received
+}
+
+// IsTimeout returns whether err is a timeout error.
+func IsTimeout(err error) bool {
+	if errors.Is(err, fasthttp.ErrDialTimeout) || errors.Is(err, fasthttp.ErrTLSHandshakeTimeout) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var te interface{ Timeout() bool }
+	return errors.As(err, &te) && te.Timeout()
+}
+
+// WriteError responds with 504 Gateway Timeout if err is a timeout error
+// and with 502 Bad Gateway otherwise.
+func WriteError(ctx *fasthttp.RequestCtx, err error) {
+	if IsTimeout(err) {
+		ctx.Error(fasthttp.StatusMessage(fasthttp.StatusGatewayTimeout), fasthttp.StatusGatewayTimeout)
+		return
+	}
+	ctx.Error(fasthttp.StatusMessage(fasthttp.StatusBadGateway), fasthttp.StatusBadGateway)
+}
@@ -1,6 +1,8 @@
 package fasthttp
 
 import (
+	"context"
+	"errors"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -18,8 +20,11 @@ type BalancingClient interface {
 // It has the following features:
 //
 //   - Balances load among available clients using 'least loaded' + 'least total'
-//     hybrid technique.
+//     hybrid technique by default. See Strategy for other techniques.
 //   - Dynamically decreases load on unhealthy clients.
+//   - Skips clients with open circuit breakers.
+//   - Skips clients failing active health checks and ejects outliers.
+//   - Ramps up load on newly added clients.
 //
 // It is forbidden copying LBClient instances. Create new instances instead.
 //
@@ -38,20 +43,101 @@ type LBClient struct {
 	// By default HealthCheck returns false if err != nil.
 	HealthCheck func(req *Request, resp *Response, err error) bool
 
-	// Clients must contain non-zero clients list.
+	// CircuitBreaker returns the circuit breaker for the given client.
+	// It is called once per client, including the clients added
+	// via AddClient. The returned breakers mustn't be shared among
+	// the clients.
+	//
+	// Clients with open breakers are skipped while there are clients
+	// with closed or half-open breakers. HostClient.CircuitBreaker
+	// of the clients is respected too.
+	//
+	// By default clients have no circuit breakers.
+	CircuitBreaker func(c BalancingClient) *CircuitBreaker
+
+	// Weight returns the weight of the given client for LBWeightedRoundRobin,
+	// LBConsistentHash and LBMaglev strategies. It is called once
+	// per client.
+	//
+	// By default all the clients have weight 1.
+	Weight func(c BalancingClient) int
+
+	// HashKey returns the key for LBConsistentHash and LBMaglev strategies.
+	// Requests with the same key are sent to the same client while
+	// it is available.
+	//
+	// By default the request URI is used.
+	HashKey func(req *Request) []byte
+
+	// ActiveHealthCheck enables background health checks of the clients.
+	// Clients failing the checks are skipped until they pass the checks
+	// again.
+	//
+	// Call Close for stopping the checks.
+	//
+	// By default clients aren't checked in background.
+	ActiveHealthCheck *ActiveHealthCheck
+
+	// OutlierDetection enables temporary ejection of the clients failing
+	// consecutive requests according to HealthCheck.
+	//
+	// By default clients aren't ejected.
+	OutlierDetection *OutlierDetection
+
+	// Discovery enables continuous reconciliation of the clients with
+	// the discovered upstreams. The first discovery is performed
+	// synchronously before the first request.
+	//
+	// Call Close for stopping the discovery.
+	//
+	// By default only Clients and the clients added via AddClient are used.
+	Discovery *Discovery
+
+	// HedgePolicy sends copies of slow idempotent requests to the clients
+	// selected by Strategy and returns the first successful response.
+	//
+	// By default requests aren't hedged.
+	HedgePolicy *HedgePolicy
+
+	// Clients must contain non-zero clients list unless Discovery is set.
 	// Incoming requests are balanced among these clients.
 	Clients []BalancingClient
 
 	cs []*lbClient
 
+	// hash table for LBConsistentHash and LBMaglev strategies.
+	// It is rebuilt on every change of the clients.
+	table lbHashTable
+
+	stopCh chan struct{}
+
 	// Timeout is the request timeout used when calling LBClient.Do.
 	//
 	// DefaultLBClientTimeout is used by default.
 	Timeout time.Duration
 
+	// SlowStartDuration is the duration of linear load ramping for the
+	// clients added via AddClient and the clients returning after
+	// ejection or failed active health checks.
+	//
+	// By default load isn't ramped.
+	SlowStartDuration time.Duration
+
+	// Strategy is the technique for selecting the client for the request.
+	//
+	// By default LBLeastLoaded is used.
+	Strategy LBStrategyType
+
 	mu sync.RWMutex
 
-	once sync.Once
+	// wrrMu protects the state of LBWeightedRoundRobin.
+	wrrMu sync.Mutex
+
+	// ejectMu serializes OutlierDetection ejections.
+	ejectMu sync.Mutex
+
+	once      sync.Once
+	closeOnce sync.Once
 }
 
 // DefaultLBClientTimeout is the default request timeout used by LBClient
@@ -60,19 +146,33 @@ type LBClient struct {
 // The timeout may be overridden via LBClient.Timeout.
 const DefaultLBClientTimeout = time.Second
 
-// DoDeadline calls DoDeadline on the least loaded client.
+// DoDeadline calls DoDeadline on the client selected by Strategy.
 func (cc *LBClient) DoDeadline(req *Request, resp *Response, deadline time.Time) error {
-	return cc.get().DoDeadline(req, resp, deadline)
+	if p := cc.HedgePolicy; p != nil && p.hedgeable(req, resp) {
+		return p.do(req, resp, func(req *Request, resp *Response) error {
+			return cc.doDeadline(req, resp, deadline)
+		})
+	}
+	return cc.doDeadline(req, resp, deadline)
 }
 
-// DoTimeout calculates deadline and calls DoDeadline on the least loaded client.
+func (cc *LBClient) doDeadline(req *Request, resp *Response, deadline time.Time) error {
+	c := cc.get(req)
+	if c == nil {
+		return ErrNoBalancingClients
+	}
+	return c.DoDeadline(req, resp, deadline)
+}
+
+// DoTimeout calculates deadline and calls DoDeadline on the client
+// selected by Strategy.
 func (cc *LBClient) DoTimeout(req *Request, resp *Response, timeout time.Duration) error {
 	deadline := time.Now().Add(timeout)
-	return cc.get().DoDeadline(req, resp, deadline)
+	return cc.DoDeadline(req, resp, deadline)
 }
 
 // Do calculates timeout using LBClient.Timeout and calls DoTimeout
-// on the least loaded client.
+// on the client selected by Strategy.
 func (cc *LBClient) Do(req *Request, resp *Response) error {
 	timeout := cc.Timeout
 	if timeout <= 0 {
@@ -81,31 +181,107 @@ func (cc *LBClient) Do(req *Request, resp *Response) error {
 	return cc.DoTimeout(req, resp, timeout)
 }
 
+// DoContext calls DoDeadline on the client selected by Strategy and aborts
+// the request when ctx is done.
+//
+// The ctx deadline is used as the request deadline. LBClient.Timeout
+// is used if ctx has no deadline.
+//
+// The returned error wraps ctx.Err() if ctx is done before the response
+// is received. See HostClient.DoContext for details.
+func (cc *LBClient) DoContext(ctx context.Context, req *Request, resp *Response) error {
+	return clientDoContext(ctx, req, resp, lbContextDoer{cc: cc})
+}
+
+// lbContextDoer performs requests with the timeout and context
+// set by clientDoContext.
+type lbContextDoer struct {
+	cc *LBClient
+}
+
+func (d lbContextDoer) Do(req *Request, resp *Response) error {
+	timeout := req.timeout
+	if timeout <= 0 {
+		timeout = d.cc.Timeout
+		if timeout <= 0 {
+			timeout = DefaultLBClientTimeout
+		}
+	}
+	return d.cc.DoDeadline(req, resp, time.Now().Add(timeout))
+}
+
+// Close stops background health checks and upstream discovery.
+//
+// LBClient may be used after Close, but its clients aren't checked
+// and reconciled with the upstreams anymore.
+func (cc *LBClient) Close() {
+	cc.once.Do(cc.init)
+	cc.closeOnce.Do(func() {
+		if cc.stopCh != nil {
+			close(cc.stopCh)
+		}
+	})
+}
+
 func (cc *LBClient) init() {
 	cc.mu.Lock()
-	defer cc.mu.Unlock()
-	if len(cc.Clients) == 0 {
+	if len(cc.Clients) == 0 && cc.Discovery == nil {
+		cc.mu.Unlock()
 		// developer sanity-check
 		panic("BUG: LBClient.Clients cannot be empty")
 	}
 	for _, c := range cc.Clients {
-		cc.cs = append(cc.cs, &lbClient{
-			c:           c,
-			healthCheck: cc.HealthCheck,
-		})
+		cc.cs = append(cc.cs, cc.newLBClient(c))
+	}
+	cc.rebuildTable()
+	if cc.ActiveHealthCheck != nil || cc.Discovery != nil {
+		cc.stopCh = make(chan struct{})
+	}
+	cc.mu.Unlock()
+
+	if cc.ActiveHealthCheck != nil {
+		go cc.runHealthChecks(cc.ActiveHealthCheck, cc.stopCh)
+	}
+	if d := cc.Discovery; d != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), d.timeout())
+		if err := cc.discover(ctx, d, true); err != nil && d.OnError != nil {
+			d.OnError(err)
+		}
+		cancel()
+		go cc.runDiscovery(d, cc.stopCh)
 	}
 }
 
+func (cc *LBClient) newLBClient(c BalancingClient) *lbClient {
+	lc := &lbClient{
+		c:            c,
+		lb:           cc,
+		healthCheck:  cc.HealthCheck,
+		weight:       1,
+		trackLatency: cc.Strategy == LBPowerOfTwoChoices,
+	}
+	if cc.CircuitBreaker != nil {
+		lc.cb = cc.CircuitBreaker(c)
+	}
+	if cc.Weight != nil {
+		lc.weight = max(cc.Weight(c), 1)
+	}
+	return lc
+}
+
 // AddClient adds a new client to the balanced clients and
 // returns the new total number of clients.
+//
+// Load on the client is ramped up during SlowStartDuration.
 func (cc *LBClient) AddClient(c BalancingClient) int {
+	lc := cc.newLBClient(c)
+	lc.startSlowStart(time.Now())
 	cc.mu.Lock()
-	cc.cs = append(cc.cs, &lbClient{
-		c:           c,
-		healthCheck: cc.HealthCheck,
-	})
+	cc.cs = append(cc.cs, lc)
+	cc.rebuildTable()
+	n := len(cc.cs)
 	cc.mu.Unlock()
-	return len(cc.cs)
+	return n
 }
 
 // RemoveClients removes clients using the provided callback.
@@ -123,51 +299,174 @@ func (cc *LBClient) RemoveClients(rc func(BalancingClient) bool) int {
 		n++
 	}
 	cc.cs = cc.cs[:n]
+	cc.rebuildTable()
 
 	cc.mu.Unlock()
-	return len(cc.cs)
+	return n
 }
 
-func (cc *LBClient) get() *lbClient {
+func (cc *LBClient) get(req *Request) *lbClient {
 	cc.once.Do(cc.init)
 
 	cc.mu.RLock()
 	cs := cc.cs
+	if len(cs) == 0 {
+		cc.mu.RUnlock()
+		return nil
+	}
+	now := time.Now()
 
-	minC := cs[0]
-	minN := minC.PendingRequests()
-	minT := atomic.LoadUint64(&minC.total)
-	for _, c := range cs[1:] {
+	// Prefer available clients admitted by slow start. Fall back
+	// to all the available clients and then to all the clients,
+	// so the requests fail fast if all the circuit breakers are open.
+	var c *lbClient
+	for _, level := range [...]lbSelectLevel{lbSelectAdmitted, lbSelectAvailable, lbSelectAny} {
+		switch cc.Strategy {
+		case LBWeightedRoundRobin:
+			c = cc.getWeightedRoundRobin(cs, level, now)
+		case LBPowerOfTwoChoices:
+			c = cc.getPowerOfTwoChoices(cs, level, now)
+		case LBConsistentHash, LBMaglev:
+			c = cc.getHashed(req, level, now)
+		default:
+			c = cc.getLeastLoaded(cs, level, now)
+		}
+		if c != nil {
+			break
+		}
+	}
+	cc.mu.RUnlock()
+	return c
+}
+
+// getLeastLoaded implements LBLeastLoaded strategy.
+func (cc *LBClient) getLeastLoaded(cs []*lbClient, level lbSelectLevel, now time.Time) *lbClient {
+	var (
+		minC *lbClient
+		minN int
+		minT uint64
+	)
+	for _, c := range cs {
+		if !c.usable(level, now) {
+			continue
+		}
 		n := c.PendingRequests()
 		t := atomic.LoadUint64(&c.total)
-		if n < minN || (n == minN && t < minT) {
+		if minC == nil || n < minN || (n == minN && t < minT) {
 			minC = c
 			minN = n
 			minT = t
 		}
 	}
-	cc.mu.RUnlock()
 	return minC
 }
 
 type lbClient struct {
 	c           BalancingClient
+	cb          *CircuitBreaker
+	lb          *LBClient
 	healthCheck func(req *Request, resp *Response, err error) bool
-	penalty     uint32
+
+	// upstream is the address of the client created by Discovery.
+	upstream string
+
+	// Unix time in nanoseconds when the client ejection ends.
+	ejectedUntil atomic.Int64
+
+	// Unix time in nanoseconds when the client slow start began.
+	slowStartAt atomic.Int64
+
+	// Latency EWMA for LBPowerOfTwoChoices.
+	ewmaUpdated time.Time
+	ewma        float64
+
+	weight int
+
+	// Index in LBClient.cs. Protected by LBClient.mu.
+	idx int
+
+	// Current weight of LBWeightedRoundRobin. Protected by LBClient.wrrMu.
+	wrrCurrent int
+
+	// Consecutive failures for OutlierDetection.
+	failures atomic.Int32
+
+	// Number of ejections for OutlierDetection.
+	ejections atomic.Int32
+
+	// Consecutive active health check results.
+	// Accessed only by the health checking goroutine.
+	checkSuccesses int
+	checkFailures  int
+
+	penalty uint32
 
 	// total amount of requests handled.
 	total uint64
+
+	ewmaMu sync.Mutex
+
+	// unhealthy is set when the client fails active health checks.
+	unhealthy atomic.Bool
+
+	trackLatency bool
 }
 
 func (c *lbClient) DoDeadline(req *Request, resp *Response, deadline time.Time) error {
+	if c.cb == nil {
+		return c.doDeadline(req, resp, deadline)
+	}
+	generation, err := c.cb.allow()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = c.doDeadline(req, resp, deadline)
+	c.cb.done(generation, req, resp, err, time.Since(start))
+	return err
+}
+
+// available returns false if the client circuit breaker rejects requests,
+// the client is ejected or it fails active health checks.
+func (c *lbClient) available(now time.Time) bool {
+	if c.unhealthy.Load() || c.isEjected(now) {
+		return false
+	}
+	cb := c.cb
+	if cb == nil {
+		if hc, ok := c.c.(*HostClient); ok {
+			cb = hc.CircuitBreaker
+		}
+	}
+	return cb == nil || cb.available()
+}
+
+func (c *lbClient) doDeadline(req *Request, resp *Response, deadline time.Time) error {
+	var start time.Time
+	if c.trackLatency {
+		start = time.Now()
+	}
 	err := c.c.DoDeadline(req, resp, deadline)
-	if !c.isHealthy(req, resp, err) && c.incPenalty() {
-		// Penalize the client returning error, so the next requests
-		// are routed to another clients.
-		time.AfterFunc(penaltyDuration, c.decPenalty)
+	if err != nil && req.ctx != nil && errors.Is(req.ctx.Err(), context.Canceled) {
+		// The caller has abandoned the request, so the error
+		// tells nothing about the client health.
+		return err
+	}
+	if c.trackLatency {
+		c.updateLatency(time.Since(start))
+	}
+	if !c.isHealthy(req, resp, err) {
+		c.onFailure()
+		if c.incPenalty() {
+			// Penalize the client returning error, so the next requests
+			// are routed to another clients.
+			time.AfterFunc(penaltyDuration, c.decPenalty)
+			return err
+		}
 	} else {
-		atomic.AddUint64(&c.total, 1)
+		c.failures.Store(0)
 	}
+	atomic.AddUint64(&c.total, 1)
 	return err
 }
 
@@ -0,0 +1,433 @@
+package fasthttp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bhargawpradhan/fasthttp/fasthttputil"
+)
+
+type testBalancingClient struct {
+	err      atomic.Pointer[error]
+	name     string
+	delay    time.Duration
+	requests atomic.Int32
+	checks   atomic.Int32
+}
+
+func (c *testBalancingClient) DoDeadline(req *Request, resp *Response, deadline time.Time) error {
+	if string(req.URI().Path()) == "/health" {
+		c.checks.Add(1)
+	} else {
+		c.requests.Add(1)
+	}
+	if c.delay > 0 {
+		time.Sleep(c.delay)
+	}
+	if err := c.err.Load(); err != nil {
+		return *err
+	}
+	resp.SetStatusCode(StatusOK)
+	return nil
+}
+
+func (c *testBalancingClient) PendingRequests() int {
+	return 0
+}
+
+func (c *testBalancingClient) String() string {
+	return c.name
+}
+
+func (c *testBalancingClient) setErr(err error) {
+	if err == nil {
+		c.err.Store(nil)
+	} else {
+		c.err.Store(&err)
+	}
+}
+
+func newTestBalancingClients(n int) ([]*testBalancingClient, []BalancingClient) {
+	cs := make([]*testBalancingClient, n)
+	bcs := make([]BalancingClient, n)
+	for i := range cs {
+		cs[i] = &testBalancingClient{name: "client" + strconv.Itoa(i)}
+		bcs[i] = cs[i]
+	}
+	return cs, bcs
+}
+
+func doLBRequests(t *testing.T, lb *LBClient, n int, uri func(i int) string) {
+	t.Helper()
+
+	req := AcquireRequest()
+	resp := AcquireResponse()
+	defer ReleaseRequest(req)
+	defer ReleaseResponse(resp)
+	for i := 0; i < n; i++ {
+		req.SetRequestURI(uri(i))
+		lb.Do(req, resp) //nolint:errcheck
+	}
+}
+
+func staticURI(int) string {
+	return "http://foobar/"
+}
+
+func TestLBClientWeightedRoundRobin(t *testing.T) {
+	t.Parallel()
+
+	cs, bcs := newTestBalancingClients(3)
+	lb := &LBClient{
+		Clients:  bcs,
+		Strategy: LBWeightedRoundRobin,
+		Weight: func(c BalancingClient) int {
+			return int(c.(*testBalancingClient).name[len("client")]-'0') + 1
+		},
+	}
+	doLBRequests(t, lb, 600, staticURI)
+	for i, c := range cs {
+		if n := c.requests.Load(); n != int32(100*(i+1)) {
+			t.Fatalf("unexpected number of requests to %s: %d. Expecting %d", c.name, n, 100*(i+1))
+		}
+	}
+}
+
+func TestLBClientPowerOfTwoChoices(t *testing.T) {
+	t.Parallel()
+
+	cs, bcs := newTestBalancingClients(2)
+	cs[0].delay = 5 * time.Millisecond
+	lb := &LBClient{
+		Clients:  bcs,
+		Strategy: LBPowerOfTwoChoices,
+	}
+	doLBRequests(t, lb, 100, staticURI)
+	if slow, fast := cs[0].requests.Load(), cs[1].requests.Load(); slow*5 > fast {
+		t.Fatalf("too many requests to the slow client: %d vs %d", slow, fast)
+	}
+}
+
+func testLBClientHashing(t *testing.T, strategy LBStrategyType) {
+	cs, bcs := newTestBalancingClients(4)
+	lb := &LBClient{
+		Clients:  bcs,
+		Strategy: strategy,
+		HashKey: func(req *Request) []byte {
+			return req.URI().QueryArgs().Peek("user")
+		},
+	}
+	keyURI := func(i int) string {
+		return fmt.Sprintf("http://foobar/?user=%d", i%1000)
+	}
+	owners := func() map[int]string {
+		m := make(map[int]string)
+		req := AcquireRequest()
+		defer ReleaseRequest(req)
+		for i := 0; i < 1000; i++ {
+			req.SetRequestURI(keyURI(i))
+			m[i] = lb.get(req).c.(*testBalancingClient).name
+		}
+		return m
+	}
+
+	doLBRequests(t, lb, 4000, keyURI)
+	for _, c := range cs {
+		if n := c.requests.Load(); n < 500 || n > 1500 {
+			t.Fatalf("unbalanced requests to %s: %d. Expecting about 1000", c.name, n)
+		}
+	}
+
+	// Keys stick to the clients.
+	before := owners()
+	if after := owners(); fmt.Sprint(before) != fmt.Sprint(after) {
+		t.Fatal("keys moved between clients without changes")
+	}
+
+	// Only the keys of the removed client move.
+	lb.RemoveClients(func(c BalancingClient) bool {
+		return c == bcs[3]
+	})
+	moved := 0
+	for k, owner := range owners() {
+		if before[k] != owner {
+			if before[k] != "client3" {
+				t.Fatalf("key %d moved from %s to %s", k, before[k], owner)
+			}
+			moved++
+		}
+	}
+	if moved == 0 {
+		t.Fatal("keys of the removed client didn't move")
+	}
+
+	// The keys of unavailable clients move to the available one.
+	for _, c := range lb.cs[1:] {
+		c.unhealthy.Store(true)
+	}
+	healthy := lb.cs[0].c.(*testBalancingClient).name
+	for k, owner := range owners() {
+		if owner != healthy {
+			t.Fatalf("key %d owned by unavailable %s. Expecting %s", k, owner, healthy)
+		}
+	}
+
+	// No client is found among the unavailable ones.
+	lb.cs[0].unhealthy.Store(true)
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.SetRequestURI(keyURI(0))
+	if c := lb.getHashed(req, lbSelectAvailable, time.Now()); c != nil {
+		t.Fatalf("unexpected unavailable client %s", c.c.(*testBalancingClient).name)
+	}
+	if lb.get(req) == nil {
+		t.Fatal("expecting a client when all the clients are unavailable")
+	}
+}
+
+func TestLBClientConsistentHash(t *testing.T) {
+	t.Parallel()
+
+	testLBClientHashing(t, LBConsistentHash)
+}
+
+func TestLBClientMaglev(t *testing.T) {
+	t.Parallel()
+
+	testLBClientHashing(t, LBMaglev)
+}
+
+func TestMaglevTableBalance(t *testing.T) {
+	t.Parallel()
+
+	cs := make([]*lbClient, 3)
+	for i := range cs {
+		cs[i] = &lbClient{c: &testBalancingClient{name: "client" + strconv.Itoa(i)}, weight: i + 1}
+	}
+	table := buildMaglevTable(cs)
+	counts := make(map[*lbClient]int)
+	for _, c := range table.clients {
+		counts[c]++
+	}
+	for i, c := range cs {
+		expected := lbMaglevTableSize * (i + 1) / 6
+		if n := counts[c]; n < expected-100 || n > expected+100 {
+			t.Fatalf("unexpected number of entries for client %d: %d. Expecting %d", i, n, expected)
+		}
+	}
+}
+
+func TestLBClientActiveHealthCheck(t *testing.T) {
+	t.Parallel()
+
+	cs, bcs := newTestBalancingClients(2)
+	lb := &LBClient{
+		Clients:  bcs,
+		Strategy: LBWeightedRoundRobin,
+		ActiveHealthCheck: &ActiveHealthCheck{
+			Path:               "/health",
+			Interval:           5 * time.Millisecond,
+			HealthyThreshold:   1,
+			UnhealthyThreshold: 2,
+		},
+	}
+	defer lb.Close()
+
+	waitFor := func(cond func() bool) {
+		t.Helper()
+
+		for i := 0; i < 200 && !cond(); i++ {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if !cond() {
+			t.Fatal("timeout")
+		}
+	}
+
+	cs[0].setErr(errors.New("unhealthy"))
+	doLBRequests(t, lb, 1, staticURI)
+	waitFor(func() bool { return lb.cs[0].unhealthy.Load() })
+	cs[0].requests.Store(0)
+	cs[1].requests.Store(0)
+	doLBRequests(t, lb, 10, staticURI)
+	if n := cs[0].requests.Load(); n != 0 {
+		t.Fatalf("unexpected number of requests to the unhealthy client %d", n)
+	}
+
+	cs[0].setErr(nil)
+	waitFor(func() bool { return !lb.cs[0].unhealthy.Load() })
+	doLBRequests(t, lb, 10, staticURI)
+	if n := cs[0].requests.Load(); n == 0 {
+		t.Fatal("no requests to the recovered client")
+	}
+
+	lb.Close()
+	time.Sleep(20 * time.Millisecond)
+	checks := cs[1].checks.Load()
+	time.Sleep(20 * time.Millisecond)
+	if n := cs[1].checks.Load(); n != checks {
+		t.Fatalf("health checks continue after Close: %d vs %d", n, checks)
+	}
+}
+
+func TestLBClientActiveHealthCheckBypass(t *testing.T) {
+	t.Parallel()
+
+	ln := fasthttputil.NewInmemoryListener()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Path()) != "/health" {
+				ctx.SetStatusCode(StatusNotFound)
+			}
+		},
+	}
+	go s.Serve(ln)     //nolint:errcheck
+	defer s.Shutdown() //nolint:errcheck
+
+	cb := &CircuitBreaker{OpenDuration: time.Hour}
+	cb.mu.Lock()
+	cb.open(time.Now())
+	cb.mu.Unlock()
+	var intercepted atomic.Int32
+	hc := &HostClient{
+		Addr:           "example.com",
+		Dial:           func(addr string) (net.Conn, error) { return ln.Dial() },
+		CircuitBreaker: cb,
+		Interceptors: []Interceptor{func(next DoFunc) DoFunc {
+			return func(req *Request, resp *Response) error {
+				intercepted.Add(1)
+				return errors.New("intercepted")
+			}
+		}},
+	}
+
+	// The open circuit and the interceptors don't fail the checks.
+	c := &lbClient{c: hc}
+	if !c.checkHealth(&ActiveHealthCheck{Path: "/health"}) {
+		t.Fatal("unexpected failed health check")
+	}
+	if c.checkHealth(&ActiveHealthCheck{Path: "/missing"}) {
+		t.Fatal("unexpected successful health check")
+	}
+	if n := intercepted.Load(); n != 0 {
+		t.Fatalf("unexpected number of intercepted checks %d", n)
+	}
+	if state := cb.State(); state != CircuitOpen {
+		t.Fatalf("unexpected circuit state %s. Expecting %s", state, CircuitOpen)
+	}
+}
+
+func TestLBClientActiveHealthCheckHost(t *testing.T) {
+	t.Parallel()
+
+	ln := fasthttputil.NewInmemoryListener()
+	hosts := make(chan string, 4)
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			hosts <- string(ctx.Host())
+			ctx.SetConnectionClose()
+		},
+	}
+	go s.Serve(ln)     //nolint:errcheck
+	defer s.Shutdown() //nolint:errcheck
+
+	hc := &HostClient{
+		Addr: "foo.test:80,bar.test:80",
+		Dial: func(addr string) (net.Conn, error) { return ln.Dial() },
+	}
+
+	// Every check is sent with the address it is dialed to.
+	c := &lbClient{c: hc}
+	for _, expected := range []string{"foo.test:80", "bar.test:80"} {
+		if !c.checkHealth(&ActiveHealthCheck{}) {
+			t.Fatal("unexpected failed health check")
+		}
+		if host := <-hosts; host != expected {
+			t.Fatalf("unexpected Host %q. Expecting %q", host, expected)
+		}
+	}
+}
+
+func TestLBClientOutlierDetection(t *testing.T) {
+	t.Parallel()
+
+	cs, bcs := newTestBalancingClients(2)
+	lb := &LBClient{
+		Clients:  bcs,
+		Strategy: LBWeightedRoundRobin,
+		OutlierDetection: &OutlierDetection{
+			ConsecutiveFailures: 2,
+			BaseEjectionTime:    50 * time.Millisecond,
+		},
+	}
+	cs[0].setErr(errors.New("failure"))
+	doLBRequests(t, lb, 20, staticURI)
+	if n := cs[0].requests.Load(); n != 2 {
+		t.Fatalf("unexpected number of requests to the ejected client %d. Expecting 2", n)
+	}
+
+	// The client is reinstated after the ejection.
+	time.Sleep(60 * time.Millisecond)
+	cs[0].setErr(nil)
+	doLBRequests(t, lb, 20, staticURI)
+	if n := cs[0].requests.Load(); n <= 2 {
+		t.Fatal("no requests to the reinstated client")
+	}
+
+	// The second ejection is longer.
+	cs[0].setErr(errors.New("failure"))
+	cs[0].requests.Store(0)
+	doLBRequests(t, lb, 20, staticURI)
+	if n := lb.cs[0].ejections.Load(); n != 2 {
+		t.Fatalf("unexpected number of ejections %d. Expecting 2", n)
+	}
+	if d := time.Until(time.Unix(0, lb.cs[0].ejectedUntil.Load())); d <= 50*time.Millisecond {
+		t.Fatalf("unexpected ejection duration %s. Expecting 100ms", d)
+	}
+
+	// The last client is never ejected.
+	cs[1].setErr(errors.New("failure"))
+	cs[1].requests.Store(0)
+	doLBRequests(t, lb, 10, staticURI)
+	if n := cs[1].requests.Load(); n != 10 {
+		t.Fatalf("unexpected number of requests %d. Expecting 10", n)
+	}
+}
+
+func TestLBClientSlowStart(t *testing.T) {
+	t.Parallel()
+
+	for _, strategy := range []LBStrategyType{LBLeastLoaded, LBWeightedRoundRobin, LBPowerOfTwoChoices} {
+		cs, bcs := newTestBalancingClients(2)
+		lb := &LBClient{
+			Clients:           bcs[:1],
+			Strategy:          strategy,
+			SlowStartDuration: time.Hour,
+		}
+		lb.AddClient(bcs[1])
+		doLBRequests(t, lb, 1000, staticURI)
+		if n := cs[1].requests.Load(); n == 0 || n > 250 {
+			t.Fatalf("unexpected number of requests to the new client with strategy %d: %d", strategy, n)
+		}
+
+		if strategy == LBPowerOfTwoChoices {
+			// The load depends on the latency of the clients.
+			continue
+		}
+
+		// The slow start is over.
+		for _, c := range lb.cs {
+			c.slowStartAt.Store(0)
+		}
+		cs[1].requests.Store(0)
+		doLBRequests(t, lb, 1000, staticURI)
+		if n := cs[1].requests.Load(); n < 400 {
+			t.Fatalf("unexpected number of requests to the client after slow start with strategy %d: %d", strategy, n)
+		}
+	}
+}
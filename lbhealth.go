@@ -0,0 +1,295 @@
+package fasthttp
+
+import (
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHealthCheckInterval is the default interval between
+	// active health checks.
+	DefaultHealthCheckInterval = 10 * time.Second
+
+	// DefaultHealthCheckTimeout is the default active health check timeout.
+	DefaultHealthCheckTimeout = time.Second
+
+	// DefaultOutlierConsecutiveFailures is the default number of
+	// consecutive failures ejecting the client.
+	DefaultOutlierConsecutiveFailures = 5
+
+	// DefaultOutlierBaseEjectionTime is the default duration of
+	// the first client ejection.
+	DefaultOutlierBaseEjectionTime = 30 * time.Second
+
+	// DefaultOutlierMaxEjectionTime is the default maximum duration
+	// of the client ejection.
+	DefaultOutlierMaxEjectionTime = 5 * time.Minute
+
+	// DefaultOutlierMaxEjectionPercent is the default maximum percentage
+	// of the ejected clients.
+	DefaultOutlierMaxEjectionPercent = 50
+)
+
+// ActiveHealthCheck configures background health checks of LBClient clients.
+//
+// Every client is sent GET request to Path each Interval. The client
+// is marked unhealthy after UnhealthyThreshold consecutive failed checks
+// and healthy again after HealthyThreshold consecutive successful checks.
+//
+// The checks aren't accounted by OutlierDetection. The checks of HostClient
+// clients are sent in a single attempt bypassing HostClient.CircuitBreaker,
+// RetryPolicy, HedgePolicy and Interceptors, so an open circuit doesn't fail
+// the checks and the checks don't affect the circuit. Other clients
+// are checked via DoDeadline.
+type ActiveHealthCheck struct {
+	// IsHealthy determines whether the health check succeeded.
+	//
+	// By default the check succeeds if the response has 2xx status code.
+	IsHealthy func(resp *Response, err error) bool
+
+	// Path is the request URI of the health checks.
+	//
+	// By default "/" is used.
+	Path string
+
+	// Host is the Host header of the health checks.
+	//
+	// By default the HostClient.Addr entry the check is sent to is used
+	// for HostClient clients and "localhost" for other clients.
+	Host string
+
+	// Interval is the interval between the checks.
+	//
+	// By default DefaultHealthCheckInterval is used.
+	Interval time.Duration
+
+	// Timeout is the check timeout.
+	//
+	// By default DefaultHealthCheckTimeout is used.
+	Timeout time.Duration
+
+	// HealthyThreshold is the number of consecutive successful checks
+	// marking the unhealthy client healthy.
+	//
+	// By default 2 checks are used.
+	HealthyThreshold int
+
+	// UnhealthyThreshold is the number of consecutive failed checks
+	// marking the client unhealthy.
+	//
+	// By default 3 checks are used.
+	UnhealthyThreshold int
+}
+
+// OutlierDetection configures ejection of LBClient clients failing
+// consecutive requests according to LBClient.HealthCheck.
+//
+// Ejected clients are skipped until the ejection ends. Every subsequent
+// ejection of the client is longer by BaseEjectionTime up to MaxEjectionTime.
+type OutlierDetection struct {
+	// ConsecutiveFailures is the number of consecutive failures
+	// ejecting the client.
+	//
+	// By default DefaultOutlierConsecutiveFailures is used.
+	ConsecutiveFailures int
+
+	// BaseEjectionTime is the duration of the first ejection.
+	//
+	// By default DefaultOutlierBaseEjectionTime is used.
+	BaseEjectionTime time.Duration
+
+	// MaxEjectionTime is the maximum ejection duration. The ejection
+	// count of the client is reset if it isn't ejected for MaxEjectionTime.
+	//
+	// By default DefaultOutlierMaxEjectionTime is used.
+	MaxEjectionTime time.Duration
+
+	// MaxEjectionPercent is the maximum percentage of the clients ejected
+	// at the same time. At least one client may be ejected if there are
+	// multiple clients.
+	//
+	// By default DefaultOutlierMaxEjectionPercent is used.
+	MaxEjectionPercent int
+}
+
+func (cc *LBClient) runHealthChecks(hc *ActiveHealthCheck, stopCh <-chan struct{}) {
+	interval := hc.Interval
+	if interval <= 0 {
+		interval = DefaultHealthCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		// RemoveClients modifies cc.cs in place, so copy it.
+		cc.mu.RLock()
+		cs := slices.Clone(cc.cs)
+		cc.mu.RUnlock()
+
+		var wg sync.WaitGroup
+		for _, c := range cs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.onHealthCheck(hc, c.checkHealth(hc))
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+func (c *lbClient) checkHealth(hc *ActiveHealthCheck) bool {
+	req := AcquireRequest()
+	resp := AcquireResponse()
+	defer ReleaseRequest(req)
+	defer ReleaseResponse(resp)
+
+	path := hc.Path
+	if path == "" {
+		path = "/"
+	}
+	hostClient, isHostClient := c.c.(*HostClient)
+	host := hc.Host
+	if host == "" {
+		host = "localhost"
+		if isHostClient {
+			// HostClient.Addr may contain multiple addresses,
+			// so the address of the probed connection is sent.
+			host, _, _ = strings.Cut(hostClient.Addr, ",")
+			req.hostFromConn = true
+			req.UseHostHeader = true
+		}
+	}
+	req.SetRequestURI(path)
+	req.Header.SetHost(host)
+	timeout := hc.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHealthCheckTimeout
+	}
+
+	// The checks bypass the outlier detection, since they are accounted
+	// separately. HostClient checks bypass the circuit breaker too,
+	// otherwise the open circuit would keep the client unhealthy.
+	var err error
+	if isHostClient {
+		req.timeout = timeout
+		_, err = hostClient.do(req, resp)
+		if err == io.EOF {
+			err = ErrConnectionClosed
+		}
+	} else {
+		err = c.c.DoDeadline(req, resp, time.Now().Add(timeout))
+	}
+	if hc.IsHealthy != nil {
+		return hc.IsHealthy(resp, err)
+	}
+	return err == nil && resp.StatusCode() >= StatusOK && resp.StatusCode() < StatusMultipleChoices
+}
+
+func (c *lbClient) onHealthCheck(hc *ActiveHealthCheck, healthy bool) {
+	if healthy {
+		c.checkFailures = 0
+		c.checkSuccesses++
+		threshold := hc.HealthyThreshold
+		if threshold <= 0 {
+			threshold = 2
+		}
+		if c.checkSuccesses >= threshold && c.unhealthy.CompareAndSwap(true, false) {
+			c.startSlowStart(time.Now())
+		}
+		return
+	}
+	c.checkSuccesses = 0
+	c.checkFailures++
+	threshold := hc.UnhealthyThreshold
+	if threshold <= 0 {
+		threshold = 3
+	}
+	if c.checkFailures >= threshold {
+		c.unhealthy.Store(true)
+	}
+}
+
+func (c *lbClient) isEjected(now time.Time) bool {
+	until := c.ejectedUntil.Load()
+	if until == 0 {
+		return false
+	}
+	if now.UnixNano() < until {
+		return true
+	}
+	// The ejection is over.
+	if c.ejectedUntil.CompareAndSwap(until, 0) {
+		c.failures.Store(0)
+		c.startSlowStart(now)
+	}
+	return false
+}
+
+// onFailure ejects the client after OutlierDetection.ConsecutiveFailures.
+func (c *lbClient) onFailure() {
+	od := c.lb.OutlierDetection
+	if od == nil {
+		return
+	}
+	threshold := od.ConsecutiveFailures
+	if threshold <= 0 {
+		threshold = DefaultOutlierConsecutiveFailures
+	}
+	if int(c.failures.Add(1)) < threshold {
+		return
+	}
+	c.lb.eject(c, od, time.Now())
+}
+
+func (cc *LBClient) eject(c *lbClient, od *OutlierDetection, now time.Time) {
+	maxPercent := od.MaxEjectionPercent
+	if maxPercent <= 0 {
+		maxPercent = DefaultOutlierMaxEjectionPercent
+	}
+
+	// Serialize ejections for respecting MaxEjectionPercent.
+	cc.ejectMu.Lock()
+	defer cc.ejectMu.Unlock()
+
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+
+	n := len(cc.cs)
+	ejected := 0
+	for _, lc := range cc.cs {
+		if lc.isEjected(now) {
+			ejected++
+		}
+	}
+	if c.isEjected(now) || n <= 1 || ejected+1 > max(n*maxPercent/100, 1) {
+		return
+	}
+
+	base := od.BaseEjectionTime
+	if base <= 0 {
+		base = DefaultOutlierBaseEjectionTime
+	}
+	maxTime := od.MaxEjectionTime
+	if maxTime <= 0 {
+		maxTime = DefaultOutlierMaxEjectionTime
+	}
+	ejections := c.ejections.Add(1)
+	d := min(base*time.Duration(ejections), maxTime)
+	c.ejectedUntil.Store(now.Add(d).UnixNano())
+	c.failures.Store(0)
+
+	// Reset the ejection count if the client isn't ejected again
+	// for MaxEjectionTime after this ejection ends.
+	time.AfterFunc(d+maxTime, func() {
+		c.ejections.CompareAndSwap(ejections, 0)
+	})
+}
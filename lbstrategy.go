@@ -0,0 +1,386 @@
+package fasthttp
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// LBStrategyType is the technique used by LBClient for selecting
+// the client for the request.
+type LBStrategyType int
+
+const (
+	// LBLeastLoaded selects the client with the least number of pending
+	// requests. Ties are broken by the least total number of requests.
+	LBLeastLoaded LBStrategyType = iota
+
+	// LBWeightedRoundRobin cycles through the clients in proportion to
+	// their LBClient.Weight using smooth weighted round robin.
+	LBWeightedRoundRobin
+
+	// LBPowerOfTwoChoices selects two random clients and sends the request
+	// to the one with the lower exponentially weighted moving average
+	// latency multiplied by the number of pending requests.
+	LBPowerOfTwoChoices
+
+	// LBConsistentHash maps LBClient.HashKey to the client on a hash ring
+	// with virtual nodes, so only a small share of keys moves to other
+	// clients when clients are added or removed.
+	LBConsistentHash
+
+	// LBMaglev maps LBClient.HashKey to the client via Maglev lookup table.
+	// It spreads keys more evenly than LBConsistentHash at the cost
+	// of the table rebuilding on every change of the clients.
+	//
+	// See https://research.google/pubs/maglev-a-fast-and-reliable-software-network-load-balancer/
+	LBMaglev
+)
+
+const (
+	// lbRingReplicas is the number of virtual nodes per weight unit
+	// on LBConsistentHash ring.
+	lbRingReplicas = 100
+
+	// lbMaglevTableSize is the size of LBMaglev lookup table.
+	// It must be a prime number much larger than the number of clients.
+	lbMaglevTableSize = 65537
+
+	// lbEWMADecay is the time constant of LBPowerOfTwoChoices latency
+	// moving average.
+	lbEWMADecay = 10 * time.Second
+
+	// lbSlowStartMinWeight is the minimum share of the load sent
+	// to the client at the beginning of slow start.
+	lbSlowStartMinWeight = 0.1
+)
+
+// lbSelectLevel determines which clients may be selected.
+type lbSelectLevel int
+
+const (
+	// Available clients admitted by slow start.
+	lbSelectAdmitted lbSelectLevel = iota
+
+	// Available clients ignoring slow start.
+	lbSelectAvailable
+
+	// All the clients.
+	lbSelectAny
+)
+
+func (c *lbClient) usable(level lbSelectLevel, now time.Time) bool {
+	switch level {
+	case lbSelectAdmitted:
+		if !c.available(now) {
+			return false
+		}
+		ramp := c.slowStartRamp(now)
+		return ramp >= 1 || rand.Float64() < ramp // #nosec G404
+	case lbSelectAvailable:
+		return c.available(now)
+	default:
+		return true
+	}
+}
+
+func (c *lbClient) startSlowStart(now time.Time) {
+	if c.lb.SlowStartDuration > 0 {
+		c.slowStartAt.Store(now.UnixNano())
+	}
+}
+
+// slowStartRamp returns the share of the load in (0, 1] range
+// the client may receive.
+func (c *lbClient) slowStartRamp(now time.Time) float64 {
+	startAt := c.slowStartAt.Load()
+	if startAt == 0 {
+		return 1
+	}
+	d := c.lb.SlowStartDuration
+	elapsed := now.UnixNano() - startAt
+	if d <= 0 || elapsed >= int64(d) {
+		c.slowStartAt.CompareAndSwap(startAt, 0)
+		return 1
+	}
+	return max(float64(elapsed)/float64(d), lbSlowStartMinWeight)
+}
+
+// getWeightedRoundRobin implements LBWeightedRoundRobin strategy.
+//
+// See https://github.com/phusion/nginx/commit/27e94984486058d73157038f7950a0a36ecc6e35
+func (cc *LBClient) getWeightedRoundRobin(cs []*lbClient, level lbSelectLevel, now time.Time) *lbClient {
+	cc.wrrMu.Lock()
+	defer cc.wrrMu.Unlock()
+
+	var best *lbClient
+	total := 0
+	for _, c := range cs {
+		if level != lbSelectAny && !c.available(now) {
+			continue
+		}
+		weight := c.weight * 100
+		if level == lbSelectAdmitted {
+			// Slow start ramps the weight instead of skipping requests.
+			weight = max(int(float64(weight)*c.slowStartRamp(now)), 1)
+		}
+		c.wrrCurrent += weight
+		total += weight
+		if best == nil || c.wrrCurrent > best.wrrCurrent {
+			best = c
+		}
+	}
+	if best != nil {
+		best.wrrCurrent -= total
+	}
+	return best
+}
+
+// getPowerOfTwoChoices implements LBPowerOfTwoChoices strategy.
+func (cc *LBClient) getPowerOfTwoChoices(cs []*lbClient, level lbSelectLevel, now time.Time) *lbClient {
+	var a, b *lbClient
+	n := len(cs)
+	if n > 1 {
+		// Fast path: two distinct random clients.
+		i := rand.IntN(n)     // #nosec G404
+		j := rand.IntN(n - 1) // #nosec G404
+		if j >= i {
+			j++
+		}
+		if cs[i].usable(level, now) && cs[j].usable(level, now) {
+			a, b = cs[i], cs[j]
+		}
+	}
+	if a == nil {
+		// Some clients are unusable, so pick the first two usable
+		// clients starting from a random position.
+		start := rand.IntN(n) // #nosec G404
+		for k := 0; k < n && b == nil; k++ {
+			c := cs[(start+k)%n]
+			if !c.usable(level, now) {
+				continue
+			}
+			if a == nil {
+				a = c
+			} else {
+				b = c
+			}
+		}
+		if b == nil {
+			return a
+		}
+	}
+	if b.loadCost(now) < a.loadCost(now) {
+		return b
+	}
+	return a
+}
+
+// loadCost returns the expected cost of sending the request to the client.
+func (c *lbClient) loadCost(now time.Time) float64 {
+	c.ewmaMu.Lock()
+	ewma := c.ewma
+	if ewma > 0 {
+		// Decay the average while the client receives no requests,
+		// so slow clients get a chance to prove they have recovered.
+		ewma *= math.Exp(-float64(now.Sub(c.ewmaUpdated)) / float64(lbEWMADecay))
+	}
+	c.ewmaMu.Unlock()
+	return (ewma + 1) * float64(c.PendingRequests()+1)
+}
+
+func (c *lbClient) updateLatency(d time.Duration) {
+	now := time.Now()
+	c.ewmaMu.Lock()
+	if c.ewma == 0 {
+		c.ewma = float64(d)
+	} else {
+		w := math.Exp(-float64(now.Sub(c.ewmaUpdated)) / float64(lbEWMADecay))
+		c.ewma = c.ewma*w + float64(d)*(1-w)
+	}
+	c.ewmaUpdated = now
+	c.ewmaMu.Unlock()
+}
+
+// lbHashTable maps request keys to clients for LBConsistentHash
+// and LBMaglev strategies.
+type lbHashTable struct {
+	// Sorted virtual node hashes for LBConsistentHash.
+	ring []uint64
+
+	// Clients for the ring virtual nodes or Maglev table entries.
+	clients []*lbClient
+}
+
+// rebuildTable rebuilds the hash table after changing the clients.
+// cc.mu must be held.
+func (cc *LBClient) rebuildTable() {
+	for i, c := range cc.cs {
+		c.idx = i
+	}
+	switch cc.Strategy {
+	case LBConsistentHash:
+		cc.table = buildHashRing(cc.cs)
+	case LBMaglev:
+		cc.table = buildMaglevTable(cc.cs)
+	}
+}
+
+func buildHashRing(cs []*lbClient) lbHashTable {
+	type vnode struct {
+		c    *lbClient
+		hash uint64
+	}
+	var nodes []vnode
+	for _, c := range cs {
+		name := lbClientName(c.c)
+		for i := 0; i < c.weight*lbRingReplicas; i++ {
+			h := lbHash([]byte(name + "#" + strconv.Itoa(i)))
+			nodes = append(nodes, vnode{hash: h, c: c})
+		}
+	}
+	slices.SortFunc(nodes, func(a, b vnode) int {
+		switch {
+		case a.hash < b.hash:
+			return -1
+		case a.hash > b.hash:
+			return 1
+		default:
+			return 0
+		}
+	})
+	t := lbHashTable{
+		ring:    make([]uint64, len(nodes)),
+		clients: make([]*lbClient, len(nodes)),
+	}
+	for i, n := range nodes {
+		t.ring[i] = n.hash
+		t.clients[i] = n.c
+	}
+	return t
+}
+
+func buildMaglevTable(cs []*lbClient) lbHashTable {
+	const m = lbMaglevTableSize
+
+	t := lbHashTable{
+		clients: make([]*lbClient, m),
+	}
+	if len(cs) == 0 {
+		return t
+	}
+	offsets := make([]uint64, len(cs))
+	skips := make([]uint64, len(cs))
+	next := make([]uint64, len(cs))
+	for i, c := range cs {
+		name := []byte(lbClientName(c.c))
+		offsets[i] = lbHash(name) % m
+		skips[i] = lbHash(append(name, '#'))%(m-1) + 1
+	}
+	filled := 0
+	for {
+		for i, c := range cs {
+			// Clients take as many entries per round as their weight.
+			for w := 0; w < c.weight; w++ {
+				pos := (offsets[i] + next[i]*skips[i]) % m
+				for t.clients[pos] != nil {
+					next[i]++
+					pos = (offsets[i] + next[i]*skips[i]) % m
+				}
+				t.clients[pos] = c
+				next[i]++
+				filled++
+				if filled == m {
+					return t
+				}
+			}
+		}
+	}
+}
+
+// getHashed implements LBConsistentHash and LBMaglev strategies.
+//
+// Unusable clients are skipped by walking the ring or the table
+// from the key position, so the keys of available clients don't move.
+// The walk stops after checking every client once.
+func (cc *LBClient) getHashed(req *Request, level lbSelectLevel, now time.Time) *lbClient {
+	if level == lbSelectAdmitted {
+		// Slow start doesn't apply to hashing, since it would break
+		// the key affinity. The keys are looked up at lbSelectAvailable
+		// level instead.
+		return nil
+	}
+	t := &cc.table
+	n := len(t.clients)
+	if n == 0 {
+		return nil
+	}
+	var key []byte
+	if cc.HashKey != nil {
+		key = cc.HashKey(req)
+	} else {
+		key = req.Header.RequestURI()
+	}
+	h := lbHash(key)
+	var pos int
+	if t.ring != nil {
+		pos = sort.Search(n, func(i int) bool { return t.ring[i] >= h })
+	} else {
+		pos = int(h % uint64(n))
+	}
+	c := t.clients[pos%n]
+	if c.usable(level, now) {
+		return c
+	}
+
+	// Adjacent entries often belong to the same client, so check
+	// each client only once.
+	seen := make([]bool, len(cc.cs))
+	seen[c.idx] = true
+	left := len(cc.cs) - 1
+	for i := 1; i < n && left > 0; i++ {
+		c = t.clients[(pos+i)%n]
+		if seen[c.idx] {
+			continue
+		}
+		if c.usable(level, now) {
+			return c
+		}
+		seen[c.idx] = true
+		left--
+	}
+	return nil
+}
+
+// lbClientName returns the stable client name used for hashing.
+func lbClientName(c BalancingClient) string {
+	switch c := c.(type) {
+	case *HostClient:
+		return c.Addr
+	case fmt.Stringer:
+		return c.String()
+	default:
+		return fmt.Sprintf("%p", c)
+	}
+}
+
+// lbHash returns FNV-1a hash of b with additional mixing
+// for better avalanche of similar keys.
+func lbHash(b []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	// splitmix64 finalizer.
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
@@ -0,0 +1,313 @@
+package fasthttp
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// The backoff of refilling MinIdleConns idle connections after failures.
+const (
+	minIdleConnsFillBackoff = 100 * time.Millisecond
+	maxIdleConnsFillBackoff = 10 * time.Second
+)
+
+// ConnPoolStats is a snapshot of the HostClient connection pool state.
+//
+// Client.Stats returns the stats aggregated over all the hosts.
+type ConnPoolStats struct {
+	// Conns is the number of open connections, including the connections
+	// being dialed.
+	Conns int
+
+	// IdleConns is the number of idle keep-alive connections in the pool.
+	IdleConns int
+
+	// InUseConns is the number of connections busy with requests.
+	InUseConns int
+
+	// WaitingRequests is the number of requests waiting for a free
+	// connection when all the HostClient.MaxConns connections are busy.
+	WaitingRequests int
+
+	// PendingRequests is the number of requests being executed.
+	PendingRequests int
+
+	// Dials is the number of dial attempts.
+	Dials uint64
+
+	// DialErrors is the number of failed dial attempts.
+	DialErrors uint64
+
+	// OldestIdleConnAge is the age of the oldest idle connection.
+	OldestIdleConnAge time.Duration
+
+	// AvgIdleConnAge is the average age of the idle connections.
+	AvgIdleConnAge time.Duration
+
+	// ConnsAcquired is the number of connections successfully acquired
+	// for requests.
+	ConnsAcquired uint64
+
+	// ConnsReused is the number of acquired connections taken
+	// from the idle pool.
+	ConnsReused uint64
+
+	// ClosedIdle is the number of idle connections closed after
+	// HostClient.MaxIdleConnDuration or by CloseIdleConnections.
+	ClosedIdle uint64
+
+	// ClosedLifetime is the number of connections closed after
+	// HostClient.MaxConnDuration.
+	ClosedLifetime uint64
+
+	// ClosedConnectionClose is the number of connections closed because
+	// of 'Connection: close' request or response header.
+	ClosedConnectionClose uint64
+
+	// ClosedError is the number of connections closed because of errors
+	// or canceled requests.
+	ClosedError uint64
+
+	// IdleConnFillErrors is the number of failed background refills
+	// of HostClient.MinIdleConns idle connections.
+	IdleConnFillErrors uint64
+}
+
+// ReuseRatio returns the ratio of acquired connections taken
+// from the idle pool.
+func (s *ConnPoolStats) ReuseRatio() float64 {
+	if s.ConnsAcquired == 0 {
+		return 0
+	}
+	return float64(s.ConnsReused) / float64(s.ConnsAcquired)
+}
+
+func (s *ConnPoolStats) add(x *ConnPoolStats) {
+	if idle := s.IdleConns + x.IdleConns; idle > 0 {
+		s.AvgIdleConnAge = (s.AvgIdleConnAge*time.Duration(s.IdleConns) +
+			x.AvgIdleConnAge*time.Duration(x.IdleConns)) / time.Duration(idle)
+	}
+	s.OldestIdleConnAge = max(s.OldestIdleConnAge, x.OldestIdleConnAge)
+	s.Conns += x.Conns
+	s.IdleConns += x.IdleConns
+	s.InUseConns += x.InUseConns
+	s.WaitingRequests += x.WaitingRequests
+	s.PendingRequests += x.PendingRequests
+	s.addCounters(x)
+}
+
+func (s *ConnPoolStats) addCounters(x *ConnPoolStats) {
+	s.Dials += x.Dials
+	s.DialErrors += x.DialErrors
+	s.ConnsAcquired += x.ConnsAcquired
+	s.ConnsReused += x.ConnsReused
+	s.ClosedIdle += x.ClosedIdle
+	s.ClosedLifetime += x.ClosedLifetime
+	s.ClosedConnectionClose += x.ClosedConnectionClose
+	s.ClosedError += x.ClosedError
+	s.IdleConnFillErrors += x.IdleConnFillErrors
+}
+
+// connPoolCounters contains the cumulative HostClient pool counters.
+type connPoolCounters struct {
+	dials                 atomic.Uint64
+	dialErrors            atomic.Uint64
+	connsAcquired         atomic.Uint64
+	connsReused           atomic.Uint64
+	closedIdle            atomic.Uint64
+	closedLifetime        atomic.Uint64
+	closedConnectionClose atomic.Uint64
+	closedError           atomic.Uint64
+	idleConnFillErrors    atomic.Uint64
+}
+
+// connCloseReason is the reason of closing a pool connection.
+type connCloseReason int
+
+const (
+	connCloseError connCloseReason = iota
+	connCloseIdle
+	connCloseLifetime
+	connCloseConnectionClose
+)
+
+// Stats returns the connection pool stats.
+func (c *HostClient) Stats() ConnPoolStats {
+	c.connsLock.Lock()
+	s := ConnPoolStats{
+		Conns:     c.connsCount,
+		IdleConns: len(c.conns),
+	}
+	if c.connsWait != nil {
+		s.WaitingRequests = c.connsWait.len()
+	}
+	if len(c.conns) > 0 {
+		now := time.Now()
+		var total time.Duration
+		for _, cc := range c.conns {
+			age := now.Sub(cc.createdTime)
+			total += age
+			s.OldestIdleConnAge = max(s.OldestIdleConnAge, age)
+		}
+		s.AvgIdleConnAge = total / time.Duration(len(c.conns))
+	}
+	c.connsLock.Unlock()
+
+	s.InUseConns = max(s.Conns-s.IdleConns, 0)
+	s.PendingRequests = c.PendingRequests()
+	s.Dials = c.counters.dials.Load()
+	s.DialErrors = c.counters.dialErrors.Load()
+	s.ConnsAcquired = c.counters.connsAcquired.Load()
+	s.ConnsReused = c.counters.connsReused.Load()
+	s.ClosedIdle = c.counters.closedIdle.Load()
+	s.ClosedLifetime = c.counters.closedLifetime.Load()
+	s.ClosedConnectionClose = c.counters.closedConnectionClose.Load()
+	s.ClosedError = c.counters.closedError.Load()
+	s.IdleConnFillErrors = c.counters.idleConnFillErrors.Load()
+	return s
+}
+
+// Stats returns the connection pool stats aggregated over all the hosts.
+//
+// The counters include the hosts removed from the Client
+// after MaxIdleConnDuration of inactivity.
+func (c *Client) Stats() ConnPoolStats {
+	c.mLock.RLock()
+	s := c.removedStats
+	for _, hc := range c.m {
+		hs := hc.Stats()
+		s.add(&hs)
+	}
+	for _, hc := range c.ms {
+		hs := hc.Stats()
+		s.add(&hs)
+	}
+	c.mLock.RUnlock()
+	return s
+}
+
+// closeConn closes the pool connection cc accounting the reason.
+func (c *HostClient) closeConn(cc *clientConn, reason connCloseReason) {
+	switch reason {
+	case connCloseIdle:
+		c.counters.closedIdle.Add(1)
+	case connCloseLifetime:
+		c.counters.closedLifetime.Add(1)
+	case connCloseConnectionClose:
+		c.counters.closedConnectionClose.Add(1)
+	default:
+		c.counters.closedError.Add(1)
+	}
+	c.decConnsCount()
+	cc.c.Close()
+	releaseClientConn(cc)
+}
+
+// closeConnOnError closes the pool connection cc after err
+// and logs the err.
+func (c *HostClient) closeConnOnError(cc *clientConn, err error) {
+	if c.Logger != nil {
+		addr := c.Addr
+		if ra := cc.c.RemoteAddr(); ra != nil {
+			addr = ra.String()
+		}
+		logRecord(c.Logger, slog.LevelDebug, "HostClient connection closed on error",
+			[]slog.Attr{slog.String("addr", addr), slog.Any("error", err)},
+			"closing connection to %q on error: %v", addr, err)
+	}
+	c.closeConn(cc, connCloseError)
+}
+
+// newClientConn wraps the conn dialed to addr into a pool connection.
+func (c *HostClient) newClientConn(conn net.Conn, addr string) *clientConn {
+	cc := acquireClientConn(conn)
+	cc.addr = addr
+	if d := c.MaxConnDuration; d > 0 {
+		if jitter := c.MaxConnDurationJitter; jitter > 0 {
+			// Connections dialed together mustn't expire together.
+			d -= rand.N(min(jitter, d))
+		}
+		cc.maxDuration = d
+	}
+	return cc
+}
+
+// expired returns whether the connection has exceeded its lifetime.
+func (cc *clientConn) expired() bool {
+	return cc.maxDuration > 0 && time.Since(cc.createdTime) > cc.maxDuration
+}
+
+// Prewarm dials connections until there are MinIdleConns idle connections
+// in the pool. It returns the first dial error.
+//
+// The pool is refilled in background after the first request,
+// so Prewarm is needed only for avoiding the dial latency
+// of the first requests.
+func (c *HostClient) Prewarm(ctx context.Context) error {
+	return c.fillIdleConns(ctx)
+}
+
+// maybeFillIdleConns starts refilling the idle connections
+// in background if there are less than MinIdleConns of them.
+//
+// Refilling is backed off exponentially after failures, so requests
+// to an unavailable host don't start a dial each.
+//
+// c.connsLock must be held.
+func (c *HostClient) maybeFillIdleConns() {
+	if c.MinIdleConns <= 0 || len(c.conns) >= c.MinIdleConns {
+		return
+	}
+	if retryAt := c.fillRetryAt.Load(); retryAt != 0 && time.Now().UnixNano() < retryAt {
+		return
+	}
+	if c.filling.CompareAndSwap(false, true) {
+		go func() {
+			if err := c.fillIdleConns(context.Background()); err != nil {
+				c.counters.idleConnFillErrors.Add(1)
+				failures := c.fillFailures.Add(1)
+				backoff := min(minIdleConnsFillBackoff<<min(failures-1, 16), maxIdleConnsFillBackoff)
+				c.fillRetryAt.Store(time.Now().Add(backoff).UnixNano())
+			} else {
+				c.fillFailures.Store(0)
+				c.fillRetryAt.Store(0)
+			}
+			c.filling.Store(false)
+		}()
+	}
+}
+
+func (c *HostClient) fillIdleConns(ctx context.Context) error {
+	c.fillMu.Lock()
+	defer c.fillMu.Unlock()
+
+	for {
+		c.connsLock.Lock()
+		maxConns := c.MaxConns
+		if maxConns <= 0 {
+			maxConns = DefaultMaxConnsPerHost
+		}
+		if len(c.conns) >= c.MinIdleConns || c.connsCount >= maxConns {
+			c.connsLock.Unlock()
+			return nil
+		}
+		c.connsCount++
+		startCleaner := !c.connsCleanerRun
+		c.connsCleanerRun = true
+		c.connsLock.Unlock()
+
+		if startCleaner {
+			go c.connsCleaner()
+		}
+		conn, addr, err := c.dialHostHard(ctx, 0, nil)
+		if err != nil {
+			c.decConnsCount()
+			return err
+		}
+		c.ReleaseConn(c.newClientConn(conn, addr))
+	}
+}
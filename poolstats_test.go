@@ -0,0 +1,255 @@
+package fasthttp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func waitForStats(t *testing.T, c *HostClient, cond func(s ConnPoolStats) bool) ConnPoolStats {
+	t.Helper()
+
+	s := c.Stats()
+	for i := 0; i < 100 && !cond(s); i++ {
+		time.Sleep(10 * time.Millisecond)
+		s = c.Stats()
+	}
+	if !cond(s) {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	return s
+}
+
+func TestHostClientStats(t *testing.T) {
+	t.Parallel()
+
+	ln := startRetryServer(t, func(ctx *RequestCtx) {})
+	c := &HostClient{
+		Addr: "example.com",
+		Dial: func(string) (net.Conn, error) { return ln.Dial() },
+	}
+	for i := 0; i < 3; i++ {
+		if _, _, err := c.Get(nil, "http://example.com/"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	s := c.Stats()
+	if s.Conns != 1 || s.IdleConns != 1 || s.InUseConns != 0 || s.Dials != 1 || s.DialErrors != 0 ||
+		s.ConnsAcquired != 3 || s.ConnsReused != 2 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	if r := s.ReuseRatio(); r < 0.6 || r > 0.7 {
+		t.Fatalf("unexpected reuse ratio %v", r)
+	}
+	if s.OldestIdleConnAge <= 0 || s.AvgIdleConnAge != s.OldestIdleConnAge {
+		t.Fatalf("unexpected idle connection ages %+v", s)
+	}
+
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.SetRequestURI("http://example.com/")
+	req.SetConnectionClose()
+	if err := c.Do(req, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s := c.Stats(); s.Conns != 0 || s.ClosedConnectionClose != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	if _, _, err := c.Get(nil, "http://example.com/"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.CloseIdleConnections()
+	if s := c.Stats(); s.Conns != 0 || s.ClosedIdle != 1 || s.Dials != 2 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	errDial := errors.New("dial failure")
+	c = &HostClient{
+		Addr: "example.com",
+		Dial: func(string) (net.Conn, error) { return nil, errDial },
+	}
+	if _, _, err := c.Get(nil, "http://example.com/"); !errors.Is(err, errDial) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errDial)
+	}
+	if s := c.Stats(); s.Dials == 0 || s.DialErrors != s.Dials || s.Conns != 0 || s.ConnsAcquired != 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestConnPoolStatsAddAges(t *testing.T) {
+	t.Parallel()
+
+	s := ConnPoolStats{IdleConns: 1, OldestIdleConnAge: 4 * time.Second, AvgIdleConnAge: 4 * time.Second}
+	s.add(&ConnPoolStats{IdleConns: 3, OldestIdleConnAge: 3 * time.Second, AvgIdleConnAge: 2 * time.Second})
+	if s.OldestIdleConnAge != 4*time.Second || s.AvgIdleConnAge != 2500*time.Millisecond {
+		t.Fatalf("unexpected idle connection ages %+v", s)
+	}
+}
+
+func TestHostClientMinIdleConnsFillBackoff(t *testing.T) {
+	t.Parallel()
+
+	errDial := errors.New("dial failure")
+	c := &HostClient{
+		Addr:         "example.com",
+		Dial:         func(string) (net.Conn, error) { return nil, errDial },
+		MinIdleConns: 2,
+	}
+	c.Get(nil, "http://example.com/") //nolint:errcheck
+	waitForStats(t, c, func(s ConnPoolStats) bool {
+		return s.IdleConnFillErrors == 1
+	})
+
+	// Requests during the backoff don't start refilling.
+	for i := 0; i < 10; i++ {
+		c.Get(nil, "http://example.com/") //nolint:errcheck
+	}
+	if s := c.Stats(); s.IdleConnFillErrors != 1 || s.Dials != 12 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	// Refilling is retried after the backoff.
+	waitForStats(t, c, func(s ConnPoolStats) bool {
+		if s.IdleConnFillErrors < 2 {
+			c.Get(nil, "http://example.com/") //nolint:errcheck
+		}
+		return s.IdleConnFillErrors >= 2
+	})
+}
+
+func TestHostClientStatsWaiting(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	ln := startRetryServer(t, func(ctx *RequestCtx) {
+		<-release
+	})
+	c := &HostClient{
+		Addr:               "example.com",
+		Dial:               func(string) (net.Conn, error) { return ln.Dial() },
+		MaxConns:           1,
+		MaxConnWaitTimeout: 5 * time.Second,
+	}
+	errCh := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, _, err := c.Get(nil, "http://example.com/")
+			errCh <- err
+		}()
+	}
+	waitForStats(t, c, func(s ConnPoolStats) bool {
+		return s.Conns == 1 && s.InUseConns == 1 && s.WaitingRequests == 1 && s.PendingRequests == 2
+	})
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errCh; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if s := c.Stats(); s.WaitingRequests != 0 || s.ConnsReused != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestHostClientMaxConnDurationJitter(t *testing.T) {
+	t.Parallel()
+
+	c := &HostClient{
+		MaxConnDuration:       time.Minute,
+		MaxConnDurationJitter: 10 * time.Second,
+	}
+	durations := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		cc := c.newClientConn(nil, "")
+		if cc.maxDuration <= 50*time.Second || cc.maxDuration > time.Minute {
+			t.Fatalf("unexpected connection duration %s", cc.maxDuration)
+		}
+		durations[cc.maxDuration] = true
+		releaseClientConn(cc)
+	}
+	if len(durations) < 50 {
+		t.Fatalf("too few distinct connection durations: %d", len(durations))
+	}
+
+	ln := startRetryServer(t, func(ctx *RequestCtx) {})
+	c = &HostClient{
+		Addr:                  "example.com",
+		Dial:                  func(string) (net.Conn, error) { return ln.Dial() },
+		MaxConnDuration:       10 * time.Millisecond,
+		MaxConnDurationJitter: 5 * time.Millisecond,
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, err := c.Get(nil, "http://example.com/"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if s := c.Stats(); s.ClosedLifetime != 1 || s.Conns != 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestHostClientMinIdleConns(t *testing.T) {
+	t.Parallel()
+
+	ln := startRetryServer(t, func(ctx *RequestCtx) {})
+	c := &HostClient{
+		Addr:                "example.com",
+		Dial:                func(string) (net.Conn, error) { return ln.Dial() },
+		MinIdleConns:        3,
+		MaxIdleConnDuration: 10 * time.Millisecond,
+	}
+	if err := c.Prewarm(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s := c.Stats(); s.IdleConns != 3 || s.Dials != 3 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	// Idle connections aren't closed below MinIdleConns.
+	time.Sleep(50 * time.Millisecond)
+	if s := c.Stats(); s.IdleConns != 3 || s.ClosedIdle != 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	// The acquired connections are replaced in background.
+	var cs []*clientConn
+	for i := 0; i < 3; i++ {
+		cc, err := c.AcquireConn(time.Second, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		cs = append(cs, cc)
+	}
+	waitForStats(t, c, func(s ConnPoolStats) bool {
+		return s.IdleConns == 3 && s.InUseConns == 3
+	})
+	for _, cc := range cs {
+		c.ReleaseConn(cc)
+	}
+
+	// The surplus idle connections are closed.
+	waitForStats(t, c, func(s ConnPoolStats) bool {
+		return s.IdleConns == 3 && s.ClosedIdle == 3
+	})
+}
+
+func TestClientStats(t *testing.T) {
+	t.Parallel()
+
+	ln := startRetryServer(t, func(ctx *RequestCtx) {})
+	c := &Client{
+		Dial: func(string) (net.Conn, error) { return ln.Dial() },
+	}
+	for _, url := range []string{"http://foo.com/", "http://bar.com/", "http://foo.com/"} {
+		if _, _, err := c.Get(nil, url); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if s := c.Stats(); s.Conns != 2 || s.IdleConns != 2 || s.Dials != 2 || s.ConnsAcquired != 3 || s.ConnsReused != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
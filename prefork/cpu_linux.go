@@ -0,0 +1,53 @@
+//go:build linux
+
+package prefork
+
+import (
+	"os/exec"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// startPinned starts cmd pinned to a CPU chosen by the child id
+// among the CPUs available to the master process.
+//
+// The child process inherits the CPU affinity of the thread starting it,
+// so the affinity is changed for the locked thread during cmd.Start.
+func startPinned(cmd *exec.Cmd, id int) error {
+	runtime.LockOSThread()
+
+	var old unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &old); err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	var cpus []int
+	for cpu := 0; len(cpus) < old.Count(); cpu++ {
+		if old.IsSet(cpu) {
+			cpus = append(cpus, cpu)
+		}
+	}
+	if len(cpus) == 0 {
+		runtime.UnlockOSThread()
+		return cmd.Start()
+	}
+
+	var set unix.CPUSet
+	set.Set(cpus[id%len(cpus)])
+	if err := unix.SchedSetaffinity(0, &set); err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	err := cmd.Start()
+	if e := unix.SchedSetaffinity(0, &old); e != nil {
+		// Keep the thread locked, so it isn't reused
+		// by other goroutines with the wrong affinity.
+		if err == nil {
+			err = e
+		}
+		return err
+	}
+	runtime.UnlockOSThread()
+	return err
+}
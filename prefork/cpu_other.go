@@ -0,0 +1,10 @@
+//go:build !linux
+
+package prefork
+
+import "os/exec"
+
+// startPinned starts cmd. CPU pinning is supported on Linux only.
+func startPinned(cmd *exec.Cmd, _ int) error {
+	return cmd.Start()
+}
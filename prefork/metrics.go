@@ -0,0 +1,398 @@
+package prefork
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/bhargawpradhan/fasthttp"
+)
+
+const (
+	preforkChildIDEnvVariable = "FASTHTTP_PREFORK_CHILD_ID"
+	preforkMetricsEnvVariable = "FASTHTTP_PREFORK_METRICS"
+
+	// metricsMagic marks the shared metrics file ("fhprmtr1").
+	metricsMagic = 0x3172746d72706866
+
+	// metricsHeaderWords is the number of uint64 words in the file header:
+	// the magic, the number of slots and the number of words per slot.
+	metricsHeaderWords = 3
+)
+
+// ErrMetricsLayout is returned by a child prefork process if the shared
+// metrics file has been created for different metrics. The master and
+// the child processes must register the same metrics in the same order.
+var ErrMetricsLayout = errors.New("shared metrics layout mismatch")
+
+var errMmapUnsupported = errors.New("shared metrics aren't supported on this platform")
+
+// ChildID returns the slot of the child prefork process in [0..Children).
+//
+// The replacement of a child keeps its slot during rolling restart
+// and after a crash. -1 is returned if the current process isn't a child.
+func ChildID() int {
+	if !IsChild() {
+		return -1
+	}
+	id, err := strconv.Atoi(os.Getenv(preforkChildIDEnvVariable))
+	if err != nil {
+		return -1
+	}
+	return id
+}
+
+// Metrics is a set of counters and histograms shared by the prefork
+// processes via mmap'd file, so the values written by the child processes
+// may be read as per-child and combined stats by the master or any child.
+//
+// The metrics must be registered via Counter and Histogram in the same
+// order in all the processes before calling Prefork.ListenAndServe,
+// e.g. during program initialization. The values are updated
+// with atomic operations without locks.
+//
+// Every child slot accumulates the values across restarts of the child.
+//
+// The metrics are shared on Unix only. Every process reports its own
+// values on other platforms and before ListenAndServe is called.
+type Metrics struct {
+	// Path is the path to the file shared by the processes.
+	//
+	// By default a temporary file removed after the master process
+	// returns is used.
+	Path string
+
+	counters   []*Counter
+	histograms []*Histogram
+	names      map[string]struct{}
+	words      int
+
+	// values are the words of the current process slot.
+	values atomic.Pointer[[]uint64]
+	// shared are all the words of the shared file.
+	shared atomic.Pointer[[]uint64]
+
+	mu sync.Mutex
+}
+
+// NewMetrics returns an empty set of metrics.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		names: make(map[string]struct{}),
+	}
+	m.values.Store(&[]uint64{})
+	return m
+}
+
+// Counter is a monotonically increasing counter.
+type Counter struct {
+	m    *Metrics
+	name string
+	idx  int
+}
+
+// Counter registers a new counter with the given name.
+//
+// It panics if the name is already registered or the metrics are in use.
+func (m *Metrics) Counter(name string) *Counter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := &Counter{m: m, name: name, idx: m.register(name, 1)}
+	m.counters = append(m.counters, c)
+	return c
+}
+
+// Add adds n to the counter.
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&(*c.m.values.Load())[c.idx], n)
+}
+
+// Inc increments the counter.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Histogram counts observations in the buckets.
+type Histogram struct {
+	m       *Metrics
+	name    string
+	buckets []float64
+	idx     int
+}
+
+// Histogram registers a new histogram with the given name and the sorted
+// upper bounds of the buckets. Observations above the last bound are counted
+// in the implicit +Inf bucket.
+//
+// It panics if the name is already registered or the metrics are in use.
+func (m *Metrics) Histogram(name string, buckets []float64) *Histogram {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("BUG: histogram %q buckets must be sorted", name))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The words are the counts of the buckets including +Inf
+	// and the sum of the observations.
+	h := &Histogram{
+		m:       m,
+		name:    name,
+		buckets: append([]float64(nil), buckets...),
+		idx:     m.register(name, len(buckets)+2),
+	}
+	m.histograms = append(m.histograms, h)
+	return h
+}
+
+// Observe adds v to the histogram.
+func (h *Histogram) Observe(v float64) {
+	values := *h.m.values.Load()
+	i := sort.SearchFloat64s(h.buckets, v)
+	atomic.AddUint64(&values[h.idx+i], 1)
+
+	sum := &values[h.idx+len(h.buckets)+1]
+	for {
+		old := atomic.LoadUint64(sum)
+		if atomic.CompareAndSwapUint64(sum, old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (m *Metrics) register(name string, words int) int {
+	if m.shared.Load() != nil {
+		panic(fmt.Sprintf("BUG: metric %q is registered after the metrics are shared", name))
+	}
+	if _, ok := m.names[name]; ok {
+		panic(fmt.Sprintf("BUG: metric %q is already registered", name))
+	}
+	m.names[name] = struct{}{}
+
+	idx := m.words
+	m.words += words
+	values := make([]uint64, m.words)
+	copy(values, *m.values.Load())
+	m.values.Store(&values)
+	return idx
+}
+
+// slotWords returns the number of words per child slot: the pid
+// of the child and the metric values.
+func (m *Metrics) slotWords() int {
+	return 1 + m.words
+}
+
+// create creates the shared file with the given number of slots
+// in the master process. It returns the path to the file and whether
+// the file must be removed after the master process returns.
+func (m *Metrics) create(slots int) (path string, temp bool, err error) {
+	var f *os.File
+	if m.Path != "" {
+		f, err = os.OpenFile(m.Path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	} else {
+		f, err = os.CreateTemp("", "fasthttp-prefork-metrics-*")
+		temp = true
+	}
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+
+	words := metricsHeaderWords + slots*m.slotWords()
+	if err = f.Truncate(int64(words * 8)); err != nil {
+		return "", false, err
+	}
+	shared, err := mmapFile(f, words*8)
+	if err != nil {
+		if temp {
+			os.Remove(f.Name())
+		}
+		return "", false, err
+	}
+	atomic.StoreUint64(&shared[1], uint64(slots))
+	atomic.StoreUint64(&shared[2], uint64(m.slotWords()))
+	atomic.StoreUint64(&shared[0], metricsMagic)
+	m.shared.Store(&shared)
+	return f.Name(), temp, nil
+}
+
+// open maps the shared file created by the master process
+// in the child process with the given id.
+func (m *Metrics) open(path string, id int) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	shared, err := mmapFile(f, int(fi.Size()))
+	if err != nil {
+		return err
+	}
+	if len(shared) < metricsHeaderWords ||
+		atomic.LoadUint64(&shared[0]) != metricsMagic ||
+		atomic.LoadUint64(&shared[2]) != uint64(m.slotWords()) {
+		return ErrMetricsLayout
+	}
+	slots := int(atomic.LoadUint64(&shared[1]))
+	if id < 0 || id >= slots || len(shared) < metricsHeaderWords+slots*m.slotWords() {
+		return ErrMetricsLayout
+	}
+
+	slot := shared[metricsHeaderWords+id*m.slotWords():][:m.slotWords()]
+	atomic.StoreUint64(&slot[0], uint64(os.Getpid()))
+	// The values written before the file is mapped stay local.
+	values := slot[1:]
+	m.values.Store(&values)
+	m.shared.Store(&shared)
+	return nil
+}
+
+// wordsOf returns the uint64 words of the mapped memory b.
+func wordsOf(b []byte) []uint64 {
+	if len(b) < 8 {
+		return nil
+	}
+	return unsafe.Slice((*uint64)(unsafe.Pointer(&b[0])), len(b)/8)
+}
+
+// MetricsSnapshot contains the metric values of every child prefork
+// process and the combined values.
+type MetricsSnapshot struct {
+	Total    MetricValues   `json:"total"`
+	Children []ChildMetrics `json:"children"`
+}
+
+// ChildMetrics contains the metric values of the child prefork process.
+type ChildMetrics struct {
+	MetricValues
+
+	// ID is the slot of the child. See ChildID.
+	ID int `json:"id"`
+
+	// PID is the process id of the last child started in the slot.
+	PID int `json:"pid"`
+}
+
+// MetricValues contains the values of the counters and the histograms
+// by their names.
+type MetricValues struct {
+	Counters   map[string]uint64            `json:"counters"`
+	Histograms map[string]HistogramSnapshot `json:"histograms"`
+}
+
+// HistogramSnapshot contains the values of the histogram.
+type HistogramSnapshot struct {
+	// Buckets contain the cumulative counts of the observations
+	// less than or equal to the upper bounds.
+	Buckets []HistogramBucket `json:"buckets"`
+
+	// Count is the number of the observations including
+	// the observations above the last bucket.
+	Count uint64 `json:"count"`
+
+	// Sum is the sum of the observations.
+	Sum float64 `json:"sum"`
+}
+
+// HistogramBucket contains the cumulative count of the observations
+// less than or equal to UpperBound.
+type HistogramBucket struct {
+	UpperBound float64 `json:"le"`
+	Count      uint64  `json:"count"`
+}
+
+// Snapshot returns the current metric values.
+//
+// The children are reported only if the metrics are shared.
+// Otherwise the values of the current process are reported
+// as a single child.
+func (m *Metrics) Snapshot() *MetricsSnapshot {
+	total := m.newValues()
+	s := &MetricsSnapshot{Total: total}
+
+	shared := m.shared.Load()
+	if shared == nil {
+		cm := ChildMetrics{MetricValues: m.newValues(), ID: max(ChildID(), 0), PID: os.Getpid()}
+		m.addValues(&cm.MetricValues, *m.values.Load())
+		m.addValues(&total, *m.values.Load())
+		s.Children = append(s.Children, cm)
+		return s
+	}
+
+	words := *shared
+	slots := int(atomic.LoadUint64(&words[1]))
+	for id := 0; id < slots; id++ {
+		slot := words[metricsHeaderWords+id*m.slotWords():][:m.slotWords()]
+		pid := atomic.LoadUint64(&slot[0])
+		if pid == 0 {
+			// The child hasn't been started yet.
+			continue
+		}
+		cm := ChildMetrics{MetricValues: m.newValues(), ID: id, PID: int(pid)}
+		m.addValues(&cm.MetricValues, slot[1:])
+		m.addValues(&total, slot[1:])
+		s.Children = append(s.Children, cm)
+	}
+	return s
+}
+
+func (m *Metrics) newValues() MetricValues {
+	v := MetricValues{
+		Counters:   make(map[string]uint64, len(m.counters)),
+		Histograms: make(map[string]HistogramSnapshot, len(m.histograms)),
+	}
+	for _, c := range m.counters {
+		v.Counters[c.name] = 0
+	}
+	for _, h := range m.histograms {
+		buckets := make([]HistogramBucket, len(h.buckets))
+		for i, b := range h.buckets {
+			buckets[i].UpperBound = b
+		}
+		v.Histograms[h.name] = HistogramSnapshot{Buckets: buckets}
+	}
+	return v
+}
+
+// addValues adds the slot values to v.
+func (m *Metrics) addValues(v *MetricValues, values []uint64) {
+	for _, c := range m.counters {
+		v.Counters[c.name] += atomic.LoadUint64(&values[c.idx])
+	}
+	for _, h := range m.histograms {
+		hs := v.Histograms[h.name]
+		var cumulative uint64
+		for i := range h.buckets {
+			cumulative += atomic.LoadUint64(&values[h.idx+i])
+			hs.Buckets[i].Count += cumulative
+		}
+		hs.Count += cumulative + atomic.LoadUint64(&values[h.idx+len(h.buckets)])
+		hs.Sum += math.Float64frombits(atomic.LoadUint64(&values[h.idx+len(h.buckets)+1]))
+		v.Histograms[h.name] = hs
+	}
+}
+
+// Handler writes the JSON representation of Snapshot to the response.
+func (m *Metrics) Handler(ctx *fasthttp.RequestCtx) {
+	b, err := json.Marshal(m.Snapshot())
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json; charset=utf-8")
+	ctx.SetBody(b)
+}
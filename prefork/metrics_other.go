@@ -0,0 +1,10 @@
+//go:build !unix
+
+package prefork
+
+import "os"
+
+// mmapFile isn't supported on this platform.
+func mmapFile(*os.File, int) ([]uint64, error) {
+	return nil, errMmapUnsupported
+}
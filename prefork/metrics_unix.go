@@ -0,0 +1,18 @@
+//go:build unix
+
+package prefork
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// mmapFile maps size bytes of f shared with other processes.
+func mmapFile(f *os.File, size int) ([]uint64, error) {
+	b, err := unix.Mmap(int(f.Fd()), 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	return wordsOf(b), nil
+}
@@ -0,0 +1,114 @@
+//go:build unix
+
+package prefork
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bhargawpradhan/fasthttp"
+)
+
+type testMetrics struct {
+	*Metrics
+	requests *Counter
+	latency  *Histogram
+}
+
+func newTestMetrics() *testMetrics {
+	m := NewMetrics()
+	return &testMetrics{
+		Metrics:  m,
+		requests: m.Counter("requests"),
+		latency:  m.Histogram("latency", []float64{0.1, 1}),
+	}
+}
+
+func TestMetricsLocal(t *testing.T) {
+	t.Parallel()
+
+	m := newTestMetrics()
+	m.requests.Add(2)
+	m.latency.Observe(0.5)
+
+	s := m.Snapshot()
+	if len(s.Children) != 1 || s.Children[0].PID != os.Getpid() {
+		t.Fatalf("unexpected children %+v", s.Children)
+	}
+	if n := s.Total.Counters["requests"]; n != 2 {
+		t.Fatalf("unexpected requests %d. Expecting %d", n, 2)
+	}
+	if h := s.Total.Histograms["latency"]; h.Count != 1 || h.Sum != 0.5 || h.Buckets[0].Count != 0 || h.Buckets[1].Count != 1 {
+		t.Fatalf("unexpected latency %+v", h)
+	}
+}
+
+func TestMetricsShared(t *testing.T) {
+	t.Parallel()
+
+	master := newTestMetrics()
+	master.Path = filepath.Join(t.TempDir(), "metrics")
+	path, temp, err := master.create(3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != master.Path || temp {
+		t.Fatalf("unexpected file %q, temp %v", path, temp)
+	}
+
+	children := make([]*testMetrics, 2)
+	for id := range children {
+		children[id] = newTestMetrics()
+		// The values written before the file is mapped stay local.
+		children[id].requests.Add(100)
+		if err = children[id].open(path, id); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	children[0].requests.Inc()
+	children[0].latency.Observe(0.05)
+	children[1].requests.Add(2)
+	children[1].latency.Observe(0.5)
+	children[1].latency.Observe(5)
+
+	for _, m := range []*testMetrics{master, children[0], children[1]} {
+		s := m.Snapshot()
+		if len(s.Children) != 2 {
+			t.Fatalf("unexpected children %+v", s.Children)
+		}
+		for id, c := range s.Children {
+			if c.ID != id || c.PID != os.Getpid() || c.Counters["requests"] != uint64(id+1) {
+				t.Fatalf("unexpected child %+v", c)
+			}
+		}
+		if n := s.Total.Counters["requests"]; n != 3 {
+			t.Fatalf("unexpected requests %d. Expecting %d", n, 3)
+		}
+		h := s.Total.Histograms["latency"]
+		if h.Count != 3 || h.Sum != 5.55 || h.Buckets[0].Count != 1 || h.Buckets[1].Count != 2 {
+			t.Fatalf("unexpected latency %+v", h)
+		}
+	}
+
+	var ctx fasthttp.RequestCtx
+	master.Handler(&ctx)
+	var s MetricsSnapshot
+	if err = json.Unmarshal(ctx.Response.Body(), &s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(s.Children) != 2 || s.Total.Counters["requests"] != 3 || s.Total.Histograms["latency"].Buckets[1].UpperBound != 1 {
+		t.Fatalf("unexpected snapshot %s", ctx.Response.Body())
+	}
+
+	other := NewMetrics()
+	other.Counter("requests")
+	if err = other.open(path, 0); !errors.Is(err, ErrMetricsLayout) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrMetricsLayout)
+	}
+	if err = newTestMetrics().open(path, 3); !errors.Is(err, ErrMetricsLayout) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrMetricsLayout)
+	}
+}
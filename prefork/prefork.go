@@ -2,22 +2,39 @@
 package prefork
 
 import (
+	"context"
 	"errors"
 	"log"
+	"log/slog"
 	"net"
 	"os"
-	"os/exec"
+	"os/signal"
 	"runtime"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/bhargawpradhan/fasthttp"
+	"github.com/bhargawpradhan/fasthttp/internal/logutil"
 	"github.com/bhargawpradhan/fasthttp/reuseport"
 )
 
 const (
 	preforkChildEnvVariable = "FASTHTTP_PREFORK_CHILD"
+	preforkReadyEnvVariable = "FASTHTTP_PREFORK_READY_FD"
 	defaultNetwork          = "tcp4"
 )
 
+const (
+	// DefaultReadyTimeout is the default time to wait for a child
+	// prefork process to start listening during rolling restart.
+	DefaultReadyTimeout = 10 * time.Second
+
+	// DefaultShutdownTimeout is the default time given to a child
+	// prefork process for graceful shutdown.
+	DefaultShutdownTimeout = 30 * time.Second
+)
+
 var (
 	defaultLogger = Logger(log.New(os.Stderr, "", log.LstdFlags))
 	// ErrOverRecovery is returned when the times of starting over child prefork processes exceed
@@ -26,6 +43,12 @@ var (
 
 	// ErrOnlyReuseportOnWindows is returned when Reuseport is false.
 	ErrOnlyReuseportOnWindows = errors.New("windows only supports Reuseport = true")
+
+	// ErrChildNotReady is logged when a child prefork process started
+	// during rolling restart exits or doesn't become ready in time.
+	ErrChildNotReady = errors.New("child prefork process isn't ready")
+
+	errShuttingDown = errors.New("the master process is shutting down")
 )
 
 // Logger is used for logging formatted messages.
@@ -42,6 +65,11 @@ type Logger interface {
 //
 // WARNING: using prefork prevents the use of any global state!
 // Things like in-memory caches won't work.
+//
+// The master process restarts the child processes one at a time
+// on SIGHUP: a new child is started, and the old one is shut down
+// gracefully via ShutdownFunc after the new one is ready to accept
+// connections. Child lifecycle events are reported to Logger.
 type Prefork struct {
 	// By default standard logger from log package is used.
 	Logger Logger
@@ -52,6 +80,51 @@ type Prefork struct {
 	ServeTLSFunc      func(ln net.Listener, certFile, keyFile string) error
 	ServeTLSEmbedFunc func(ln net.Listener, certData, keyData []byte) error
 
+	// ShutdownFunc is called by a child prefork process on SIGTERM
+	// for graceful shutdown, e.g. during rolling restart.
+	//
+	// The child process is terminated by SIGTERM if ShutdownFunc is nil.
+	ShutdownFunc func(ctx context.Context) error
+
+	// ShutdownTimeout limits the duration of ShutdownFunc call.
+	// The master process kills the child process being restarted
+	// if it doesn't exit in time.
+	//
+	// By default DefaultShutdownTimeout is used.
+	ShutdownTimeout time.Duration
+
+	// ReadyTimeout is the maximum duration to wait for a child prefork
+	// process started during rolling restart to start listening.
+	// Rolling restart is aborted if the child isn't ready in time.
+	//
+	// By default DefaultReadyTimeout is used.
+	ReadyTimeout time.Duration
+
+	// Children is the number of child prefork processes.
+	//
+	// By default runtime.GOMAXPROCS(0) child processes are started.
+	Children int
+
+	// ForwardSignals are the signals forwarded by the master process
+	// to all the child processes.
+	//
+	// The master process waits for the child processes to exit and
+	// returns if os.Interrupt or syscall.SIGTERM is forwarded.
+	// Rolling restart on SIGHUP is disabled if syscall.SIGHUP is forwarded.
+	//
+	// By default no signals are forwarded.
+	ForwardSignals []os.Signal
+
+	// Metrics are shared by the child prefork processes if set.
+	// See Metrics for details.
+	Metrics *Metrics
+
+	// PinCPUs pins every child prefork process to a single CPU
+	// from the CPUs available to the master process via sched_setaffinity.
+	//
+	// It is supported on Linux only and ignored on other platforms.
+	PinCPUs bool
+
 	// The network must be "tcp", "tcp4" or "tcp6".
 	//
 	// By default is "tcp4"
@@ -84,7 +157,29 @@ func New(s *fasthttp.Server) *Prefork {
 		ServeFunc:         s.Serve,
 		ServeTLSFunc:      s.ServeTLS,
 		ServeTLSEmbedFunc: s.ServeTLSEmbed,
+		ShutdownFunc:      s.ShutdownWithContext,
+	}
+}
+
+func (p *Prefork) children() int {
+	if p.Children > 0 {
+		return p.Children
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+func (p *Prefork) shutdownTimeout() time.Duration {
+	if p.ShutdownTimeout > 0 {
+		return p.ShutdownTimeout
+	}
+	return DefaultShutdownTimeout
+}
+
+func (p *Prefork) readyTimeout() time.Duration {
+	if p.ReadyTimeout > 0 {
+		return p.ReadyTimeout
 	}
+	return DefaultReadyTimeout
 }
 
 func (p *Prefork) logger() Logger {
@@ -94,6 +189,13 @@ func (p *Prefork) logger() Logger {
 	return defaultLogger
 }
 
+// logRecord logs the record with the given attributes if the logger is
+// fasthttp.StructuredLogger. Otherwise the format and args are passed
+// to Printf. See logutil.Record.
+func (p *Prefork) logRecord(level slog.Level, msg string, attrs []slog.Attr, format string, args ...any) {
+	logutil.Record(p.logger(), level, msg, attrs, format, args...)
+}
+
 func (p *Prefork) listen(addr string) (net.Listener, error) {
 	runtime.GOMAXPROCS(1)
 
@@ -135,17 +237,6 @@ func (p *Prefork) setTCPListenerFiles(addr string) error {
 	return nil
 }
 
-func (p *Prefork) doCommand() (*exec.Cmd, error) {
-	// #nosec G204
-	cmd := exec.Command(os.Args[0], os.Args[1:]...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Env = append(os.Environ(), preforkChildEnvVariable+"=1")
-	cmd.ExtraFiles = p.files
-	err := cmd.Start()
-	return cmd, err
-}
-
 func (p *Prefork) prefork(addr string) (err error) {
 	if !p.Reuseport {
 		if runtime.GOOS == "windows" {
@@ -165,74 +256,188 @@ func (p *Prefork) prefork(addr string) (err error) {
 		}()
 	}
 
-	type procSig struct {
-		err error
-		pid int
+	sp := newSupervisor(p)
+	defer sp.stop()
+
+	if p.Metrics != nil {
+		var temp bool
+		sp.metricsPath, temp, err = p.Metrics.create(p.children())
+		switch {
+		case errors.Is(err, errMmapUnsupported):
+			p.logRecord(slog.LevelWarn, "prefork metrics aren't shared", []slog.Attr{slog.Any("error", err)},
+				"prefork metrics aren't shared: %v", err)
+			err = nil
+		case err != nil:
+			return err
+		case temp:
+			defer os.Remove(sp.metricsPath)
+		}
+	}
+
+	sigs := []os.Signal{syscall.SIGHUP}
+	sigs = append(sigs, p.ForwardSignals...)
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, sigs...)
+	defer signal.Stop(sigCh)
+
+	for id := 0; id < p.children(); id++ {
+		if _, err = sp.start(id, false); err != nil {
+			p.logRecord(slog.LevelError, "failed to start a child prefork process",
+				[]slog.Attr{slog.Int("id", id), slog.Any("error", err)},
+				"failed to start a child prefork process, error: %v\n", err)
+			return err
+		}
 	}
 
-	goMaxProcs := runtime.GOMAXPROCS(0)
-	sigCh := make(chan procSig, goMaxProcs)
-	childProcs := make(map[int]*exec.Cmd)
+	var exitedProcs int
+	for {
+		select {
+		case sig := <-sigCh:
+			if sig == syscall.SIGHUP && !p.forwards(syscall.SIGHUP) {
+				sp.rollingRestart()
+				continue
+			}
+			p.logRecord(slog.LevelInfo, "forwarding signal to child prefork processes",
+				[]slog.Attr{slog.String("signal", sig.String())},
+				"forwarding signal %s to child prefork processes", sig)
+			sp.signal(sig)
+			if sig == os.Interrupt || sig == syscall.SIGTERM {
+				sp.shutdown()
+			}
 
-	defer func() {
-		for _, proc := range childProcs {
-			_ = proc.Process.Kill()
+		case ps := <-sp.exitCh:
+			c := ps.c
+			st := sp.remove(c)
+			switch {
+			case st.draining:
+				p.logRecord(slog.LevelInfo, "child prefork process drained",
+					[]slog.Attr{slog.Int("pid", c.pid()), slog.Int("id", c.id), slog.Any("error", ps.err)},
+					"child prefork process %d drained", c.pid())
+			case st.shuttingDown:
+				p.logRecord(slog.LevelInfo, "child prefork process exited",
+					[]slog.Attr{slog.Int("pid", c.pid()), slog.Int("id", c.id), slog.Any("error", ps.err)},
+					"child prefork process %d exited: %v", c.pid(), ps.err)
+			case st.pending:
+				// The rolling restart is aborted, the old child keeps running.
+				p.logRecord(slog.LevelWarn, "child prefork process exited before becoming ready",
+					[]slog.Attr{slog.Int("pid", c.pid()), slog.Int("id", c.id), slog.Any("error", ps.err)},
+					"child prefork process %d exited before becoming ready: %v", c.pid(), ps.err)
+			default:
+				exitedProcs++
+				p.logRecord(slog.LevelWarn, "child prefork process exited",
+					[]slog.Attr{
+						slog.Int("pid", c.pid()),
+						slog.Int("id", c.id),
+						slog.Any("error", ps.err),
+						slog.Int("exited", exitedProcs),
+						slog.Int("recover_threshold", p.RecoverThreshold),
+					},
+					"one of the child prefork processes exited with "+
+						"error: %v", ps.err)
+
+				if exitedProcs > p.RecoverThreshold {
+					p.logRecord(slog.LevelError, "child prefork processes exit too many times",
+						[]slog.Attr{slog.Int("exited", exitedProcs), slog.Int("recover_threshold", p.RecoverThreshold)},
+						"child prefork processes exit too many times, "+
+							"which exceeds the value of RecoverThreshold(%d), "+
+							"exiting the master process.\n", exitedProcs)
+					return ErrOverRecovery
+				}
+
+				var nc *child
+				if nc, err = sp.start(c.id, false); err != nil {
+					p.logRecord(slog.LevelError, "failed to restart a child prefork process",
+						[]slog.Attr{slog.Int("exited_pid", c.pid()), slog.Any("error", err)},
+						"failed to restart child prefork process %d: %v", c.pid(), err)
+					return err
+				}
+				p.logRecord(slog.LevelInfo, "child prefork process restarted",
+					[]slog.Attr{slog.Int("exited_pid", c.pid()), slog.Int("pid", nc.pid())},
+					"child prefork process %d restarted as %d", c.pid(), nc.pid())
+			}
+			if st.shuttingDown && st.left == 0 {
+				return nil
+			}
 		}
-	}()
+	}
+}
 
-	for i := 0; i < goMaxProcs; i++ {
-		var cmd *exec.Cmd
-		if cmd, err = p.doCommand(); err != nil {
-			p.logger().Printf("failed to start a child prefork process, error: %v\n", err)
-			return err
+func (p *Prefork) forwards(sig os.Signal) bool {
+	for _, s := range p.ForwardSignals {
+		if s == sig {
+			return true
 		}
+	}
+	return false
+}
 
-		childProcs[cmd.Process.Pid] = cmd
-		go func() {
-			sigCh <- procSig{pid: cmd.Process.Pid, err: cmd.Wait()}
-		}()
+// serveChild serves the connections accepted by the child prefork process.
+func (p *Prefork) serveChild(addr string, serve func(ln net.Listener) error) error {
+	ln, err := p.listen(addr)
+	if err != nil {
+		return err
 	}
 
-	var exitedProcs int
-	for sig := range sigCh {
-		delete(childProcs, sig.pid)
-
-		p.logger().Printf("one of the child prefork processes exited with "+
-			"error: %v", sig.err)
-
-		exitedProcs++
-		if exitedProcs > p.RecoverThreshold {
-			p.logger().Printf("child prefork processes exit too many times, "+
-				"which exceeds the value of RecoverThreshold(%d), "+
-				"exiting the master process.\n", exitedProcs)
-			err = ErrOverRecovery
-			break
-		}
+	p.ln = ln
 
-		var cmd *exec.Cmd
-		if cmd, err = p.doCommand(); err != nil {
-			break
+	if path := os.Getenv(preforkMetricsEnvVariable); p.Metrics != nil && path != "" {
+		if err = p.Metrics.open(path, ChildID()); err != nil {
+			ln.Close()
+			return err
 		}
-		childProcs[cmd.Process.Pid] = cmd
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	shutdownDone := make(chan struct{})
+	serveDone := make(chan struct{})
+	if p.ShutdownFunc != nil {
+		signal.Notify(sigCh, syscall.SIGTERM)
 		go func() {
-			sigCh <- procSig{pid: cmd.Process.Pid, err: cmd.Wait()}
+			defer close(shutdownDone)
+			select {
+			case <-sigCh:
+			case <-serveDone:
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), p.shutdownTimeout())
+			defer cancel()
+			if err := p.ShutdownFunc(ctx); err != nil {
+				p.logRecord(slog.LevelError, "graceful shutdown of child prefork process failed",
+					[]slog.Attr{slog.Int("pid", os.Getpid()), slog.Any("error", err)},
+					"graceful shutdown of child prefork process failed: %v", err)
+			}
 		}()
+	} else {
+		close(shutdownDone)
 	}
 
+	notifyReady()
+	err = serve(ln)
+	close(serveDone)
+	signal.Stop(sigCh)
+	// Wait for the connections to be drained if the shutdown is in progress.
+	<-shutdownDone
 	return err
 }
 
+// notifyReady reports the master process that the child is listening.
+func notifyReady() {
+	fd, err := strconv.Atoi(os.Getenv(preforkReadyEnvVariable))
+	if err != nil {
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	if f == nil {
+		return
+	}
+	f.Write([]byte{1}) //nolint:errcheck
+	f.Close()
+}
+
 // ListenAndServe serves HTTP requests from the given TCP addr.
 func (p *Prefork) ListenAndServe(addr string) error {
 	if IsChild() {
-		ln, err := p.listen(addr)
-		if err != nil {
-			return err
-		}
-
-		p.ln = ln
-
-		return p.ServeFunc(ln)
+		return p.serveChild(addr, p.ServeFunc)
 	}
 
 	return p.prefork(addr)
@@ -243,14 +448,9 @@ func (p *Prefork) ListenAndServe(addr string) error {
 // certFile and keyFile are paths to TLS certificate and key files.
 func (p *Prefork) ListenAndServeTLS(addr, certKey, certFile string) error {
 	if IsChild() {
-		ln, err := p.listen(addr)
-		if err != nil {
-			return err
-		}
-
-		p.ln = ln
-
-		return p.ServeTLSFunc(ln, certFile, certKey)
+		return p.serveChild(addr, func(ln net.Listener) error {
+			return p.ServeTLSFunc(ln, certFile, certKey)
+		})
 	}
 
 	return p.prefork(addr)
@@ -261,14 +461,9 @@ func (p *Prefork) ListenAndServeTLS(addr, certKey, certFile string) error {
 // certData and keyData must contain valid TLS certificate and key data.
 func (p *Prefork) ListenAndServeTLSEmbed(addr string, certData, keyData []byte) error {
 	if IsChild() {
-		ln, err := p.listen(addr)
-		if err != nil {
-			return err
-		}
-
-		p.ln = ln
-
-		return p.ServeTLSEmbedFunc(ln, certData, keyData)
+		return p.serveChild(addr, func(ln net.Listener) error {
+			return p.ServeTLSEmbedFunc(ln, certData, keyData)
+		})
 	}
 
 	return p.prefork(addr)
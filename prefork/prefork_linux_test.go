@@ -0,0 +1,173 @@
+//go:build linux
+
+package prefork
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bhargawpradhan/fasthttp"
+	"golang.org/x/sys/unix"
+)
+
+const supervisionAddrEnvVariable = "FASTHTTP_PREFORK_TEST_ADDR"
+
+type recordLogger struct {
+	msgs []string
+	mu   sync.Mutex
+}
+
+func (l *recordLogger) Printf(format string, args ...any) {
+	l.LogAttrs(context.Background(), slog.LevelInfo, fmt.Sprintf(format, args...))
+}
+
+func (l *recordLogger) LogAttrs(_ context.Context, _ slog.Level, msg string, _ ...slog.Attr) {
+	l.mu.Lock()
+	l.msgs = append(l.msgs, msg)
+	l.mu.Unlock()
+}
+
+func (l *recordLogger) count(msg string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := 0
+	for _, m := range l.msgs {
+		if m == msg {
+			n++
+		}
+	}
+	return n
+}
+
+func (l *recordLogger) wait(t *testing.T, msg string, n int) {
+	t.Helper()
+
+	for i := 0; i < 500; i++ {
+		if l.count(msg) >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%q isn't logged %d times", msg, n)
+}
+
+// serveSupervisionChild serves the requests in the child prefork process
+// started by Test_Supervision.
+func serveSupervisionChild() {
+	m := newTestMetrics()
+	s := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			m.requests.Inc()
+			var set unix.CPUSet
+			if err := unix.SchedGetaffinity(0, &set); err != nil {
+				ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+				return
+			}
+			fmt.Fprintf(ctx, "%d %d", os.Getpid(), set.Count())
+		},
+	}
+	p := New(s)
+	p.Metrics = m.Metrics
+	if err := p.ListenAndServe(os.Getenv(supervisionAddrEnvVariable)); err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func Test_Supervision(t *testing.T) {
+	// This test can't run parallel as it modifies os.Args.
+
+	if IsChild() {
+		serveSupervisionChild()
+	}
+
+	args := os.Args
+	os.Args = []string{os.Args[0], "-test.run=^Test_Supervision$"}
+	defer func() { os.Args = args }()
+
+	addr := getAddr()
+	t.Setenv(supervisionAddrEnvVariable, addr)
+
+	logger := &recordLogger{}
+	m := newTestMetrics()
+	p := &Prefork{
+		Metrics:         m.Metrics,
+		Logger:          logger,
+		Children:        2,
+		PinCPUs:         true,
+		ForwardSignals:  []os.Signal{syscall.SIGTERM},
+		ShutdownTimeout: time.Second,
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.ListenAndServe(addr)
+	}()
+	logger.wait(t, "child prefork process ready", 2)
+
+	c := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	get := func() string {
+		t.Helper()
+
+		resp, err := c.Get("http://" + addr)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		pid, cpus, _ := strings.Cut(string(body), " ")
+		if cpus != "1" {
+			t.Fatalf("child prefork process %s is pinned to %s CPUs, want 1", pid, cpus)
+		}
+		return pid
+	}
+	oldPid := get()
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger.wait(t, "rolling restart of child prefork processes finished", 1)
+	if n := logger.count("child prefork process drained"); n != 2 {
+		t.Fatalf("%d child prefork processes drained, want 2", n)
+	}
+	if n := logger.count("child prefork process ready"); n != 4 {
+		t.Fatalf("%d child prefork processes ready, want 4", n)
+	}
+	for i := 0; i < 10; i++ {
+		if pid := get(); pid == oldPid {
+			t.Fatalf("the request is served by drained child prefork process %s", pid)
+		}
+	}
+
+	// The slots keep the values of the drained children.
+	if n := m.Snapshot().Total.Counters["requests"]; n != 11 {
+		t.Fatalf("unexpected requests %d. Expecting %d", n, 11)
+	}
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	if n := logger.count("child prefork process exited"); n != 2 {
+		t.Fatalf("%d child prefork processes exited, want 2", n)
+	}
+}
@@ -0,0 +1,314 @@
+package prefork
+
+import (
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// child is a child prefork process.
+type child struct {
+	cmd *exec.Cmd
+
+	// ready is closed when the child starts listening.
+	ready chan struct{}
+	// done is closed when the child exits.
+	done chan struct{}
+
+	// id is the slot of the child in [0..Children).
+	id int
+
+	// pending is set until the child started during rolling restart
+	// becomes ready.
+	pending bool
+	// draining is set when the child is being shut down
+	// during rolling restart.
+	draining bool
+}
+
+func (c *child) pid() int {
+	return c.cmd.Process.Pid
+}
+
+type procSig struct {
+	c   *child
+	err error
+}
+
+// supervisor tracks the child prefork processes of the master process.
+type supervisor struct {
+	p *Prefork
+
+	exitCh chan procSig
+	done   chan struct{}
+
+	// metricsPath is the path to the shared Metrics file.
+	metricsPath string
+
+	children     map[int]*child
+	restarting   bool
+	shuttingDown bool
+	mu           sync.Mutex
+}
+
+func newSupervisor(p *Prefork) *supervisor {
+	return &supervisor{
+		p:        p,
+		exitCh:   make(chan procSig),
+		done:     make(chan struct{}),
+		children: make(map[int]*child),
+	}
+}
+
+// start starts the child prefork process with the given id.
+//
+// The child is marked as pending if it replaces a running child
+// during rolling restart.
+func (sp *supervisor) start(id int, pending bool) (*child, error) {
+	p := sp.p
+
+	// #nosec G204
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), preforkChildEnvVariable+"=1", preforkChildIDEnvVariable+"="+strconv.Itoa(id))
+	if sp.metricsPath != "" {
+		cmd.Env = append(cmd.Env, preforkMetricsEnvVariable+"="+sp.metricsPath)
+	}
+	cmd.ExtraFiles = append(cmd.ExtraFiles, p.files...)
+
+	c := &child{
+		cmd:     cmd,
+		ready:   make(chan struct{}),
+		done:    make(chan struct{}),
+		id:      id,
+		pending: pending,
+	}
+
+	// The child reports the readiness by writing to the pipe.
+	// Extra files aren't supported on Windows, so the child is ready
+	// as soon as it is started there.
+	var readyR *os.File
+	if runtime.GOOS != "windows" {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		readyR = r
+		cmd.ExtraFiles = append(cmd.ExtraFiles, w)
+		cmd.Env = append(cmd.Env, preforkReadyEnvVariable+"="+strconv.Itoa(2+len(cmd.ExtraFiles)))
+		defer w.Close()
+	} else {
+		close(c.ready)
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	var err error
+	switch {
+	case sp.shuttingDown && pending:
+		err = errShuttingDown
+	case p.PinCPUs:
+		err = startPinned(cmd, id)
+	default:
+		err = cmd.Start()
+	}
+	if err != nil {
+		if readyR != nil {
+			readyR.Close()
+		}
+		return nil, err
+	}
+	sp.children[c.pid()] = c
+	p.logRecord(slog.LevelInfo, "child prefork process started",
+		[]slog.Attr{slog.Int("pid", c.pid()), slog.Int("id", id)},
+		"child prefork process %d started", c.pid())
+
+	if readyR != nil {
+		go func() {
+			var b [1]byte
+			if n, _ := readyR.Read(b[:]); n == 1 {
+				p.logRecord(slog.LevelInfo, "child prefork process ready",
+					[]slog.Attr{slog.Int("pid", c.pid()), slog.Int("id", id)},
+					"child prefork process %d ready", c.pid())
+				close(c.ready)
+			}
+			readyR.Close()
+		}()
+	}
+	go func() {
+		err := cmd.Wait()
+		close(c.done)
+		select {
+		case sp.exitCh <- procSig{c: c, err: err}:
+		case <-sp.done:
+		}
+	}()
+	return c, nil
+}
+
+// exitState is the state of the master process after a child exits.
+type exitState struct {
+	draining     bool
+	pending      bool
+	shuttingDown bool
+	left         int
+}
+
+// remove removes the exited child.
+func (sp *supervisor) remove(c *child) exitState {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	delete(sp.children, c.pid())
+	return exitState{
+		draining:     c.draining,
+		pending:      c.pending,
+		shuttingDown: sp.shuttingDown,
+		left:         len(sp.children),
+	}
+}
+
+// signal sends sig to all the children.
+func (sp *supervisor) signal(sig os.Signal) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	for _, c := range sp.children {
+		_ = c.cmd.Process.Signal(sig)
+	}
+}
+
+// shutdown makes the master process wait for the children to exit.
+func (sp *supervisor) shutdown() {
+	sp.mu.Lock()
+	sp.shuttingDown = true
+	sp.mu.Unlock()
+}
+
+// stop kills the children left and stops the goroutines waiting for them.
+func (sp *supervisor) stop() {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	sp.shuttingDown = true
+	for _, c := range sp.children {
+		_ = c.cmd.Process.Kill()
+	}
+	close(sp.done)
+}
+
+// rollingRestart restarts the children one at a time in the background.
+func (sp *supervisor) rollingRestart() {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if sp.shuttingDown {
+		return
+	}
+	if sp.restarting {
+		sp.p.logRecord(slog.LevelWarn, "rolling restart of child prefork processes is already in progress", nil,
+			"rolling restart of child prefork processes is already in progress")
+		return
+	}
+	sp.restarting = true
+
+	old := make([]*child, 0, len(sp.children))
+	for _, c := range sp.children {
+		if !c.draining {
+			old = append(old, c)
+		}
+	}
+	go sp.restart(old)
+}
+
+func (sp *supervisor) restart(old []*child) {
+	p := sp.p
+	p.logRecord(slog.LevelInfo, "rolling restart of child prefork processes started",
+		[]slog.Attr{slog.Int("children", len(old))},
+		"rolling restart of %d child prefork processes started", len(old))
+
+	var err error
+	restarted := 0
+	for _, c := range old {
+		if err = sp.replace(c); err != nil {
+			break
+		}
+		restarted++
+	}
+
+	sp.mu.Lock()
+	sp.restarting = false
+	sp.mu.Unlock()
+
+	if err != nil {
+		p.logRecord(slog.LevelError, "rolling restart of child prefork processes failed",
+			[]slog.Attr{slog.Int("restarted", restarted), slog.Any("error", err)},
+			"rolling restart of child prefork processes failed after %d restarts: %v", restarted, err)
+		return
+	}
+	p.logRecord(slog.LevelInfo, "rolling restart of child prefork processes finished",
+		[]slog.Attr{slog.Int("restarted", restarted)},
+		"rolling restart of %d child prefork processes finished", restarted)
+}
+
+// replace starts a new child in place of c and drains c
+// once the new child is ready.
+func (sp *supervisor) replace(c *child) error {
+	p := sp.p
+
+	select {
+	case <-c.done:
+		// The child has already exited and is restarted by the master.
+		return nil
+	default:
+	}
+
+	nc, err := sp.start(c.id, true)
+	if err != nil {
+		return err
+	}
+
+	t := time.NewTimer(p.readyTimeout())
+	defer t.Stop()
+	select {
+	case <-nc.ready:
+	case <-nc.done:
+		return ErrChildNotReady
+	case <-t.C:
+		_ = nc.cmd.Process.Kill()
+		return ErrChildNotReady
+	}
+
+	sp.mu.Lock()
+	nc.pending = false
+	c.draining = true
+	sp.mu.Unlock()
+
+	p.logRecord(slog.LevelInfo, "draining child prefork process",
+		[]slog.Attr{slog.Int("pid", c.pid()), slog.Int("id", c.id), slog.Int("new_pid", nc.pid())},
+		"draining child prefork process %d replaced by %d", c.pid(), nc.pid())
+	if err = c.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		// Signals other than Kill aren't supported on Windows.
+		_ = c.cmd.Process.Kill()
+	}
+
+	// Give the child some time to exit after ShutdownFunc returns.
+	t.Reset(p.shutdownTimeout() + time.Second)
+	select {
+	case <-c.done:
+	case <-t.C:
+		p.logRecord(slog.LevelWarn, "killing child prefork process after shutdown timeout",
+			[]slog.Attr{slog.Int("pid", c.pid()), slog.Int("id", c.id)},
+			"killing child prefork process %d after shutdown timeout", c.pid())
+		_ = c.cmd.Process.Kill()
+		<-c.done
+	}
+	return nil
+}
@@ -0,0 +1,364 @@
+package fasthttp
+
+import (
+	"context"
+	"io"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBackoffInitial is the default initial delay between retries.
+	DefaultBackoffInitial = 100 * time.Millisecond
+
+	// DefaultBackoffMax is the default maximum delay between retries.
+	DefaultBackoffMax = 10 * time.Second
+
+	// DefaultMaxRetryAfter is the default maximum Retry-After delay
+	// RetryPolicy waits for.
+	DefaultMaxRetryAfter = time.Minute
+
+	// DefaultRetryBudgetRatio is the default maximum ratio of retries
+	// to requests allowed by RetryBudget.
+	DefaultRetryBudgetRatio = 0.2
+
+	// DefaultRetryBudgetMinRetriesPerSecond is the default number
+	// of retries per second allowed by RetryBudget regardless of the ratio.
+	DefaultRetryBudgetMinRetriesPerSecond = 10
+)
+
+// DefaultRetryStatusCodes contains the response status codes retried
+// by RetryPolicy by default.
+var DefaultRetryStatusCodes = []int{
+	StatusTooManyRequests,
+	StatusBadGateway,
+	StatusServiceUnavailable,
+	StatusGatewayTimeout,
+}
+
+// RetryPolicy controls the delays between request attempts and the retries
+// of the responses with certain status codes in HostClient.
+//
+// Errors are retried as before according to HostClient.RetryIfErr
+// and HostClient.RetryIf. Responses with RetryStatusCodes are retried
+// only for the requests allowed by HostClient.RetryIf, i.e. only
+// for idempotent requests by default. The last response is returned
+// if the attempts are exhausted.
+//
+// RetryPolicy may be shared by multiple clients.
+type RetryPolicy struct {
+	// Backoff returns the delay before each retry.
+	//
+	// By default DecorrelatedJitterBackoff with default settings is used.
+	Backoff Backoff
+
+	// Budget limits retries across all the clients sharing it, so retries
+	// cannot multiply the load on struggling servers.
+	//
+	// By default retries aren't limited by a budget.
+	Budget *RetryBudget
+
+	// RetryStatusCodes contains the response status codes to retry.
+	//
+	// DefaultRetryStatusCodes are used if RetryStatusCodes is nil.
+	// Set it to an empty slice for retrying only errors.
+	RetryStatusCodes []int
+
+	// MaxAttempts is the maximum number of attempts including
+	// the first one.
+	//
+	// By default HostClient.MaxIdemponentCallAttempts is used.
+	MaxAttempts int
+
+	// MaxRetryAfter is the maximum delay requested by Retry-After header
+	// of 429 and 503 responses the client waits for. Responses requesting
+	// longer delays are returned to the caller without retrying.
+	//
+	// By default DefaultMaxRetryAfter is used.
+	MaxRetryAfter time.Duration
+
+	// IgnoreRetryAfter disables honoring Retry-After response header.
+	IgnoreRetryAfter bool
+}
+
+func (p *RetryPolicy) retryStatus(statusCode int) bool {
+	codes := p.RetryStatusCodes
+	if codes == nil {
+		codes = DefaultRetryStatusCodes
+	}
+	return slices.Contains(codes, statusCode)
+}
+
+// delay returns the delay before the given retry. resp is non-nil
+// if the retry is caused by the response status code.
+//
+// false is returned if the response mustn't be retried because
+// of Retry-After header.
+func (p *RetryPolicy) delay(attempt int, prev time.Duration, resp *Response, now time.Time) (time.Duration, bool) {
+	backoff := p.Backoff
+	if backoff == nil {
+		backoff = defaultBackoff
+	}
+	d := backoff.Delay(attempt, prev)
+	if resp == nil || p.IgnoreRetryAfter {
+		return d, true
+	}
+	if code := resp.StatusCode(); code != StatusTooManyRequests && code != StatusServiceUnavailable {
+		return d, true
+	}
+	retryAfter, ok := parseRetryAfter(resp.Header.Peek(HeaderRetryAfter), now)
+	if !ok {
+		return d, true
+	}
+	maxRetryAfter := p.MaxRetryAfter
+	if maxRetryAfter <= 0 {
+		maxRetryAfter = DefaultMaxRetryAfter
+	}
+	if retryAfter > maxRetryAfter {
+		return 0, false
+	}
+	return max(d, retryAfter), true
+}
+
+// parseRetryAfter parses Retry-After header value containing either
+// delay-seconds or HTTP-date.
+func parseRetryAfter(v []byte, now time.Time) (time.Duration, bool) {
+	if len(v) == 0 {
+		return 0, false
+	}
+	if n, err := ParseUint(v); err == nil {
+		return time.Duration(n) * time.Second, true
+	}
+	t, err := ParseHTTPDate(v)
+	if err != nil {
+		return 0, false
+	}
+	return max(t.Sub(now), 0), true
+}
+
+// Backoff returns the delay before retrying the request.
+type Backoff interface {
+	// Delay returns the delay before the given retry. attempt is 1
+	// for the first retry. prev is the delay before the previous retry
+	// or zero for the first retry.
+	Delay(attempt int, prev time.Duration) time.Duration
+}
+
+var defaultBackoff = &DecorrelatedJitterBackoff{}
+
+// ExponentialBackoff multiplies the delay by Multiplier after each retry.
+type ExponentialBackoff struct {
+	// Initial is the delay before the first retry.
+	//
+	// By default DefaultBackoffInitial is used.
+	Initial time.Duration
+
+	// Max is the maximum delay.
+	//
+	// By default DefaultBackoffMax is used.
+	Max time.Duration
+
+	// Multiplier for the delay after each retry.
+	//
+	// By default the delay is doubled.
+	Multiplier float64
+
+	// Jitter is the fraction of the delay randomized for spreading
+	// the retries of concurrent requests. The delay is chosen from
+	// [delay*(1-Jitter), delay]. It must be in [0, 1] range.
+	//
+	// By default the delay isn't randomized.
+	Jitter float64
+}
+
+// Delay implements Backoff.
+func (b *ExponentialBackoff) Delay(attempt int, _ time.Duration) time.Duration {
+	initial, maxDelay := backoffBounds(b.Initial, b.Max)
+	multiplier := b.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	d := float64(initial) * math.Pow(multiplier, float64(attempt-1))
+	d = min(d, float64(maxDelay))
+	if b.Jitter > 0 {
+		d -= d * min(b.Jitter, 1) * rand.Float64() // #nosec G404
+	}
+	return time.Duration(d)
+}
+
+// DecorrelatedJitterBackoff chooses a random delay between Initial
+// and three times the previous delay. It spreads the retries of
+// concurrent requests better than ExponentialBackoff.
+//
+// See https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+type DecorrelatedJitterBackoff struct {
+	// Initial is the minimum delay.
+	//
+	// By default DefaultBackoffInitial is used.
+	Initial time.Duration
+
+	// Max is the maximum delay.
+	//
+	// By default DefaultBackoffMax is used.
+	Max time.Duration
+}
+
+// Delay implements Backoff.
+func (b *DecorrelatedJitterBackoff) Delay(_ int, prev time.Duration) time.Duration {
+	initial, maxDelay := backoffBounds(b.Initial, b.Max)
+	prev = max(prev, initial)
+	d := initial + rand.N(3*prev-initial+1) // #nosec G404
+	return min(d, maxDelay)
+}
+
+func backoffBounds(initial, maxDelay time.Duration) (time.Duration, time.Duration) {
+	if initial <= 0 {
+		initial = DefaultBackoffInitial
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultBackoffMax
+	}
+	return initial, max(initial, maxDelay)
+}
+
+const retryBudgetWindow = 10
+
+// RetryBudget limits the number of retries relative to the number
+// of requests over the last 10 seconds.
+//
+// Retries are allowed while their number doesn't exceed Ratio of
+// the requests plus MinRetriesPerSecond per second. This prevents
+// retry storms when the servers fail, while still allowing retries
+// for occasional errors.
+//
+// Zero value is usable. RetryBudget is safe for concurrent use.
+type RetryBudget struct {
+	now func() time.Time
+
+	buckets [retryBudgetWindow]retryBudgetBucket
+
+	// Ratio is the maximum ratio of retries to requests.
+	//
+	// By default DefaultRetryBudgetRatio is used.
+	Ratio float64
+
+	// MinRetriesPerSecond is the number of retries per second
+	// allowed regardless of Ratio.
+	//
+	// By default DefaultRetryBudgetMinRetriesPerSecond is used.
+	MinRetriesPerSecond int
+
+	mu sync.Mutex
+}
+
+type retryBudgetBucket struct {
+	sec      int64
+	requests int
+	retries  int
+}
+
+// bucket returns the bucket for the current second.
+// b.mu must be held.
+func (b *RetryBudget) bucket() *retryBudgetBucket {
+	now := time.Now
+	if b.now != nil {
+		now = b.now
+	}
+	sec := now().Unix()
+	bucket := &b.buckets[sec%retryBudgetWindow]
+	if bucket.sec != sec {
+		*bucket = retryBudgetBucket{sec: sec}
+	}
+	return bucket
+}
+
+func (b *RetryBudget) onRequest() {
+	b.mu.Lock()
+	b.bucket().requests++
+	b.mu.Unlock()
+}
+
+// withdraw returns true and accounts the retry if the budget allows it.
+func (b *RetryBudget) withdraw() bool {
+	ratio := b.Ratio
+	if ratio <= 0 {
+		ratio = DefaultRetryBudgetRatio
+	}
+	minRetries := b.MinRetriesPerSecond
+	if minRetries <= 0 {
+		minRetries = DefaultRetryBudgetMinRetriesPerSecond
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current := b.bucket()
+	requests, retries := 0, 0
+	for i := range b.buckets {
+		if bucket := &b.buckets[i]; current.sec-bucket.sec < retryBudgetWindow {
+			requests += bucket.requests
+			retries += bucket.retries
+		}
+	}
+	if float64(retries+1) > ratio*float64(requests)+float64(minRetries*retryBudgetWindow) {
+		return false
+	}
+	current.retries++
+	return true
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := AcquireTimer(d)
+	defer ReleaseTimer(t)
+	if ctx == nil {
+		<-t.C
+		return nil
+	}
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetBodyStreamFunc sets the request body stream returned by getBody.
+//
+// Unlike SetBodyStream, the request may be retried by HostClient, since
+// getBody is called again for obtaining a fresh body stream before each
+// retry. getBody must return a stream with the same contents every time.
+//
+// See SetBodyStream for bodySize and closing the body stream details.
+func (req *Request) SetBodyStreamFunc(getBody func() (io.Reader, error), bodySize int) error {
+	bodyStream, err := getBody()
+	if err != nil {
+		return err
+	}
+	req.SetBodyStream(bodyStream, bodySize)
+	req.getBody = getBody
+	return nil
+}
+
+// replayBodyStream replaces the consumed body stream with a fresh one
+// obtained from the function passed to SetBodyStreamFunc.
+//
+// false is returned if the body stream cannot be replayed.
+func (req *Request) replayBodyStream() bool {
+	if req.getBody == nil {
+		return false
+	}
+	bodyStream, err := req.getBody()
+	if err != nil {
+		return false
+	}
+	req.closeBodyStream() //nolint:errcheck
+	req.bodyStream = bodyStream
+	return true
+}
@@ -0,0 +1,358 @@
+package fasthttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bhargawpradhan/fasthttp/fasthttputil"
+)
+
+func startRetryServer(t *testing.T, h RequestHandler) *fasthttputil.InmemoryListener {
+	t.Helper()
+
+	ln := fasthttputil.NewInmemoryListener()
+	s := &Server{Handler: h}
+	serverCh := make(chan error, 1)
+	go func() {
+		serverCh <- s.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		if err := <-serverCh; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	return ln
+}
+
+// failingServer responds with statusCode to the first failures requests.
+func failingServer(failures int32, statusCode int, retryAfter string) (RequestHandler, *atomic.Int32) {
+	var n atomic.Int32
+	return func(ctx *RequestCtx) {
+		if n.Add(1) <= failures {
+			ctx.Error("failure", statusCode)
+			if retryAfter != "" {
+				ctx.Response.Header.Set(HeaderRetryAfter, retryAfter)
+			}
+			return
+		}
+		ctx.Write(ctx.PostBody()) //nolint:errcheck
+	}, &n
+}
+
+func TestRetryPolicyStatusCodes(t *testing.T) {
+	t.Parallel()
+
+	h, n := failingServer(2, StatusServiceUnavailable, "")
+	ln := startRetryServer(t, h)
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		RetryPolicy: &RetryPolicy{
+			Backoff: &ExponentialBackoff{Initial: time.Millisecond},
+		},
+	}
+
+	statusCode, _, err := c.Get(nil, "http://foobar/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if statusCode != StatusOK || n.Load() != 3 {
+		t.Fatalf("unexpected status code %d after %d attempts", statusCode, n.Load())
+	}
+
+	// Non-idempotent requests aren't retried.
+	n.Store(0)
+	statusCode, _, err = c.Post(nil, "http://foobar/", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if statusCode != StatusServiceUnavailable || n.Load() != 1 {
+		t.Fatalf("unexpected status code %d after %d attempts", statusCode, n.Load())
+	}
+
+	// The last response is returned when the attempts are exhausted.
+	n.Store(-10)
+	c.RetryPolicy.MaxAttempts = 3
+	statusCode, _, err = c.Get(nil, "http://foobar/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if statusCode != StatusServiceUnavailable || n.Load() != -7 {
+		t.Fatalf("unexpected status code %d after %d attempts", statusCode, n.Load()+10)
+	}
+}
+
+func TestRetryPolicyEmptyStatusCodes(t *testing.T) {
+	t.Parallel()
+
+	h, n := failingServer(2, StatusBadGateway, "")
+	ln := startRetryServer(t, h)
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		RetryPolicy: &RetryPolicy{RetryStatusCodes: []int{}},
+	}
+	statusCode, _, err := c.Get(nil, "http://foobar/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if statusCode != StatusBadGateway || n.Load() != 1 {
+		t.Fatalf("unexpected status code %d after %d attempts", statusCode, n.Load())
+	}
+}
+
+func TestRetryPolicyRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	h, n := failingServer(1, StatusTooManyRequests, "1")
+	ln := startRetryServer(t, h)
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		RetryPolicy: &RetryPolicy{
+			Backoff: &ExponentialBackoff{Initial: time.Millisecond},
+		},
+	}
+	start := time.Now()
+	statusCode, _, err := c.Get(nil, "http://foobar/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if statusCode != StatusOK || n.Load() != 2 {
+		t.Fatalf("unexpected status code %d after %d attempts", statusCode, n.Load())
+	}
+	if d := time.Since(start); d < time.Second {
+		t.Fatalf("Retry-After wasn't honored: the request took %s", d)
+	}
+
+	// Retry-After exceeding MaxRetryAfter stops retrying.
+	n.Store(0)
+	c.RetryPolicy.MaxRetryAfter = 500 * time.Millisecond
+	statusCode, _, err = c.Get(nil, "http://foobar/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if statusCode != StatusTooManyRequests || n.Load() != 1 {
+		t.Fatalf("unexpected status code %d after %d attempts", statusCode, n.Load())
+	}
+
+	// The last response is returned immediately if the delay exceeds the deadline.
+	n.Store(0)
+	c.RetryPolicy.MaxRetryAfter = 0
+	req := AcquireRequest()
+	resp := AcquireResponse()
+	defer ReleaseRequest(req)
+	defer ReleaseResponse(resp)
+	req.SetRequestURI("http://foobar/")
+	start = time.Now()
+	if err := c.DoTimeout(req, resp, 500*time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.StatusCode() != StatusTooManyRequests || time.Since(start) >= 500*time.Millisecond {
+		t.Fatalf("unexpected status code %d after %s", resp.StatusCode(), time.Since(start))
+	}
+
+	// The delay is interrupted by canceling the request.
+	n.Store(0)
+	req.SetTimeout(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if err := c.DoContext(ctx, req, resp); !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, context.Canceled)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		v  string
+		d  time.Duration
+		ok bool
+	}{
+		{v: "", ok: false},
+		{v: "120", d: 2 * time.Minute, ok: true},
+		{v: "0", d: 0, ok: true},
+		{v: "-1", ok: false},
+		{v: "soon", ok: false},
+		{v: "Mon, 01 Jan 2024 00:00:30 GMT", d: 30 * time.Second, ok: true},
+		{v: "Sun, 31 Dec 2023 23:00:00 GMT", d: 0, ok: true},
+	} {
+		d, ok := parseRetryAfter([]byte(tc.v), now)
+		if d != tc.d || ok != tc.ok {
+			t.Fatalf("unexpected result for %q: %s, %v. Expecting %s, %v", tc.v, d, ok, tc.d, tc.ok)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	t.Parallel()
+
+	b := &ExponentialBackoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+	for attempt, expected := range []time.Duration{10, 20, 40, 50, 50} {
+		if d := b.Delay(attempt+1, 0); d != expected*time.Millisecond {
+			t.Fatalf("unexpected delay %s for attempt %d. Expecting %s", d, attempt+1, expected*time.Millisecond)
+		}
+	}
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := b.Delay(2, 0); d < 10*time.Millisecond || d > 20*time.Millisecond {
+			t.Fatalf("unexpected delay %s with jitter. Expecting [10ms, 20ms]", d)
+		}
+	}
+
+	dj := &DecorrelatedJitterBackoff{Initial: 10 * time.Millisecond, Max: time.Second}
+	var prev time.Duration
+	for i := 0; i < 100; i++ {
+		d := dj.Delay(i+1, prev)
+		if d < 10*time.Millisecond || d > max(3*prev, 30*time.Millisecond) || d > time.Second {
+			t.Fatalf("unexpected delay %s after %s", d, prev)
+		}
+		prev = d
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1000, 0)
+	b := &RetryBudget{
+		Ratio:               0.5,
+		MinRetriesPerSecond: 1,
+		now:                 func() time.Time { return now },
+	}
+	// 10 retries are allowed without requests.
+	for i := 0; i < 10; i++ {
+		if !b.withdraw() {
+			t.Fatalf("retry %d must be allowed", i)
+		}
+	}
+	if b.withdraw() {
+		t.Fatal("retry must be denied")
+	}
+
+	for i := 0; i < 10; i++ {
+		b.onRequest()
+	}
+	for i := 0; i < 5; i++ {
+		if !b.withdraw() {
+			t.Fatalf("retry %d must be allowed", i)
+		}
+	}
+	if b.withdraw() {
+		t.Fatal("retry must be denied")
+	}
+
+	// Old buckets expire.
+	now = now.Add(10 * time.Second)
+	if !b.withdraw() {
+		t.Fatal("retry must be allowed after the window passes")
+	}
+}
+
+func TestRetryPolicyBudget(t *testing.T) {
+	t.Parallel()
+
+	h, n := failingServer(1000, StatusServiceUnavailable, "")
+	ln := startRetryServer(t, h)
+	budget := &RetryBudget{Ratio: 0.1, MinRetriesPerSecond: 1}
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		RetryPolicy: &RetryPolicy{
+			Backoff: &ExponentialBackoff{Initial: time.Microsecond},
+			Budget:  budget,
+		},
+	}
+	for i := 0; i < 20; i++ {
+		if _, _, err := c.Get(nil, "http://foobar/"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// 20 requests allow 2 retries plus 10 retries for the window.
+	if attempts := n.Load(); attempts != 32 {
+		t.Fatalf("unexpected number of attempts %d. Expecting 32", attempts)
+	}
+}
+
+type closeCountingReader struct {
+	io.Reader
+	closed *atomic.Int32
+}
+
+func (r *closeCountingReader) Close() error {
+	r.closed.Add(1)
+	return nil
+}
+
+func TestRetryReplayBodyStream(t *testing.T) {
+	t.Parallel()
+
+	var attempts atomic.Int32
+	ln := startRetryServer(t, func(ctx *RequestCtx) {
+		if attempts.Add(1) == 1 {
+			// Break the connection for the first attempt.
+			ctx.Conn().Close()
+			return
+		}
+		ctx.Write(ctx.PostBody()) //nolint:errcheck
+	})
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		RetryIf: func(req *Request) bool { return true },
+	}
+
+	var bodies, closed atomic.Int32
+	req := AcquireRequest()
+	resp := AcquireResponse()
+	defer ReleaseRequest(req)
+	defer ReleaseResponse(resp)
+	req.SetRequestURI("http://foobar/")
+	req.Header.SetMethod(MethodPost)
+	err := req.SetBodyStreamFunc(func() (io.Reader, error) {
+		bodies.Add(1)
+		return &closeCountingReader{Reader: strings.NewReader("payload"), closed: &closed}, nil
+	}, -1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(resp.Body()) != "payload" || attempts.Load() != 2 {
+		t.Fatalf("unexpected response %q after %d attempts", resp.Body(), attempts.Load())
+	}
+	if bodies.Load() != 2 || closed.Load() != 2 {
+		t.Fatalf("unexpected number of body streams %d, closed %d. Expecting 2", bodies.Load(), closed.Load())
+	}
+
+	// Body streams without the func aren't retried.
+	attempts.Store(0)
+	req.SetBodyStream(strings.NewReader("payload"), -1)
+	if err := c.Do(req, resp); err == nil {
+		t.Fatal("expecting error")
+	}
+	if attempts.Load() != 1 {
+		t.Fatalf("unexpected number of attempts %d. Expecting 1", attempts.Load())
+	}
+}
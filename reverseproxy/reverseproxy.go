@@ -0,0 +1,383 @@
+// Package reverseproxy provides a reverse proxy request handler
+// forwarding requests to fasthttp.HostClient or fasthttp.LBClient.
+//
+// The proxy strips hop-by-hop headers, sets X-Forwarded-For,
+// X-Forwarded-Proto, X-Forwarded-Host and RFC 7239 Forwarded request headers
+// and maps upstream errors to 502 Bad Gateway and 504 Gateway Timeout
+// responses. Request bodies are streamed to the upstream if
+// fasthttp.Server.StreamRequestBody is set, while response bodies are
+// streamed to the client if Config.StreamResponseBody is set.
+// Upgrade requests such as WebSocket are passed to the upstream
+// over a hijacked connection.
+package reverseproxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/bhargawpradhan/fasthttp"
+	"github.com/bhargawpradhan/fasthttp/internal/proxyutil"
+)
+
+// DefaultTimeout is the default timeout for upstream requests.
+const DefaultTimeout = 30 * time.Second
+
+// ErrUpgradeNotSupported is passed to Config.ErrorHandler for upgrade
+// requests if Config.DialUpgrade isn't set and Config.Client isn't
+// *fasthttp.HostClient.
+var ErrUpgradeNotSupported = errors.New("reverseproxy: DialUpgrade is required for proxying upgrade requests")
+
+// Doer performs upstream requests.
+//
+// It is implemented by fasthttp.HostClient and fasthttp.LBClient.
+type Doer interface {
+	DoDeadline(req *fasthttp.Request, resp *fasthttp.Response, deadline time.Time) error
+}
+
+// Config configures the ReverseProxy.
+type Config struct {
+	// Client performs the upstream requests.
+	//
+	// The incoming request host is preserved, so the upstream address
+	// must be set in the client, e.g. via fasthttp.HostClient.Addr.
+	Client Doer
+
+	// RewriteRequest is called before sending the request to the upstream,
+	// after the hop-by-hop headers are stripped and the forwarding headers
+	// are set.
+	//
+	// The request is the incoming request, so RewriteRequest may modify
+	// its URI, host and headers in place.
+	RewriteRequest func(ctx *fasthttp.RequestCtx, req *fasthttp.Request)
+
+	// ModifyResponse is called with the upstream response after
+	// the hop-by-hop headers are stripped. The error returned by
+	// ModifyResponse is passed to ErrorHandler.
+	//
+	// The response body isn't read yet if StreamResponseBody is set.
+	ModifyResponse func(ctx *fasthttp.RequestCtx, resp *fasthttp.Response) error
+
+	// ErrorHandler writes the response for the upstream error.
+	//
+	// By default 504 Gateway Timeout is returned for timeouts
+	// and 502 Bad Gateway for the remaining errors.
+	ErrorHandler func(ctx *fasthttp.RequestCtx, err error)
+
+	// DialUpgrade dials the upstream connection for the upgrade requests.
+	//
+	// By default the address and the dialer of Client are used
+	// if it is *fasthttp.HostClient. DialUpgrade is required for proxying
+	// upgrade requests via other clients such as fasthttp.LBClient,
+	// since their upstream address is unknown. ErrUpgradeNotSupported
+	// is passed to ErrorHandler for upgrade requests otherwise.
+	DialUpgrade func(req *fasthttp.Request) (net.Conn, error)
+
+	// Scheme is the upstream scheme, either http or https.
+	//
+	// By default https is used if Client is *fasthttp.HostClient
+	// with IsTLS set, otherwise http is used.
+	Scheme string
+
+	// Timeout for the upstream requests including reading the response
+	// headers of the upgrade requests.
+	//
+	// By default DefaultTimeout is used.
+	Timeout time.Duration
+
+	// TrustForwardedHeaders appends the client address to X-Forwarded-For
+	// and Forwarded headers of the incoming request and preserves
+	// its X-Forwarded-Proto and X-Forwarded-Host headers.
+	//
+	// By default the incoming forwarding headers are replaced, since
+	// they may be forged by the client.
+	TrustForwardedHeaders bool
+
+	// StreamResponseBody streams the upstream response body to the client
+	// instead of reading it into memory.
+	StreamResponseBody bool
+}
+
+// ReverseProxy forwards requests to an upstream.
+//
+// It is safe to call ReverseProxy methods from concurrently running goroutines.
+type ReverseProxy struct {
+	client         Doer
+	rewriteRequest func(ctx *fasthttp.RequestCtx, req *fasthttp.Request)
+	modifyResponse func(ctx *fasthttp.RequestCtx, resp *fasthttp.Response) error
+	errorHandler   func(ctx *fasthttp.RequestCtx, err error)
+	dialUpgrade    func(req *fasthttp.Request) (net.Conn, error)
+
+	scheme  string
+	timeout time.Duration
+
+	trustForwardedHeaders bool
+	streamResponseBody    bool
+}
+
+// New returns a ReverseProxy with the given config.
+func New(cfg Config) *ReverseProxy {
+	p := &ReverseProxy{
+		client:                cfg.Client,
+		rewriteRequest:        cfg.RewriteRequest,
+		modifyResponse:        cfg.ModifyResponse,
+		errorHandler:          cfg.ErrorHandler,
+		dialUpgrade:           cfg.DialUpgrade,
+		scheme:                cfg.Scheme,
+		timeout:               cfg.Timeout,
+		trustForwardedHeaders: cfg.TrustForwardedHeaders,
+		streamResponseBody:    cfg.StreamResponseBody,
+	}
+	hc, _ := cfg.Client.(*fasthttp.HostClient)
+	if p.errorHandler == nil {
+		p.errorHandler = proxyutil.WriteError
+	}
+	if p.dialUpgrade == nil && hc != nil {
+		p.dialUpgrade = func(req *fasthttp.Request) (net.Conn, error) {
+			return dialUpgrade(hc, p.timeout)
+		}
+	}
+	if p.scheme == "" {
+		p.scheme = "http"
+		if hc != nil && hc.IsTLS {
+			p.scheme = "https"
+		}
+	}
+	if p.timeout <= 0 {
+		p.timeout = DefaultTimeout
+	}
+	return p
+}
+
+// Handler forwards the request to the upstream and writes
+// the upstream response.
+func (p *ReverseProxy) Handler(ctx *fasthttp.RequestCtx) {
+	req := &ctx.Request
+	resp := &ctx.Response
+
+	connectionClose := req.Header.ConnectionClose()
+	upgrade := ""
+	if req.Header.ConnectionUpgrade() {
+		upgrade = string(req.Header.Peek(fasthttp.HeaderUpgrade))
+	}
+
+	proxyutil.RemoveHopHeaders(&req.Header)
+	p.setForwardedHeaders(ctx)
+	req.URI().SetScheme(p.scheme)
+	if p.rewriteRequest != nil {
+		p.rewriteRequest(ctx, req)
+	}
+
+	var (
+		err      error
+		upstream net.Conn
+		br       *bufio.Reader
+	)
+	if upgrade != "" {
+		upstream, br, err = p.forwardUpgrade(ctx, upgrade)
+	} else {
+		resp.StreamBody = p.streamResponseBody
+		err = p.client.DoDeadline(req, resp, time.Now().Add(p.timeout))
+		if err == nil {
+			proxyutil.RemoveHopHeaders(&resp.Header)
+		}
+	}
+	if err == nil && p.modifyResponse != nil {
+		err = p.modifyResponse(ctx, resp)
+	}
+	if err != nil {
+		if upstream != nil {
+			upstream.Close()
+		}
+		resp.CloseBodyStream() //nolint:errcheck
+		resp.Reset()
+		p.errorHandler(ctx, err)
+	} else if upstream != nil {
+		// The connection is hijacked only after ModifyResponse accepts
+		// the response, so the tunnel doesn't follow an error response.
+		ctx.Hijack(func(c net.Conn) {
+			proxyutil.Tunnel(c, upstream, br)
+		})
+	}
+
+	if connectionClose {
+		ctx.SetConnectionClose()
+	}
+}
+
+// forwardUpgrade sends the upgrade request over a new upstream connection.
+//
+// The upstream connection and its reader are returned for tunneling
+// if the upstream switches protocols.
+func (p *ReverseProxy) forwardUpgrade(ctx *fasthttp.RequestCtx, upgrade string) (net.Conn, *bufio.Reader, error) {
+	req := &ctx.Request
+	resp := &ctx.Response
+
+	if p.dialUpgrade == nil {
+		return nil, nil, ErrUpgradeNotSupported
+	}
+
+	req.Header.Set(fasthttp.HeaderConnection, "Upgrade")
+	req.Header.Set(fasthttp.HeaderUpgrade, upgrade)
+
+	conn, err := p.dialUpgrade(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = conn.SetDeadline(time.Now().Add(p.timeout)); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	bw := bufio.NewWriter(conn)
+	br := bufio.NewReader(conn)
+	if err = req.Write(bw); err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = resp.Read(br)
+	}
+	if err == nil {
+		err = conn.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	if resp.StatusCode() != fasthttp.StatusSwitchingProtocols {
+		conn.Close()
+		proxyutil.RemoveHopHeaders(&resp.Header)
+		return nil, nil, nil
+	}
+
+	upgrade = string(resp.Header.Peek(fasthttp.HeaderUpgrade))
+	proxyutil.RemoveHopHeaders(&resp.Header)
+	resp.Header.Set(fasthttp.HeaderConnection, "Upgrade")
+	resp.Header.Set(fasthttp.HeaderUpgrade, upgrade)
+	return conn, br, nil
+}
+
+func dialUpgrade(hc *fasthttp.HostClient, timeout time.Duration) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	addr, _, _ := strings.Cut(hc.Addr, ",")
+	isTLS := hc.IsTLS
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		if isTLS {
+			addr = net.JoinHostPort(addr, "443")
+		} else {
+			addr = net.JoinHostPort(addr, "80")
+		}
+	}
+
+	switch {
+	case hc.Dial != nil:
+		conn, err = hc.Dial(addr)
+	case hc.DialTimeout != nil:
+		conn, err = hc.DialTimeout(addr, timeout)
+	default:
+		conn, err = fasthttp.DialTimeout(addr, timeout)
+	}
+	if err != nil || !isTLS {
+		return conn, err
+	}
+
+	var cfg *tls.Config
+	if hc.TLSConfig != nil {
+		cfg = hc.TLSConfig.Clone()
+	} else {
+		cfg = &tls.Config{}
+	}
+	if cfg.ServerName == "" {
+		host, _, _ := net.SplitHostPort(addr)
+		cfg.ServerName = host
+	}
+	// The upgraded connection speaks HTTP/1.1 only.
+	cfg.NextProtos = nil
+
+	tlsConn := tls.Client(conn, cfg)
+	hctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(hctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+func (p *ReverseProxy) setForwardedHeaders(ctx *fasthttp.RequestCtx) {
+	h := &ctx.Request.Header
+
+	ip := ctx.RemoteIP().String()
+	proto := "http"
+	if ctx.IsTLS() {
+		proto = "https"
+	}
+	host := string(ctx.Host())
+
+	if !p.trustForwardedHeaders {
+		h.Del(fasthttp.HeaderXForwardedFor)
+		h.Del(fasthttp.HeaderForwarded)
+		h.Del(fasthttp.HeaderXForwardedProto)
+		h.Del(fasthttp.HeaderXForwardedHost)
+	}
+
+	if prior := h.Peek(fasthttp.HeaderXForwardedFor); len(prior) > 0 {
+		h.Set(fasthttp.HeaderXForwardedFor, string(prior)+", "+ip)
+	} else {
+		h.Set(fasthttp.HeaderXForwardedFor, ip)
+	}
+	if len(h.Peek(fasthttp.HeaderXForwardedProto)) == 0 {
+		h.Set(fasthttp.HeaderXForwardedProto, proto)
+	}
+	if len(h.Peek(fasthttp.HeaderXForwardedHost)) == 0 && host != "" {
+		h.Set(fasthttp.HeaderXForwardedHost, host)
+	}
+
+	forwarded := forwardedElement(ctx.RemoteIP(), host, proto)
+	if prior := h.Peek(fasthttp.HeaderForwarded); len(prior) > 0 {
+		forwarded = string(prior) + ", " + forwarded
+	}
+	h.Set(fasthttp.HeaderForwarded, forwarded)
+}
+
+// forwardedElement returns RFC 7239 Forwarded header element.
+func forwardedElement(ip net.IP, host, proto string) string {
+	var b strings.Builder
+	b.WriteString("for=")
+	if ip.To4() == nil && ip.To16() != nil {
+		b.WriteString(`"[` + ip.String() + `]"`)
+	} else {
+		b.WriteString(ip.String())
+	}
+	if host != "" {
+		b.WriteString(";host=")
+		b.WriteString(forwardedValue(host))
+	}
+	b.WriteString(";proto=")
+	b.WriteString(proto)
+	return b.String()
+}
+
+// forwardedValue returns v as a token or as a quoted string
+// if it contains non-token characters.
+func forwardedValue(v string) string {
+	for i := 0; i < len(v); i++ {
+		if !isTokenChar(v[i]) {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+		}
+	}
+	return v
+}
+
+func isTokenChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
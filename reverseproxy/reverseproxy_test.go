@@ -0,0 +1,563 @@
+package reverseproxy
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bhargawpradhan/fasthttp"
+	"github.com/bhargawpradhan/fasthttp/fasthttputil"
+)
+
+var clientAddr = &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}
+
+func startServer(t *testing.T, s *fasthttp.Server) *fasthttputil.InmemoryListener {
+	t.Helper()
+
+	ln := fasthttputil.NewInmemoryListener()
+	serverCh := make(chan error, 1)
+	go func() {
+		serverCh <- s.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		if err := <-serverCh; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	return ln
+}
+
+// startProxy starts the proxy in front of the upstream handler
+// and returns the client for the proxy.
+//
+// The upstream handler is served by cfg.Client unless it is set.
+func startProxy(t *testing.T, upstream fasthttp.RequestHandler, cfg Config) *fasthttp.HostClient {
+	t.Helper()
+
+	if cfg.Client == nil {
+		upstreamLn := startServer(t, &fasthttp.Server{Handler: upstream, StreamRequestBody: true})
+		cfg.Client = &fasthttp.HostClient{
+			Addr: "upstream",
+			Dial: func(string) (net.Conn, error) { return upstreamLn.Dial() },
+		}
+	}
+	p := New(cfg)
+	proxyLn := startServer(t, &fasthttp.Server{Handler: p.Handler, StreamRequestBody: true})
+	c := &fasthttp.HostClient{
+		Addr: "example.com",
+		Dial: func(string) (net.Conn, error) { return proxyLn.DialWithLocalAddr(clientAddr) },
+	}
+	return c
+}
+
+func doRequest(t *testing.T, c *fasthttp.HostClient, req *fasthttp.Request) *fasthttp.Response {
+	t.Helper()
+
+	resp := fasthttp.AcquireResponse()
+	t.Cleanup(func() { fasthttp.ReleaseResponse(resp) })
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return resp
+}
+
+func TestReverseProxyHeaders(t *testing.T) {
+	t.Parallel()
+
+	var upstreamHeader fasthttp.RequestHeader
+	c := startProxy(t, func(ctx *fasthttp.RequestCtx) {
+		ctx.Request.Header.CopyTo(&upstreamHeader)
+		ctx.Response.Header.Set("Keep-Alive", "timeout=5")
+		ctx.Response.Header.Set("Connection", "X-Hop")
+		ctx.Response.Header.Set("X-Hop", "1")
+		ctx.Response.Header.Set("X-End", "1")
+		ctx.SetBodyString("upstream")
+	}, Config{})
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI("http://example.com/foo?bar=baz")
+	req.Header.Set("Connection", "X-Hop")
+	req.Header.Set("X-Hop", "1")
+	req.Header.Set("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+	req.Header.Set("X-End", "1")
+	req.Header.Set("X-Forwarded-For", "203.0.113.1")
+	req.Header.Set("Forwarded", "for=203.0.113.1")
+	resp := doRequest(t, c, req)
+
+	if body := string(resp.Body()); body != "upstream" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "upstream")
+	}
+	if uri := string(upstreamHeader.RequestURI()); uri != "/foo?bar=baz" {
+		t.Fatalf("unexpected request uri %q. Expecting %q", uri, "/foo?bar=baz")
+	}
+	for _, h := range []string{"X-Hop", "Proxy-Authorization"} {
+		if v := upstreamHeader.Peek(h); len(v) > 0 {
+			t.Fatalf("unexpected request header %s: %q", h, v)
+		}
+		if v := resp.Header.Peek(h); len(v) > 0 {
+			t.Fatalf("unexpected response header %s: %q", h, v)
+		}
+	}
+	if v := resp.Header.Peek("Keep-Alive"); len(v) > 0 {
+		t.Fatalf("unexpected response header Keep-Alive: %q", v)
+	}
+	expected := map[string]string{
+		"Host":              "example.com",
+		"X-End":             "1",
+		"X-Forwarded-For":   "192.0.2.1",
+		"X-Forwarded-Proto": "http",
+		"X-Forwarded-Host":  "example.com",
+		"Forwarded":         "for=192.0.2.1;host=example.com;proto=http",
+	}
+	for k, v := range expected {
+		if s := string(upstreamHeader.Peek(k)); s != v {
+			t.Fatalf("unexpected request header %s: %q. Expecting %q", k, s, v)
+		}
+	}
+	if v := string(resp.Header.Peek("X-End")); v != "1" {
+		t.Fatalf("unexpected response header X-End: %q. Expecting %q", v, "1")
+	}
+	if resp.ConnectionClose() {
+		t.Fatal("unexpected 'Connection: close' response")
+	}
+}
+
+func TestReverseProxyTrustForwardedHeaders(t *testing.T) {
+	t.Parallel()
+
+	var upstreamHeader fasthttp.RequestHeader
+	c := startProxy(t, func(ctx *fasthttp.RequestCtx) {
+		ctx.Request.Header.CopyTo(&upstreamHeader)
+	}, Config{TrustForwardedHeaders: true})
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI("http://example.com:8080/")
+	req.Header.Set("X-Forwarded-For", "203.0.113.1")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("Forwarded", `for="[2001:db8::1]";proto=https`)
+	doRequest(t, c, req)
+
+	expected := map[string]string{
+		"X-Forwarded-For":   "203.0.113.1, 192.0.2.1",
+		"X-Forwarded-Proto": "https",
+		"X-Forwarded-Host":  "example.com:8080",
+		"Forwarded":         `for="[2001:db8::1]";proto=https, for=192.0.2.1;host="example.com:8080";proto=http`,
+	}
+	for k, v := range expected {
+		if s := string(upstreamHeader.Peek(k)); s != v {
+			t.Fatalf("unexpected request header %s: %q. Expecting %q", k, s, v)
+		}
+	}
+}
+
+func TestForwardedElement(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		ip       string
+		host     string
+		proto    string
+		expected string
+	}{
+		{"192.0.2.1", "example.com", "http", "for=192.0.2.1;host=example.com;proto=http"},
+		{"2001:db8::1", "", "https", `for="[2001:db8::1]";proto=https`},
+		{"192.0.2.1", "[2001:db8::2]:443", "https", `for=192.0.2.1;host="[2001:db8::2]:443";proto=https`},
+	} {
+		if s := forwardedElement(net.ParseIP(tc.ip), tc.host, tc.proto); s != tc.expected {
+			t.Fatalf("unexpected element %q. Expecting %q", s, tc.expected)
+		}
+	}
+}
+
+func TestReverseProxyConnectionClose(t *testing.T) {
+	t.Parallel()
+
+	c := startProxy(t, func(ctx *fasthttp.RequestCtx) {
+		if ctx.Request.Header.ConnectionClose() {
+			t.Error("unexpected 'Connection: close' request to upstream")
+		}
+		ctx.SetConnectionClose()
+	}, Config{})
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI("http://example.com/")
+	if resp := doRequest(t, c, req); resp.ConnectionClose() {
+		t.Fatal("upstream 'Connection: close' mustn't be forwarded")
+	}
+
+	req.SetConnectionClose()
+	if resp := doRequest(t, c, req); !resp.ConnectionClose() {
+		t.Fatal("missing 'Connection: close' response")
+	}
+}
+
+func TestReverseProxyRewrite(t *testing.T) {
+	t.Parallel()
+
+	c := startProxy(t, func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.Header.Set("X-Path", string(ctx.Path()))
+		ctx.Response.Header.Set("X-Host", string(ctx.Host()))
+	}, Config{
+		RewriteRequest: func(ctx *fasthttp.RequestCtx, req *fasthttp.Request) {
+			req.URI().SetPath("/api" + string(req.URI().Path()))
+			req.SetHost("backend.local")
+		},
+		ModifyResponse: func(ctx *fasthttp.RequestCtx, resp *fasthttp.Response) error {
+			resp.Header.Set("X-Proxied", "1")
+			return nil
+		},
+	})
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI("http://example.com/users")
+	resp := doRequest(t, c, req)
+	expected := map[string]string{
+		"X-Path":    "/api/users",
+		"X-Host":    "backend.local",
+		"X-Proxied": "1",
+	}
+	for k, v := range expected {
+		if s := string(resp.Header.Peek(k)); s != v {
+			t.Fatalf("unexpected response header %s: %q. Expecting %q", k, s, v)
+		}
+	}
+}
+
+func TestReverseProxyErrors(t *testing.T) {
+	t.Parallel()
+
+	errDial := errors.New("dial failure")
+	c := startProxy(t, nil, Config{
+		Client: &fasthttp.HostClient{
+			Addr: "upstream",
+			Dial: func(string) (net.Conn, error) { return nil, errDial },
+		},
+	})
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI("http://example.com/")
+	if resp := doRequest(t, c, req); resp.StatusCode() != fasthttp.StatusBadGateway {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), fasthttp.StatusBadGateway)
+	}
+
+	c = startProxy(t, func(ctx *fasthttp.RequestCtx) {
+		time.Sleep(200 * time.Millisecond)
+		ctx.Response.Header.Set("X-Upstream", "1")
+	}, Config{Timeout: 20 * time.Millisecond})
+	resp := doRequest(t, c, req)
+	if resp.StatusCode() != fasthttp.StatusGatewayTimeout {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), fasthttp.StatusGatewayTimeout)
+	}
+
+	errModify := errors.New("modify failure")
+	var handlerErr error
+	c = startProxy(t, func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.Header.Set("X-Upstream", "1")
+		ctx.SetBodyString("upstream")
+	}, Config{
+		ModifyResponse: func(ctx *fasthttp.RequestCtx, resp *fasthttp.Response) error {
+			return errModify
+		},
+		ErrorHandler: func(ctx *fasthttp.RequestCtx, err error) {
+			handlerErr = err
+			ctx.Error("custom", fasthttp.StatusServiceUnavailable)
+		},
+	})
+	resp = doRequest(t, c, req)
+	if resp.StatusCode() != fasthttp.StatusServiceUnavailable || string(resp.Body()) != "custom" {
+		t.Fatalf("unexpected response %d %q", resp.StatusCode(), resp.Body())
+	}
+	if v := resp.Header.Peek("X-Upstream"); len(v) > 0 {
+		t.Fatalf("unexpected upstream header in the error response: %q", v)
+	}
+	if !errors.Is(handlerErr, errModify) {
+		t.Fatalf("unexpected error: %v. Expecting %v", handlerErr, errModify)
+	}
+}
+
+// blockingReader returns the first chunk and blocks until next
+// is closed before returning the second chunk.
+type blockingReader struct {
+	next   chan struct{}
+	chunks []string
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	if len(r.chunks) == 1 {
+		<-r.next
+	}
+	n := copy(p, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
+
+func TestReverseProxyStreamRequestBody(t *testing.T) {
+	t.Parallel()
+
+	next := make(chan struct{})
+	c := startProxy(t, func(ctx *fasthttp.RequestCtx) {
+		br := bufio.NewReader(ctx.RequestBodyStream())
+		first := make([]byte, 3)
+		if _, err := io.ReadFull(br, first); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		// The proxy streams the first chunk before the client sends the rest.
+		close(next)
+		rest, err := io.ReadAll(br)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		ctx.SetBody(append(first, rest...))
+	}, Config{})
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI("http://example.com/")
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.SetBodyStream(&blockingReader{next: next, chunks: []string{"foo", "bar"}}, -1)
+	resp := doRequest(t, c, req)
+	if body := string(resp.Body()); body != "foobar" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "foobar")
+	}
+}
+
+func TestReverseProxyStreamResponseBody(t *testing.T) {
+	t.Parallel()
+
+	next := make(chan struct{})
+	c := startProxy(t, func(ctx *fasthttp.RequestCtx) {
+		ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+			w.WriteString("foo") //nolint:errcheck
+			w.Flush()            //nolint:errcheck
+			<-next
+			w.WriteString("bar") //nolint:errcheck
+		})
+	}, Config{StreamResponseBody: true})
+	c.StreamResponseBody = true
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI("http://example.com/")
+	resp := doRequest(t, c, req)
+	defer resp.CloseBodyStream() //nolint:errcheck
+
+	br := bufio.NewReader(resp.BodyStream())
+	first := make([]byte, 3)
+	if _, err := io.ReadFull(br, first); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	close(next)
+	rest, err := io.ReadAll(br)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if body := string(first) + string(rest); body != "foobar" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "foobar")
+	}
+}
+
+func echoUpgradeHandler(ctx *fasthttp.RequestCtx) {
+	if string(ctx.Request.Header.Peek(fasthttp.HeaderUpgrade)) != "echo" {
+		ctx.Error("unsupported upgrade", fasthttp.StatusBadRequest)
+		return
+	}
+	ctx.SetStatusCode(fasthttp.StatusSwitchingProtocols)
+	ctx.Response.Header.Set(fasthttp.HeaderConnection, "Upgrade")
+	ctx.Response.Header.Set(fasthttp.HeaderUpgrade, "echo")
+	ctx.Hijack(func(c net.Conn) {
+		io.Copy(c, c) //nolint:errcheck
+	})
+}
+
+func TestReverseProxyUpgrade(t *testing.T) {
+	t.Parallel()
+
+	c := startProxy(t, echoUpgradeHandler, Config{})
+
+	conn, err := c.Dial("example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\nhello")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	br := bufio.NewReader(conn)
+	var resp fasthttp.Response
+	if err = resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.StatusCode() != fasthttp.StatusSwitchingProtocols {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), fasthttp.StatusSwitchingProtocols)
+	}
+	if v := string(resp.Header.Peek(fasthttp.HeaderUpgrade)); v != "echo" {
+		t.Fatalf("unexpected Upgrade header %q. Expecting %q", v, "echo")
+	}
+	if !resp.Header.ConnectionUpgrade() {
+		t.Fatal("missing 'Connection: Upgrade' response header")
+	}
+
+	for _, msg := range []string{"hello", " world"} {
+		if msg != "hello" {
+			if _, err = conn.Write([]byte(msg)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		buf := make([]byte, len(msg))
+		if _, err = io.ReadFull(br, buf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(buf, []byte(msg)) {
+			t.Fatalf("unexpected data %q. Expecting %q", buf, msg)
+		}
+	}
+}
+
+func TestReverseProxyUpgradeRejected(t *testing.T) {
+	t.Parallel()
+
+	c := startProxy(t, echoUpgradeHandler, Config{})
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI("http://example.com/ws")
+	req.Header.Set(fasthttp.HeaderConnection, "Upgrade")
+	req.Header.Set(fasthttp.HeaderUpgrade, "unknown")
+	resp := doRequest(t, c, req)
+	if resp.StatusCode() != fasthttp.StatusBadRequest || !strings.Contains(string(resp.Body()), "unsupported upgrade") {
+		t.Fatalf("unexpected response %d %q", resp.StatusCode(), resp.Body())
+	}
+
+	// The connection to the proxy is kept alive.
+	req.Header.Del(fasthttp.HeaderConnection)
+	req.Header.Del(fasthttp.HeaderUpgrade)
+	resp = doRequest(t, c, req)
+	if resp.StatusCode() != fasthttp.StatusBadRequest {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), fasthttp.StatusBadRequest)
+	}
+	if c.ConnsCount() != 1 {
+		t.Fatalf("unexpected connections count %d. Expecting 1", c.ConnsCount())
+	}
+}
+
+func TestReverseProxyLBClient(t *testing.T) {
+	t.Parallel()
+
+	var cs []fasthttp.BalancingClient
+	for _, name := range []string{"a", "b"} {
+		ln := startServer(t, &fasthttp.Server{Handler: func(ctx *fasthttp.RequestCtx) {
+			ctx.SetBodyString(name)
+		}})
+		cs = append(cs, &fasthttp.HostClient{
+			Addr: name,
+			Dial: func(string) (net.Conn, error) { return ln.Dial() },
+		})
+	}
+	c := startProxy(t, nil, Config{Client: &fasthttp.LBClient{Clients: cs}})
+
+	seen := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		req := fasthttp.AcquireRequest()
+		req.SetRequestURI("http://example.com/")
+		seen[string(doRequest(t, c, req).Body())] = true
+		fasthttp.ReleaseRequest(req)
+	}
+	if !seen["a"] || !seen["b"] {
+		t.Fatalf("requests aren't balanced: %v", seen)
+	}
+}
+
+func TestReverseProxyUpgradeModifyResponseError(t *testing.T) {
+	t.Parallel()
+
+	errModify := errors.New("modify failure")
+	var gotErr error
+	c := startProxy(t, echoUpgradeHandler, Config{
+		ModifyResponse: func(ctx *fasthttp.RequestCtx, resp *fasthttp.Response) error {
+			if resp.StatusCode() != fasthttp.StatusSwitchingProtocols {
+				return nil
+			}
+			return errModify
+		},
+		ErrorHandler: func(ctx *fasthttp.RequestCtx, err error) {
+			gotErr = err
+			ctx.SetStatusCode(fasthttp.StatusBadGateway)
+		},
+	})
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI("http://example.com/ws")
+	req.Header.Set(fasthttp.HeaderConnection, "Upgrade")
+	req.Header.Set(fasthttp.HeaderUpgrade, "echo")
+	resp := doRequest(t, c, req)
+	if resp.StatusCode() != fasthttp.StatusBadGateway {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), fasthttp.StatusBadGateway)
+	}
+	if !errors.Is(gotErr, errModify) {
+		t.Fatalf("unexpected error: %v. Expecting %v", gotErr, errModify)
+	}
+
+	// The connection isn't hijacked, so it serves the following requests.
+	req.Header.Del(fasthttp.HeaderConnection)
+	req.Header.Del(fasthttp.HeaderUpgrade)
+	doRequest(t, c, req)
+	if c.ConnsCount() != 1 {
+		t.Fatalf("unexpected connections count %d. Expecting 1", c.ConnsCount())
+	}
+}
+
+func TestReverseProxyLBClientUpgrade(t *testing.T) {
+	t.Parallel()
+
+	ln := startServer(t, &fasthttp.Server{Handler: echoUpgradeHandler})
+	lbc := &fasthttp.LBClient{Clients: []fasthttp.BalancingClient{&fasthttp.HostClient{
+		Addr: "upstream",
+		Dial: func(string) (net.Conn, error) { return ln.Dial() },
+	}}}
+
+	var gotErr error
+	c := startProxy(t, nil, Config{
+		Client: lbc,
+		ErrorHandler: func(ctx *fasthttp.RequestCtx, err error) {
+			gotErr = err
+			ctx.SetStatusCode(fasthttp.StatusBadGateway)
+		},
+	})
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI("http://example.com/ws")
+	req.Header.Set(fasthttp.HeaderConnection, "Upgrade")
+	req.Header.Set(fasthttp.HeaderUpgrade, "echo")
+	if resp := doRequest(t, c, req); resp.StatusCode() != fasthttp.StatusBadGateway {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), fasthttp.StatusBadGateway)
+	}
+	if !errors.Is(gotErr, ErrUpgradeNotSupported) {
+		t.Fatalf("unexpected error: %v. Expecting %v", gotErr, ErrUpgradeNotSupported)
+	}
+
+	c = startProxy(t, nil, Config{
+		Client: lbc,
+		DialUpgrade: func(req *fasthttp.Request) (net.Conn, error) {
+			return ln.Dial()
+		},
+	})
+	req.Header.Set(fasthttp.HeaderUpgrade, "unknown")
+	if resp := doRequest(t, c, req); resp.StatusCode() != fasthttp.StatusBadRequest {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), fasthttp.StatusBadRequest)
+	}
+}
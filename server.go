@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"mime/multipart"
 	"net"
 	"os"
@@ -436,6 +437,36 @@ type Server struct {
 	// and calls the handler sooner when given body is
 	// larger than the current limit.
 	StreamRequestBody bool
+
+	// DecompressRequestBody enables transparent decoding of request bodies
+	// with gzip, deflate, br or zstd Content-Encoding.
+	//
+	// The Content-Encoding header is removed from the request after decoding,
+	// so the handler sees the plain body. Requests with unsupported
+	// Content-Encoding are rejected with StatusUnsupportedMediaType.
+	//
+	// Streamed request bodies (see StreamRequestBody) are decoded on the fly
+	// while the handler reads them.
+	DecompressRequestBody bool
+
+	// Maximum decompressed request body size.
+	//
+	// The server rejects requests with decompressed bodies exceeding
+	// this limit with StatusRequestEntityTooLarge. Streamed request bodies
+	// return ErrDecompressedBodyTooLarge from Read instead.
+	//
+	// MaxRequestBodySize is used by default.
+	MaxDecompressedRequestBodySize int
+
+	// Maximum ratio between the decompressed and the compressed request
+	// body sizes. It protects from zip bombs, which decompress to a huge
+	// size from a tiny body.
+	//
+	// The ratio isn't checked until the decompressed body reaches 64KiB.
+	//
+	// DefaultMaxRequestBodyDecompressionRatio is used by default.
+	// Negative value disables the check.
+	MaxRequestBodyDecompressionRatio int
 }
 
 // TimeoutHandler creates RequestHandler, which returns StatusRequestTimeout
@@ -503,6 +534,21 @@ type RequestConfig struct {
 	// Maximum request body size.
 	// A zero value means that default values will be honored.
 	MaxRequestBodySize int
+	// DecompressRequestBody enables transparent request body decoding
+	// for the request. See Server.DecompressRequestBody for details.
+	// A false value means that default values will be honored.
+	DecompressRequestBody bool
+	// DisableDecompressRequestBody disables transparent request body
+	// decoding for the request even if Server.DecompressRequestBody is set.
+	// It takes precedence over DecompressRequestBody.
+	DisableDecompressRequestBody bool
+	// Maximum decompressed request body size.
+	// A zero value means that default values will be honored.
+	MaxDecompressedRequestBodySize int
+	// Maximum ratio between the decompressed and the compressed request
+	// body sizes.
+	// A zero value means that default values will be honored.
+	MaxRequestBodyDecompressionRatio int
 }
 
 // CompressHandler returns RequestHandler that transparently compresses
@@ -740,6 +786,20 @@ func (ctx *RequestCtx) Hijacked() bool {
 	return ctx.hijackHandler != nil
 }
 
+// HijackHandler returns the handler registered via Hijack.
+//
+// This function is intended for custom Server implementations.
+func (ctx *RequestCtx) HijackHandler() HijackHandler {
+	return ctx.hijackHandler
+}
+
+// HijackNoResponse returns the value set via HijackSetNoResponse.
+//
+// This function is intended for custom Server implementations.
+func (ctx *RequestCtx) HijackNoResponse() bool {
+	return ctx.hijackNoResponse
+}
+
 // SetUserValue stores the given value (arbitrary object)
 // under the given key in Request.
 //
@@ -934,6 +994,10 @@ type ctxLogger struct {
 
 func (cl *ctxLogger) Printf(format string, args ...any) {
 	msg := fmt.Sprintf(format, args...)
+	if sl, ok := cl.logger.(StructuredLogger); ok {
+		sl.LogAttrs(context.Background(), slog.LevelInfo, msg, cl.ctx.requestAttrs()...)
+		return
+	}
 	ctxLoggerLock.Lock()
 	cl.logger.Printf("%.3f %s - %s", time.Since(cl.ctx.ConnTime()).Seconds(), cl.ctx.String(), msg)
 	ctxLoggerLock.Unlock()
@@ -1893,8 +1957,13 @@ func (s *Server) Serve(ln net.Listener) error {
 			c.Close()
 			s.setState(c, StateClosed)
 			if time.Since(lastOverflowErrorTime) > time.Minute {
-				s.logger().Printf("The incoming connection cannot be served, because %d concurrent connections are served. "+
-					"Try increasing Server.Concurrency", maxWorkersCount)
+				logRecord(s.logger(), slog.LevelWarn, "concurrency limit exceeded",
+					[]slog.Attr{
+						slog.Int("concurrency", maxWorkersCount),
+						slog.String("remote_addr", c.RemoteAddr().String()),
+					},
+					"The incoming connection cannot be served, because %d concurrent connections are served. "+
+						"Try increasing Server.Concurrency", maxWorkersCount)
 				lastOverflowErrorTime = time.Now()
 			}
 
@@ -1990,12 +2059,16 @@ func acceptConn(s *Server, ln net.Listener, lastPerIPErrorTime *time.Time) (net.
 		c, err := ln.Accept()
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				s.logger().Printf("Timeout error when accepting new connections: %v", netErr)
+				logRecord(s.logger(), slog.LevelWarn, "timeout when accepting new connections",
+					[]slog.Attr{slog.String("addr", ln.Addr().String()), slog.Any("error", netErr)},
+					"Timeout error when accepting new connections: %v", netErr)
 				time.Sleep(time.Second)
 				continue
 			}
 			if err != io.EOF && !strings.Contains(err.Error(), "use of closed network connection") {
-				s.logger().Printf("Permanent error when accepting new connections: %v", err)
+				logRecord(s.logger(), slog.LevelError, "permanent error when accepting new connections",
+					[]slog.Attr{slog.String("addr", ln.Addr().String()), slog.Any("error", err)},
+					"Permanent error when accepting new connections: %v", err)
 				return nil, err
 			}
 			return nil, io.EOF
@@ -2018,8 +2091,9 @@ func acceptConn(s *Server, ln net.Listener, lastPerIPErrorTime *time.Time) (net.
 			pic := wrapPerIPConn(s, c)
 			if pic == nil {
 				if time.Since(*lastPerIPErrorTime) > time.Minute {
-					s.logger().Printf("The number of connections from %s exceeds MaxConnsPerIP=%d",
-						getConnIP4(c), s.MaxConnsPerIP)
+					logRecord(s.logger(), slog.LevelWarn, "too many connections per IP",
+						[]slog.Attr{slog.String("ip", getConnIP4(c).String()), slog.Int("max_conns_per_ip", s.MaxConnsPerIP)},
+						"The number of connections from %s exceeds MaxConnsPerIP=%d", getConnIP4(c), s.MaxConnsPerIP)
 					*lastPerIPErrorTime = time.Now()
 				}
 				continue
@@ -2223,6 +2297,9 @@ func (s *Server) serveConn(c net.Conn) error {
 	}
 	writeTimeout := s.WriteTimeout
 	previousWriteTimeout := time.Duration(0)
+	decompressRequestBody := s.DecompressRequestBody
+	maxDecompressedRequestBodySize := s.MaxDecompressedRequestBodySize
+	maxRequestBodyDecompressionRatio := s.MaxRequestBodyDecompressionRatio
 
 	ctx := s.acquireCtx(c)
 	ctx.connTime = connTime
@@ -2348,6 +2425,24 @@ func (s *Server) serveConn(c net.Conn) error {
 					} else {
 						writeTimeout = s.WriteTimeout
 					}
+					switch {
+					case reqConf.DisableDecompressRequestBody:
+						decompressRequestBody = false
+					case reqConf.DecompressRequestBody:
+						decompressRequestBody = true
+					default:
+						decompressRequestBody = s.DecompressRequestBody
+					}
+					if reqConf.MaxDecompressedRequestBodySize > 0 {
+						maxDecompressedRequestBodySize = reqConf.MaxDecompressedRequestBodySize
+					} else {
+						maxDecompressedRequestBodySize = s.MaxDecompressedRequestBodySize
+					}
+					if reqConf.MaxRequestBodyDecompressionRatio != 0 {
+						maxRequestBodyDecompressionRatio = reqConf.MaxRequestBodyDecompressionRatio
+					} else {
+						maxRequestBodyDecompressionRatio = s.MaxRequestBodyDecompressionRatio
+					}
 				}
 
 				if err == nil {
@@ -2460,6 +2555,14 @@ func (s *Server) serveConn(c net.Conn) error {
 		ctx.connRequestNum = connRequestNum
 		ctx.time = time.Now()
 
+		if continueReadingRequest && decompressRequestBody {
+			if err = s.decompressRequestBody(ctx, maxRequestBodySize,
+				maxDecompressedRequestBodySize, maxRequestBodyDecompressionRatio); err != nil {
+				bw = s.writeErrorResponse(bw, ctx, serverName, err)
+				break
+			}
+		}
+
 		// If a client denies a request the handler should not be called
 		if continueReadingRequest {
 			s.Handler(ctx)
@@ -2563,8 +2666,11 @@ func (s *Server) serveConn(c net.Conn) error {
 		}
 
 		if ctx.Request.bodyStream != nil {
-			if rs, ok := ctx.Request.bodyStream.(*requestStream); ok {
-				releaseRequestStream(rs)
+			switch bs := ctx.Request.bodyStream.(type) {
+			case *requestStream:
+				releaseRequestStream(bs)
+			case *requestBodyDecoder:
+				bs.Close() //nolint:errcheck
 			}
 			ctx.Request.bodyStream = nil
 		}
@@ -2951,6 +3057,10 @@ func (s *Server) writeFastError(w io.Writer, statusCode int, msg string) {
 func defaultErrorHandler(ctx *RequestCtx, err error) {
 	if _, ok := err.(*ErrSmallBuffer); ok {
 		ctx.Error("Too big request header", StatusRequestHeaderFieldsTooLarge)
+	} else if errors.Is(err, ErrDecompressedBodyTooLarge) || errors.Is(err, ErrDecompressionRatioTooLarge) {
+		ctx.Error("Too big decompressed request body", StatusRequestEntityTooLarge)
+	} else if errors.Is(err, ErrContentEncodingUnsupported) {
+		ctx.Error("Unsupported request Content-Encoding", StatusUnsupportedMediaType)
 	} else if netErr, ok := err.(*net.OpError); ok && netErr.Timeout() {
 		ctx.Error("Request timeout", StatusRequestTimeout)
 	} else {
@@ -2958,6 +3068,19 @@ func defaultErrorHandler(ctx *RequestCtx, err error) {
 	}
 }
 
+func (s *Server) decompressRequestBody(ctx *RequestCtx, maxRequestBodySize, maxSize, maxRatio int) error {
+	if maxSize <= 0 {
+		maxSize = maxRequestBodySize
+	}
+	switch {
+	case maxRatio == 0:
+		maxRatio = DefaultMaxRequestBodyDecompressionRatio
+	case maxRatio < 0:
+		maxRatio = 0
+	}
+	return ctx.Request.decompressBody(maxSize, maxRatio)
+}
+
 func (s *Server) writeErrorResponse(bw *bufio.Writer, ctx *RequestCtx, serverName string, err error) *bufio.Writer {
 	errorHandler := defaultErrorHandler
 	if s.ErrorHandler != nil {
@@ -4514,3 +4514,212 @@ func TestRequestCtxInitShouldNotBeCanceledIssue1879(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestServerDecompressRequestBody(t *testing.T) {
+	t.Parallel()
+
+	body := strings.Repeat("foobar baz ", 1000)
+	encoders := map[string]func([]byte) []byte{
+		"gzip":    func(p []byte) []byte { return AppendGzipBytes(nil, p) },
+		"deflate": func(p []byte) []byte { return AppendDeflateBytes(nil, p) },
+		"br":      func(p []byte) []byte { return AppendBrotliBytes(nil, p) },
+		"zstd":    func(p []byte) []byte { return AppendZstdBytes(nil, p) },
+		"gzip, br": func(p []byte) []byte {
+			return AppendBrotliBytes(nil, AppendGzipBytes(nil, p))
+		},
+	}
+
+	for _, stream := range []bool{false, true} {
+		for encoding, encode := range encoders {
+			s := &Server{
+				Handler: func(ctx *RequestCtx) {
+					if ce := ctx.Request.Header.ContentEncoding(); len(ce) != 0 {
+						t.Errorf("unexpected Content-Encoding %q", ce)
+					}
+					ctx.SetBody(ctx.Request.Body())
+				},
+				DecompressRequestBody: true,
+				StreamRequestBody:     stream,
+			}
+
+			encoded := encode([]byte(body))
+			rw := &readWriter{}
+			fmt.Fprintf(&rw.r, "POST /foo HTTP/1.1\r\nHost: google.com\r\nContent-Encoding: %s\r\nContent-Length: %d\r\n\r\n%s",
+				encoding, len(encoded), encoded)
+
+			if err := s.ServeConn(rw); err != nil {
+				t.Fatalf("unexpected error for %q (stream %v): %v", encoding, stream, err)
+			}
+
+			var resp Response
+			if err := resp.Read(bufio.NewReader(&rw.w)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.StatusCode() != StatusOK {
+				t.Fatalf("unexpected status code %d for %q (stream %v)", resp.StatusCode(), encoding, stream)
+			}
+			if string(resp.Body()) != body {
+				t.Fatalf("unexpected body for %q (stream %v): %q", encoding, stream, resp.Body())
+			}
+		}
+	}
+}
+
+func TestServerDecompressRequestBodyLimits(t *testing.T) {
+	t.Parallel()
+
+	bomb := AppendGzipBytes(nil, make([]byte, 10<<20))
+
+	testCases := []struct {
+		name       string
+		s          *Server
+		encoding   string
+		body       []byte
+		statusCode int
+	}{
+		{
+			name:       "max size",
+			s:          &Server{MaxDecompressedRequestBodySize: 1 << 20},
+			encoding:   "gzip",
+			body:       bomb,
+			statusCode: StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "default max size",
+			s:          &Server{MaxRequestBodySize: 1 << 20, MaxRequestBodyDecompressionRatio: -1},
+			encoding:   "gzip",
+			body:       bomb,
+			statusCode: StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "ratio",
+			s:          &Server{MaxDecompressedRequestBodySize: 100 << 20},
+			encoding:   "gzip",
+			body:       bomb,
+			statusCode: StatusRequestEntityTooLarge,
+		},
+		{
+			name: "request config",
+			s: &Server{
+				HeaderReceived: func(header *RequestHeader) RequestConfig {
+					return RequestConfig{
+						DecompressRequestBody:            true,
+						MaxDecompressedRequestBodySize:   100 << 20,
+						MaxRequestBodyDecompressionRatio: -1,
+					}
+				},
+			},
+			encoding:   "gzip",
+			body:       bomb,
+			statusCode: StatusOK,
+		},
+		{
+			name:       "unsupported",
+			s:          &Server{},
+			encoding:   "compress",
+			body:       []byte("foobar"),
+			statusCode: StatusUnsupportedMediaType,
+		},
+		{
+			name:       "corrupted",
+			s:          &Server{},
+			encoding:   "gzip",
+			body:       []byte("foobar"),
+			statusCode: StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			tc.s.Handler = func(ctx *RequestCtx) {}
+			if tc.s.MaxRequestBodySize == 0 {
+				tc.s.MaxRequestBodySize = 20 << 20
+			}
+			if tc.s.HeaderReceived == nil {
+				tc.s.DecompressRequestBody = true
+			}
+
+			rw := &readWriter{}
+			fmt.Fprintf(&rw.r, "POST /foo HTTP/1.1\r\nHost: google.com\r\nContent-Encoding: %s\r\nContent-Length: %d\r\n\r\n%s",
+				tc.encoding, len(tc.body), tc.body)
+
+			tc.s.ServeConn(rw) //nolint:errcheck
+
+			var resp Response
+			if err := resp.Read(bufio.NewReader(&rw.w)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.StatusCode() != tc.statusCode {
+				t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), tc.statusCode)
+			}
+		})
+	}
+}
+
+func TestServerDecompressRequestBodyStreamLimit(t *testing.T) {
+	t.Parallel()
+
+	bomb := AppendGzipBytes(nil, make([]byte, 10<<20))
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			_, err := io.Copy(io.Discard, ctx.RequestBodyStream())
+			if !errors.Is(err, ErrDecompressedBodyTooLarge) {
+				t.Errorf("unexpected error: %v. Expecting %v", err, ErrDecompressedBodyTooLarge)
+			}
+		},
+		DecompressRequestBody:            true,
+		MaxDecompressedRequestBodySize:   1 << 20,
+		MaxRequestBodyDecompressionRatio: -1,
+		StreamRequestBody:                true,
+	}
+
+	rw := &readWriter{}
+	fmt.Fprintf(&rw.r, "POST /foo HTTP/1.1\r\nHost: google.com\r\nConnection: close\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s",
+		len(bomb), bomb)
+
+	if err := s.ServeConn(rw); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestServerDecompressRequestBodyDisabledPerRequest(t *testing.T) {
+	t.Parallel()
+
+	encoded := AppendGzipBytes(nil, []byte("foobar"))
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if ce := ctx.Request.Header.ContentEncoding(); string(ce) != "gzip" {
+				t.Errorf("unexpected Content-Encoding %q. Expecting %q", ce, "gzip")
+			}
+			if !bytes.Equal(ctx.Request.Body(), encoded) {
+				t.Errorf("unexpected body %q. Expecting %q", ctx.Request.Body(), encoded)
+			}
+		},
+		HeaderReceived: func(header *RequestHeader) RequestConfig {
+			return RequestConfig{
+				DecompressRequestBody:        true,
+				DisableDecompressRequestBody: true,
+			}
+		},
+		DecompressRequestBody: true,
+	}
+
+	rw := &readWriter{}
+	fmt.Fprintf(&rw.r, "POST /foo HTTP/1.1\r\nHost: google.com\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s",
+		len(encoded), encoded)
+
+	if err := s.ServeConn(rw); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var resp Response
+	if err := resp.Read(bufio.NewReader(&rw.w)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusOK)
+	}
+}
@@ -0,0 +1,196 @@
+package fasthttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/bhargawpradhan/fasthttp/internal/logutil"
+)
+
+// StructuredLogger is a Logger accepting structured log records.
+//
+// Server, HostClient, PipelineClient and prefork emit records with attributes
+// to a StructuredLogger instead of formatted messages, so the log
+// pipeline doesn't need to parse them. See NewSlogLogger.
+type StructuredLogger interface {
+	Logger
+
+	// LogAttrs logs the message with the given level and attributes.
+	LogAttrs(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr)
+}
+
+// SlogLogger is a StructuredLogger writing records to slog.Handler.
+//
+// It may be used as Logger in Server, HostClient, PipelineClient, prefork.Prefork
+// and anywhere else a Printf Logger is accepted.
+type SlogLogger struct {
+	handler slog.Handler
+	level   slog.Leveler
+}
+
+// NewSlogLogger returns a logger writing records to h.
+//
+// Records with levels below level are dropped. All the records
+// enabled by h are written if level is nil.
+//
+// Messages logged via Printf are written with slog.LevelInfo.
+func NewSlogLogger(h slog.Handler, level slog.Leveler) *SlogLogger {
+	return &SlogLogger{
+		handler: h,
+		level:   level,
+	}
+}
+
+// Printf implements Logger.
+func (l *SlogLogger) Printf(format string, args ...any) {
+	ctx := context.Background()
+	if !l.Enabled(ctx, slog.LevelInfo) {
+		return
+	}
+	l.LogAttrs(ctx, slog.LevelInfo, strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"))
+}
+
+// LogAttrs implements StructuredLogger.
+func (l *SlogLogger) LogAttrs(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr) {
+	if !l.Enabled(ctx, level) {
+		return
+	}
+	r := slog.NewRecord(time.Now(), level, msg, 0)
+	r.AddAttrs(attrs...)
+	_ = l.handler.Handle(ctx, r)
+}
+
+// Enabled returns true if records with the given level are logged.
+func (l *SlogLogger) Enabled(ctx context.Context, level slog.Level) bool {
+	if l.level != nil && level < l.level.Level() {
+		return false
+	}
+	return l.handler.Enabled(ctx, level)
+}
+
+// Handler returns slog.Handler applying the level filter of l.
+func (l *SlogLogger) Handler() slog.Handler {
+	if l.level == nil {
+		return l.handler
+	}
+	return &levelHandler{Handler: l.handler, level: l.level}
+}
+
+// levelHandler drops records with levels below the given level.
+type levelHandler struct {
+	slog.Handler
+	level slog.Leveler
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() && h.Handler.Enabled(ctx, level)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithAttrs(attrs), level: h.level}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithGroup(name), level: h.level}
+}
+
+// logRecord logs the record with the given attributes if l is a StructuredLogger.
+// Otherwise the format and args are passed to l.Printf. See logutil.Record.
+func logRecord(l Logger, level slog.Level, msg string, attrs []slog.Attr, format string, args ...any) {
+	logutil.Record(l, level, msg, attrs, format, args...)
+}
+
+// connErrorRecord returns the level, message and error kind for the error
+// returned while serving a connection.
+func connErrorRecord(err error) (slog.Level, string, string) {
+	var smallBuffer *ErrSmallBuffer
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() ||
+		strings.Contains(err.Error(), "i/o timeout") {
+		return slog.LevelDebug, "connection timeout", "timeout"
+	}
+	if errors.As(err, &smallBuffer) || strings.Contains(err.Error(), "error when reading request headers") {
+		return slog.LevelWarn, "cannot parse request", "parse"
+	}
+	return slog.LevelWarn, "error when serving connection", "conn"
+}
+
+// requestAttrs returns the attributes identifying the request.
+func (ctx *RequestCtx) requestAttrs() []slog.Attr {
+	return []slog.Attr{
+		slog.Uint64("request_id", ctx.ID()),
+		slog.Uint64("conn_id", ctx.ConnID()),
+		slog.Uint64("conn_request_num", ctx.ConnRequestNum()),
+		slog.String("local_addr", ctx.LocalAddr().String()),
+		slog.String("remote_addr", ctx.RemoteAddr().String()),
+		slog.String("method", string(ctx.Method())),
+		slog.String("uri", string(ctx.URI().FullURI())),
+	}
+}
+
+// LogAttrs implements StructuredLogger.
+//
+// The request attributes are added to the record. The message and
+// attributes are formatted with Printf if the Server logger isn't
+// a StructuredLogger.
+func (cl *ctxLogger) LogAttrs(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr) {
+	if sl, ok := cl.logger.(StructuredLogger); ok {
+		sl.LogAttrs(ctx, level, msg, append(cl.ctx.requestAttrs(), attrs...)...)
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString(msg)
+	for _, a := range attrs {
+		sb.WriteByte(' ')
+		sb.WriteString(a.String())
+	}
+	cl.Printf("%s", sb.String())
+}
+
+// Slog returns slog.Logger for logging request-specific records inside
+// RequestHandler.
+//
+// Each record logged via the returned logger contains request_id, conn_id,
+// conn_request_num, local_addr, remote_addr, method and uri attributes.
+// Records are written to the Server logger handler if the Server logger
+// is SlogLogger. Otherwise they are formatted as text and passed to
+// the Server logger Printf.
+//
+// The returned logger is valid until your request handler returns.
+func (ctx *RequestCtx) Slog() *slog.Logger {
+	cl := ctx.Logger().(*ctxLogger)
+	var h slog.Handler
+	if sl, ok := cl.logger.(*SlogLogger); ok {
+		h = sl.Handler()
+	} else {
+		h = slog.NewTextHandler(printfWriter{cl.logger}, &slog.HandlerOptions{
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				// The Printf logger writes its own timestamp.
+				if len(groups) == 0 && a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				return a
+			},
+		})
+	}
+	attrs := ctx.requestAttrs()
+	args := make([]any, len(attrs))
+	for i, a := range attrs {
+		args[i] = a
+	}
+	return slog.New(h).With(args...)
+}
+
+// printfWriter passes the lines written by slog.TextHandler to Logger.
+type printfWriter struct {
+	logger Logger
+}
+
+func (w printfWriter) Write(p []byte) (int, error) {
+	w.logger.Printf("%s", strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
@@ -0,0 +1,229 @@
+package fasthttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bhargawpradhan/fasthttp/fasthttputil"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	b  bytes.Buffer
+	mu sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) records(t *testing.T) []map[string]any {
+	t.Helper()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(b.b.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("cannot parse log line %q: %v", line, err)
+		}
+		records = append(records, m)
+	}
+	return records
+}
+
+func TestSlogLoggerLevel(t *testing.T) {
+	t.Parallel()
+
+	var out syncBuffer
+	l := NewSlogLogger(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}), slog.LevelWarn)
+	l.Printf("info message %d", 1)
+	logRecord(l, slog.LevelDebug, "debug", nil, "")
+	logRecord(l, slog.LevelError, "error record", []slog.Attr{slog.Int("n", 42)}, "unused %d", 42)
+
+	records := out.records(t)
+	if len(records) != 1 {
+		t.Fatalf("unexpected number of records %d. Expecting 1", len(records))
+	}
+	if records[0]["msg"] != "error record" || records[0]["n"] != float64(42) || records[0]["level"] != "ERROR" {
+		t.Fatalf("unexpected record %v", records[0])
+	}
+	if l.Handler().Enabled(context.Background(), slog.LevelInfo) {
+		t.Fatal("the handler must filter the info level")
+	}
+}
+
+func TestLogRecordPrintf(t *testing.T) {
+	t.Parallel()
+
+	var tl testLogger
+	logRecord(&tl, slog.LevelWarn, "ignored", []slog.Attr{slog.Int("n", 1)}, "foo %d", 1)
+	logRecord(&tl, slog.LevelInfo, "structured only", nil, "")
+	if tl.out != "1\n" {
+		t.Fatalf("unexpected output %q", tl.out)
+	}
+}
+
+func TestRequestCtxSlog(t *testing.T) {
+	t.Parallel()
+
+	var out syncBuffer
+	logger := NewSlogLogger(slog.NewJSONHandler(&out, nil), nil)
+
+	var req Request
+	req.SetRequestURI("http://example.com/foo")
+	var ctx RequestCtx
+	ctx.Init(&req, &net.TCPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 80}, logger)
+
+	ctx.Slog().Info("from slog", "k", "v")
+	ctx.Logger().Printf("from printf %d", 2)
+	ctx.Slog().Debug("filtered")
+
+	records := out.records(t)
+	if len(records) != 2 {
+		t.Fatalf("unexpected number of records %d. Expecting 2", len(records))
+	}
+	for _, r := range records {
+		if r["request_id"] != float64(ctx.ID()) || r["conn_id"] != float64(ctx.ConnID()) ||
+			r["remote_addr"] != "1.2.3.4:80" || r["uri"] != "http://example.com/foo" {
+			t.Fatalf("missing request attributes in %v", r)
+		}
+	}
+	if records[0]["k"] != "v" || records[1]["msg"] != "from printf 2" {
+		t.Fatalf("unexpected records %v", records)
+	}
+}
+
+func TestRequestCtxSlogPrintfLogger(t *testing.T) {
+	t.Parallel()
+
+	var tl testLogger
+	var req Request
+	req.SetRequestURI("http://example.com/foo")
+	var ctx RequestCtx
+	ctx.Init(&req, nil, &tl)
+
+	ctx.Slog().Warn("slow request", "elapsed", time.Second)
+	if !strings.Contains(tl.out, `msg="slow request"`) || !strings.Contains(tl.out, "elapsed=1s") ||
+		!strings.Contains(tl.out, "uri=http://example.com/foo") {
+		t.Fatalf("unexpected output %q", tl.out)
+	}
+}
+
+func TestServerSlogConnErrors(t *testing.T) {
+	t.Parallel()
+
+	var out syncBuffer
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {},
+		Logger:  NewSlogLogger(slog.NewJSONHandler(&out, nil), nil),
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	serverCh := make(chan error, 1)
+	go func() {
+		serverCh <- s.Serve(ln)
+	}()
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = c.Write([]byte("GET / HTTP/1.1\r\nHost: foo\r\nbad header line\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf [1024]byte
+	_, _ = c.Read(buf[:])
+	c.Close()
+
+	var records []map[string]any
+	for i := 0; i < 100; i++ {
+		if records = out.records(t); len(records) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(records) != 1 {
+		t.Fatalf("unexpected number of records %d. Expecting 1", len(records))
+	}
+	r := records[0]
+	if r["msg"] != "cannot parse request" || r["kind"] != "parse" || r["level"] != "WARN" {
+		t.Fatalf("unexpected record %v", r)
+	}
+	if s, _ := r["error"].(string); !strings.Contains(s, "error when reading request headers") {
+		t.Fatalf("unexpected error attribute in %v", r)
+	}
+
+	ln.Close()
+	if err := <-serverCh; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestHostClientSlog(t *testing.T) {
+	t.Parallel()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	var out syncBuffer
+	dials := 0
+	c := &HostClient{
+		Addr: "foo.bar",
+		Dial: func(addr string) (net.Conn, error) {
+			if dials++; dials == 1 {
+				return nil, errors.New("dial error")
+			}
+			return ln.Dial()
+		},
+		Logger:                    NewSlogLogger(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}), nil),
+		MaxIdemponentCallAttempts: 2,
+	}
+
+	if err := c.Do(&Request{}, &Response{}); err == nil {
+		t.Fatal("expecting dial error")
+	}
+	if err := c.Do(&Request{}, &Response{}); err == nil {
+		t.Fatal("expecting connection error")
+	}
+
+	msgs := make(map[string]int)
+	for _, r := range out.records(t) {
+		msgs[r["msg"].(string)]++
+		if r["addr"] == nil || r["error"] == nil {
+			t.Fatalf("missing attributes in record %v", r)
+		}
+	}
+	if msgs["HostClient dial error"] != 1 {
+		t.Fatalf("unexpected dial error records in %v", msgs)
+	}
+	if msgs["HostClient retrying request"] != 1 {
+		t.Fatalf("unexpected retry records in %v", msgs)
+	}
+	if msgs["HostClient connection closed on error"] != 2 {
+		t.Fatalf("unexpected connection close records in %v", msgs)
+	}
+}
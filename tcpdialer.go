@@ -69,9 +69,8 @@ func DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
 //
 //   - It reduces load on DNS resolver by caching resolved TCP addressed
 //     for DNSCacheDuration.
-//   - It dials all the resolved TCP addresses in round-robin manner until
-//     connection is established. This may be useful if certain addresses
-//     are temporarily unreachable.
+//   - It races connection attempts to the resolved IPv6 and IPv4 addresses
+//     as described in RFC 8305 (Happy Eyeballs). See TCPDialer.FallbackDelay.
 //   - It returns ErrDialTimeout if connection cannot be established during
 //     DefaultDialTimeout seconds. Use DialDualStackTimeout for custom dial
 //     timeout.
@@ -98,9 +97,8 @@ func DialDualStack(addr string) (net.Conn, error) {
 //
 //   - It reduces load on DNS resolver by caching resolved TCP addressed
 //     for DNSCacheDuration.
-//   - It dials all the resolved TCP addresses in round-robin manner until
-//     connection is established. This may be useful if certain addresses
-//     are temporarily unreachable.
+//   - It races connection attempts to the resolved IPv6 and IPv4 addresses
+//     as described in RFC 8305 (Happy Eyeballs). See TCPDialer.FallbackDelay.
 //
 // This dialer is intended for custom code wrapping before passing
 // to Client.DialTimeout or HostClient.DialTimeout.
@@ -137,8 +135,17 @@ type TCPDialer struct {
 	// 		},
 	// 	},
 	// }
+	//
+	// The time-to-live of the resolved records is taken into account
+	// if Resolver implements TTLResolver. See DoHResolver.
 	Resolver Resolver
 
+	// StaticHosts overrides DNS resolution for the given host names
+	// like /etc/hosts does. Map keys are host names without port.
+	//
+	// WARNING: StaticHosts mustn't be modified after the first Dial.
+	StaticHosts map[string][]net.IPAddr
+
 	// LocalAddr is the local address to use when dialing an
 	// address.
 	// If nil, a local address is automatically chosen.
@@ -157,10 +164,58 @@ type TCPDialer struct {
 	Concurrency int
 
 	// DNSCacheDuration may be used to override the default DNS cache duration (DefaultDNSCacheDuration)
+	//
+	// Addresses returned by TTLResolver are cached for their time-to-live
+	// clamped to [DNSMinTTL, DNSMaxTTL] instead. DNSCacheDuration is used
+	// for them if the time-to-live is unknown.
 	DNSCacheDuration time.Duration
 
+	// DNSMinTTL is the minimum duration for caching addresses
+	// returned by TTLResolver.
+	//
+	// By default the time-to-live of the records isn't limited from below.
+	DNSMinTTL time.Duration
+
+	// DNSMaxTTL is the maximum duration for caching addresses
+	// returned by TTLResolver.
+	//
+	// By default the time-to-live of the records isn't limited from above.
+	DNSMaxTTL time.Duration
+
+	// DNSStaleDuration is the duration for serving expired cached addresses
+	// while the resolver fails, so resolver outages don't break dialing
+	// to the known hosts.
+	//
+	// By default expired addresses aren't served on resolver errors.
+	DNSStaleDuration time.Duration
+
+	// DNSPrefetchDuration enables re-resolving the cached host names
+	// in the background. A cached entry used during the last
+	// DNSPrefetchDuration of its time-to-live is refreshed before
+	// it expires, so frequently dialed hosts never wait for the resolver.
+	//
+	// By default host names are re-resolved only after they expire.
+	DNSPrefetchDuration time.Duration
+
+	// OnDualStackDial, when set, is called after DialDualStack* establishes
+	// the connection by racing the resolved addresses. See FallbackDelay.
+	OnDualStackDial func(addr string, info DualStackDialInfo)
+
+	// testHookDial replaces net.Dialer.DialContext in tests.
+	testHookDial func(ctx context.Context, network, addr string) (net.Conn, error)
+
 	once sync.Once
 
+	// FallbackDelay is the Connection Attempt Delay from RFC 8305 used
+	// by DialDualStack*. It is the time to wait for the in-progress
+	// connection attempt before starting the attempt to the next resolved
+	// address. IPv6 and IPv4 addresses are interleaved, so a blackholed
+	// address family doesn't delay the connection for the whole timeout.
+	//
+	// DefaultFallbackDelay is used if FallbackDelay is zero. Negative value
+	// disables racing, so the resolved addresses are dialed one after another.
+	FallbackDelay time.Duration
+
 	// DisableDNSResolution may be used to disable DNS resolution
 	DisableDNSResolution bool
 }
@@ -189,7 +244,7 @@ type TCPDialer struct {
 //   - foo.bar:80
 //   - aaa.com:8080
 func (d *TCPDialer) Dial(addr string) (net.Conn, error) {
-	return d.dial(addr, false, DefaultDialTimeout)
+	return d.dial(addr, false, DefaultDialTimeout, nil)
 }
 
 // DialTimeout dials the given TCP addr using tcp4 using the given timeout.
@@ -214,7 +269,7 @@ func (d *TCPDialer) Dial(addr string) (net.Conn, error) {
 //   - foo.bar:80
 //   - aaa.com:8080
 func (d *TCPDialer) DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
-	return d.dial(addr, false, timeout)
+	return d.dial(addr, false, timeout, nil)
 }
 
 // DialDualStack dials the given TCP addr using both tcp4 and tcp6.
@@ -223,9 +278,8 @@ func (d *TCPDialer) DialTimeout(addr string, timeout time.Duration) (net.Conn, e
 //
 //   - It reduces load on DNS resolver by caching resolved TCP addressed
 //     for DNSCacheDuration.
-//   - It dials all the resolved TCP addresses in round-robin manner until
-//     connection is established. This may be useful if certain addresses
-//     are temporarily unreachable.
+//   - It races connection attempts to the resolved IPv6 and IPv4 addresses
+//     as described in RFC 8305 (Happy Eyeballs). See TCPDialer.FallbackDelay.
 //   - It returns ErrDialTimeout if connection cannot be established during
 //     DefaultDialTimeout seconds. Use DialDualStackTimeout for custom dial
 //     timeout.
@@ -242,7 +296,7 @@ func (d *TCPDialer) DialTimeout(addr string, timeout time.Duration) (net.Conn, e
 //   - foo.bar:80
 //   - aaa.com:8080
 func (d *TCPDialer) DialDualStack(addr string) (net.Conn, error) {
-	return d.dial(addr, true, DefaultDialTimeout)
+	return d.dial(addr, true, DefaultDialTimeout, nil)
 }
 
 // DialDualStackTimeout dials the given TCP addr using both tcp4 and tcp6
@@ -252,9 +306,8 @@ func (d *TCPDialer) DialDualStack(addr string) (net.Conn, error) {
 //
 //   - It reduces load on DNS resolver by caching resolved TCP addressed
 //     for DNSCacheDuration.
-//   - It dials all the resolved TCP addresses in round-robin manner until
-//     connection is established. This may be useful if certain addresses
-//     are temporarily unreachable.
+//   - It races connection attempts to the resolved IPv6 and IPv4 addresses
+//     as described in RFC 8305 (Happy Eyeballs). See TCPDialer.FallbackDelay.
 //
 // This dialer is intended for custom code wrapping before passing
 // to Client.DialTimeout or HostClient.DialTimeout.
@@ -268,7 +321,7 @@ func (d *TCPDialer) DialDualStack(addr string) (net.Conn, error) {
 //   - foo.bar:80
 //   - aaa.com:8080
 func (d *TCPDialer) DialDualStackTimeout(addr string, timeout time.Duration) (net.Conn, error) {
-	return d.dial(addr, true, timeout)
+	return d.dial(addr, true, timeout, nil)
 }
 
 // FlushDNSCache clears all cached DNS entries, forcing fresh DNS lookups on subsequent dials.
@@ -287,7 +340,7 @@ func FlushDNSCache() {
 	defaultDialer.FlushDNSCache()
 }
 
-func (d *TCPDialer) dial(addr string, dualStack bool, timeout time.Duration) (net.Conn, error) {
+func (d *TCPDialer) dial(addr string, dualStack bool, timeout time.Duration, trace *ClientTrace) (net.Conn, error) {
 	d.once.Do(func() {
 		if d.Concurrency > 0 {
 			d.concurrencyCh = make(chan struct{}, d.Concurrency)
@@ -307,16 +360,20 @@ func (d *TCPDialer) dial(addr string, dualStack bool, timeout time.Duration) (ne
 		network = "tcp"
 	}
 	if d.DisableDNSResolution {
-		return d.tryDial(network, addr, deadline, d.concurrencyCh)
+		return d.tryDial(network, addr, deadline, d.concurrencyCh, trace)
 	}
-	addrs, idx, err := d.getTCPAddrs(addr, dualStack, deadline)
+	e, idx, err := d.getTCPAddrs(addr, dualStack, deadline, trace)
 	if err != nil {
 		return nil, err
 	}
+	addrs := e.addrs
+	if dualStack && d.FallbackDelay >= 0 && len(addrs) > 1 {
+		return d.dialHappyEyeballs(addr, e, idx, deadline, trace)
+	}
 	var conn net.Conn
 	n := uint32(len(addrs)) // #nosec G115
 	for range n {
-		conn, err = d.tryDial(network, addrs[idx%n].String(), deadline, d.concurrencyCh)
+		conn, err = d.tryDial(network, addrs[idx%n].String(), deadline, d.concurrencyCh, trace)
 		if err == nil {
 			return conn, nil
 		}
@@ -329,7 +386,16 @@ func (d *TCPDialer) dial(addr string, dualStack bool, timeout time.Duration) (ne
 }
 
 func (d *TCPDialer) tryDial(
-	network string, addr string, deadline time.Time, concurrencyCh chan struct{},
+	network string, addr string, deadline time.Time, concurrencyCh chan struct{}, trace *ClientTrace,
+) (net.Conn, error) {
+	return d.tryDialContext(context.Background(), network, addr, deadline, concurrencyCh, trace)
+}
+
+// tryDialContext dials addr like tryDial. The dial is aborted
+// when parent is done.
+func (d *TCPDialer) tryDialContext(
+	parent context.Context, network string, addr string, deadline time.Time, concurrencyCh chan struct{},
+	trace *ClientTrace,
 ) (net.Conn, error) {
 	timeout := time.Until(deadline)
 	if timeout <= 0 {
@@ -342,15 +408,21 @@ func (d *TCPDialer) tryDial(
 		default:
 			tc := AcquireTimer(timeout)
 			isTimeout := false
+			isCanceled := false
 			select {
 			case concurrencyCh <- struct{}{}:
 			case <-tc.C:
 				isTimeout = true
+			case <-parent.Done():
+				isCanceled = true
 			}
 			ReleaseTimer(tc)
 			if isTimeout {
 				return nil, wrapDialWithUpstream(ErrDialTimeout, addr)
 			}
+			if isCanceled {
+				return nil, wrapDialWithUpstream(parent.Err(), addr)
+			}
 		}
 		defer func() { <-concurrencyCh }()
 	}
@@ -360,9 +432,15 @@ func (d *TCPDialer) tryDial(
 		dialer.LocalAddr = d.LocalAddr
 	}
 
-	ctx, cancelCtx := context.WithDeadline(context.Background(), deadline)
+	ctx, cancelCtx := context.WithDeadline(parent, deadline)
 	defer cancelCtx()
-	conn, err := dialer.DialContext(ctx, network, addr)
+	dialContext := dialer.DialContext
+	if d.testHookDial != nil {
+		dialContext = d.testHookDial
+	}
+	trace.connectStart(network, addr)
+	conn, err := dialContext(ctx, network, addr)
+	trace.connectDone(network, addr, err)
 	if err != nil {
 		if ctx.Err() == context.DeadlineExceeded {
 			return nil, wrapDialWithUpstream(ErrDialTimeout, addr)
@@ -412,24 +490,30 @@ const DefaultDialTimeout = 3 * time.Second
 
 type tcpAddrEntry struct {
 	resolveTime time.Time
+	expireTime  time.Time
 	addrs       []net.TCPAddr
 	addrsIdx    uint32
 
 	pending int32
+
+	// preferredFamily is the address family (4 or 6) of the last
+	// address DialDualStack* has connected to. Zero means unknown.
+	preferredFamily atomic.Int32
 }
 
 // DefaultDNSCacheDuration is the duration for caching resolved TCP addresses
 // by Dial* functions.
 const DefaultDNSCacheDuration = time.Minute
 
-// cleanExpiredDNSEntries removes expired DNS cache entries based on DNSCacheDuration.
+// cleanExpiredDNSEntries removes expired DNS cache entries based on DNSCacheDuration
+// and DNSStaleDuration.
 // This is the core cleanup logic used by both the background cleaner and manual cleanup.
 func (d *TCPDialer) cleanExpiredDNSEntries() {
-	expireDuration := 2 * d.DNSCacheDuration
+	expireDuration := max(d.DNSCacheDuration, d.DNSStaleDuration)
 
 	t := time.Now()
 	d.tcpAddrsMap.Range(func(k, v any) bool {
-		if e, ok := v.(*tcpAddrEntry); ok && t.Sub(e.resolveTime) > expireDuration {
+		if e, ok := v.(*tcpAddrEntry); ok && t.Sub(e.expireTime) > expireDuration {
 			d.tcpAddrsMap.Delete(k)
 		}
 		return true
@@ -443,58 +527,67 @@ func (d *TCPDialer) tcpAddrsClean() {
 	}
 }
 
-func (d *TCPDialer) getTCPAddrs(addr string, dualStack bool, deadline time.Time) ([]net.TCPAddr, uint32, error) {
+func (d *TCPDialer) getTCPAddrs(addr string, dualStack bool, deadline time.Time, trace *ClientTrace) (*tcpAddrEntry, uint32, error) {
 	item, exist := d.tcpAddrsMap.Load(addr)
 	e, ok := item.(*tcpAddrEntry)
-	if exist && ok && e != nil && time.Since(e.resolveTime) > d.DNSCacheDuration {
-		// Only let one goroutine re-resolve at a time.
-		if atomic.SwapInt32(&e.pending, 1) == 0 {
-			e = nil
+	if !exist || !ok {
+		e = nil
+	}
+	stale := e
+	if e != nil {
+		now := time.Now()
+		if now.After(e.expireTime) {
+			// Only let one goroutine re-resolve at a time.
+			if atomic.SwapInt32(&e.pending, 1) == 0 {
+				e = nil
+			}
+		} else if d.DNSPrefetchDuration > 0 && e.expireTime.Sub(now) <= d.DNSPrefetchDuration &&
+			atomic.SwapInt32(&e.pending, 1) == 0 {
+			go d.prefetchTCPAddrs(addr, dualStack, e)
 		}
 	}
 
 	if e == nil {
-		addrs, err := resolveTCPAddrs(addr, dualStack, d.Resolver, deadline)
+		var err error
+		if trace != nil {
+			host, _, _ := net.SplitHostPort(addr)
+			trace.dnsStart(host)
+		}
+		e, err = d.resolveTCPAddrEntry(addr, dualStack, deadline, stale)
+		trace.dnsDone(e, err)
 		if err != nil {
-			item, exist := d.tcpAddrsMap.Load(addr)
-			e, ok = item.(*tcpAddrEntry)
-			if exist && ok && e != nil {
+			if stale != nil {
 				// Set pending to 0 so another goroutine can retry.
-				atomic.StoreInt32(&e.pending, 0)
+				atomic.StoreInt32(&stale.pending, 0)
+				if d.DNSStaleDuration > 0 && time.Since(stale.expireTime) <= d.DNSStaleDuration {
+					// Serve the expired addresses during resolver outage.
+					idx := atomic.AddUint32(&stale.addrsIdx, 1)
+					return stale, idx, nil
+				}
 			}
 			return nil, 0, err
 		}
-
-		e = &tcpAddrEntry{
-			addrs:       addrs,
-			resolveTime: time.Now(),
-		}
-		d.tcpAddrsMap.Store(addr, e)
 	}
 
 	idx := atomic.AddUint32(&e.addrsIdx, 1)
-	return e.addrs, idx, nil
+	return e, idx, nil
 }
 
-func resolveTCPAddrs(addr string, dualStack bool, resolver Resolver, deadline time.Time) ([]net.TCPAddr, error) {
+func (d *TCPDialer) resolveTCPAddrs(addr string, dualStack bool, deadline time.Time) ([]net.TCPAddr, time.Duration, error) {
 	host, portS, err := net.SplitHostPort(addr)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	port, err := strconv.Atoi(portS)
 	if err != nil {
-		return nil, err
-	}
-
-	if resolver == nil {
-		resolver = net.DefaultResolver
+		return nil, 0, err
 	}
 
 	ctx, cancel := context.WithDeadline(context.Background(), deadline)
 	defer cancel()
-	ipaddrs, err := resolver.LookupIPAddr(ctx, host)
+	ipaddrs, ttl, err := d.lookupIPAddr(ctx, host)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	n := len(ipaddrs)
@@ -511,9 +604,9 @@ func resolveTCPAddrs(addr string, dualStack bool, resolver Resolver, deadline ti
 		})
 	}
 	if len(addrs) == 0 {
-		return nil, errNoDNSEntries
+		return nil, 0, errNoDNSEntries
 	}
-	return addrs, nil
+	return addrs, ttl, nil
 }
 
 var errNoDNSEntries = errors.New("couldn't find DNS entries for the given domain. Try using DialDualStack")
@@ -0,0 +1,105 @@
+package fasthttp
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+// ErrTCPInfoUnsupported is returned by RequestCtx.TCPInfo if the platform
+// or the connection doesn't provide TCP_INFO.
+var ErrTCPInfoUnsupported = errors.New("TCP_INFO isn't supported for the connection")
+
+// TCPInfo is a snapshot of the kernel TCP state of the connection.
+//
+// It may be used for reporting the network quality per connection
+// in handlers and access logs. The fields unsupported by the kernel
+// are zero.
+type TCPInfo struct {
+	// RTT is the smoothed round-trip time.
+	RTT time.Duration
+
+	// RTTVar is the round-trip time variance.
+	RTTVar time.Duration
+
+	// MinRTT is the minimum round-trip time observed.
+	MinRTT time.Duration
+
+	// RTO is the retransmission timeout.
+	RTO time.Duration
+
+	// Retransmits is the number of the retransmission timeouts
+	// since the last acknowledged segment.
+	Retransmits uint32
+
+	// TotalRetransmits is the number of the retransmitted segments
+	// since the connection has been established.
+	TotalRetransmits uint32
+
+	// Lost is the number of the segments considered lost.
+	Lost uint32
+
+	// Unacked is the number of the sent segments not acknowledged yet.
+	Unacked uint32
+
+	// SendCwnd is the congestion window in segments.
+	SendCwnd uint32
+
+	// SendSSThresh is the slow start threshold in segments.
+	SendSSThresh uint32
+
+	// SendMSS is the maximum segment size for sending.
+	SendMSS uint32
+
+	// RecvMSS is the maximum segment size for receiving.
+	RecvMSS uint32
+
+	// PMTU is the path MTU.
+	PMTU uint32
+
+	// NotSentBytes is the number of the bytes in the write queue
+	// not sent yet.
+	NotSentBytes uint32
+
+	// BytesAcked is the number of the sent bytes acknowledged by the peer.
+	BytesAcked uint64
+
+	// BytesReceived is the number of the bytes received from the peer.
+	BytesReceived uint64
+
+	// DeliveryRate is the recent delivery rate in bytes per second.
+	DeliveryRate uint64
+}
+
+// TCPInfo returns the TCP_INFO snapshot of the underlying connection.
+//
+// ErrTCPInfoUnsupported is returned on platforms other than Linux
+// and for the connections not backed by a TCP socket.
+func (ctx *RequestCtx) TCPInfo() (*TCPInfo, error) {
+	c := syscallConn(ctx.c)
+	if c == nil {
+		return nil, ErrTCPInfoUnsupported
+	}
+	return getTCPInfo(c)
+}
+
+// syscallConn returns the socket of c unwrapping the connections
+// created by the server.
+func syscallConn(c net.Conn) syscall.Conn {
+	for {
+		switch cc := c.(type) {
+		case syscall.Conn:
+			return cc
+		case *perIPConn:
+			c = cc.Conn
+		case *perIPTLSConn:
+			c = cc.NetConn()
+		case *tls.Conn:
+			c = cc.NetConn()
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,52 @@
+//go:build linux
+
+package fasthttp
+
+import (
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func getTCPInfo(c syscall.Conn) (*TCPInfo, error) {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var ti *unix.TCPInfo
+	var serr error
+	if err = rc.Control(func(fd uintptr) {
+		ti, serr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	}); err != nil {
+		return nil, err
+	}
+	if serr != nil {
+		if serr == unix.EOPNOTSUPP || serr == unix.ENOPROTOOPT {
+			return nil, ErrTCPInfoUnsupported
+		}
+		return nil, serr
+	}
+
+	// The times are reported in microseconds.
+	return &TCPInfo{
+		RTT:              time.Duration(ti.Rtt) * time.Microsecond,
+		RTTVar:           time.Duration(ti.Rttvar) * time.Microsecond,
+		MinRTT:           time.Duration(ti.Min_rtt) * time.Microsecond,
+		RTO:              time.Duration(ti.Rto) * time.Microsecond,
+		Retransmits:      uint32(ti.Retransmits),
+		TotalRetransmits: ti.Total_retrans,
+		Lost:             ti.Lost,
+		Unacked:          ti.Unacked,
+		SendCwnd:         ti.Snd_cwnd,
+		SendSSThresh:     ti.Snd_ssthresh,
+		SendMSS:          ti.Snd_mss,
+		RecvMSS:          ti.Rcv_mss,
+		PMTU:             ti.Pmtu,
+		NotSentBytes:     ti.Notsent_bytes,
+		BytesAcked:       ti.Bytes_acked,
+		BytesReceived:    ti.Bytes_received,
+		DeliveryRate:     ti.Delivery_rate,
+	}, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package fasthttp
+
+import "syscall"
+
+func getTCPInfo(c syscall.Conn) (*TCPInfo, error) {
+	return nil, ErrTCPInfoUnsupported
+}
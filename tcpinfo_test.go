@@ -0,0 +1,77 @@
+package fasthttp
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"testing"
+
+	"github.com/bhargawpradhan/fasthttp/fasthttputil"
+)
+
+func TestRequestCtxTCPInfo(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := &Server{
+		// The connections are wrapped for limiting the connections per ip.
+		MaxConnsPerIP: 10,
+		Handler: func(ctx *RequestCtx) {
+			ti, err := ctx.TCPInfo()
+			if runtime.GOOS != "linux" {
+				if !errors.Is(err, ErrTCPInfoUnsupported) {
+					t.Errorf("unexpected error: %v. Expecting %v", err, ErrTCPInfoUnsupported)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if ti.SendCwnd == 0 || ti.SendMSS == 0 || ti.BytesReceived == 0 {
+				t.Errorf("unexpected TCP info %+v", ti)
+			}
+		},
+	}
+	go s.Serve(ln) //nolint:errcheck
+	defer ln.Close()
+
+	c := &Client{}
+	defer c.CloseIdleConnections()
+	for i := 0; i < 2; i++ {
+		statusCode, _, err := c.Get(nil, "http://"+ln.Addr().String())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if statusCode != StatusOK {
+			t.Fatalf("unexpected status code %d. Expecting %d", statusCode, StatusOK)
+		}
+	}
+}
+
+func TestRequestCtxTCPInfoUnsupported(t *testing.T) {
+	t.Parallel()
+
+	ln := fasthttputil.NewInmemoryListener()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if _, err := ctx.TCPInfo(); !errors.Is(err, ErrTCPInfoUnsupported) {
+				t.Errorf("unexpected error: %v. Expecting %v", err, ErrTCPInfoUnsupported)
+			}
+		},
+	}
+	go s.Serve(ln)     //nolint:errcheck
+	defer s.Shutdown() //nolint:errcheck
+
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	if _, _, err := c.Get(nil, "http://example.com"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -12,6 +12,10 @@
 //
 //   - TCP_FASTOPEN. See https://lwn.net/Articles/508865/ for details.
 //
+//   - TCP_USER_TIMEOUT, TCP_NOTSENT_LOWAT, SO_RCVBUF, SO_SNDBUF, TCP keep-alive
+//     idle, interval and count, IP_FREEBIND, SO_MARK and TCP_CONGESTION
+//     for tuning the accepted connections.
+//
 // The package is derived from https://github.com/bhargawpradhan/tcplisten
 package tcplisten
 
@@ -21,6 +25,7 @@ import (
 	"math"
 	"net"
 	"os"
+	"time"
 
 	"golang.org/x/sys/unix"
 )
@@ -42,6 +47,62 @@ type Config struct {
 	//
 	// By default system-level backlog value is used.
 	Backlog int
+
+	// UserTimeout sets TCP_USER_TIMEOUT: the maximum time the transmitted
+	// data may remain unacknowledged before the connection is closed.
+	// Linux only.
+	//
+	// By default system-level value is used.
+	UserTimeout time.Duration
+
+	// NotSentLowat sets TCP_NOTSENT_LOWAT: the maximum number of unsent
+	// bytes in the socket write queue. Linux only.
+	//
+	// By default system-level value is used.
+	NotSentLowat int
+
+	// RecvBuffer sets SO_RCVBUF, the size of the socket receive buffer.
+	//
+	// By default system-level value is used.
+	RecvBuffer int
+
+	// SendBuffer sets SO_SNDBUF, the size of the socket send buffer.
+	//
+	// By default system-level value is used.
+	SendBuffer int
+
+	// KeepAliveIdle is the time the connection must be idle before
+	// the first keep-alive probe is sent.
+	//
+	// By default the value set by the net package is used.
+	KeepAliveIdle time.Duration
+
+	// KeepAliveInterval is the time between keep-alive probes.
+	//
+	// By default the value set by the net package is used.
+	KeepAliveInterval time.Duration
+
+	// KeepAliveCount is the maximum number of keep-alive probes
+	// that may be unanswered before the connection is closed.
+	//
+	// By default the value set by the net package is used.
+	KeepAliveCount int
+
+	// FreeBind enables IP_FREEBIND, so the listener may be bound
+	// to the address not assigned to the host yet. Linux only.
+	FreeBind bool
+
+	// Mark sets SO_MARK for policy routing and filtering of the accepted
+	// connections. It requires CAP_NET_ADMIN. Linux only.
+	//
+	// By default the connections aren't marked.
+	Mark int
+
+	// Congestion sets TCP_CONGESTION, the name of the congestion control
+	// algorithm such as "cubic" or "bbr". Linux only.
+	//
+	// By default system-level algorithm is used.
+	Congestion string
 }
 
 // NewListener returns TCP listener with options set in the Config.
@@ -79,9 +140,47 @@ func (cfg *Config) NewListener(network, addr string) (net.Listener, error) {
 		return nil, err
 	}
 
+	// The keep-alive options of the listening socket are overridden
+	// by the net package on Accept, so they are set on the accepted
+	// connections instead.
+	if cfg.KeepAliveIdle > 0 || cfg.KeepAliveInterval > 0 || cfg.KeepAliveCount > 0 {
+		ln = &keepAliveListener{
+			TCPListener: ln.(*net.TCPListener),
+			cfg: net.KeepAliveConfig{
+				Enable:   true,
+				Idle:     cfg.KeepAliveIdle,
+				Interval: cfg.KeepAliveInterval,
+				Count:    cfg.KeepAliveCount,
+			},
+		}
+	}
+
 	return ln, nil
 }
 
+type keepAliveListener struct {
+	*net.TCPListener
+
+	cfg net.KeepAliveConfig
+}
+
+func (ln *keepAliveListener) Accept() (net.Conn, error) {
+	for {
+		c, err := ln.AcceptTCP()
+		if err != nil {
+			return nil, err
+		}
+		// The options can't be set if the connection is already reset
+		// by the peer. Such connection is dropped, while the listener
+		// keeps accepting, so the server isn't stopped.
+		if err = c.SetKeepAliveConfig(ln.cfg); err != nil {
+			c.Close()
+			continue
+		}
+		return c, nil
+	}
+}
+
 func (cfg *Config) fdSetup(fd int, sa unix.Sockaddr, addr string) error {
 	var err error
 
@@ -113,6 +212,49 @@ func (cfg *Config) fdSetup(fd int, sa unix.Sockaddr, addr string) error {
 		}
 	}
 
+	if cfg.RecvBuffer > 0 {
+		if err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, cfg.RecvBuffer); err != nil {
+			return fmt.Errorf("cannot set SO_RCVBUF: %w", err)
+		}
+	}
+
+	if cfg.SendBuffer > 0 {
+		if err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_SNDBUF, cfg.SendBuffer); err != nil {
+			return fmt.Errorf("cannot set SO_SNDBUF: %w", err)
+		}
+	}
+
+	// The options below are inherited by the accepted sockets.
+	if cfg.UserTimeout > 0 {
+		if err = setUserTimeout(fd, cfg.UserTimeout); err != nil {
+			return err
+		}
+	}
+
+	if cfg.NotSentLowat > 0 {
+		if err = setNotSentLowat(fd, cfg.NotSentLowat); err != nil {
+			return err
+		}
+	}
+
+	if cfg.FreeBind {
+		if err = enableFreeBind(fd); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Mark != 0 {
+		if err = setMark(fd, cfg.Mark); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Congestion != "" {
+		if err = setCongestion(fd, cfg.Congestion); err != nil {
+			return err
+		}
+	}
+
 	if err = unix.Bind(fd, sa); err != nil {
 		return fmt.Errorf("cannot bind to %q: %w", addr, err)
 	}
@@ -2,6 +2,7 @@ package tcplisten
 
 import (
 	"net"
+	"time"
 )
 
 // A dummy implementation for js,wasm
@@ -10,6 +11,17 @@ type Config struct {
 	DeferAccept bool
 	FastOpen    bool
 	Backlog     int
+
+	UserTimeout       time.Duration
+	NotSentLowat      int
+	RecvBuffer        int
+	SendBuffer        int
+	KeepAliveIdle     time.Duration
+	KeepAliveInterval time.Duration
+	KeepAliveCount    int
+	FreeBind          bool
+	Mark              int
+	Congestion        string
 }
 
 func (cfg *Config) NewListener(network, addr string) (net.Listener, error) {
@@ -7,6 +7,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/sys/unix"
 )
@@ -32,6 +33,41 @@ func enableFastOpen(fd int) error {
 
 const fastOpenQlen = 16 * 1024
 
+func setUserTimeout(fd int, d time.Duration) error {
+	if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, int(d.Milliseconds())); err != nil {
+		return fmt.Errorf("cannot set TCP_USER_TIMEOUT(%s): %w", d, err)
+	}
+	return nil
+}
+
+func setNotSentLowat(fd, n int) error {
+	if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_NOTSENT_LOWAT, n); err != nil {
+		return fmt.Errorf("cannot set TCP_NOTSENT_LOWAT(%d): %w", n, err)
+	}
+	return nil
+}
+
+func enableFreeBind(fd int) error {
+	if err := unix.SetsockoptInt(fd, unix.SOL_IP, unix.IP_FREEBIND, 1); err != nil {
+		return fmt.Errorf("cannot enable IP_FREEBIND: %w", err)
+	}
+	return nil
+}
+
+func setMark(fd, mark int) error {
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_MARK, mark); err != nil {
+		return fmt.Errorf("cannot set SO_MARK(%d): %w", mark, err)
+	}
+	return nil
+}
+
+func setCongestion(fd int, name string) error {
+	if err := unix.SetsockoptString(fd, unix.IPPROTO_TCP, unix.TCP_CONGESTION, name); err != nil {
+		return fmt.Errorf("cannot set TCP_CONGESTION(%q): %w", name, err)
+	}
+	return nil
+}
+
 func soMaxConn() (int, error) {
 	data, err := os.ReadFile(soMaxConnFilePath)
 	if err != nil {
@@ -2,7 +2,11 @@
 
 package tcplisten
 
-import "golang.org/x/sys/unix"
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
 
 const soReusePort = unix.SO_REUSEPORT
 
@@ -16,6 +20,28 @@ func enableFastOpen(fd int) error {
 	return nil
 }
 
+// The options below are Linux only.
+
+func setUserTimeout(fd int, d time.Duration) error {
+	return nil
+}
+
+func setNotSentLowat(fd, n int) error {
+	return nil
+}
+
+func enableFreeBind(fd int) error {
+	return nil
+}
+
+func setMark(fd, mark int) error {
+	return nil
+}
+
+func setCongestion(fd int, name string) error {
+	return nil
+}
+
 func soMaxConn() (int, error) {
 	// TODO: properly implement it
 	return unix.SOMAXCONN, nil
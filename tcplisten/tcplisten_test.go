@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"os"
 	"runtime"
 	"testing"
 	"time"
+
+	"golang.org/x/sys/unix"
 )
 
 func TestConfigDeferAccept(t *testing.T) {
@@ -48,6 +51,94 @@ func TestConfigBacklog(t *testing.T) {
 	testConfig(t, cfg)
 }
 
+func TestConfigSocketOptions(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip()
+	}
+	cfg := Config{
+		UserTimeout:       3 * time.Second,
+		NotSentLowat:      16 * 1024,
+		RecvBuffer:        64 * 1024,
+		SendBuffer:        64 * 1024,
+		KeepAliveIdle:     7 * time.Second,
+		KeepAliveInterval: 3 * time.Second,
+		KeepAliveCount:    4,
+		FreeBind:          true,
+		Congestion:        "reno",
+	}
+	if os.Geteuid() == 0 {
+		// SO_MARK requires CAP_NET_ADMIN.
+		cfg.Mark = 42
+	}
+	testConfig(t, cfg)
+
+	ln, err := cfg.NewListener("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot create listener using Config %#v: %s", &cfg, err)
+	}
+	defer ln.Close()
+
+	c, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error when dialing: %s", err)
+	}
+	defer c.Close()
+	sc, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("unexpected error when accepting: %s", err)
+	}
+	defer sc.Close()
+
+	rc, err := sc.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []struct {
+		name       string
+		level, opt int
+		value      int
+	}{
+		{"TCP_USER_TIMEOUT", unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, 3000},
+		{"TCP_NOTSENT_LOWAT", unix.IPPROTO_TCP, unix.TCP_NOTSENT_LOWAT, 16 * 1024},
+		{"SO_KEEPALIVE", unix.SOL_SOCKET, unix.SO_KEEPALIVE, 1},
+		{"TCP_KEEPIDLE", unix.IPPROTO_TCP, unix.TCP_KEEPIDLE, 7},
+		{"TCP_KEEPINTVL", unix.IPPROTO_TCP, unix.TCP_KEEPINTVL, 3},
+		{"TCP_KEEPCNT", unix.IPPROTO_TCP, unix.TCP_KEEPCNT, 4},
+		{"SO_MARK", unix.SOL_SOCKET, unix.SO_MARK, cfg.Mark},
+	}
+	err = rc.Control(func(fd uintptr) {
+		for _, e := range expected {
+			v, err := unix.GetsockoptInt(int(fd), e.level, e.opt)
+			if err != nil {
+				t.Fatalf("cannot get %s: %s", e.name, err)
+			}
+			if v != e.value {
+				t.Fatalf("unexpected %s %d. Expecting %d", e.name, v, e.value)
+			}
+		}
+		// The kernel doubles the buffer sizes for bookkeeping overhead.
+		for _, opt := range []int{unix.SO_RCVBUF, unix.SO_SNDBUF} {
+			v, err := unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, opt)
+			if err != nil {
+				t.Fatalf("cannot get buffer size: %s", err)
+			}
+			if v < 64*1024 {
+				t.Fatalf("unexpected buffer size %d. Expecting at least %d", v, 64*1024)
+			}
+		}
+		cc, err := unix.GetsockoptString(int(fd), unix.IPPROTO_TCP, unix.TCP_CONGESTION)
+		if err != nil {
+			t.Fatalf("cannot get TCP_CONGESTION: %s", err)
+		}
+		if cc != "reno" {
+			t.Fatalf("unexpected TCP_CONGESTION %q. Expecting %q", cc, "reno")
+		}
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
 func testConfig(t *testing.T, cfg Config) {
 	testConfigV(t, cfg, "tcp", "localhost:10083")
 	testConfigV(t, cfg, "tcp", "[::1]:10083")
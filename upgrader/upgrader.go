@@ -0,0 +1,638 @@
+// Package upgrader provides zero-downtime binary upgrades for fasthttp servers.
+//
+// The Upgrader re-executes the current binary and passes the listening
+// sockets to the new process via inherited file descriptors. The old process
+// keeps accepting connections until the new one signals readiness, then it
+// gracefully shuts down with Server.ShutdownWithContext. No connection is
+// refused while the upgrade is in progress since the listening sockets are
+// never closed in the kernel.
+//
+// Both TCP listeners and UNIX socket listeners are supported.
+package upgrader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bhargawpradhan/fasthttp"
+)
+
+const (
+	listenersEnvVariable = "FASTHTTP_UPGRADE_LISTENERS"
+	readyFDEnvVariable   = "FASTHTTP_UPGRADE_READY_FD"
+
+	// The first file descriptor passed via exec.Cmd.ExtraFiles.
+	firstInheritedFD = 3
+
+	defaultNetwork         = "tcp4"
+	defaultReadyTimeout    = time.Minute
+	defaultShutdownTimeout = 30 * time.Second
+)
+
+var (
+	defaultLogger = Logger(log.New(os.Stderr, "", log.LstdFlags))
+
+	// ErrUpgradeInProgress is returned by Upgrade when another upgrade
+	// hasn't finished yet.
+	ErrUpgradeInProgress = errors.New("upgrade is already in progress")
+
+	// ErrUpgraded is returned by Upgrade when the process has already handed
+	// its listeners over to a new process.
+	ErrUpgraded = errors.New("listeners have already been handed over to a new process")
+
+	// ErrReadyTimeout is returned by Upgrade when the new process didn't
+	// signal readiness within Upgrader.ReadyTimeout.
+	ErrReadyTimeout = errors.New("timeout when waiting for the new process to become ready")
+
+	// ErrChildExited is returned by Upgrade when the new process exited
+	// before signalling readiness.
+	ErrChildExited = errors.New("new process exited before becoming ready")
+
+	// ErrNotSupported is returned on platforms without file descriptor
+	// inheritance.
+	ErrNotSupported = errors.New("binary upgrades are not supported on this platform")
+)
+
+// Logger is used for logging formatted messages.
+type Logger interface {
+	// Printf must have the same semantics as log.Printf.
+	Printf(format string, args ...any)
+}
+
+// execCommand is overridden in tests.
+var execCommand = exec.Command
+
+// Upgrader implements zero-downtime binary upgrades for fasthttp.Server.
+//
+// Use the Upgrader's ListenAndServe or ListenAndServeUNIX instead of
+// the Server ones. Every listener obtained through the Upgrader is handed
+// over to the new process on upgrade, so the new process must create
+// the same listeners (with the same network and address) on startup.
+//
+// The upgrade is triggered by one of the Signals or by calling Upgrade.
+//
+// It is forbidden copying Upgrader instances. Create new Upgrader instances
+// instead.
+type Upgrader struct {
+	// Server to serve the listeners with and to shut down after
+	// the new process becomes ready.
+	Server *fasthttp.Server
+
+	// By default the Server's Logger or the standard logger
+	// from log package is used.
+	Logger Logger
+
+	// Signals triggering the upgrade.
+	//
+	// By default SIGHUP and SIGUSR2 are used.
+	Signals []os.Signal
+
+	// The maximum duration for the new process to signal readiness.
+	// The new process is killed and the current one continues serving
+	// if the deadline is exceeded.
+	//
+	// By default one minute is used.
+	ReadyTimeout time.Duration
+
+	// The maximum duration for the graceful shutdown of the current process
+	// after the new process becomes ready.
+	//
+	// By default 30 seconds is used.
+	ShutdownTimeout time.Duration
+
+	// The network for ListenAndServe. Must be "tcp", "tcp4" or "tcp6".
+	//
+	// By default is "tcp4".
+	Network string
+
+	// Env contains additional environment variables for the new process
+	// in the form of "key=value".
+	Env []string
+
+	done chan struct{}
+
+	listeners []namedListener
+
+	mu       sync.Mutex
+	stopOnce sync.Once
+	sigOnce  sync.Once
+	stopSigs func()
+
+	upgrading atomic.Bool
+	upgraded  atomic.Bool
+}
+
+type namedListener struct {
+	ln   net.Listener
+	name string
+
+	// serving is set when the listener is passed to Serve.
+	serving bool
+}
+
+type filer interface {
+	File() (*os.File, error)
+}
+
+// New returns an Upgrader for the given server.
+func New(s *fasthttp.Server) *Upgrader {
+	u := &Upgrader{
+		Server:          s,
+		Signals:         defaultSignals(),
+		ReadyTimeout:    defaultReadyTimeout,
+		ShutdownTimeout: defaultShutdownTimeout,
+		Network:         defaultNetwork,
+	}
+	if s.Logger != nil {
+		u.Logger = s.Logger
+	}
+	return u
+}
+
+// IsChild returns true if the current process has been started
+// by an upgrade.
+func IsChild() bool {
+	return os.Getenv(readyFDEnvVariable) != ""
+}
+
+func (u *Upgrader) logger() Logger {
+	if u.Logger != nil {
+		return u.Logger
+	}
+	return defaultLogger
+}
+
+// Listen returns a listener for the given network and addr.
+//
+// The listener inherited from the parent process is returned if there is
+// one with the same network and addr. Otherwise a new listener is created.
+func (u *Upgrader) Listen(network, addr string) (net.Listener, error) {
+	name := listenerName(network, addr)
+	ln, err := inheritedListener(name)
+	if err != nil {
+		return nil, err
+	}
+	if ln == nil {
+		if ln, err = net.Listen(network, addr); err != nil {
+			return nil, err
+		}
+	}
+	u.addListener(name, ln)
+	return ln, nil
+}
+
+// ListenUNIX returns a UNIX socket listener for the given addr.
+//
+// The listener inherited from the parent process is returned if there is
+// one with the same addr. Otherwise the existing file at addr is deleted
+// and a new listener with the given file mode is created.
+func (u *Upgrader) ListenUNIX(addr string, mode os.FileMode) (net.Listener, error) {
+	name := listenerName("unix", addr)
+	ln, err := inheritedListener(name)
+	if err != nil {
+		return nil, err
+	}
+	if ln == nil {
+		if err = os.Remove(addr); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("unexpected error when trying to remove unix socket file %q: %w", addr, err)
+		}
+		if ln, err = net.Listen("unix", addr); err != nil {
+			return nil, err
+		}
+		if err = os.Chmod(addr, mode); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("cannot chmod %#o for %q: %w", mode, addr, err)
+		}
+	}
+	u.addListener(name, ln)
+	return ln, nil
+}
+
+// ListenAndServe serves HTTP requests from the given TCP addr.
+//
+// The listener is inherited from the parent process if the current process
+// has been started by an upgrade.
+//
+// ListenAndServe returns nil after the listener has been handed over to
+// a new process and the graceful shutdown is complete.
+func (u *Upgrader) ListenAndServe(addr string) error {
+	network := u.Network
+	if network == "" {
+		network = defaultNetwork
+	}
+	ln, err := u.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	return u.Serve(ln)
+}
+
+// ListenAndServeUNIX serves HTTP requests from the given UNIX addr.
+//
+// The listener is inherited from the parent process if the current process
+// has been started by an upgrade.
+//
+// ListenAndServeUNIX returns nil after the listener has been handed over to
+// a new process and the graceful shutdown is complete.
+func (u *Upgrader) ListenAndServeUNIX(addr string, mode os.FileMode) error {
+	ln, err := u.ListenUNIX(addr, mode)
+	if err != nil {
+		return err
+	}
+	return u.Serve(ln)
+}
+
+// Serve serves HTTP requests from the given listener, which must be obtained
+// via Listen or ListenUNIX.
+//
+// Serve signals readiness to the parent process after all the listeners
+// obtained via the Upgrader are served and all the listeners inherited
+// from the parent process are obtained. Call Ready explicitly if the current
+// process doesn't use some of the inherited listeners.
+//
+// Serve starts watching for the upgrade Signals.
+func (u *Upgrader) Serve(ln net.Listener) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- u.Server.Serve(ln)
+	}()
+
+	if u.markServing(ln) {
+		if err := u.Ready(); err != nil {
+			u.logger().Printf("cannot signal readiness to the parent process: %v", err)
+		}
+	}
+	u.watchSignals()
+
+	err := <-errCh
+	if err == nil && u.upgraded.Load() {
+		<-u.doneCh()
+	}
+	return err
+}
+
+// Ready signals the parent process that the current process is ready
+// to serve requests, so the parent may shut down. The listeners inherited
+// from the parent process and not obtained via Listen or ListenUNIX
+// are closed.
+//
+// Call Ready after all the listeners have been obtained. Serve,
+// ListenAndServe and ListenAndServeUNIX call Ready automatically
+// after all the listeners are served.
+//
+// Ready is a no-op if the current process hasn't been started by an upgrade.
+func (u *Upgrader) Ready() error {
+	for _, name := range closeUnclaimedListeners() {
+		u.logger().Printf("closed inherited listener %q not used by the current process", name)
+	}
+	return signalReady()
+}
+
+// markServing marks ln as served and returns whether all the listeners
+// are served, so the readiness may be signalled.
+func (u *Upgrader) markServing(ln net.Listener) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	ready := true
+	for i := range u.listeners {
+		if u.listeners[i].ln == ln {
+			u.listeners[i].serving = true
+		}
+		ready = ready && u.listeners[i].serving
+	}
+	return ready && !hasUnclaimedListeners()
+}
+
+// Upgrade starts a new process, hands the listeners over to it and waits
+// for the new process to signal readiness. Then the Server is gracefully shut
+// down within ShutdownTimeout.
+//
+// The current process continues serving if the new process fails to start
+// or to become ready.
+func (u *Upgrader) Upgrade() error {
+	if !fdInheritanceSupported {
+		return ErrNotSupported
+	}
+	if u.upgraded.Load() {
+		return ErrUpgraded
+	}
+	if !u.upgrading.CompareAndSwap(false, true) {
+		return ErrUpgradeInProgress
+	}
+	defer u.upgrading.Store(false)
+
+	if err := u.startChild(); err != nil {
+		return err
+	}
+
+	u.upgraded.Store(true)
+	u.stopSignals()
+	defer close(u.doneCh())
+
+	u.keepUNIXSocketFiles()
+
+	timeout := u.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return u.Server.ShutdownWithContext(ctx)
+}
+
+func (u *Upgrader) startChild() error {
+	u.mu.Lock()
+	listeners := append([]namedListener(nil), u.listeners...)
+	u.mu.Unlock()
+
+	names := make([]string, 0, len(listeners))
+	files := make([]*os.File, 0, len(listeners)+1)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, nl := range listeners {
+		fl, ok := nl.ln.(filer)
+		if !ok {
+			return fmt.Errorf("cannot hand over listener %q of type %T", nl.name, nl.ln)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return fmt.Errorf("cannot obtain file for listener %q: %w", nl.name, err)
+		}
+		names = append(names, nl.name)
+		files = append(files, f)
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("cannot create readiness pipe: %w", err)
+	}
+	defer readyR.Close()
+	files = append(files, readyW)
+
+	encodedNames, err := json.Marshal(names)
+	if err != nil {
+		return err
+	}
+
+	// #nosec G204
+	cmd := execCommand(os.Args[0], os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(childEnv(os.Environ()), u.Env...)
+	cmd.Env = append(cmd.Env,
+		listenersEnvVariable+"="+string(encodedNames),
+		readyFDEnvVariable+"="+strconv.Itoa(firstInheritedFD+len(names)),
+	)
+	cmd.ExtraFiles = files
+	err = cmd.Start()
+	for _, nl := range listeners {
+		if nerr := restoreNonblock(nl.ln); nerr != nil {
+			u.logger().Printf("cannot restore non-blocking mode for listener %q: %v", nl.name, nerr)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot start the new process: %w", err)
+	}
+
+	// Close our copy of the write end, so reading from the pipe
+	// returns io.EOF if the child exits without signalling readiness.
+	readyW.Close()
+	files = files[:len(files)-1]
+
+	readyCh := make(chan error, 1)
+	go func() {
+		var b [1]byte
+		_, err := readyR.Read(b[:])
+		readyCh <- err
+	}()
+
+	timeout := u.ReadyTimeout
+	if timeout <= 0 {
+		timeout = defaultReadyTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err = <-readyCh:
+		if err == nil {
+			u.logger().Printf("the new process %d is ready, shutting down the current one", cmd.Process.Pid)
+			// Reap the child if it exits before we do.
+			go cmd.Wait() //nolint:errcheck
+			return nil
+		}
+		if err == io.EOF {
+			err = ErrChildExited
+		}
+	case <-timer.C:
+		err = ErrReadyTimeout
+	}
+
+	_ = cmd.Process.Kill()
+	_ = cmd.Wait()
+	return fmt.Errorf("upgrade failed for the new process %d: %w", cmd.Process.Pid, err)
+}
+
+func (u *Upgrader) addListener(name string, ln net.Listener) {
+	u.mu.Lock()
+	u.listeners = append(u.listeners, namedListener{name: name, ln: ln})
+	u.mu.Unlock()
+}
+
+// keepUNIXSocketFiles prevents removing the socket files, which are used
+// by the new process, when closing the UNIX listeners on shutdown.
+func (u *Upgrader) keepUNIXSocketFiles() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, nl := range u.listeners {
+		if ul, ok := nl.ln.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+}
+
+func (u *Upgrader) doneCh() chan struct{} {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.done == nil {
+		u.done = make(chan struct{})
+	}
+	return u.done
+}
+
+func (u *Upgrader) watchSignals() {
+	u.sigOnce.Do(func() {
+		if len(u.Signals) == 0 {
+			return
+		}
+		sigCh := make(chan os.Signal, 1)
+		stopCh := make(chan struct{})
+		signal.Notify(sigCh, u.Signals...)
+
+		u.mu.Lock()
+		u.stopSigs = func() {
+			signal.Stop(sigCh)
+			close(stopCh)
+		}
+		u.mu.Unlock()
+
+		go func() {
+			for {
+				select {
+				case sig := <-sigCh:
+					u.logger().Printf("received signal %v, upgrading", sig)
+					if err := u.Upgrade(); err != nil {
+						u.logger().Printf("upgrade failed: %v", err)
+					}
+				case <-stopCh:
+					return
+				}
+			}
+		}()
+	})
+}
+
+func (u *Upgrader) stopSignals() {
+	u.mu.Lock()
+	stop := u.stopSigs
+	u.mu.Unlock()
+	if stop != nil {
+		u.stopOnce.Do(stop)
+	}
+}
+
+func listenerName(network, addr string) string {
+	return network + ":" + addr
+}
+
+func childEnv(env []string) []string {
+	result := make([]string, 0, len(env))
+	for _, kv := range env {
+		if strings.HasPrefix(kv, listenersEnvVariable+"=") || strings.HasPrefix(kv, readyFDEnvVariable+"=") {
+			continue
+		}
+		result = append(result, kv)
+	}
+	return result
+}
+
+var (
+	inheritOnce sync.Once
+	inheritMu   sync.Mutex
+	inherited   map[string]net.Listener
+	inheritErr  error
+
+	readyOnce sync.Once
+	readyErr  error
+)
+
+func loadInheritedListeners() {
+	inheritOnce.Do(func() {
+		inherited, inheritErr = inheritListeners(os.Getenv(listenersEnvVariable))
+	})
+}
+
+func inheritedListener(name string) (net.Listener, error) {
+	loadInheritedListeners()
+	if inheritErr != nil {
+		return nil, inheritErr
+	}
+
+	inheritMu.Lock()
+	defer inheritMu.Unlock()
+	ln := inherited[name]
+	delete(inherited, name)
+	return ln, nil
+}
+
+func hasUnclaimedListeners() bool {
+	loadInheritedListeners()
+
+	inheritMu.Lock()
+	defer inheritMu.Unlock()
+	return len(inherited) > 0
+}
+
+// closeUnclaimedListeners closes the inherited listeners, which haven't
+// been obtained via inheritedListener, and returns their names.
+func closeUnclaimedListeners() []string {
+	loadInheritedListeners()
+
+	inheritMu.Lock()
+	defer inheritMu.Unlock()
+	names := make([]string, 0, len(inherited))
+	for name, ln := range inherited {
+		ln.Close()
+		delete(inherited, name)
+		names = append(names, name)
+	}
+	return names
+}
+
+func inheritListeners(encodedNames string) (map[string]net.Listener, error) {
+	if encodedNames == "" {
+		return nil, nil
+	}
+	var names []string
+	if err := json.Unmarshal([]byte(encodedNames), &names); err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %w", listenersEnvVariable, err)
+	}
+
+	listeners := make(map[string]net.Listener, len(names))
+	for i, name := range names {
+		f := os.NewFile(uintptr(firstInheritedFD+i), name)
+		if f == nil {
+			return nil, fmt.Errorf("invalid file descriptor for inherited listener %q", name)
+		}
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot inherit listener %q: %w", name, err)
+		}
+		listeners[name] = ln
+	}
+	return listeners, nil
+}
+
+func signalReady() error {
+	readyOnce.Do(func() {
+		s := os.Getenv(readyFDEnvVariable)
+		if s == "" {
+			return
+		}
+		fd, err := strconv.Atoi(s)
+		if err != nil {
+			readyErr = fmt.Errorf("cannot parse %s=%q: %w", readyFDEnvVariable, s, err)
+			return
+		}
+		f := os.NewFile(uintptr(fd), "ready")
+		if f == nil {
+			readyErr = fmt.Errorf("invalid readiness file descriptor %d", fd)
+			return
+		}
+		defer f.Close()
+		if _, err = f.Write([]byte{1}); err != nil {
+			readyErr = fmt.Errorf("cannot write to readiness file descriptor: %w", err)
+		}
+	})
+	return readyErr
+}
@@ -0,0 +1,259 @@
+//go:build !windows
+
+package upgrader
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bhargawpradhan/fasthttp"
+)
+
+const helperEnvVariable = "FASTHTTP_UPGRADER_HELPER"
+
+// TestHelperProcess isn't a real test. It is started as the new process
+// by the tests below.
+func TestHelperProcess(t *testing.T) {
+	mode := os.Getenv(helperEnvVariable)
+	if mode == "" {
+		return
+	}
+	if mode == "fail" {
+		os.Exit(3)
+	}
+
+	s := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			if string(ctx.Path()) == "/exit" {
+				go func() {
+					time.Sleep(50 * time.Millisecond)
+					os.Exit(0)
+				}()
+			}
+			ctx.SetBodyString("child")
+		},
+	}
+	u := New(s)
+	u.Signals = nil
+
+	time.AfterFunc(10*time.Second, func() { os.Exit(1) })
+
+	var err error
+	if mode == "unix" {
+		err = u.ListenAndServeUNIX(os.Getenv("FASTHTTP_UPGRADER_ADDR"), 0o600)
+	} else {
+		err = u.ListenAndServe(os.Getenv("FASTHTTP_UPGRADER_ADDR"))
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+	os.Exit(0)
+}
+
+func setHelperCommand(t *testing.T) {
+	execCommand = func(string, ...string) *exec.Cmd {
+		return exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+	}
+	t.Cleanup(func() {
+		execCommand = exec.Command
+	})
+}
+
+func newTestUpgrader(mode, addr string) *Upgrader {
+	s := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			ctx.SetBodyString("parent")
+		},
+	}
+	u := New(s)
+	u.Signals = nil
+	u.ReadyTimeout = 5 * time.Second
+	u.ShutdownTimeout = time.Second
+	u.Env = []string{helperEnvVariable + "=" + mode, "FASTHTTP_UPGRADER_ADDR=" + addr}
+	return u
+}
+
+func get(t *testing.T, dial fasthttp.DialFunc) string {
+	t.Helper()
+
+	c := &fasthttp.HostClient{
+		Addr: "upgrader",
+		Dial: dial,
+	}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI("http://upgrader/")
+	req.SetConnectionClose()
+	if err := c.DoTimeout(req, resp, 5*time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return string(resp.Body())
+}
+
+func testUpgrade(t *testing.T, u *Upgrader, ln net.Listener, dial fasthttp.DialFunc) {
+	serveCh := make(chan error, 1)
+	go func() {
+		serveCh <- u.Serve(ln)
+	}()
+
+	if body := get(t, dial); body != "parent" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "parent")
+	}
+
+	if err := u.Upgrade(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case err := <-serveCh:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	if err := u.Upgrade(); !errors.Is(err, ErrUpgraded) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrUpgraded)
+	}
+
+	if body := get(t, dial); body != "child" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "child")
+	}
+
+	c, err := dial("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer c.Close()
+	if _, err = c.Write([]byte("GET /exit HTTP/1.1\r\nHost: upgrader\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, _ = io.ReadAll(c)
+}
+
+func TestUpgradeTCP(t *testing.T) {
+	setHelperCommand(t)
+
+	const addr = "127.0.0.1:0"
+	u := newTestUpgrader("tcp", addr)
+	ln, err := u.Listen(defaultNetwork, addr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The child must create the listener with the same name,
+	// so the listener is inherited instead of binding a new port.
+	realAddr := ln.Addr().String()
+
+	testUpgrade(t, u, ln, func(string) (net.Conn, error) {
+		return net.Dial("tcp4", realAddr)
+	})
+}
+
+func TestUpgradeUNIX(t *testing.T) {
+	setHelperCommand(t)
+
+	addr := filepath.Join(t.TempDir(), "upgrader.sock")
+	u := newTestUpgrader("unix", addr)
+	ln, err := u.ListenUNIX(addr, 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testUpgrade(t, u, ln, func(string) (net.Conn, error) {
+		return net.Dial("unix", addr)
+	})
+}
+
+func TestUpgradeChildExited(t *testing.T) {
+	setHelperCommand(t)
+
+	u := newTestUpgrader("fail", "")
+	ln, err := u.Listen(defaultNetwork, "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer u.Server.Shutdown() //nolint:errcheck
+
+	go u.Serve(ln) //nolint:errcheck
+
+	if err = u.Upgrade(); !errors.Is(err, ErrChildExited) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrChildExited)
+	}
+
+	body := get(t, func(string) (net.Conn, error) {
+		return net.Dial("tcp4", ln.Addr().String())
+	})
+	if body != "parent" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "parent")
+	}
+}
+
+func TestChildEnv(t *testing.T) {
+	t.Parallel()
+
+	env := childEnv([]string{
+		"PATH=/bin",
+		listenersEnvVariable + `=["tcp4:a"]`,
+		readyFDEnvVariable + "=4",
+		"FOO=bar",
+	})
+	if len(env) != 2 || env[0] != "PATH=/bin" || env[1] != "FOO=bar" {
+		t.Fatalf("unexpected env %q", env)
+	}
+}
+
+func TestMarkServing(t *testing.T) {
+	loadInheritedListeners()
+	unclaimed, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	inheritMu.Lock()
+	prevInherited := inherited
+	inherited = map[string]net.Listener{"tcp4:unclaimed": unclaimed}
+	inheritMu.Unlock()
+	defer func() {
+		inheritMu.Lock()
+		inherited = prevInherited
+		inheritMu.Unlock()
+	}()
+
+	u := newTestUpgrader("tcp", "")
+	ln1, err := u.Listen(defaultNetwork, "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer ln1.Close()
+	ln2, err := u.Listen(defaultNetwork, "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer ln2.Close()
+
+	if u.markServing(ln1) {
+		t.Fatalf("expecting not ready while the second listener isn't served")
+	}
+	if u.markServing(ln2) {
+		t.Fatalf("expecting not ready while the inherited listener isn't claimed")
+	}
+
+	if names := closeUnclaimedListeners(); len(names) != 1 || names[0] != "tcp4:unclaimed" {
+		t.Fatalf("unexpected closed listeners %q", names)
+	}
+	if _, err = unclaimed.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, net.ErrClosed)
+	}
+	if !u.markServing(ln2) {
+		t.Fatalf("expecting ready after all the listeners are served")
+	}
+}
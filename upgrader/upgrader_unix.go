@@ -0,0 +1,39 @@
+//go:build !windows
+
+package upgrader
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+const fdInheritanceSupported = true
+
+func defaultSignals() []os.Signal {
+	return []os.Signal{syscall.SIGHUP, syscall.SIGUSR2}
+}
+
+// restoreNonblock puts the listener back into non-blocking mode.
+//
+// exec.Cmd switches the files passed via ExtraFiles into blocking mode,
+// which also affects the listener since the duplicated descriptors share
+// the file status flags. A blocking listener would hang in accept(2)
+// instead of waiting in the network poller.
+func restoreNonblock(ln net.Listener) error {
+	sc, ok := ln.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err = rc.Control(func(fd uintptr) {
+		serr = syscall.SetNonblock(int(fd), true)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package upgrader
+
+import (
+	"net"
+	"os"
+)
+
+const fdInheritanceSupported = false
+
+func defaultSignals() []os.Signal {
+	return nil
+}
+
+func restoreNonblock(net.Listener) error {
+	return nil
+}
@@ -2,6 +2,7 @@ package fasthttp
 
 import (
 	"errors"
+	"log/slog"
 	"net"
 	"runtime"
 	"strings"
@@ -231,7 +232,15 @@ func (wp *workerPool) workerFunc(ch *workerChan) {
 				strings.Contains(errStr, "i/o timeout") ||
 				errors.Is(err, ErrBadTrailer)
 			if wp.LogAllErrors || !shouldIgnore {
-				wp.Logger.Printf("error when serving connection %q<->%q: %v", c.LocalAddr(), c.RemoteAddr(), err)
+				level, msg, kind := connErrorRecord(err)
+				logRecord(wp.Logger, level, msg,
+					[]slog.Attr{
+						slog.String("local_addr", c.LocalAddr().String()),
+						slog.String("remote_addr", c.RemoteAddr().String()),
+						slog.String("kind", kind),
+						slog.Any("error", err),
+					},
+					"error when serving connection %q<->%q: %v", c.LocalAddr(), c.RemoteAddr(), err)
 			}
 		}
 		if err == errHijacked {